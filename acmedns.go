@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// acmeDNSQueries select the registrations of an acme-dns database as a JSON
+// array, by database engine as named in the acme-dns configuration. The
+// columns are those of the records table of acme-dns; the TXT values of its
+// txt table are challenge tokens that ACME clients set again on renewal and
+// aren't migrated.
+var acmeDNSQueries = map[string]string{
+	"sqlite3":  `SELECT json_group_array(json_object('username', Username, 'password', Password, 'subdomain', Subdomain, 'allowfrom', AllowFrom)) FROM records`,
+	"postgres": `SELECT coalesce(json_agg(json_build_object('username', Username, 'password', Password, 'subdomain', Subdomain, 'allowfrom', AllowFrom)), '[]') FROM records`,
+}
+
+// acmeDNSRecord is a row of the records table of acme-dns.
+type acmeDNSRecord struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`  // bcrypt hash
+	Subdomain string `json:"subdomain"` // a UUID
+	AllowFrom string `json:"allowfrom"` // JSON array of CIDR ranges
+}
+
+// queryAcmeDNS returns the output of the query for the registrations of the
+// acme-dns database db, a SQLite file or a PostgreSQL connection string. It
+// runs the sqlite3 or psql client rather than linking database drivers.
+func queryAcmeDNS(ctx context.Context, engine, db string) ([]byte, error) {
+	query, ok := acmeDNSQueries[engine]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database engine %q, want sqlite3 or postgres", engine)
+	}
+	var cmd *exec.Cmd
+	if engine == "sqlite3" {
+		cmd = exec.CommandContext(ctx, "sqlite3", "-batch", "-readonly", db, query)
+	} else {
+		cmd = exec.CommandContext(ctx, "psql", "--no-psqlrc", "--tuples-only", "--no-align", "--dbname", db, "--command", query)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// parseAcmeDNS converts the registrations queried from acme-dns to
+// dns-pajatso registrations. The passwords are bcrypt hashes in both.
+func parseAcmeDNS(out []byte) ([]pajatso.Registration, error) {
+	var records []acmeDNSRecord
+	if err := json.Unmarshal(bytes.TrimSpace(out), &records); err != nil {
+		return nil, fmt.Errorf("reading acme-dns records: %w", err)
+	}
+	regs := make([]pajatso.Registration, 0, len(records))
+	for _, rec := range records {
+		reg := pajatso.Registration{Username: rec.Username, PasswordHash: rec.Password, Subdomain: rec.Subdomain}
+		if rec.AllowFrom != "" {
+			if err := json.Unmarshal([]byte(rec.AllowFrom), &reg.AllowFrom); err != nil {
+				return nil, fmt.Errorf("registration %s: allowfrom: %w", rec.Subdomain, err)
+			}
+		}
+		regs = append(regs, reg)
+	}
+	return regs, nil
+}
+
+// importCommand returns the import subcommand, migrating data from other
+// servers.
+func importCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import the data of another DNS challenge server",
+	}
+
+	var (
+		db       string
+		engine   string
+		registry string
+	)
+	acmeDNS := &cobra.Command{
+		Use:   "acme-dns",
+		Short: "Import the registrations of a joohoi/acme-dns database, read with the sqlite3 or psql client, into an --acme-dns-registry file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out, err := queryAcmeDNS(cmd.Context(), engine, db)
+			if err != nil {
+				return err
+			}
+			regs, err := parseAcmeDNS(out)
+			if err != nil {
+				return err
+			}
+			r, err := pajatso.LoadRegistry(registry)
+			if err != nil {
+				return err
+			}
+			n, err := r.Import(regs)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "imported %d of %d registrations into %s\n", n, len(regs), registry)
+			return nil
+		},
+	}
+	acmeDNS.SilenceUsage = true
+	acmeDNS.Flags().StringVar(&db, "db", "", "acme-dns database: a SQLite file, or a PostgreSQL connection string")
+	acmeDNS.Flags().StringVar(&engine, "db-engine", "sqlite3", "Database engine of acme-dns (sqlite3 or postgres)")
+	acmeDNS.Flags().StringVar(&registry, "registry", "", "Registry file to import into, as passed to --acme-dns-registry")
+	acmeDNS.MarkFlagRequired("db")
+	acmeDNS.MarkFlagRequired("registry")
+
+	cmd.AddCommand(acmeDNS)
+	return cmd
+}
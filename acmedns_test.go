@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseAcmeDNS(t *testing.T) {
+	regs, err := parseAcmeDNS([]byte(`[{"username":"u1","password":"$2a$10$x","subdomain":"s1","allowfrom":"[\"192.0.2.0/24\"]"},{"username":"u2","password":"$2a$10$y","subdomain":"s2","allowfrom":"[]"}]` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regs) != 2 || regs[0].Subdomain != "s1" || len(regs[0].AllowFrom) != 1 || len(regs[1].AllowFrom) != 0 {
+		t.Fatalf("unexpected registrations %+v", regs)
+	}
+	if _, err := parseAcmeDNS([]byte(`[{"subdomain":"s1","allowfrom":"192.0.2.0/24"}]`)); err == nil {
+		t.Fatal("expected malformed allowfrom to fail")
+	}
+}
+
+func TestImportAcmeDNSSQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	db := filepath.Join(t.TempDir(), "acme-dns.db")
+	schema := `CREATE TABLE records(Username TEXT UNIQUE NOT NULL PRIMARY KEY, Password TEXT UNIQUE NOT NULL, Subdomain TEXT UNIQUE NOT NULL, AllowFrom TEXT);
+INSERT INTO records VALUES ('user', '` + string(hash) + `', 'd420c923-bbd7-4056-ab64-c3ca54c9b3cf', '["192.0.2.0/24"]');`
+	if out, err := exec.Command("sqlite3", db, schema).CombinedOutput(); err != nil {
+		t.Fatalf("creating the database: %v: %s", err, out)
+	}
+
+	out, err := queryAcmeDNS(context.Background(), "sqlite3", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs, err := parseAcmeDNS(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pajatso.LoadRegistry(filepath.Join(t.TempDir(), "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Import(regs); err != nil || n != 1 {
+		t.Fatalf("expected 1 registration imported, got %d, %v", n, err)
+	}
+
+	if _, err := queryAcmeDNS(context.Background(), "mysql", db); err == nil {
+		t.Fatal("expected an unsupported engine to fail")
+	}
+}
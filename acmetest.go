@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+	"golang.org/x/crypto/acme"
+)
+
+// updateRecord publishes the challenge record with TSIG-signed RFC 2136
+// updates sent to a running server, and queries it after each change, like
+// an ACME client using the server would.
+type updateRecord struct {
+	network, addr      string
+	zone, name         string
+	keyName, algorithm string
+	secret             []byte
+	graced             bool // deleted values are still answered, see Store.DeleteGrace
+}
+
+func (u *updateRecord) set(ctx context.Context, value string) error {
+	if err := u.update(ctx, newUpdate(u.zone, u.name, value, 60)); err != nil {
+		return err
+	}
+	values, err := u.query(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(values, value) {
+		return fmt.Errorf("%s TXT not answered after the update", u.name)
+	}
+	return nil
+}
+
+func (u *updateRecord) clear(ctx context.Context) error {
+	if err := u.update(ctx, newUpdate(u.zone, u.name, "", 0)); err != nil {
+		return err
+	}
+	values, err := u.query(ctx)
+	if err != nil {
+		return err
+	}
+	if len(values) > 0 && !u.graced {
+		return fmt.Errorf("%s TXT still answered after the delete", u.name)
+	}
+	return nil
+}
+
+// update sends the update m, failing unless it is answered with NOERROR.
+func (u *updateRecord) update(ctx context.Context, m *dns.Msg) error {
+	r, err := exchangeUpdate(ctx, m, u.network, u.addr, u.keyName, u.algorithm, u.secret)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update failed: %s", dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
+
+// query returns the values of the challenge record served.
+func (u *updateRecord) query(ctx context.Context) ([]string, error) {
+	r, _, err := dns.NewClient().Exchange(ctx, dns.NewMsg(u.name, dns.TypeTXT), u.network, u.addr)
+	if err != nil {
+		return nil, fmt.Errorf("querying %s TXT: %w", u.name, err)
+	}
+	var values []string
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+	return values, nil
+}
+
+// selfAddr returns an address of addrs, bound by the server, that the
+// server can send itself requests at, preferring UDP.
+func selfAddr(addrs []net.Addr) (network, addr string, err error) {
+	for _, want := range []string{"udp", "tcp"} {
+		for _, a := range addrs {
+			if a.Network() != want {
+				continue
+			}
+			host, port, err := net.SplitHostPort(a.String())
+			if err != nil {
+				continue
+			}
+			if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+				host = "localhost"
+			}
+			return want, net.JoinHostPort(host, port), nil
+		}
+	}
+	return "", "", errors.New("no DNS listener to send updates to")
+}
+
+// newACMETestClient returns a client of the ACME directory for acmeTest,
+// with a throwaway account key.
+func newACMETestClient(directory, directoryCA string) (*acme.Client, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newACMEClient(key, directory, directoryCA)
+}
+
+// acmeTest issues a certificate for the challenge domain of srv and its
+// wildcard from the ACME directory of client, e.g. a Pebble instance
+// validating against srv. The challenges are published and cleaned up with
+// signed updates to srv, so the whole update, query and cleanup loop of a
+// real issuance is exercised. The certificate is discarded.
+func acmeTest(ctx context.Context, client *acme.Client, srv *pajatso.Server) error {
+	network, addr, err := selfAddr(srv.Addrs())
+	if err != nil {
+		return err
+	}
+	secret, err := base64.StdEncoding.DecodeString(srv.TsigSecret)
+	if err != nil {
+		return fmt.Errorf("invalid TSIG secret: %w", err)
+	}
+	rec := &updateRecord{
+		network:   network,
+		addr:      addr,
+		zone:      srv.Zone,
+		name:      srv.ChallengeName(),
+		keyName:   srv.TsigName,
+		algorithm: srv.TsigAlg,
+		secret:    secret,
+		graced:    srv.Store.DeleteGrace > 0,
+	}
+	domain := strings.TrimSuffix(strings.TrimPrefix(srv.ChallengeName(), "_acme-challenge."), ".")
+	domains := []string{domain, "*." + domain}
+	slog.Info("acme test: starting", "directory", client.DirectoryURL, "domains", domains, "server", network+" "+addr)
+
+	// The directory may still be starting, e.g. next to the server in CI.
+	for {
+		_, err := client.Discover(ctx)
+		if err == nil {
+			break
+		}
+		slog.Warn("acme test: directory not available, retrying", "err", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("acme test: fetching directory: %w", err)
+		case <-time.After(time.Second):
+		}
+	}
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("acme test: registering account: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	chain, err := issue(ctx, client, rec, domains, certKey)
+	if err != nil {
+		return fmt.Errorf("acme test: %w", err)
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return fmt.Errorf("acme test: parsing certificate: %w", err)
+	}
+	slog.Info("acme test: certificate issued", "names", cert.DNSNames, "issuer", cert.Issuer.String())
+	return nil
+}
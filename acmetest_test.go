@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+func TestUpdateRecord(t *testing.T) {
+	srv := &pajatso.Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &pajatso.Store{}}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	rec := &updateRecord{
+		network:   "udp",
+		addr:      addr,
+		zone:      testZone,
+		name:      testChallenge,
+		keyName:   testTsigName,
+		algorithm: dns.HmacSHA512,
+		secret:    secret,
+	}
+	ctx := context.Background()
+	if err := rec.set(ctx, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.set(ctx, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(store.Entries()); n != 2 {
+		t.Fatalf("expected 2 values, got %d", n)
+	}
+	if err := rec.clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values still served after the delete fail the cleanup, unless the
+	// Store keeps them on purpose.
+	graced := &pajatso.Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &pajatso.Store{DeleteGrace: time.Minute}}
+	gracedAddr, _, gracedCleanup := startTestServerFor(t, graced)
+	defer gracedCleanup()
+	rec.addr = gracedAddr
+	if err := rec.set(ctx, "third"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.clear(ctx); err == nil {
+		t.Fatal("expected the cleanup to fail while the value is still served")
+	}
+	rec.graced = true
+	if err := rec.clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rec.secret = []byte("wrong")
+	if err := rec.set(ctx, "fourth"); err == nil {
+		t.Fatal("expected an update with the wrong secret to fail")
+	}
+}
+
+func TestSelfAddr(t *testing.T) {
+	addrs := []net.Addr{
+		&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53},
+		&net.UDPAddr{IP: net.IPv4zero, Port: 5353},
+	}
+	network, addr, err := selfAddr(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network != "udp" || addr != "localhost:5353" {
+		t.Fatalf("expected udp localhost:5353, got %s %s", network, addr)
+	}
+	if _, _, err := selfAddr(nil); err == nil {
+		t.Fatal("expected an error without listeners")
+	}
+}
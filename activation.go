@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// activationFiles returns the sockets passed by systemd socket activation
+// (see sd_listen_fds(3)), or nil if the process was not socket-activated.
+func activationFiles() []*os.File {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Don't pass the sockets on to hooks and other children.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	files := make([]*os.File, n)
+	for i := range files {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files[i] = os.NewFile(uintptr(listenFDsStart+i), name)
+	}
+	return files
+}
+
+// activatedServers returns a DNS server with the handler options opts for
+// each of the sockets in files, which are closed as the servers use
+// duplicates of them.
+func activatedServers(srv *pajatso.Server, files []*os.File, opts ...pajatso.HandlerOption) ([]*dns.Server, error) {
+	var servers []*dns.Server
+	for _, f := range files {
+		ds := srv.NewDNSServer(opts...)
+		if pc, err := net.FilePacketConn(f); err == nil {
+			ds.PacketConn, ds.Net = pc, "udp"
+		} else if ln, err := net.FileListener(f); err == nil {
+			ds.Listener, ds.Net = srv.TCPListener(ln), "tcp"
+		} else {
+			f.Close()
+			return nil, fmt.Errorf("socket activation: %s is neither a UDP nor a TCP socket", f.Name())
+		}
+		f.Close()
+		servers = append(servers, ds)
+	}
+	return servers, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+func TestActivationFilesNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	if files := activationFiles(); files != nil {
+		t.Errorf("got %d files for another process", len(files))
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if files := activationFiles(); files != nil {
+		t.Errorf("got %d files for LISTEN_FDS=0", len(files))
+	}
+}
+
+func TestActivatedServers(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Pass duplicates of the sockets, as systemd would.
+	udpFile, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpFile, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &pajatso.Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &pajatso.Store{}}
+	servers, err := activatedServers(srv, []*os.File{udpFile, tcpFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || servers[0].Net != "udp" || servers[1].Net != "tcp" {
+		t.Fatalf("servers = %+v", servers)
+	}
+	for _, ds := range servers {
+		go ds.ListenAndServe()
+		defer ds.Shutdown(context.Background())
+	}
+	srv.Store.Replace("activated")
+
+	for _, proto := range []string{"udp", "tcp"} {
+		addr := pc.LocalAddr().String()
+		if proto == "tcp" {
+			addr = ln.Addr().String()
+		}
+		r, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), proto, addr)
+		if err != nil {
+			t.Fatalf("%s query failed: %v", proto, err)
+		}
+		if len(r.Answer) != 1 || r.Answer[0].(*dns.TXT).Txt[0] != "activated" {
+			t.Errorf("%s answer = %v", proto, r.Answer)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// awsRef refers to a TSIG secret in AWS Secrets Manager or the SSM
+// Parameter Store by ARN, optionally with the key of a JSON secret, e.g.
+// arn:aws:secretsmanager:eu-north-1:123456789012:secret:dns-pajatso-AbCdEf#tsig.
+type awsRef struct {
+	ARN     string
+	Service string // secretsmanager or ssm
+	Region  string
+	Name    string // ARN without the key, as the SDK accepts it for both
+	Key     string // key of a JSON secret, none if empty
+}
+
+// parseAWSRef parses an ARN[#key] reference.
+func parseAWSRef(s string) (awsRef, error) {
+	arn, key, _ := strings.Cut(s, "#")
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[3] == "" {
+		return awsRef{}, fmt.Errorf("invalid AWS reference %q, want a Secrets Manager or SSM parameter ARN", s)
+	}
+	r := awsRef{ARN: arn, Service: parts[2], Region: parts[3], Key: key}
+	switch {
+	case r.Service == "secretsmanager" && strings.HasPrefix(parts[5], "secret:"):
+		r.Name = arn
+	case r.Service == "ssm" && strings.HasPrefix(parts[5], "parameter/"):
+		r.Name = arn
+	default:
+		return awsRef{}, fmt.Errorf("invalid AWS reference %q, want a Secrets Manager or SSM parameter ARN", s)
+	}
+	return r, nil
+}
+
+func (r awsRef) String() string {
+	if r.Key != "" {
+		return r.ARN + "#" + r.Key
+	}
+	return r.ARN
+}
+
+// awsClient reads secrets with the AWS SDK and its default credential
+// chain: the environment, shared config and SSO profiles, web identity
+// tokens (EKS IRSA), EKS Pod Identity, the ECS task role and the EC2
+// instance role.
+type awsClient struct {
+	cfg aws.Config
+}
+
+// newAWSClient returns a client for the public endpoints of region.
+func newAWSClient(ctx context.Context, region string) (*awsClient, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(10*time.Second)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	return &awsClient{cfg: cfg}, nil
+}
+
+// read returns the TSIG secret r refers to and its version, which changes
+// with every rotation.
+func (r awsRef) read(ctx context.Context, a *awsClient) (string, string, error) {
+	var value, version string
+	if r.Service == "secretsmanager" {
+		out, err := secretsmanager.NewFromConfig(a.cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(r.Name)})
+		if err != nil {
+			return "", "", fmt.Errorf("reading secret %s: %w", r.ARN, err)
+		}
+		value, version = aws.ToString(out.SecretString), aws.ToString(out.VersionId)
+	} else {
+		out, err := ssm.NewFromConfig(a.cfg).GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(r.Name), WithDecryption: aws.Bool(true)})
+		if err != nil {
+			return "", "", fmt.Errorf("reading parameter %s: %w", r.ARN, err)
+		}
+		value, version = aws.ToString(out.Parameter.Value), fmt.Sprint(out.Parameter.Version)
+	}
+
+	if r.Key != "" {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(value), &fields); err != nil {
+			return "", "", fmt.Errorf("secret %s is not a JSON object: %w", r.ARN, err)
+		}
+		s, ok := fields[r.Key].(string)
+		if !ok {
+			return "", "", fmt.Errorf("secret %s has no string key %q", r.ARN, r.Key)
+		}
+		value = s
+	}
+	if value = strings.TrimSpace(value); value == "" {
+		return "", "", errors.New("secret " + r.ARN + " is empty")
+	}
+	return value, version, nil
+}
+
+// poll calls fn with the TSIG secret r refers to whenever a new version
+// was rotated in, checking every interval until ctx is done. Errors are
+// logged and retried at the next interval.
+func (r awsRef) poll(ctx context.Context, a *awsClient, interval time.Duration, version string, fn func(string)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		value, v, err := r.read(ctx, a)
+		if err != nil {
+			slog.Warn("aws: reading TSIG secret failed, retrying", "ref", r, "err", err)
+			continue
+		}
+		if v != version {
+			version = v
+			fn(value)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func TestParseAWSRef(t *testing.T) {
+	ref, err := parseAWSRef("arn:aws:secretsmanager:eu-north-1:123456789012:secret:dns-AbCdEf#tsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Service != "secretsmanager" || ref.Region != "eu-north-1" || ref.Name != "arn:aws:secretsmanager:eu-north-1:123456789012:secret:dns-AbCdEf" || ref.Key != "tsig" {
+		t.Errorf("ref = %+v", ref)
+	}
+	// Parameters are read by ARN, whether their names are hierarchical or
+	// not: a name without a leading slash isn't the path of the ARN.
+	for _, arn := range []string{"arn:aws:ssm:us-east-1:123456789012:parameter/dns/tsig", "arn:aws:ssm:us-east-1:123456789012:parameter/tsig"} {
+		ref, err = parseAWSRef(arn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.Service != "ssm" || ref.Region != "us-east-1" || ref.Name != arn {
+			t.Errorf("ref = %+v", ref)
+		}
+	}
+	for _, s := range []string{"", "dns-tsig", "arn:aws:s3:::bucket", "arn:aws:ssm::123456789012:parameter/dns", "arn:aws:secretsmanager:eu-north-1:1:other:x"} {
+		if _, err := parseAWSRef(s); err == nil {
+			t.Errorf("parseAWSRef(%q) succeeded", s)
+		}
+	}
+}
+
+// fakeAWS is a fake Secrets Manager and SSM endpoint serving a secret whose
+// version is bumped with each rotation.
+type fakeAWS struct {
+	t       *testing.T
+	version atomic.Int32
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Security-Token") != "session" {
+		f.t.Errorf("unsigned request, headers %v", r.Header)
+	}
+	var in struct{ SecretId, Name string }
+	json.NewDecoder(r.Body).Decode(&in)
+	v := f.version.Load()
+	switch target := r.Header.Get("X-Amz-Target"); target {
+	case "secretsmanager.GetSecretValue":
+		value, _ := json.Marshal(map[string]string{"tsig": fmt.Sprint("secret-", v)})
+		fmt.Fprintf(w, `{"ARN": %q, "SecretString": %q, "VersionId": "v%d"}`, in.SecretId, value, v)
+	case "AmazonSSM.GetParameter":
+		fmt.Fprintf(w, `{"Parameter": {"ARN": %q, "Value": "parameter-%d", "Version": %d}}`, in.Name, v, v)
+	default:
+		f.t.Errorf("unexpected target %q", target)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func testAWSClient(t *testing.T, f *fakeAWS) *awsClient {
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	return &awsClient{cfg: aws.Config{
+		Region:       "eu-north-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "secret", "session"),
+		BaseEndpoint: aws.String(ts.URL),
+		HTTPClient:   ts.Client(),
+	}}
+}
+
+func TestAWSReadParameter(t *testing.T) {
+	f := &fakeAWS{t: t}
+	f.version.Store(3)
+	a := testAWSClient(t, f)
+	for _, arn := range []string{"arn:aws:ssm:eu-north-1:123456789012:parameter/dns/tsig", "arn:aws:ssm:eu-north-1:123456789012:parameter/tsig"} {
+		ref, err := parseAWSRef(arn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, v, err := ref.read(context.Background(), a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != "parameter-3" || v != "3" {
+			t.Fatalf("read = %q, %q, want parameter-3, 3", value, v)
+		}
+	}
+}
+
+func TestAWSPoll(t *testing.T) {
+	f := &fakeAWS{t: t}
+	f.version.Store(1)
+	a := testAWSClient(t, f)
+
+	ref, err := parseAWSRef("arn:aws:secretsmanager:eu-north-1:123456789012:secret:dns-AbCdEf#tsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, v, err := ref.read(context.Background(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "secret-1" || v != "v1" {
+		t.Fatalf("read = %q, %q, want secret-1, v1", value, v)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan string, 1)
+	go ref.poll(ctx, a, 10*time.Millisecond, v, func(s string) { got <- s })
+	f.version.Store(2)
+	select {
+	case s := <-got:
+		if s != "secret-2" {
+			t.Fatalf("got %q, want secret-2", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the rotated secret")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/spf13/cobra"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// benchStats collects the outcomes of one kind of request of a bench.
+type benchStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration // of requests answered with NOERROR
+	errors    map[string]int  // failed requests by rcode, "timeout" or "error"
+}
+
+func (s *benchStats) record(latency time.Duration, failure string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if failure == "" {
+		s.latencies = append(s.latencies, latency)
+		return
+	}
+	if s.errors == nil {
+		s.errors = map[string]int{}
+	}
+	s.errors[failure]++
+}
+
+// total returns the number of requests and of failed ones.
+func (s *benchStats) total() (n, failed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.errors {
+		failed += c
+	}
+	return len(s.latencies) + failed, failed
+}
+
+// report writes a summary of s, over a bench of elapsed, to w.
+func (s *benchStats) report(w io.Writer, kind string, elapsed time.Duration) {
+	n, failed := s.total()
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(w, "%s: %d sent, %.1f/s, %d failed (%.2f%%)", kind, n, float64(n)/elapsed.Seconds(), failed, 100*float64(failed)/float64(n))
+	if failed > 0 {
+		var kinds []string
+		for k, c := range s.errors {
+			kinds = append(kinds, fmt.Sprintf("%s %d", k, c))
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(w, ": %s", strings.Join(kinds, ", "))
+	}
+	fmt.Fprintln(w)
+	if len(s.latencies) > 0 {
+		sorted := slices.Sorted(slices.Values(s.latencies))
+		p := func(p float64) time.Duration { return percentile(sorted, p).Round(time.Microsecond) }
+		fmt.Fprintf(w, "  latency p50 %v, p90 %v, p99 %v, max %v\n", p(50), p(90), p(99), p(100))
+	}
+}
+
+// percentile returns the p-th percentile of the sorted durations, by the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// bench floods a server with a mix of challenge queries and signed updates.
+type bench struct {
+	network, target    string
+	zone, name         string
+	keyName, algorithm string
+	secret             []byte
+	updateRatio        float64 // fraction of requests that are updates
+
+	queries, updates benchStats
+}
+
+// run sends requests from concurrency workers until ctx is done, at up to
+// rate requests per second in total if rate is positive.
+func (b *bench) run(ctx context.Context, concurrency int, rate float64) {
+	var tokens <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Go(func() {
+			for {
+				if tokens != nil {
+					select {
+					case <-tokens:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if rand.Float64() < b.updateRatio {
+					b.update(ctx)
+				} else {
+					b.query(ctx)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// query sends a TXT query for the challenge record.
+func (b *bench) query(ctx context.Context) {
+	start := time.Now()
+	r, _, err := dns.NewClient().Exchange(context.WithoutCancel(ctx), dns.NewMsg(b.name, dns.TypeTXT), b.network, b.target)
+	b.queries.record(time.Since(start), benchFailure(r, err))
+}
+
+// update sends a signed update adding a random token.
+func (b *bench) update(ctx context.Context) {
+	var token [32]byte
+	crand.Read(token[:])
+	m := newUpdate(b.zone, b.name, base64.RawURLEncoding.EncodeToString(token[:]), 60)
+	start := time.Now()
+	r, err := exchangeUpdate(context.WithoutCancel(ctx), m, b.network, b.target, b.keyName, b.algorithm, b.secret)
+	b.updates.record(time.Since(start), benchFailure(r, err))
+}
+
+// benchFailure classifies the outcome of a request, returning "" for a
+// NOERROR response.
+func benchFailure(r *dns.Msg, err error) string {
+	var nerr net.Error
+	switch {
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return "timeout"
+	case r != nil && r.Rcode != dns.RcodeSuccess:
+		return dns.RcodeToString[r.Rcode]
+	case err != nil:
+		return "error"
+	}
+	return ""
+}
+
+// benchCommand returns the bench subcommand.
+func benchCommand() *cobra.Command {
+	var (
+		target       string
+		zoneOpts     zoneFlags
+		keyOpts      keyFlags
+		duration     time.Duration
+		concurrency  int
+		rate         float64
+		updateRatio  float64
+		network      string
+		maxErrorRate float64
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Load-test a server with challenge queries and signed updates, reporting latencies and errors",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if updateRatio < 0 || updateRatio > 1 {
+				return fmt.Errorf("--update-ratio must be between 0 and 1")
+			}
+			if updateRatio > 0 && (keyOpts.name == "" || !keyOpts.given()) {
+				return fmt.Errorf("--tsig-name and a TSIG secret are required with --update-ratio")
+			}
+			if concurrency < 1 {
+				return fmt.Errorf("--concurrency must be at least 1")
+			}
+			if _, _, err := net.SplitHostPort(target); err != nil {
+				target = net.JoinHostPort(target, "53")
+			}
+			var secret []byte
+			if updateRatio > 0 {
+				tsigSecret, _, err := keyOpts.resolve(cmd.Context())
+				if err != nil {
+					return err
+				}
+				if secret, err = base64.StdEncoding.DecodeString(tsigSecret); err != nil {
+					return fmt.Errorf("invalid TSIG secret: %w", err)
+				}
+			}
+
+			zone, err := asciiFQDN(zoneOpts.zone)
+			if err != nil {
+				return err
+			}
+			b := &bench{
+				network:     network,
+				target:      target,
+				zone:        zone,
+				name:        (&pajatso.Server{Zone: zone, Subdomain: strings.TrimRight(zoneOpts.subdomain, ".")}).ChallengeName(),
+				keyName:     ensureFQDN(keyOpts.name),
+				algorithm:   ensureFQDN(strings.ToLower(keyOpts.algorithm)),
+				secret:      secret,
+				updateRatio: updateRatio,
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "benchmarking %s %s for %v with %d workers\n", network, target, duration, concurrency)
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), duration)
+			defer cancel()
+			start := time.Now()
+			b.run(ctx, concurrency, rate)
+			elapsed := time.Since(start)
+
+			b.queries.report(cmd.OutOrStdout(), "queries", elapsed)
+			b.updates.report(cmd.OutOrStdout(), "updates", elapsed)
+
+			// Don't leave the tokens of the bench published.
+			if n, _ := b.updates.total(); n > 0 {
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(cmd.Context()), 10*time.Second)
+				defer cancel()
+				if _, err := exchangeUpdate(ctx, newUpdate(zone, b.name, "", 0), network, target, b.keyName, b.algorithm, secret); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "deleting the bench tokens: %v\n", err)
+				}
+			}
+
+			qn, qfailed := b.queries.total()
+			un, ufailed := b.updates.total()
+			if n := qn + un; n > 0 {
+				if errRate := float64(qfailed+ufailed) / float64(n); errRate > maxErrorRate {
+					return fmt.Errorf("error rate %.2f%% exceeds %.2f%%", 100*errRate, 100*maxErrorRate)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.SilenceUsage = true
+
+	cmd.Flags().StringVar(&target, "target", "", "Server address (host or host:port)")
+	zoneOpts.register(cmd.Flags())
+	keyOpts.register(cmd.Flags())
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "How long to send requests for")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 16, "Number of requests in flight at once")
+	cmd.Flags().Float64Var(&rate, "rate", 0, "Requests per second to send in total (0 = as fast as answered)")
+	cmd.Flags().Float64Var(&updateRatio, "update-ratio", 0, "Fraction of requests that are signed updates adding a random token, the rest are challenge queries")
+	cmd.Flags().StringVar(&network, "net", "udp", "Transport to use (udp or tcp)")
+	cmd.Flags().Float64Var(&maxErrorRate, "max-error-rate", 0.01, "Fail if more than this fraction of requests time out or aren't answered with NOERROR")
+
+	cmd.MarkFlagRequired("target")
+	cmd.MarkFlagRequired("zone")
+
+	return cmd
+}
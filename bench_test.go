@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{{50, 50 * time.Millisecond}, {99, 99 * time.Millisecond}, {100, 100 * time.Millisecond}, {0, time.Millisecond}} {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("p%v: expected %v, got %v", tt.p, tt.want, got)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("expected 0 without samples, got %v", got)
+	}
+}
+
+func TestBench(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	b := &bench{
+		network:     "udp",
+		target:      addr,
+		zone:        testZone,
+		name:        testChallenge,
+		keyName:     testTsigName,
+		algorithm:   dns.HmacSHA512,
+		secret:      secret,
+		updateRatio: 0.5,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	b.run(ctx, 4, 0)
+
+	qn, qfailed := b.queries.total()
+	un, ufailed := b.updates.total()
+	if qn == 0 || un == 0 {
+		t.Fatalf("expected queries and updates, got %d and %d", qn, un)
+	}
+	if qfailed+ufailed > 0 {
+		t.Fatalf("expected no failures, got %v and %v", b.queries.errors, b.updates.errors)
+	}
+	if len(store.Entries()) == 0 {
+		t.Fatal("expected the updates to add tokens")
+	}
+
+	var out bytes.Buffer
+	b.queries.report(&out, "queries", 200*time.Millisecond)
+	if !strings.Contains(out.String(), "queries: ") || !strings.Contains(out.String(), "latency p50") {
+		t.Fatalf("unexpected report %q", out.String())
+	}
+
+	// Updates signed with the wrong key are failures, counted by rcode.
+	b.secret = []byte("wrong")
+	b.update(context.Background())
+	if b.updates.errors["NOTAUTH"] != 1 {
+		t.Fatalf("expected a NOTAUTH failure, got %v", b.updates.errors)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+// bindRetryMax is the longest pause between two attempts of bindRetry.
+const bindRetryMax = 2 * time.Second
+
+// bindError is a failed bind of a DNS listener.
+type bindError struct {
+	network, addr string
+	err           error
+}
+
+func (e *bindError) Error() string {
+	msg := fmt.Sprintf("binding %s %s", e.network, e.addr)
+	switch bindReason(e.err) {
+	case "address in use":
+		msg += ": address in use (is another DNS server, e.g. systemd-resolved, listening on it?)"
+	case "permission denied":
+		msg += ": permission denied (run as root, with CAP_NET_BIND_SERVICE or with socket activation)"
+	}
+	return msg + ": " + e.err.Error()
+}
+
+func (e *bindError) Unwrap() error { return e.err }
+
+// bindReason tells the usual causes of bind failures apart, returning
+// "address in use", "permission denied" or "" for other errors.
+func bindReason(err error) string {
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return "address in use"
+	case errors.Is(err, os.ErrPermission):
+		return "permission denied"
+	}
+	return ""
+}
+
+// bindRetry calls bind until it succeeds, retrying with exponential backoff
+// for up to window while addr is in use, e.g. by a systemd-resolved being
+// restarted. Other errors aren't retried.
+func bindRetry(ctx context.Context, network, addr string, window time.Duration, bind func() error) error {
+	deadline := time.Now().Add(window)
+	wait := 100 * time.Millisecond
+	for {
+		err := bind()
+		if err == nil {
+			return nil
+		}
+		reason := bindReason(err)
+		if reason != "address in use" || time.Until(deadline) < wait {
+			slog.Error("bind failed", "network", network, "addr", addr, "reason", reason, "err", err)
+			return &bindError{network, addr, err}
+		}
+		slog.Warn("bind: address in use, retrying", "network", network, "addr", addr, "retry_in", wait)
+		select {
+		case <-ctx.Done():
+			return &bindError{network, addr, err}
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, bindRetryMax)
+	}
+}
+
+// waitUDP waits with bindRetry until addr can be bound for UDP. The sockets
+// themselves are bound by the dns package, which sets options for replying
+// from the address queries arrived at.
+func waitUDP(ctx context.Context, addr string, window time.Duration) error {
+	return bindRetry(ctx, "udp", addr, window, func() error {
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		return pc.Close()
+	})
+}
+
+// listenTCP binds addr for TCP, retrying with bindRetry.
+func listenTCP(ctx context.Context, addr string, window time.Duration) (net.Listener, error) {
+	var ln net.Listener
+	err := bindRetry(ctx, "tcp", addr, window, func() (err error) {
+		ln, err = net.Listen("tcp", addr)
+		return err
+	})
+	return ln, err
+}
+
+// serveHTTP binds addr and serves hs on it in the background, over TLS if hs
+// has a TLSConfig with certificates, sending the error it stops with to
+// errCh. Binding before returning lets the caller drop privileges after it.
+func serveHTTP(hs *http.Server, addr string, errCh chan<- error) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if hs.TLSConfig != nil {
+			errCh <- hs.ServeTLS(ln, "", "")
+		} else {
+			errCh <- hs.Serve(ln)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBindRetry(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := held.Addr().String()
+
+	// Without a window, an address in use fails right away.
+	_, err = listenTCP(context.Background(), addr, 0)
+	if !errors.Is(err, syscall.EADDRINUSE) || !strings.Contains(err.Error(), "address in use") {
+		t.Fatalf("expected an address in use error, got %v", err)
+	}
+
+	// Within the window, the address is bound once it is released.
+	time.AfterFunc(300*time.Millisecond, func() { held.Close() })
+	ln, err := listenTCP(context.Background(), addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected the bind to be retried, got %v", err)
+	}
+	ln.Close()
+
+	// Other errors aren't retried.
+	start := time.Now()
+	if _, err := listenTCP(context.Background(), "256.0.0.1:53", 5*time.Second); err == nil {
+		t.Fatal("expected an error for an invalid address")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("expected an invalid address not to be retried")
+	}
+}
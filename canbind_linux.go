@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capNetBindService is the bit of CAP_NET_BIND_SERVICE in capability sets.
+const capNetBindService = 10
+
+// canBind reports whether the process may bind port: unprivileged ports
+// start at net.ipv4.ip_unprivileged_port_start, and binding below needs root
+// or CAP_NET_BIND_SERVICE.
+func canBind(port int) bool {
+	start := 1024
+	if b, err := os.ReadFile("/proc/sys/net/ipv4/ip_unprivileged_port_start"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			start = n
+		}
+	}
+	if port == 0 || port >= start || os.Geteuid() == 0 {
+		return true
+	}
+
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if hex, ok := strings.CutPrefix(sc.Text(), "CapEff:"); ok {
+			caps, err := strconv.ParseUint(strings.TrimSpace(hex), 16, 64)
+			return err == nil && caps&(1<<capNetBindService) != 0
+		}
+	}
+	return false
+}
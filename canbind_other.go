@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// canBind reports whether the process may bind port: ports below 1024 need
+// root on Unix systems, and there is no such restriction elsewhere.
+func canBind(port int) bool {
+	// Geteuid returns -1 on Windows.
+	return port == 0 || port >= 1024 || os.Geteuid() <= 0
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"github.com/spf13/cobra"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// defaultResolvers are the public recursive resolvers used by the check subcommand.
+var defaultResolvers = []string{"1.1.1.1", "8.8.8.8", "9.9.9.9"}
+
+// checker verifies that a zone is delegated to dns-pajatso and reachable,
+// printing each step to out.
+type checker struct {
+	client    *dns.Client
+	resolvers []string
+	out       io.Writer
+	failed    bool
+}
+
+func (c *checker) ok(format string, args ...any) {
+	fmt.Fprintf(c.out, "ok    "+format+"\n", args...)
+}
+
+// fail reports a failed check with a hint on how to fix it.
+func (c *checker) fail(hint, format string, args ...any) {
+	c.failed = true
+	fmt.Fprintf(c.out, "FAIL  "+format+"\n", args...)
+	if hint != "" {
+		fmt.Fprintf(c.out, "      hint: %s\n", hint)
+	}
+}
+
+// exchange sends a single query for name and qtype to addr over network.
+func (c *checker) exchange(ctx context.Context, network, addr, name string, qtype uint16, recursive bool) (*dns.Msg, error) {
+	m := dns.NewMsg(name, qtype)
+	m.RecursionDesired = recursive
+	m.UDPSize = 1232
+	r, _, err := c.client.Exchange(ctx, m, network, addr)
+	return r, err
+}
+
+// resolve looks up name through the first public resolver that answers.
+func (c *checker) resolve(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	var lastErr error
+	for _, res := range c.resolvers {
+		r, err := c.exchange(ctx, "udp", net.JoinHostPort(res, "53"), name, qtype, true)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("%s %s: %s", name, dns.TypeToString[qtype], dns.RcodeToString[r.Rcode])
+		}
+		return r.Answer, nil
+	}
+	return nil, lastErr
+}
+
+// resolveAddrs returns the IPv4 and IPv6 addresses of host.
+func (c *checker) resolveAddrs(ctx context.Context, host string) []netip.Addr {
+	var addrs []netip.Addr
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrs, _ := c.resolve(ctx, host, qtype)
+		addrs = append(addrs, addrsOf(rrs, host)...)
+	}
+	return addrs
+}
+
+// addrsOf returns the addresses of the A and AAAA records for owner in rrs.
+func addrsOf(rrs []dns.RR, owner string) []netip.Addr {
+	var addrs []netip.Addr
+	for _, rr := range rrs {
+		if !dns.EqualName(rr.Header().Name, owner) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, rr.Addr)
+		case *dns.AAAA:
+			addrs = append(addrs, rr.Addr)
+		}
+	}
+	return addrs
+}
+
+// parentZone returns the parent of zone, e.g. "com." for "example.com.".
+func parentZone(zone string) string {
+	if _, parent, ok := strings.Cut(zone, "."); ok && parent != "" {
+		return parent
+	}
+	return "."
+}
+
+// delegation asks the parent zone's servers for the NS set of zone and
+// returns the NS names together with any glue addresses.
+func (c *checker) delegation(ctx context.Context, zone string) (map[string][]netip.Addr, error) {
+	parent := parentZone(zone)
+	rrs, err := c.resolve(ctx, parent, dns.TypeNS)
+	if err != nil {
+		return nil, fmt.Errorf("looking up NS of parent zone %s: %w", parent, err)
+	}
+
+	for _, rr := range rrs {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		for _, addr := range c.resolveAddrs(ctx, ns.Ns) {
+			r, err := c.exchange(ctx, "udp", net.JoinHostPort(addr.String(), "53"), zone, dns.TypeNS, false)
+			if err != nil {
+				continue
+			}
+			servers := map[string][]netip.Addr{}
+			for _, rr := range append(r.Answer, r.Ns...) {
+				if ns, ok := rr.(*dns.NS); ok && dns.EqualName(ns.Hdr.Name, zone) {
+					servers[strings.ToLower(ns.Ns)] = addrsOf(r.Extra, ns.Ns)
+				}
+			}
+			return servers, nil
+		}
+	}
+	return nil, fmt.Errorf("no server of parent zone %s answered", parent)
+}
+
+// probe queries the challenge record at addr over network, directly and
+// without recursion, as a CA validating the challenge would.
+func (c *checker) probe(ctx context.Context, network, addr, challenge string) error {
+	r, err := c.exchange(ctx, network, addr, challenge, dns.TypeTXT, false)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("answered %s", dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
+
+// run performs all checks for the challenge record of zone. If server is
+// valid, the zone must be delegated to that address.
+func (c *checker) run(ctx context.Context, zone, challenge string, server netip.Addr) {
+	servers, err := c.delegation(ctx, zone)
+	if err != nil {
+		c.fail("check that the parent zone is reachable", "%v", err)
+		return
+	}
+	if len(servers) == 0 {
+		c.fail("add NS records for the zone at your registrar or in the parent zone", "%s is not delegated: the parent zone has no NS records for it", zone)
+		return
+	}
+
+	var addrs []netip.Addr
+	for name, glue := range servers {
+		inZone := dnsutil.IsBelow(zone, name)
+		switch {
+		case inZone && len(glue) == 0:
+			c.fail("add glue A/AAAA records for the name server in the parent zone", "%s is inside %s but the parent zone has no glue for it", name, zone)
+			continue
+		case len(glue) == 0:
+			glue = c.resolveAddrs(ctx, name)
+		}
+		if len(glue) == 0 {
+			c.fail("add A/AAAA records for the name server", "%s has no addresses", name)
+			continue
+		}
+		c.ok("%s is delegated to %s (%v)", zone, name, glue)
+		addrs = append(addrs, glue...)
+	}
+
+	if server.IsValid() {
+		if slices.Contains(addrs, server) {
+			c.ok("delegation includes %s", server)
+		} else {
+			c.fail("point the NS records (or their glue) at this server", "wrong NS: none of the delegated name servers resolve to %s", server)
+		}
+	}
+
+	for _, addr := range addrs {
+		hostport := net.JoinHostPort(addr.String(), "53")
+		for _, network := range []string{"udp", "tcp"} {
+			if err := c.probe(ctx, network, hostport, challenge); err != nil {
+				c.fail(fmt.Sprintf("check that the firewall allows inbound %s port 53 and that dns-pajatso serves %s", strings.ToUpper(network), zone),
+					"%s over %s: %v", addr, strings.ToUpper(network), err)
+				continue
+			}
+			c.ok("%s answers %s over %s", addr, challenge, strings.ToUpper(network))
+		}
+	}
+
+	for _, res := range c.resolvers {
+		r, err := c.exchange(ctx, "udp", net.JoinHostPort(res, "53"), challenge, dns.TypeTXT, true)
+		if err != nil {
+			c.fail("", "resolver %s: %v", res, err)
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			c.fail("the delegation is lame or the name servers are unreachable from the internet", "resolver %s answered %s for %s", res, dns.RcodeToString[r.Rcode], challenge)
+			continue
+		}
+		c.ok("resolver %s resolves %s (%d TXT records)", res, challenge, len(r.Answer))
+	}
+}
+
+// checkCommand returns the check subcommand.
+func checkCommand() *cobra.Command {
+	var (
+		zoneOpts  zoneFlags
+		server    string
+		resolvers []string
+		timeout   time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Verify that the challenge record is delegated to this server and reachable",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			zone, err := asciiFQDN(strings.ToLower(zoneOpts.zone))
+			if err != nil {
+				return err
+			}
+			subdomain, err := pajatso.ToASCII(strings.TrimRight(zoneOpts.subdomain, "."))
+			if err != nil {
+				return err
+			}
+			challenge := (&pajatso.Server{Zone: zone, Subdomain: subdomain}).ChallengeName()
+
+			var addr netip.Addr
+			if server != "" {
+				if addr, err = netip.ParseAddr(server); err != nil {
+					return fmt.Errorf("invalid --server: %w", err)
+				}
+			}
+
+			client := dns.NewClient()
+			client.Dialer = &net.Dialer{Timeout: timeout}
+			client.ReadTimeout = timeout
+			client.WriteTimeout = timeout
+			c := &checker{client: client, resolvers: resolvers, out: cmd.OutOrStdout()}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), time.Minute)
+			defer cancel()
+			c.run(ctx, zone, challenge, addr)
+			if c.failed {
+				return fmt.Errorf("some checks failed")
+			}
+			return nil
+		},
+	}
+	cmd.SilenceUsage = true
+
+	zoneOpts.register(cmd.Flags())
+	cmd.Flags().StringVar(&server, "server", "", "Public IP address the zone should be delegated to (optional)")
+	cmd.Flags().StringSliceVar(&resolvers, "resolvers", defaultResolvers, "Public recursive resolvers to query")
+	cmd.Flags().DurationVar(&timeout, "timeout", 3*time.Second, "Timeout for each query")
+	cmd.MarkFlagRequired("zone")
+
+	return cmd
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestParentZone(t *testing.T) {
+	for zone, want := range map[string]string{
+		"sub.example.com.": "example.com.",
+		"example.com.":     "com.",
+		"com.":             ".",
+	} {
+		if got := parentZone(zone); got != want {
+			t.Fatalf("parentZone(%q) = %q, want %q", zone, got, want)
+		}
+	}
+}
+
+func TestCheckProbe(t *testing.T) {
+	addr, _, cleanup := startTestServer(t)
+	defer cleanup()
+
+	client := dns.NewClient()
+	client.Dialer = &net.Dialer{Timeout: time.Second}
+	client.ReadTimeout = time.Second
+	c := &checker{client: client, out: io.Discard}
+
+	if err := c.probe(context.Background(), "udp", addr, testChallenge); err != nil {
+		t.Fatalf("udp: %v", err)
+	}
+	if err := c.probe(context.Background(), "tcp", addr, testChallenge); err != nil {
+		t.Fatalf("tcp: %v", err)
+	}
+	cleanup()
+	if err := c.probe(context.Background(), "tcp", addr, testChallenge); err == nil {
+		t.Fatal("tcp: expected error from a stopped server")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// defaultControlSocket is the default path of the control socket, in a
+// directory only accessible by the server's user.
+const defaultControlSocket = "/run/dns-pajatso/control.sock"
+
+// listenControl creates the control socket at path, replacing a stale socket
+// left behind by a previous run, and restricts it to the current user, or to
+// owner if set. A missing directory is created accessible by the user only.
+// Directories writable by other users, such as /tmp, are refused, as they
+// could take the path first.
+func listenControl(path string, owner *runAs) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("control socket: %w", err)
+		}
+		if owner != nil {
+			if err := os.Chown(dir, owner.uid, owner.gid); err != nil {
+				return nil, fmt.Errorf("control socket: %w", err)
+			}
+		}
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	} else if fi.Mode().Perm()&0o022 != 0 {
+		return nil, fmt.Errorf("control socket: directory %s is writable by other users", dir)
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("control socket: %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale control socket: %w", err)
+		}
+	}
+
+	// Create the socket without permissions for others, rather than
+	// restricting it after it is already reachable.
+	var ln net.Listener
+	err := withUmask(0o177, func() (err error) {
+		ln, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+	if owner != nil {
+		if err := os.Chown(path, owner.uid, owner.gid); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("control socket: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// controlRequest performs an HTTP request against the control socket at path
+// and decodes a JSON response body into out, if non-nil, or copies it to out
+// if it is an io.Writer.
+func controlRequest(path, method, endpoint string, body any, out any) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = strings.NewReader(string(b))
+	}
+	req, err := http.NewRequest(method, "http://control"+endpoint, r)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return errors.New(e.Error)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case io.Writer:
+		_, err := io.Copy(out, resp.Body)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+// controlCommands returns the subcommands operating on a running server
+// through the control socket at *socket.
+func controlCommands(socket *string) []*cobra.Command {
+	cmds := []*cobra.Command{
+		{
+			Use:   "status",
+			Short: "Show the served zone and current challenge records",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var st pajatso.ControlStatus
+				if err := controlRequest(*socket, "GET", "/status", nil, &st); err != nil {
+					return err
+				}
+				fmt.Printf("zone:      %s\n", st.Zone)
+				fmt.Printf("challenge: %s\n", st.ChallengeName)
+				if st.Build.Version != "" {
+					fmt.Printf("version:   %s\n", strings.TrimSpace(st.Build.Version+" "+st.Build.Commit))
+				}
+				if st.Mode != "" {
+					fmt.Printf("mode:      %s\n", st.Mode)
+				}
+				for _, l := range st.Listeners {
+					fmt.Printf("listening: %s\n", l)
+				}
+				if len(st.Records) == 0 {
+					fmt.Println("token:     <none>")
+				}
+				for _, rec := range st.Records {
+					expires := "never"
+					if rec.Expires != nil {
+						expires = rec.Expires.Local().String()
+					}
+					fmt.Printf("token:     %q (expires %s)\n", rec.Value, expires)
+					if rec.SetAt != nil {
+						fmt.Printf("  set:     %s by %s\n", rec.SetAt.Local(), rec.SetBy)
+					}
+					if rec.QueriedAt != nil {
+						fmt.Printf("  queried: %s from %s (%d times)\n", rec.QueriedAt.Local(), rec.QueriedFrom, rec.Queries)
+					} else {
+						fmt.Println("  queried: never")
+					}
+				}
+				if st.LastQuery != nil {
+					fmt.Printf("last query: %s from %s (%d total)\n", st.LastQuery.Local(), st.LastQueryFrom, st.Queries)
+				} else {
+					fmt.Println("last query: never")
+				}
+				for _, k := range st.Keys {
+					last := "never"
+					if k.LastUpdate != nil {
+						last = k.LastUpdate.Local().String()
+					}
+					fmt.Printf("key:       %s queries=%d updates=%d refused=%d (last update %s)\n", k.Key, k.Queries, k.Updates, k.Refused, last)
+				}
+				return nil
+			},
+		},
+		{
+			Use:   "get",
+			Short: "Print the current challenge token",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var rec pajatso.Record
+				if err := controlRequest(*socket, "GET", "/record", nil, &rec); err != nil {
+					return err
+				}
+				fmt.Println(rec.Value)
+				return nil
+			},
+		},
+		{
+			Use:   "set <token>",
+			Short: "Set the challenge token",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return controlRequest(*socket, "PUT", "/record", map[string]string{"value": args[0]}, nil)
+			},
+		},
+		{
+			Use:   "delete",
+			Short: "Delete the challenge token",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return controlRequest(*socket, "DELETE", "/record", nil, nil)
+			},
+		},
+		{
+			Use:   "mode [normal|read-only|maintenance]",
+			Short: "Print or switch the operating mode, refusing updates when read-only and failing all but health queries in maintenance",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if len(args) == 1 {
+					return controlRequest(*socket, "PUT", "/mode", map[string]string{"mode": args[0]}, nil)
+				}
+				var resp struct {
+					Mode string `json:"mode"`
+				}
+				if err := controlRequest(*socket, "GET", "/mode", nil, &resp); err != nil {
+					return err
+				}
+				fmt.Println(resp.Mode)
+				return nil
+			},
+		},
+		{
+			Use:   "export-zone",
+			Short: "Print the records currently served in the zone as an RFC 1035 zone file",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return controlRequest(*socket, "GET", "/zone", nil, cmd.OutOrStdout())
+			},
+		},
+		{
+			Use:   "dump [file]",
+			Short: "Write the stored records, with their expiry times and activity, as JSON to file or stdout",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				var snap pajatso.Snapshot
+				if err := controlRequest(*socket, "GET", "/snapshot", nil, &snap); err != nil {
+					return err
+				}
+				if len(args) == 1 {
+					return writeSnapshot(args[0], snap)
+				}
+				return encodeSnapshot(cmd.OutOrStdout(), snap)
+			},
+		},
+		{
+			Use:   "restore [file]",
+			Short: "Add the records of a dump, read from file or stdin, to the store",
+			Args:  cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				r := cmd.InOrStdin()
+				if len(args) == 1 {
+					f, err := os.Open(args[0])
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					r = f
+				}
+				var snap pajatso.Snapshot
+				if err := json.NewDecoder(r).Decode(&snap); err != nil {
+					return fmt.Errorf("reading dump: %w", err)
+				}
+				return controlRequest(*socket, "PUT", "/snapshot", snap, nil)
+			},
+		},
+	}
+	for _, c := range cmds {
+		c.SilenceUsage = true // errors come from the server, not from bad usage
+	}
+	return cmds
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// withUmask runs fn. There is no file mode creation mask on this platform.
+func withUmask(mask int, fn func() error) error {
+	return fn()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// startTestControl serves the control socket of a fresh Server and returns
+// the socket path.
+func startTestControl(t *testing.T) (string, *pajatso.Store) {
+	t.Helper()
+
+	store := &pajatso.Store{}
+	srv := &pajatso.Server{Zone: testZone, Store: store}
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := listenControl(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{Handler: srv.ControlHandler()}
+	go hs.Serve(ln)
+	t.Cleanup(func() { hs.Close() })
+	return path, store
+}
+
+func TestControlSetGetDelete(t *testing.T) {
+	path, store := startTestControl(t)
+
+	if err := controlRequest(path, "PUT", "/record", map[string]string{"value": "control-token"}, nil); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if val, ok := store.Value(); !ok || val != "control-token" {
+		t.Fatalf("set: expected (control-token, true), got (%q, %v)", val, ok)
+	}
+
+	var st pajatso.ControlStatus
+	if err := controlRequest(path, "GET", "/status", nil, &st); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if st.ChallengeName != testChallenge || len(st.Records) != 1 || st.Records[0].Value != "control-token" || st.Records[0].SetBy != "control" {
+		t.Fatalf("status: unexpected %+v", st)
+	}
+	if st.Build != pajatso.ReadBuildInfo() {
+		t.Fatalf("status: expected the build info %+v, got %+v", pajatso.ReadBuildInfo(), st.Build)
+	}
+
+	if err := controlRequest(path, "DELETE", "/record", nil, nil); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := controlRequest(path, "GET", "/record", nil, &pajatso.Record{}); err == nil {
+		t.Fatal("get after delete: expected error")
+	}
+}
+
+func TestControlStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	// A socket left behind by a previous run must not prevent startup.
+	first, err := listenControl(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := listenControl(path, nil)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	second.Close()
+}
+
+func TestControlSocketDirectory(t *testing.T) {
+	// A missing directory is created for the user only.
+	path := filepath.Join(t.TempDir(), "run", "control.sock")
+	ln, err := listenControl(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	for name, want := range map[string]os.FileMode{filepath.Dir(path): 0o700, path: 0o600} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Fatalf("%s: expected mode %v, got %v", name, want, fi.Mode().Perm())
+		}
+	}
+
+	shared := t.TempDir()
+	if err := os.Chmod(shared, 0o1777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenControl(filepath.Join(shared, "control.sock"), nil); err == nil {
+		t.Fatal("expected a world-writable directory to be refused")
+	}
+
+	// Files that aren't sockets are not removed.
+	file := filepath.Join(t.TempDir(), "control.sock")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenControl(file, nil); err == nil {
+		t.Fatal("expected an existing file to be refused")
+	}
+}
+
+func TestControlMode(t *testing.T) {
+	path, _ := startTestControl(t)
+
+	if err := controlRequest(path, "PUT", "/mode", map[string]string{"mode": "read-only"}, nil); err != nil {
+		t.Fatalf("set mode: %v", err)
+	}
+	var st pajatso.ControlStatus
+	if err := controlRequest(path, "GET", "/status", nil, &st); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if st.Mode != pajatso.ModeReadOnly {
+		t.Fatalf("expected read-only, got %q", st.Mode)
+	}
+	if err := controlRequest(path, "PUT", "/mode", map[string]string{"mode": "off"}, nil); err == nil {
+		t.Fatal("expected an unknown mode to fail")
+	}
+}
+
+func TestControlDumpRestore(t *testing.T) {
+	path, store := startTestControl(t)
+	store.Add("first")
+	store.Add("second")
+
+	var snap pajatso.Snapshot
+	if err := controlRequest(path, "GET", "/snapshot", nil, &snap); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "dump.json")
+	if err := writeSnapshot(file, snap); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Clear()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var restored pajatso.Snapshot
+	if err := json.NewDecoder(f).Decode(&restored); err != nil {
+		t.Fatal(err)
+	}
+	if err := controlRequest(path, "PUT", "/snapshot", restored, nil); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if n := len(store.Entries()); n != 2 {
+		t.Fatalf("expected 2 restored values, got %d", n)
+	}
+}
+
+func TestControlExportZone(t *testing.T) {
+	path, store := startTestControl(t)
+	store.Add("zone-token")
+
+	var b strings.Builder
+	if err := controlRequest(path, "GET", "/zone", nil, &b); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if !strings.Contains(b.String(), "$ORIGIN "+testZone) || !strings.Contains(b.String(), `"zone-token"`) {
+		t.Fatalf("unexpected zone file:\n%s", b.String())
+	}
+}
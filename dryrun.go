@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// listenAddr is a listen address set with flag, checked by --dry-run.
+type listenAddr struct {
+	flag    string
+	network string // "udp" or "tcp"
+	addr    string
+}
+
+// check resolves the address and checks that the process may bind its
+// port, without binding it.
+func (l listenAddr) check() error {
+	var port int
+	switch l.network {
+	case "udp":
+		a, err := net.ResolveUDPAddr("udp", l.addr)
+		if err != nil {
+			return fmt.Errorf("--%s %s: %w", l.flag, l.addr, err)
+		}
+		port = a.Port
+	default:
+		a, err := net.ResolveTCPAddr("tcp", l.addr)
+		if err != nil {
+			return fmt.Errorf("--%s %s: %w", l.flag, l.addr, err)
+		}
+		port = a.Port
+	}
+	if !canBind(port) {
+		return fmt.Errorf("--%s %s: binding port %d needs root or CAP_NET_BIND_SERVICE", l.flag, l.addr, port)
+	}
+	return nil
+}
+
+// keyPair is a TLS certificate and key set with the flags of a listener,
+// checked by --dry-run.
+type keyPair struct {
+	flag      string
+	cert, key string
+}
+
+func (k keyPair) check() error {
+	if _, err := tls.LoadX509KeyPair(k.cert, k.key); err != nil {
+		return fmt.Errorf("--%s: loading TLS key pair: %w", k.flag, err)
+	}
+	return nil
+}
+
+// printConfig writes the effective configuration of srv and its tenants,
+// after defaults and normalization, leaving out secrets.
+func printConfig(w io.Writer, srv *pajatso.Server, tenants []*pajatso.Server, listeners []listenAddr) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	line := func(key string, value any) {
+		fmt.Fprintf(tw, "%s:\t%v\n", key, value)
+	}
+	zone := func(s *pajatso.Server) {
+		line("zone", s.Zone)
+		line("challenge", s.ChallengeName())
+		if s.TsigName != "" {
+			line("tsig key", s.TsigName+" ("+strings.TrimSuffix(s.TsigAlg, ".")+")")
+		} else {
+			line("tsig key", "none, updates refused")
+		}
+		for _, g := range s.UpdatePolicy {
+			line("update policy", g)
+		}
+		for _, p := range s.UpdateAllowKey[s.TsigName] {
+			line("update allow", p)
+		}
+	}
+
+	zone(srv)
+	if len(srv.NS) > 0 {
+		line("name servers", strings.Join(srv.NS, " "))
+		line("authority ns", srv.AuthorityNS)
+		line("negative ttl", srv.NegativeTTL)
+	}
+	line("nxdomain", srv.NXDomain)
+	ttl := "never expire"
+	if srv.Store.TTL > 0 {
+		ttl = srv.Store.TTL.String()
+	}
+	line("token ttl", ttl)
+	line("delete grace", srv.Store.DeleteGrace)
+	line("strict tokens", srv.StrictTokens)
+	line("generic txt", srv.GenericTXT)
+	for _, l := range listeners {
+		line(l.flag, l.network+" "+l.addr)
+	}
+	for _, t := range tenants {
+		fmt.Fprintln(tw)
+		zone(t)
+	}
+	return tw.Flush()
+}
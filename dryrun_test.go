@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+func TestPrintConfig(t *testing.T) {
+	srv, err := pajatso.NewServer(pajatso.Config{
+		Zone:       "example.com",
+		TsigName:   "acme-update",
+		TsigSecret: "c2VjcmV0LWtleQ==",
+		NS:         []string{"ns1.example.net"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners := []listenAddr{{"listen", "udp", "127.0.0.1:0"}, {"listen", "tcp", "127.0.0.1:0"}}
+	for _, l := range listeners {
+		if err := l.check(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b strings.Builder
+	if err := printConfig(&b, srv, nil, listeners); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{"example.com.", "_acme-challenge.example.com.", "acme-update. (hmac-sha512)", "ns1.example.net.", "udp 127.0.0.1:0"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "c2VjcmV0LWtleQ==") {
+		t.Errorf("output contains the secret:\n%s", out)
+	}
+
+	if err := (listenAddr{"api-listen", "tcp", "127.0.0.1:http-nonexistent"}).check(); err == nil {
+		t.Error("expected an error for an unresolvable port")
+	}
+}
+
+func TestServeDryRunTenantAllowKey(t *testing.T) {
+	var controlSocket string
+	cmd := serveCommand(&controlSocket)
+	var out strings.Builder
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{
+		"--dry-run", "--listen", "127.0.0.1:0",
+		"--zone", "example.com", "--tsig-name", "acme-update", "--tsig-secret", "c2VjcmV0LWtleQ==",
+		"--tenant", "example.net=tenant-update:dGVuYW50LWtleQ==",
+		"--update-allow-key", "acme-update=192.0.2.0/24",
+		"--update-allow-key", "tenant-update.=198.51.100.0/24",
+	})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	// Each zone lists the allowlist of its own key only.
+	apex, tenant, ok := strings.Cut(out.String(), "\n\n")
+	if !ok {
+		t.Fatalf("expected a tenant section:\n%s", out.String())
+	}
+	if !strings.Contains(apex, "192.0.2.0/24") || strings.Contains(apex, "198.51.100.0/24") {
+		t.Errorf("main zone allowlist wrong:\n%s", apex)
+	}
+	if !strings.Contains(tenant, "198.51.100.0/24") || strings.Contains(tenant, "192.0.2.0/24") {
+		t.Errorf("tenant zone allowlist wrong:\n%s", tenant)
+	}
+
+	cmd = serveCommand(&controlSocket)
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs([]string{
+		"--dry-run", "--listen", "127.0.0.1:0",
+		"--zone", "example.com", "--tsig-name", "acme-update", "--tsig-secret", "c2VjcmV0LWtleQ==",
+		"--update-allow-key", "other-update=192.0.2.0/24",
+	})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "unknown TSIG key") {
+		t.Fatalf("expected an unknown key error, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// encodeSnapshot writes snap to w as indented JSON.
+func encodeSnapshot(w io.Writer, snap pajatso.Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snap)
+}
+
+// writeSnapshot writes snap to the file at path, replacing it atomically so
+// that a dump is never read half-written. The file is only readable by the
+// current user, as it holds the challenge tokens.
+func writeSnapshot(path string, snap pajatso.Snapshot) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing dump: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if err := encodeSnapshot(f, snap); err != nil {
+		f.Close()
+		return fmt.Errorf("writing dump: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing dump: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("writing dump: %w", err)
+	}
+	return nil
+}
+
+// dumpOnSignal writes a snapshot of srv to path, or to stdout if empty,
+// whenever the process receives SIGUSR1, until done is closed.
+func dumpOnSignal(srv *pajatso.Server, path string, done <-chan struct{}) {
+	sig := make(chan os.Signal, 1)
+	if !notifyDump(sig) {
+		return
+	}
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-sig:
+			case <-done:
+				return
+			}
+			snap := srv.Snapshot()
+			var err error
+			if path != "" {
+				err = writeSnapshot(path, snap)
+			} else {
+				err = encodeSnapshot(os.Stdout, snap)
+			}
+			if err != nil {
+				slog.Error("dump failed", "err", err)
+				continue
+			}
+			slog.Info("dumped store", "records", len(snap.Records), "file", path)
+		}
+	}()
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyDump is not supported on this platform, which lacks SIGUSR1.
+func notifyDump(c chan<- os.Signal) bool {
+	return false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// secretEnv is the environment variable the TSIG secret is read from when
+// no flag gives it, keeping it out of the process list.
+const secretEnv = "PAJATSO_TSIG_SECRET"
+
+// zoneFlags are the flags naming the zone and its challenge record, shared
+// by serve and the subcommands talking to a server.
+type zoneFlags struct {
+	zone      string
+	subdomain string
+}
+
+func (f *zoneFlags) register(fs *pflag.FlagSet) {
+	fs.StringVar(&f.zone, "zone", "", "DNS zone (e.g. example.com.)")
+	fs.StringVar(&f.subdomain, "subdomain", "", "Subdomain prefix for the challenge record (e.g. sub for _acme-challenge.sub.example.com.)")
+}
+
+// keyFlags are the flags of the TSIG key and the source of its secret,
+// shared by serve and the subcommands signing updates.
+type keyFlags struct {
+	name       string
+	algorithm  string
+	secret     string
+	file       string
+	ref        string
+	vault      string
+	vaultToken string
+	aws        string
+}
+
+func (f *keyFlags) register(fs *pflag.FlagSet) {
+	fs.StringVar(&f.name, "tsig-name", "", "TSIG key name (e.g. acme-update.)")
+	fs.StringVar(&f.algorithm, "tsig-algorithm", "hmac-sha512", "TSIG algorithm ("+tsigAlgorithmNames()+")")
+	fs.StringVar(&f.secret, "tsig-secret", "", "Base64 HMAC secret (default: "+secretEnv+")")
+	fs.StringVar(&f.file, "tsig-secret-file", "", "Read the base64 TSIG secret from this file, e.g. a systemd credential, instead of --tsig-secret")
+	fs.StringVar(&f.ref, "tsig-secret-ref", "", "Read the TSIG secret from a Kubernetes Secret (namespace/name/key), following rotations when serving, instead of --tsig-secret")
+	fs.StringVar(&f.vault, "tsig-secret-vault", "", "Read the TSIG secret from a field of a Vault KV secret (path#field, e.g. secret/data/dns-pajatso#tsig) at VAULT_ADDR, following rotations when serving, instead of --tsig-secret")
+	fs.StringVar(&f.vaultToken, "vault-token-file", "", "Vault token file, e.g. written by Vault Agent (default: VAULT_TOKEN, renewed periodically)")
+	fs.StringVar(&f.aws, "tsig-secret-aws", "", "Read the TSIG secret from AWS Secrets Manager or the SSM Parameter Store by ARN (optionally ARN#key for a JSON secret) with the default AWS credentials, following rotations when serving, instead of --tsig-secret")
+}
+
+// given reports whether a source of the secret is given.
+func (f *keyFlags) given() bool {
+	return f.secret != "" || f.file != "" || f.ref != "" || f.vault != "" || f.aws != "" || os.Getenv(secretEnv) != ""
+}
+
+// secretSource is where the secret of a keyFlags was read from, for serve
+// to follow its rotations. Its clients are nil for the other sources.
+type secretSource struct {
+	kube       *kubeClient
+	ref        secretRef
+	vault      *vaultClient
+	vref       vaultRef
+	aws        *awsClient
+	aref       awsRef
+	awsVersion string
+}
+
+// resolve reads the TSIG secret from the one source given by the flags, or
+// from the environment if none is.
+func (f *keyFlags) resolve(ctx context.Context) (string, secretSource, error) {
+	var src secretSource
+	n := len(slices.DeleteFunc([]string{f.secret, f.file, f.ref, f.vault, f.aws}, func(s string) bool { return s == "" }))
+	if n > 1 {
+		return "", src, fmt.Errorf("only one of --tsig-secret, --tsig-secret-file, --tsig-secret-ref, --tsig-secret-vault and --tsig-secret-aws may be given")
+	}
+	if n == 0 {
+		if secret := os.Getenv(secretEnv); secret != "" {
+			return secret, src, nil
+		}
+		return "", src, fmt.Errorf("one of --tsig-secret, --tsig-secret-file, --tsig-secret-ref, --tsig-secret-vault, --tsig-secret-aws and %s is required", secretEnv)
+	}
+
+	var (
+		secret string
+		err    error
+	)
+	switch {
+	case f.secret != "":
+		secret = f.secret
+	case f.file != "":
+		var b []byte
+		if b, err = os.ReadFile(f.file); err != nil {
+			return "", src, fmt.Errorf("--tsig-secret-file: %w", err)
+		}
+		secret = strings.TrimSpace(string(b))
+	case f.ref != "":
+		if src.ref, err = parseSecretRef(f.ref); err != nil {
+			return "", src, err
+		}
+		if src.kube, err = inClusterClient(); err != nil {
+			return "", src, err
+		}
+		secret, _, err = src.ref.read(ctx, src.kube)
+	case f.vault != "":
+		if src.vref, err = parseVaultRef(f.vault); err != nil {
+			return "", src, err
+		}
+		if src.vault, err = vaultFromEnv(f.vaultToken); err != nil {
+			return "", src, err
+		}
+		secret, err = src.vref.read(ctx, src.vault)
+	case f.aws != "":
+		if src.aref, err = parseAWSRef(f.aws); err != nil {
+			return "", src, err
+		}
+		if src.aws, err = newAWSClient(ctx, src.aref.Region); err != nil {
+			return "", src, err
+		}
+		secret, src.awsVersion, err = src.aref.read(ctx, src.aws)
+	}
+	return secret, src, err
+}
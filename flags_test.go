@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyFlagsResolve(t *testing.T) {
+	t.Setenv(secretEnv, "")
+	if (&keyFlags{}).given() {
+		t.Fatal("expected no secret to be given")
+	}
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(file, []byte("c2VjcmV0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		flags keyFlags
+		env   string
+		want  string
+		fails bool
+	}{
+		{name: "flag", flags: keyFlags{secret: "ZmxhZw=="}, want: "ZmxhZw=="},
+		{name: "file", flags: keyFlags{file: file}, want: "c2VjcmV0"},
+		{name: "env", env: "ZW52", want: "ZW52"},
+		{name: "flag over env", flags: keyFlags{secret: "ZmxhZw=="}, env: "ZW52", want: "ZmxhZw=="},
+		{name: "none", fails: true},
+		{name: "two", flags: keyFlags{secret: "ZmxhZw==", file: file}, fails: true},
+		{name: "missing file", flags: keyFlags{file: file + ".missing"}, fails: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(secretEnv, tc.env)
+			got, _, err := tc.flags.resolve(context.Background())
+			if tc.fails {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUpdateCommandSecretFromEnv(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+
+	t.Setenv(secretEnv, testTsigSecret)
+	cmd := updateCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"--server", addr, "--zone", testZone, "--tsig-name", testTsigName, "--set", "token"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := store.Value(); !ok || val != "token" {
+		t.Fatalf("expected token to be set, got (%q, %v)", val, ok)
+	}
+}
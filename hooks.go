@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// runHook runs argv with the record name and value in its environment and
+// returns an error including the command's output if it fails.
+func runHook(ctx context.Context, argv []string, env []string) error {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// hookQueueSize is the number of hook runs of a zone waiting behind the one
+// running. Further events are dropped, so that a hanging command can't pile
+// up processes.
+const hookQueueSize = 64
+
+// hooks runs the commands configured for the store events of a zone, one at
+// a time in the order of the events, so that the set and delete hooks of a
+// value never run concurrently or out of order. Commands are split on white
+// space and run without a shell, with PAJATSO_OP, PAJATSO_ZONE, PAJATSO_NAME,
+// PAJATSO_VALUE and PAJATSO_REQUEST, the ID of the request making the change
+// if any, set. They are killed after timeout.
+type hooks struct {
+	s        *pajatso.Server
+	commands map[pajatso.Op][]string // argv by event type
+	timeout  time.Duration
+
+	start    sync.Once
+	queue    chan hookRun
+	dropping atomic.Bool // runs are being dropped, logged once per run of drops
+}
+
+// hookRun is a queued run of a hook command.
+type hookRun struct {
+	op   pajatso.Op
+	argv []string
+	env  []string
+}
+
+// newHooks returns the hooks of s running the commands by event type,
+// skipping empty ones.
+func newHooks(s *pajatso.Server, commands map[pajatso.Op]string, timeout time.Duration) *hooks {
+	h := &hooks{s: s, commands: map[pajatso.Op][]string{}, timeout: timeout}
+	for op, command := range commands {
+		if argv := strings.Fields(command); len(argv) > 0 {
+			h.commands[op] = argv
+		}
+	}
+	return h
+}
+
+// storeEvent is a Store subscriber queueing the command for the event, if
+// any.
+func (h *hooks) storeEvent(e pajatso.Event) {
+	argv, ok := h.commands[e.Op]
+	if !ok {
+		return
+	}
+	h.start.Do(func() {
+		h.queue = make(chan hookRun, hookQueueSize)
+		go h.run()
+	})
+	run := hookRun{op: e.Op, argv: argv, env: []string{
+		"PAJATSO_OP=" + string(e.Op),
+		"PAJATSO_ZONE=" + h.s.Zone,
+		"PAJATSO_NAME=" + e.Name,
+		"PAJATSO_VALUE=" + e.Value,
+		"PAJATSO_REQUEST=" + e.Request,
+	}}
+	select {
+	case h.queue <- run:
+		h.dropping.Store(false)
+	default:
+		if !h.dropping.Swap(true) {
+			slog.Warn("hook queue full, dropping hook runs", "zone", h.s.Zone, "op", e.Op)
+		}
+	}
+}
+
+// run runs the queued commands one after another.
+func (h *hooks) run() {
+	for r := range h.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+		err := runHook(ctx, r.argv, r.env)
+		cancel()
+		if err != nil {
+			slog.Warn("hook failed", "op", r.op, "zone", h.s.Zone, "command", r.argv[0], "err", err)
+			continue
+		}
+		slog.Info("hook ran", "op", r.op, "zone", h.s.Zone, "command", r.argv[0])
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+func TestRunHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	err := runHook(context.Background(), []string{"sh", "-c", `echo "$PAJATSO_NAME $PAJATSO_VALUE" > ` + out},
+		[]string{"PAJATSO_NAME=" + testChallenge, "PAJATSO_VALUE=token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), testChallenge+" token\n"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRunHookFailure(t *testing.T) {
+	err := runHook(context.Background(), []string{"sh", "-c", "echo oops; exit 3"}, nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := err.Error(); got != "exit status 3: oops" {
+		t.Fatalf("unexpected error %q", got)
+	}
+}
+
+func TestHookOnlyMatchingOp(t *testing.T) {
+	dir := t.TempDir()
+	srv := &pajatso.Server{Zone: testZone, Store: &pajatso.Store{}}
+	h := newHooks(srv, map[pajatso.Op]string{pajatso.OpDelete: "touch " + filepath.Join(dir, "deleted")}, time.Second)
+	srv.Store.Subscribe(h.storeEvent)
+
+	srv.Store.Replace("token")
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(filepath.Join(dir, "deleted")); err == nil {
+		t.Fatal("delete hook ran on set")
+	}
+
+	srv.Store.Clear()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "deleted")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("delete hook did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHooksRunInOrder(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	// The set hook is slower, so run concurrently it would finish last.
+	script := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(script, []byte(`[ "$PAJATSO_OP" = set ] && sleep 0.2; echo "$PAJATSO_OP" >> `+out+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := &pajatso.Server{Zone: testZone, Store: &pajatso.Store{}}
+	h := newHooks(srv, map[pajatso.Op]string{pajatso.OpSet: "sh " + script, pajatso.OpDelete: "sh " + script}, 5*time.Second)
+	srv.Store.Subscribe(h.storeEvent)
+
+	srv.Store.Replace("token")
+	srv.Store.Clear()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, _ := os.ReadFile(out)
+		if got := string(b); strings.Count(got, "\n") == 2 {
+			if got != "set\ndelete\n" {
+				t.Fatalf("expected the set hook to run before the delete hook, got %q", got)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hooks did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+	"golang.org/x/crypto/acme"
+)
+
+// letsEncryptDirectory is the default ACME directory of the issue subcommand.
+const letsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+// loadOrCreateKey reads a PEM-encoded EC private key from path, generating
+// and saving a new P-256 key if the file does not exist.
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeKeyFile(path, key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// writeKeyFile writes key to path in PEM format, readable only by the owner.
+func writeKeyFile(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+// writeCertFile writes the DER-encoded certificate chain to path in PEM format.
+func writeCertFile(path string, chain [][]byte) error {
+	var b []byte
+	for _, der := range chain {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// challengeRecord publishes the challenge record for issue.
+type challengeRecord interface {
+	set(ctx context.Context, value string) error
+	clear(ctx context.Context) error
+}
+
+// storeRecord publishes the challenge record by setting the Store directly.
+type storeRecord struct {
+	store *pajatso.Store
+	name  string
+}
+
+func (r storeRecord) set(_ context.Context, value string) error {
+	r.store.Set(r.name, value, 0)
+	return nil
+}
+
+func (r storeRecord) clear(context.Context) error {
+	r.store.Delete(r.name, "")
+	return nil
+}
+
+// issue orders a certificate for domains from client, answering the DNS-01
+// challenges through rec, and returns the DER-encoded chain for key.
+//
+// All domains share the same challenge record, so their authorizations are
+// validated one after the other.
+func issue(ctx context.Context, client *acme.Client, rec challengeRecord, domains []string, key crypto.Signer) ([][]byte, error) {
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, fmt.Errorf("creating order: %w", err)
+	}
+
+	for _, url := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, url)
+		if err != nil {
+			return nil, fmt.Errorf("fetching authorization: %w", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var chal *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				chal = c
+				break
+			}
+		}
+		if chal == nil {
+			return nil, fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+		}
+
+		value, err := client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return nil, err
+		}
+		if err := rec.set(ctx, value); err != nil {
+			return nil, fmt.Errorf("publishing challenge for %s: %w", authz.Identifier.Value, err)
+		}
+		slog.Info("issue: set _acme-challenge TXT", "domain", authz.Identifier.Value, "wildcard", authz.Wildcard)
+
+		if _, err := client.Accept(ctx, chal); err != nil {
+			return nil, fmt.Errorf("accepting challenge for %s: %w", authz.Identifier.Value, err)
+		}
+		if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+			return nil, fmt.Errorf("validating %s: %w", authz.Identifier.Value, err)
+		}
+		slog.Info("issue: authorization valid", "domain", authz.Identifier.Value)
+	}
+	if err := rec.clear(ctx); err != nil {
+		return nil, fmt.Errorf("cleaning up challenge record: %w", err)
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for order: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: domains}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("finalizing order: %w", err)
+	}
+	return chain, nil
+}
+
+// newACMEClient returns a client of the ACME directory, trusting the CA
+// bundle directoryCA for its TLS certificate if not empty.
+func newACMEClient(key crypto.Signer, directory, directoryCA string) (*acme.Client, error) {
+	client := &acme.Client{Key: key, DirectoryURL: directory, UserAgent: "dns-pajatso"}
+	if directoryCA != "" {
+		pem, err := os.ReadFile(directoryCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading directory CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", directoryCA)
+		}
+		client.HTTPClient = &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}}
+	}
+	return client, nil
+}
+
+// issueCommand returns the issue subcommand.
+func issueCommand() *cobra.Command {
+	var (
+		zoneOpts    zoneFlags
+		listen      string
+		directory   string
+		directoryCA string
+		email       string
+		accountKey  string
+		certOut     string
+		keyOut      string
+		wildcard    bool
+		timeout     time.Duration
+		agreeTOS    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "issue",
+		Short: "Serve the zone and obtain a certificate via ACME DNS-01, then exit",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !agreeTOS {
+				return fmt.Errorf("--agree-tos is required to register an account, after reading the terms of service of the CA at %s", directory)
+			}
+			srv, err := pajatso.NewServer(pajatso.Config{Zone: zoneOpts.zone, Subdomain: zoneOpts.subdomain})
+			if err != nil {
+				return err
+			}
+			domain := strings.TrimSuffix(strings.TrimPrefix(srv.ChallengeName(), "_acme-challenge."), ".")
+			domains := []string{domain}
+			if wildcard {
+				domains = append(domains, "*."+domain)
+			}
+
+			acctKey, err := loadOrCreateKey(accountKey)
+			if err != nil {
+				return fmt.Errorf("account key: %w", err)
+			}
+			certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				return err
+			}
+
+			client, err := newACMEClient(acctKey, directory, directoryCA)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			udpServer := srv.NewDNSServer()
+			udpServer.Addr = listen
+			udpServer.Net = "udp"
+			tcpServer := srv.NewDNSServer()
+			tcpServer.Addr = listen
+			tcpServer.Net = "tcp"
+
+			errCh := make(chan error, 2)
+			go func() { errCh <- udpServer.ListenAndServe() }()
+			go func() { errCh <- tcpServer.ListenAndServe() }()
+			defer udpServer.Shutdown(context.Background())
+			defer tcpServer.Shutdown(context.Background())
+			slog.Info("server started", "zone", srv.Zone, "record", srv.ChallengeName(), "listen", listen)
+
+			var contact []string
+			if email != "" {
+				contact = []string{"mailto:" + email}
+			}
+			if _, err := client.Register(ctx, &acme.Account{Contact: contact}, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+				return fmt.Errorf("registering account: %w", err)
+			}
+
+			type result struct {
+				chain [][]byte
+				err   error
+			}
+			resCh := make(chan result, 1)
+			go func() {
+				chain, err := issue(ctx, client, storeRecord{srv.Store, srv.ChallengeName()}, domains, certKey)
+				resCh <- result{chain, err}
+			}()
+
+			var res result
+			select {
+			case err := <-errCh:
+				return fmt.Errorf("server error: %w", err)
+			case res = <-resCh:
+			}
+			if res.err != nil {
+				return res.err
+			}
+
+			if err := writeKeyFile(keyOut, certKey); err != nil {
+				return err
+			}
+			if err := writeCertFile(certOut, res.chain); err != nil {
+				return err
+			}
+			slog.Info("issue: certificate written", "domains", domains, "cert", certOut, "key", keyOut)
+			return nil
+		},
+	}
+	cmd.SilenceUsage = true
+
+	zoneOpts.register(cmd.Flags())
+	cmd.Flags().StringVar(&listen, "listen", ":53", "Listen address")
+	cmd.Flags().StringVar(&directory, "directory", letsEncryptDirectory, "ACME directory URL (e.g. Let's Encrypt staging or Pebble)")
+	cmd.Flags().StringVar(&directoryCA, "directory-ca", "", "CA bundle for verifying the ACME directory's TLS certificate (e.g. Pebble's)")
+	cmd.Flags().StringVar(&email, "email", "", "Contact email of the ACME account (optional)")
+	cmd.Flags().StringVar(&accountKey, "account-key", "account.key", "ACME account key file, created if missing")
+	cmd.Flags().StringVar(&certOut, "cert-out", "cert.pem", "Output file for the certificate chain")
+	cmd.Flags().StringVar(&keyOut, "key-out", "key.pem", "Output file for the certificate private key")
+	cmd.Flags().BoolVar(&wildcard, "wildcard", false, "Also include the wildcard name in the certificate")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "Give up if the certificate has not been issued within this time")
+	cmd.Flags().BoolVar(&agreeTOS, "agree-tos", false, "Agree to the terms of service of the ACME CA, required to register an account")
+	cmd.MarkFlagRequired("zone")
+
+	return cmd
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "account.key")
+
+	key, err := loadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode 0600, got %v", fi.Mode().Perm())
+	}
+
+	again, err := loadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(again) {
+		t.Fatal("expected the saved key to be loaded again")
+	}
+}
+
+func TestLoadOrCreateKeyInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "account.key")
+	if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrCreateKey(path); err == nil {
+		t.Fatal("expected an error for a file without PEM data")
+	}
+}
+
+func TestIssueRequiresAgreeTOS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "account.key")
+	cmd := issueCommand()
+	cmd.SetArgs([]string{"--zone", testZone, "--account-key", path})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "--agree-tos") {
+		t.Fatalf("expected an error asking for --agree-tos, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no account key to be created, got %v", err)
+	}
+}
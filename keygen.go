@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+// tsigKeySizes maps the supported TSIG algorithms to the size of generated
+// secrets, matching each algorithm's output size.
+var tsigKeySizes = map[string]int{
+	dns.HmacSHA256: 32,
+	dns.HmacSHA384: 48,
+	dns.HmacSHA512: 64,
+}
+
+// tsigAlgorithmNames returns the supported TSIG algorithms for help texts.
+func tsigAlgorithmNames() string {
+	names := make([]string, 0, len(tsigKeySizes))
+	for alg := range tsigKeySizes {
+		names = append(names, strings.TrimSuffix(alg, "."))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// keygen returns a new random base64-encoded secret for algorithm.
+func keygen(algorithm string) (string, error) {
+	size, ok := tsigKeySizes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unsupported algorithm %q (supported: %s)", strings.TrimSuffix(algorithm, "."), tsigAlgorithmNames())
+	}
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(secret), nil
+}
+
+// writeKey prints the key as dns-pajatso flags, a BIND key statement and
+// certbot-dns-rfc2136 credentials.
+func writeKey(w io.Writer, name, algorithm, secret, server string) {
+	alg := strings.TrimSuffix(algorithm, ".")
+
+	fmt.Fprintf(w, "# dns-pajatso flags\n")
+	fmt.Fprintf(w, "--tsig-name=%s --tsig-algorithm=%s --tsig-secret=%s\n\n", name, alg, secret)
+
+	fmt.Fprintf(w, "# BIND key statement (named.conf, nsupdate -k)\n")
+	fmt.Fprintf(w, "key \"%s\" {\n\talgorithm %s;\n\tsecret \"%s\";\n};\n\n", name, alg, secret)
+
+	fmt.Fprintf(w, "# certbot-dns-rfc2136 credentials INI\n")
+	fmt.Fprintf(w, "dns_rfc2136_server = %s\n", server)
+	fmt.Fprintf(w, "dns_rfc2136_port = 53\n")
+	fmt.Fprintf(w, "dns_rfc2136_name = %s\n", name)
+	fmt.Fprintf(w, "dns_rfc2136_secret = %s\n", secret)
+	fmt.Fprintf(w, "dns_rfc2136_algorithm = %s\n", strings.ToUpper(alg))
+}
+
+// keygenCommand returns the keygen subcommand.
+func keygenCommand() *cobra.Command {
+	var (
+		name      string
+		algorithm string
+		server    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a random TSIG secret and print client configuration for it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			algorithm = ensureFQDN(strings.ToLower(algorithm))
+			secret, err := keygen(algorithm)
+			if err != nil {
+				return err
+			}
+			writeKey(cmd.OutOrStdout(), ensureFQDN(name), algorithm, secret, server)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "acme-update.", "TSIG key name")
+	cmd.Flags().StringVar(&algorithm, "algorithm", "hmac-sha512", "TSIG algorithm ("+tsigAlgorithmNames()+")")
+	cmd.Flags().StringVar(&server, "server", "<dns-pajatso address>", "Server address written to the certbot credentials")
+
+	return cmd
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestKeygen(t *testing.T) {
+	for alg, size := range tsigKeySizes {
+		secret, err := keygen(alg)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		raw, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil || len(raw) != size {
+			t.Fatalf("%s: expected %d byte secret, got %d (%v)", alg, size, len(raw), err)
+		}
+	}
+
+	if _, err := keygen(dns.HmacSHA1); err == nil {
+		t.Fatal("expected hmac-sha1 to be rejected")
+	}
+}
+
+func TestWriteKey(t *testing.T) {
+	var buf bytes.Buffer
+	writeKey(&buf, testTsigName, dns.HmacSHA256, "c2VjcmV0", "192.0.2.1")
+	out := buf.String()
+
+	for _, want := range []string{
+		"--tsig-name=acme-update. --tsig-algorithm=hmac-sha256 --tsig-secret=c2VjcmV0",
+		"key \"acme-update.\" {\n\talgorithm hmac-sha256;\n\tsecret \"c2VjcmV0\";\n};",
+		"dns_rfc2136_server = 192.0.2.1",
+		"dns_rfc2136_algorithm = HMAC-SHA256",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// serviceAccountDir holds the credentials of the pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient is a minimal client for the Kubernetes API, just enough to get
+// and watch a few resources without pulling in client-go.
+type kubeClient struct {
+	base      string // API server URL
+	tokenFile string // bearer token, re-read on every request as it is rotated
+	client    *http.Client
+}
+
+// kubeError is a non-success response of the API server.
+type kubeError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *kubeError) Error() string {
+	return fmt.Sprintf("kubernetes API: %s (%d)", e.Message, e.Code)
+}
+
+// inClusterClient returns a client using the pod's service account.
+func inClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster (KUBERNETES_SERVICE_HOST is not set)")
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in the service account CA")
+	}
+	return &kubeClient{
+		base:      "https://" + net.JoinHostPort(host, port),
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		}},
+	}, nil
+}
+
+// do performs a GET request for path and returns the response if successful.
+func (k *kubeClient) do(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", k.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		e := &kubeError{Code: resp.StatusCode, Message: resp.Status}
+		json.NewDecoder(resp.Body).Decode(e)
+		return nil, e
+	}
+	return resp, nil
+}
+
+// get decodes the object at path into out.
+func (k *kubeClient) get(ctx context.Context, path string, out any) error {
+	resp, err := k.do(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// watchEvent is an event of a watch stream. For ERROR events, Object is a
+// Status, e.g. 410 Gone once resourceVersion is too old.
+type watchEvent struct {
+	Type   string          `json:"type"` // ADDED, MODIFIED, DELETED, BOOKMARK or ERROR
+	Object json.RawMessage `json:"object"`
+}
+
+// watch calls fn for every change to the collection at path (which may
+// carry a selector query) after
+// resourceVersion, until the server ends the stream, ctx is done or fn
+// returns an error.
+func (k *kubeClient) watch(ctx context.Context, path, resourceVersion string, fn func(watchEvent) error) error {
+	q := url.Values{"watch": {"true"}, "allowWatchBookmarks": {"true"}, "resourceVersion": {resourceVersion}}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	resp, err := k.do(ctx, path+sep+q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e watchEvent
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestKubeClient returns a client for the fake API server h, authenticating
+// with the token "test-token".
+func newTestKubeClient(t *testing.T, h http.Handler) *kubeClient {
+	t.Helper()
+
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("test-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &kubeClient{base: ts.URL, tokenFile: tokenFile, client: ts.Client()}
+}
+
+func TestKubeGet(t *testing.T) {
+	k := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if r.URL.Path != "/api/v1/namespaces/default/secrets/tsig" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind": "Status", "code": 404, "message": "secrets \"other\" not found"}`)
+			return
+		}
+		fmt.Fprint(w, `{"data": {"secret": "c2VjcmV0"}}`)
+	}))
+
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := k.get(context.Background(), "/api/v1/namespaces/default/secrets/tsig", &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["secret"] != "c2VjcmV0" {
+		t.Errorf("data = %v", secret.Data)
+	}
+
+	err := k.get(context.Background(), "/api/v1/namespaces/default/secrets/other", &secret)
+	var kerr *kubeError
+	if !errors.As(err, &kerr) || kerr.Code != http.StatusNotFound || kerr.Message != `secrets "other" not found` {
+		t.Errorf("err = %v, want 404 status", err)
+	}
+}
+
+func TestKubeWatch(t *testing.T) {
+	k := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("watch") != "true" || q.Get("resourceVersion") != "42" {
+			t.Errorf("query = %v", q)
+		}
+		fmt.Fprintln(w, `{"type": "ADDED", "object": {"metadata": {"name": "a"}}}`)
+		fmt.Fprintln(w, `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "43"}}}`)
+		fmt.Fprintln(w, `{"type": "DELETED", "object": {"metadata": {"name": "a"}}}`)
+	}))
+
+	var types []string
+	err := k.watch(context.Background(), "/apis/test/v1/things", "42", func(e watchEvent) error {
+		types = append(types, e.Type)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(types) != "[ADDED BOOKMARK DELETED]" {
+		t.Errorf("events = %v", types)
+	}
+
+	// An error returned by fn ends the watch.
+	stop := errors.New("stop")
+	n := 0
+	err = k.watch(context.Background(), "/apis/test/v1/things", "42", func(e watchEvent) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("err = %v after %d events, want stop after 1", err, n)
+	}
+}
@@ -1,16 +1,14 @@
 package main
 
 import (
-	"context"
-	"fmt"
 	"log"
 	"log/slog"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
 )
 
 // ensureFQDN appends a trailing dot if missing.
@@ -21,6 +19,16 @@ func ensureFQDN(s string) string {
 	return s
 }
 
+// asciiFQDN returns the FQDN of the name s given on the command line, with
+// A-labels if it is internationalized.
+func asciiFQDN(s string) (string, error) {
+	ascii, err := pajatso.ToASCII(s)
+	if err != nil {
+		return "", err
+	}
+	return ensureFQDN(ascii), nil
+}
+
 func main() {
 	// Log to /dev/kmsg so messages appear in dmesg.
 	if kmsg, err := os.OpenFile("/dev/kmsg", os.O_WRONLY, 0); err == nil {
@@ -28,72 +36,41 @@ func main() {
 		log.SetPrefix("[dns-pajatso] ")
 	}
 
-	var (
-		zone       string
-		subdomain  string
-		tsigName   string
-		tsigSecret string
-		listen     string
-	)
+	var controlSocket string
+
+	// Tag the log records of requests with their IDs. The default handler
+	// writes through the log package, which SetDefault points at slog in
+	// turn: point it back at its output.
+	out, flags := log.Writer(), log.Flags()
+	slog.SetDefault(slog.New(pajatso.LogHandler(slog.Default().Handler())))
+	log.SetOutput(out)
+	log.SetFlags(flags)
 
+	serve := serveCommand(&controlSocket)
+
+	// The bare invocation serves as well, taking the flags of serve without
+	// listing them in its help.
 	cmd := &cobra.Command{
 		Use:   "dns-pajatso",
 		Short: "Minimal DNS server for ACME DNS-01 challenges",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// Normalize DNS names.
-			zone = ensureFQDN(zone)
-			subdomain = strings.TrimRight(subdomain, ".")
-			tsigName = ensureFQDN(tsigName)
-
-			srv := &Server{
-				Zone:       zone,
-				Subdomain:  subdomain,
-				TsigName:   tsigName,
-				TsigSecret: tsigSecret,
-				Store:      &Store{},
-			}
-
-			// Set up signal handling.
-			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
-			defer stop()
-
-			// Start UDP server.
-			udpServer := srv.NewDNSServer()
-			udpServer.Addr = listen
-			udpServer.Net = "udp"
-
-			// Start TCP server.
-			tcpServer := srv.NewDNSServer()
-			tcpServer.Addr = listen
-			tcpServer.Net = "tcp"
-
-			errCh := make(chan error, 2)
-			go func() { errCh <- udpServer.ListenAndServe() }()
-			go func() { errCh <- tcpServer.ListenAndServe() }()
-
-			slog.Info("server started", "zone", zone, "record", srv.challengeName(), "listen", listen)
-
-			select {
-			case err := <-errCh:
-				return fmt.Errorf("server error: %w", err)
-			case <-ctx.Done():
-				slog.Info("shutting down")
-				udpServer.Shutdown(context.Background())
-				tcpServer.Shutdown(context.Background())
-				return nil
-			}
-		},
+		RunE:  serve.RunE,
 	}
-
-	cmd.Flags().StringVar(&zone, "zone", "", "DNS zone (e.g. example.com.)")
-	cmd.Flags().StringVar(&subdomain, "subdomain", "", "Subdomain prefix for the challenge record (e.g. sub for _acme-challenge.sub.example.com.)")
-	cmd.Flags().StringVar(&tsigName, "tsig-name", "", "TSIG key name (e.g. acme-update.)")
-	cmd.Flags().StringVar(&tsigSecret, "tsig-secret", "", "Base64 HMAC-SHA512 secret")
-	cmd.Flags().StringVar(&listen, "listen", ":53", "Listen address")
-
-	cmd.MarkFlagRequired("zone")
-	cmd.MarkFlagRequired("tsig-name")
-	cmd.MarkFlagRequired("tsig-secret")
+	serve.Flags().VisitAll(func(f *pflag.Flag) {
+		alias := *f
+		alias.Hidden = true
+		cmd.Flags().AddFlag(&alias)
+	})
+	cmd.PersistentFlags().StringVar(&controlSocket, "control-socket", defaultControlSocket, "Path of the local control socket (disabled if empty)")
+	cmd.AddCommand(serve)
+	cmd.AddCommand(versionCommand())
+	cmd.AddCommand(controlCommands(&controlSocket)...)
+	cmd.AddCommand(keygenCommand())
+	cmd.AddCommand(checkCommand())
+	cmd.AddCommand(updateCommand())
+	cmd.AddCommand(issueCommand())
+	cmd.AddCommand(benchCommand())
+	cmd.AddCommand(importCommand())
+	cmd.AddCommand(operatorCommand())
 
 	if err := cmd.Execute(); err != nil {
 		os.Exit(1)
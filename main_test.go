@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+	"github.com/twelho/dns-pajatso/pkg/pajatsotest"
+)
+
+const (
+	testZone      = "example.com."
+	testTsigName  = "acme-update."
+	testChallenge = "_acme-challenge.example.com."
+)
+
+// testTsigSecret is a deterministic test key (base64-encoded).
+var testTsigSecret = base64.StdEncoding.EncodeToString(
+	hmac.New(sha512.New, []byte("test-key")).Sum(nil),
+)
+
+// startTestServer starts a DNS server on a random UDP port and returns
+// the address and a cleanup function.
+func startTestServer(t *testing.T) (string, *pajatso.Store, func()) {
+	t.Helper()
+
+	return startTestServerFor(t, &pajatso.Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		Store:      &pajatso.Store{},
+	})
+}
+
+// startTestServerFor starts srv on a random port.
+func startTestServerFor(t *testing.T, srv *pajatso.Server) (string, *pajatso.Store, func()) {
+	t.Helper()
+	return pajatsotest.StartServer(t, srv)
+}
+
+func query(t *testing.T, addr string, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	r, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(name, qtype), "udp", addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	return r
+}
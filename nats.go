@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// natsPublisher publishes store changes and refused updates as JSON
+// notifications to <subject>.<event> on a NATS server.
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// connectNATS connects to the NATS server at url, optionally authenticating
+// with a credentials file. The connection reconnects indefinitely; events
+// published while disconnected are buffered by the client.
+func connectNATS(url, creds, subject string) (*natsPublisher, error) {
+	opts := []nats.Option{
+		nats.Name("dns-pajatso"),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2 * time.Second),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			slog.Warn("nats disconnected", "err", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			slog.Info("nats reconnected", "url", c.ConnectedUrlRedacted())
+		}),
+	}
+	if creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+	}
+
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	return &natsPublisher{conn: conn, subject: subject}, nil
+}
+
+// publish sends n to the subject of its event.
+func (p *natsPublisher) publish(n notification) {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return
+	}
+	if err := p.conn.Publish(p.subject+"."+n.Event, data); err != nil {
+		slog.Warn("nats publish failed", "event", n.Event, "err", err)
+	}
+}
+
+// storeEvent returns a Store subscriber publishing changes to the challenge
+// record of s.
+func (p *natsPublisher) storeEvent(s *pajatso.Server) func(pajatso.Event) {
+	return func(e pajatso.Event) { p.publish(storeNotification(s, e)) }
+}
+
+// updateRefused returns an OnUpdateRefused callback publishing rejected
+// updates to s.
+func (p *natsPublisher) updateRefused(s *pajatso.Server) func(context.Context, net.Addr, string) {
+	return func(ctx context.Context, remote net.Addr, reason string) {
+		p.publish(refusedNotification(s, pajatso.RequestID(ctx), remote, reason))
+	}
+}
+
+// zoneNotified returns an OnNotify callback publishing NOTIFY messages
+// accepted by s.
+func (p *natsPublisher) zoneNotified() func(net.Addr, string, uint32) {
+	return func(remote net.Addr, zone string, serial uint32) { p.publish(notifyNotification(remote, zone, serial)) }
+}
+
+// close flushes buffered events and closes the connection.
+func (p *natsPublisher) close() {
+	p.conn.Drain()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// natsMsg is a message received by fakeNATS.
+type natsMsg struct {
+	subject string
+	data    []byte
+}
+
+// fakeNATS speaks just enough of the NATS client protocol to accept a
+// connection and collect published messages.
+func fakeNATS(t *testing.T) (string, <-chan natsMsg) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	msgs := make(chan natsMsg, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"max_payload\":1048576}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			switch fields[0] {
+			case "PING":
+				fmt.Fprintf(conn, "PONG\r\n")
+			case "PUB":
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				data := make([]byte, size+2) // payload and CRLF
+				if _, err := io.ReadFull(r, data); err != nil {
+					return
+				}
+				msgs <- natsMsg{subject: fields[1], data: data[:size]}
+			}
+		}
+	}()
+	return "nats://" + ln.Addr().String(), msgs
+}
+
+func TestNATSPublish(t *testing.T) {
+	url, msgs := fakeNATS(t)
+	pub, err := connectNATS(url, "", "pajatso.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.close()
+
+	srv := &pajatso.Server{Zone: testZone, Store: &pajatso.Store{}}
+	srv.Store.Subscribe(pub.storeEvent(srv))
+	srv.Store.Set(srv.ChallengeName(), "token", 0)
+	pub.updateRefused(srv)(context.Background(), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}, "wrong zone")
+	pub.conn.Flush()
+
+	for _, want := range []notification{
+		{Event: "set", Value: "token"},
+		{Event: "refused", Reason: "wrong zone", Remote: "192.0.2.1:5353"},
+	} {
+		select {
+		case msg := <-msgs:
+			if msg.subject != "pajatso.events."+want.Event {
+				t.Fatalf("expected subject pajatso.events.%s, got %s", want.Event, msg.subject)
+			}
+			var n notification
+			if err := json.Unmarshal(msg.data, &n); err != nil {
+				t.Fatal(err)
+			}
+			if n.Event != want.Event || n.Name != testChallenge || n.Value != want.Value || n.Reason != want.Reason || n.Remote != want.Remote {
+				t.Fatalf("unexpected payload %+v", n)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %s message published", want.Event)
+		}
+	}
+}
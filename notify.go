@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// notification is the JSON payload POSTed by a notifier.
+type notification struct {
+	Event  string    `json:"event"` // set, delete, expire, refused or notify
+	Zone   string    `json:"zone"`
+	Name   string    `json:"name"`
+	Value  string    `json:"value,omitempty"`
+	Reason string    `json:"reason,omitempty"` // why an update was refused
+	Remote string    `json:"remote,omitempty"` // client of a refused update or sender of a NOTIFY
+	Time   time.Time `json:"time"`
+
+	Request string `json:"request,omitempty"` // ID of the request making the change, see pajatso.RequestID
+}
+
+// Deliveries of a notifier in flight: notifyWorkers send at once, and up to
+// notifyQueueSize more wait. Further notifications are dropped, so that a
+// flood of refused updates can't grow the goroutines and memory of the server
+// while the endpoint is slow or retried.
+const (
+	notifyWorkers   = 4
+	notifyQueueSize = 256
+)
+
+// notifier POSTs notifications to a URL, signing the body with an
+// HMAC-SHA256 in the X-Pajatso-Signature header if a secret is set.
+type notifier struct {
+	url     string
+	secret  []byte
+	retries int           // additional attempts after a failure
+	backoff time.Duration // delay before the first retry, doubled for each further one
+	client  *http.Client
+
+	start    sync.Once
+	queue    chan notification
+	dropping atomic.Bool // notifications are being dropped, logged once per run of drops
+}
+
+// send delivers n, retrying on network errors and non-2xx responses.
+func (nt *notifier) send(ctx context.Context, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	backoff := nt.backoff
+	for attempt := 0; ; attempt++ {
+		err = nt.post(ctx, body)
+		if err == nil || attempt >= nt.retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// post makes a single delivery attempt.
+func (nt *notifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", nt.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(nt.secret) > 0 {
+		mac := hmac.New(sha256.New, nt.secret)
+		mac.Write(body)
+		req.Header.Set("X-Pajatso-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := nt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// notify queues n for delivery in the background, logging if it fails, or
+// drops it if the queue is full.
+func (nt *notifier) notify(n notification) {
+	nt.start.Do(func() {
+		nt.queue = make(chan notification, notifyQueueSize)
+		for range notifyWorkers {
+			go nt.deliver()
+		}
+	})
+	select {
+	case nt.queue <- n:
+		nt.dropping.Store(false)
+	default:
+		if !nt.dropping.Swap(true) {
+			slog.Warn("notification queue full, dropping notifications", "event", n.Event, "url", nt.url)
+		}
+	}
+}
+
+// deliver sends the queued notifications.
+func (nt *notifier) deliver() {
+	for n := range nt.queue {
+		if err := nt.send(context.Background(), n); err != nil {
+			slog.Warn("notification failed", "event", n.Event, "url", nt.url, "err", err)
+		}
+	}
+}
+
+// storeEvent returns a Store subscriber notifying about changes to the
+// challenge record of s.
+func (nt *notifier) storeEvent(s *pajatso.Server) func(pajatso.Event) {
+	return func(e pajatso.Event) { nt.notify(storeNotification(s, e)) }
+}
+
+// updateRefused returns an OnUpdateRefused callback notifying about
+// rejected updates to s.
+func (nt *notifier) updateRefused(s *pajatso.Server) func(context.Context, net.Addr, string) {
+	return func(ctx context.Context, remote net.Addr, reason string) {
+		nt.notify(refusedNotification(s, pajatso.RequestID(ctx), remote, reason))
+	}
+}
+
+// zoneNotified returns an OnNotify callback notifying about NOTIFY
+// messages accepted by s.
+func (nt *notifier) zoneNotified() func(net.Addr, string, uint32) {
+	return func(remote net.Addr, zone string, serial uint32) { nt.notify(notifyNotification(remote, zone, serial)) }
+}
+
+// storeNotification returns the notification for a change to a record of
+// the store, the challenge record or one published with GenericTXT.
+func storeNotification(s *pajatso.Server, e pajatso.Event) notification {
+	return notification{
+		Event: string(e.Op),
+		Zone:  s.Zone,
+		Name:  e.Name,
+		Value: e.Value,
+		Time:  e.Time,
+
+		Request: e.Request,
+	}
+}
+
+// refusedNotification returns the notification for the rejected update
+// with the request ID request.
+func refusedNotification(s *pajatso.Server, request string, remote net.Addr, reason string) notification {
+	n := notification{
+		Event:  "refused",
+		Zone:   s.Zone,
+		Name:   s.ChallengeName(),
+		Reason: reason,
+		Time:   time.Now(),
+
+		Request: request,
+	}
+	if remote != nil {
+		n.Remote = remote.String()
+	}
+	return n
+}
+
+// notifyNotification returns the notification for a NOTIFY of a change to
+// zone, with the new SOA serial as the value if it was given.
+func notifyNotification(remote net.Addr, zone string, serial uint32) notification {
+	n := notification{
+		Event: "notify",
+		Zone:  zone,
+		Name:  zone,
+		Time:  time.Now(),
+	}
+	if serial != 0 {
+		n.Value = strconv.FormatUint(uint64(serial), 10)
+	}
+	if remote != nil {
+		n.Remote = remote.String()
+	}
+	return n
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+func TestNotifierSigned(t *testing.T) {
+	var got notification
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-Pajatso-Signature") != want {
+			t.Errorf("bad signature %q", r.Header.Get("X-Pajatso-Signature"))
+		}
+		json.Unmarshal(body, &got)
+	}))
+	defer ts.Close()
+
+	nt := &notifier{url: ts.URL, secret: []byte("secret"), client: ts.Client()}
+	if err := nt.send(context.Background(), notification{Event: "set", Name: testChallenge, Value: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != "set" || got.Name != testChallenge || got.Value != "token" {
+		t.Fatalf("unexpected payload %+v", got)
+	}
+}
+
+func TestNotifierRetries(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	nt := &notifier{url: ts.URL, retries: 2, backoff: time.Millisecond, client: ts.Client()}
+	if err := nt.send(context.Background(), notification{Event: "delete"}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+
+	attempts = -10
+	if err := nt.send(context.Background(), notification{Event: "delete"}); err == nil {
+		t.Fatal("expected an error after the retries are exhausted")
+	}
+}
+
+func TestStoreNotificationName(t *testing.T) {
+	srv := &pajatso.Server{Zone: testZone, Store: &pajatso.Store{}}
+	n := storeNotification(srv, pajatso.Event{Op: pajatso.OpSet, Name: "_verify.www.example.com.", Value: "token"})
+	if n.Name != "_verify.www.example.com." {
+		t.Fatalf("expected the name of the record changed, got %q", n.Name)
+	}
+}
+
+func TestNotifierQueue(t *testing.T) {
+	var received atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		received.Add(1)
+	}))
+	defer ts.Close()
+
+	// While the endpoint is stuck, notifications beyond the workers and
+	// the queue are dropped rather than piling up.
+	nt := &notifier{url: ts.URL, client: ts.Client()}
+	for range notifyWorkers + notifyQueueSize + 100 {
+		nt.notify(notification{Event: "refused"})
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for received.Load() < notifyQueueSize && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := received.Load(); got < notifyQueueSize || got > notifyWorkers+notifyQueueSize {
+		t.Fatalf("expected %d to %d notifications delivered, got %d", notifyQueueSize, notifyWorkers+notifyQueueSize, got)
+	}
+}
+
+func TestNotifierUpdateRefused(t *testing.T) {
+	got := make(chan notification, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		json.NewDecoder(r.Body).Decode(&n)
+		got <- n
+	}))
+	defer ts.Close()
+
+	srv := &pajatso.Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &pajatso.Store{}}
+	srv.OnUpdateRefused = (&notifier{url: ts.URL, client: ts.Client()}).updateRefused(srv)
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	// An unsigned update is refused.
+	r, _, err := dns.NewClient().Exchange(context.Background(), newUpdate(testZone, testChallenge, "token", 60), "udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED, got %s", dns.RcodeToString[r.Rcode])
+	}
+
+	select {
+	case n := <-got:
+		if n.Event != "refused" || n.Reason != "missing TSIG record" || n.Remote == "" || n.Request == "" {
+			t.Fatalf("unexpected payload %+v", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no notification")
+	}
+}
+
+func TestNotifierZoneNotified(t *testing.T) {
+	got := make(chan notification, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		json.NewDecoder(r.Body).Decode(&n)
+		got <- n
+	}))
+	defer ts.Close()
+
+	srv := &pajatso.Server{Zone: testZone, Store: &pajatso.Store{}, NotifyZones: []string{"example.net."}}
+	srv.OnNotify = (&notifier{url: ts.URL, client: ts.Client()}).zoneNotified()
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	m := dns.NewMsg("example.net.", dns.TypeSOA)
+	m.Opcode = dns.OpcodeNotify
+	r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+
+	select {
+	case n := <-got:
+		if n.Event != "notify" || n.Zone != "example.net." || n.Value != "" || n.Remote == "" {
+			t.Fatalf("unexpected payload %+v", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no notification")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// waitOneShot waits until the challenge token has been served and no further
+// validation queries arrived for linger. It returns an error if no query was
+// served within timeout.
+func waitOneShot(ctx context.Context, served <-chan struct{}, timeout, linger time.Duration) error {
+	deadline := time.After(timeout)
+	var quiet <-chan time.Time // nil until the first query
+
+	for {
+		select {
+		case <-served:
+			if quiet == nil {
+				slog.Info("one-shot: challenge token queried, waiting for further validation queries", "linger", linger)
+			}
+			quiet = time.After(linger)
+		case <-quiet:
+			slog.Info("one-shot: validation complete")
+			return nil
+		case <-deadline:
+			if quiet != nil {
+				// Queries were served, just not a quiet period before the timeout.
+				return nil
+			}
+			return errors.New("one-shot: timed out waiting for validation queries")
+		case <-ctx.Done():
+			return nil // interrupted, treated like a regular shutdown
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+func TestOneShotValidated(t *testing.T) {
+	served := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() { done <- waitOneShot(context.Background(), served, time.Minute, 50*time.Millisecond) }()
+
+	served <- struct{}{}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected success, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("one-shot did not finish after linger")
+	}
+}
+
+func TestOneShotTimeout(t *testing.T) {
+	served := make(chan struct{})
+	if err := waitOneShot(context.Background(), served, 50*time.Millisecond, time.Minute); err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestOneShotChallengeQuery(t *testing.T) {
+	served := make(chan struct{}, 1)
+	srv := &pajatso.Server{
+		Zone:             testZone,
+		TsigName:         testTsigName,
+		TsigSecret:       testTsigSecret,
+		Store:            &pajatso.Store{},
+		OnChallengeQuery: func(net.Addr) { served <- struct{}{} },
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	store.Replace("one-shot-token")
+	query(t, addr, testChallenge, dns.TypeTXT)
+
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnChallengeQuery was not called")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/spf13/cobra"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// defaultOperatorGroup is the default API group of the ChallengeZone resource.
+const defaultOperatorGroup = "dns-pajatso.twelho.github.io"
+
+// challengeZone is a ChallengeZone custom resource, declaring a zone to serve.
+type challengeZone struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec challengeZoneSpec `json:"spec"`
+}
+
+type challengeZoneSpec struct {
+	Zone          string `json:"zone"`
+	Subdomain     string `json:"subdomain,omitempty"`
+	TsigKeyName   string `json:"tsigKeyName"`
+	TsigAlgorithm string `json:"tsigAlgorithm,omitempty"`
+	TsigSecretRef struct {
+		Name string `json:"name"` // Secret in the namespace of the ChallengeZone
+		Key  string `json:"key"`  // key holding the base64 HMAC secret, as for --tsig-secret
+	} `json:"tsigSecretRef"`
+	NS []string `json:"ns,omitempty"` // name servers of the zone, as for --ns
+}
+
+// key returns the namespace/name of z.
+func (z *challengeZone) key() string {
+	return z.Metadata.Namespace + "/" + z.Metadata.Name
+}
+
+// operatorZone is a zone served on behalf of a ChallengeZone.
+type operatorZone struct {
+	spec challengeZoneSpec
+	srv  *pajatso.Server
+}
+
+// operator serves the zones declared by ChallengeZone resources, mounting a
+// Server for each of them on a shared dns.ServeMux. It is driven by a
+// single goroutine and needs no locking.
+type operator struct {
+	kube  *kubeClient
+	path  string // collection path of the ChallengeZones
+	mux   *dns.ServeMux
+	zones map[string]*operatorZone // by namespace/name
+}
+
+// run keeps the served zones in sync with the ChallengeZones until ctx is done.
+func (o *operator) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		rv, err := o.sync(ctx)
+		if err == nil {
+			err = o.kube.watch(ctx, o.path, rv, func(e watchEvent) error { return o.handle(ctx, e) })
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("operator: watch failed, resyncing", "err", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// sync applies all ChallengeZones, removes zones whose resource is gone and
+// returns the resource version to watch from.
+func (o *operator) sync(ctx context.Context) (string, error) {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []challengeZone `json:"items"`
+	}
+	if err := o.kube.get(ctx, o.path, &list); err != nil {
+		return "", err
+	}
+
+	seen := map[string]bool{}
+	for _, z := range list.Items {
+		seen[z.key()] = true
+		o.apply(ctx, z)
+	}
+	for key := range o.zones {
+		if !seen[key] {
+			o.remove(key)
+		}
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// handle applies a watch event.
+func (o *operator) handle(ctx context.Context, e watchEvent) error {
+	switch e.Type {
+	case "ADDED", "MODIFIED", "DELETED":
+		var z challengeZone
+		if err := json.Unmarshal(e.Object, &z); err != nil {
+			return err
+		}
+		if e.Type == "DELETED" {
+			o.remove(z.key())
+		} else {
+			o.apply(ctx, z)
+		}
+	case "ERROR":
+		var status kubeError
+		json.Unmarshal(e.Object, &status)
+		return &status
+	}
+	return nil
+}
+
+// apply starts serving the zone of z, or reconfigures it if z or its TSIG
+// secret changed. The challenge token is kept if the zone stays the same.
+// A zone whose resource became invalid, e.g. as its Secret was deleted, is
+// no longer served, so that a revoked key can't update it.
+func (o *operator) apply(ctx context.Context, z challengeZone) {
+	key := z.key()
+	srv, err := o.newServer(ctx, z)
+	if err != nil {
+		slog.Error("operator: invalid ChallengeZone", "name", key, "err", err)
+		o.remove(key)
+		return
+	}
+	old := o.zones[key]
+	if old != nil && reflect.DeepEqual(old.spec, z.Spec) && old.srv.TsigSecret == srv.TsigSecret {
+		return
+	}
+	for other, oz := range o.zones {
+		if other != key && oz.srv.Zone == srv.Zone {
+			slog.Error("operator: zone already served for another ChallengeZone", "name", key, "zone", srv.Zone, "other", other)
+			return
+		}
+	}
+
+	if old != nil {
+		if old.srv.Zone == srv.Zone {
+			srv.Store = old.srv.Store
+			srv.Store.Rename(old.srv.ChallengeName(), srv.ChallengeName())
+		} else {
+			o.mux.HandleRemove(old.srv.Zone)
+		}
+	}
+	o.mux.Handle(srv.Zone, srv.Handler())
+	o.zones[key] = &operatorZone{spec: z.Spec, srv: srv}
+	if old != nil {
+		old.srv.Close()
+	}
+	slog.Info("operator: serving zone", "name", key, "zone", srv.Zone, "record", srv.ChallengeName())
+}
+
+// remove stops serving the zone of the ChallengeZone key.
+func (o *operator) remove(key string) {
+	oz, ok := o.zones[key]
+	if !ok {
+		return
+	}
+	o.mux.HandleRemove(oz.srv.Zone)
+	oz.srv.Close()
+	delete(o.zones, key)
+	slog.Info("operator: removed zone", "name", key, "zone", oz.srv.Zone)
+}
+
+// newServer reads the TSIG secret of z and returns a Server for its zone.
+func (o *operator) newServer(ctx context.Context, z challengeZone) (*pajatso.Server, error) {
+	ref := secretRef{z.Metadata.Namespace, z.Spec.TsigSecretRef.Name, z.Spec.TsigSecretRef.Key}
+	tsigSecret, _, err := ref.read(ctx, o.kube)
+	if err != nil {
+		return nil, err
+	}
+
+	return pajatso.NewServer(pajatso.Config{
+		Zone:       z.Spec.Zone,
+		Subdomain:  z.Spec.Subdomain,
+		TsigName:   z.Spec.TsigKeyName,
+		TsigSecret: tsigSecret,
+		TsigAlg:    z.Spec.TsigAlgorithm,
+		NS:         z.Spec.NS,
+	})
+}
+
+// operatorCommand returns the operator subcommand.
+func operatorCommand() *cobra.Command {
+	var (
+		listen    string
+		namespace string
+		group     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Serve the zones declared by ChallengeZone resources in the Kubernetes cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kube, err := inClusterClient()
+			if err != nil {
+				return err
+			}
+			path := "/apis/" + group + "/v1alpha1/challengezones"
+			if namespace != "" {
+				path = "/apis/" + group + "/v1alpha1/namespaces/" + namespace + "/challengezones"
+			}
+			o := &operator{kube: kube, path: path, mux: dns.NewServeMux(), zones: map[string]*operatorZone{}}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			udpServer := &dns.Server{Addr: listen, Net: "udp", Handler: o.mux}
+			tcpServer := &dns.Server{Addr: listen, Net: "tcp", Handler: o.mux}
+			errCh := make(chan error, 2)
+			go func() { errCh <- udpServer.ListenAndServe() }()
+			go func() { errCh <- tcpServer.ListenAndServe() }()
+			defer udpServer.Shutdown(context.Background())
+			defer tcpServer.Shutdown(context.Background())
+
+			go o.run(ctx)
+			slog.Info("operator started", "listen", listen, "resources", path)
+
+			select {
+			case err := <-errCh:
+				return fmt.Errorf("server error: %w", err)
+			case <-ctx.Done():
+				slog.Info("shutting down")
+				return nil
+			}
+		},
+	}
+	cmd.SilenceUsage = true
+
+	cmd.Flags().StringVar(&listen, "listen", ":53", "Listen address")
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Only watch ChallengeZones in this namespace (all namespaces if empty)")
+	cmd.Flags().StringVar(&group, "group", defaultOperatorGroup, "API group of the ChallengeZone resource")
+
+	return cmd
+}
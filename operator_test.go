@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+const testZonesPath = "/apis/" + defaultOperatorGroup + "/v1alpha1/challengezones"
+
+// fakeKube is a fake API server holding ChallengeZones and the data of
+// Secrets by path.
+type fakeKube struct {
+	mu      sync.Mutex
+	zones   []challengeZone
+	secrets map[string]map[string]string
+}
+
+func (f *fakeKube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == testZonesPath {
+		json.NewEncoder(w).Encode(map[string]any{
+			"metadata": map[string]string{"resourceVersion": "1"},
+			"items":    f.zones,
+		})
+		return
+	}
+	if data, ok := f.secrets[r.URL.Path]; ok {
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(kubeError{Code: http.StatusNotFound, Message: "not found"})
+}
+
+func testChallengeZone(name, zone string) challengeZone {
+	var z challengeZone
+	z.Metadata.Name = name
+	z.Metadata.Namespace = "default"
+	z.Spec.Zone = zone
+	z.Spec.TsigKeyName = testTsigName
+	z.Spec.TsigSecretRef.Name = "tsig"
+	z.Spec.TsigSecretRef.Key = "secret"
+	return z
+}
+
+// startTestOperator returns an operator backed by f, with its mux served on
+// a random UDP port.
+func startTestOperator(t *testing.T, f *fakeKube) (*operator, string) {
+	t.Helper()
+
+	f.secrets = map[string]map[string]string{
+		"/api/v1/namespaces/default/secrets/tsig": {
+			"secret": base64.StdEncoding.EncodeToString([]byte(testTsigSecret)),
+		},
+	}
+	o := &operator{
+		kube:  newTestKubeClient(t, f),
+		path:  testZonesPath,
+		mux:   dns.NewServeMux(),
+		zones: map[string]*operatorZone{},
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsServer := &dns.Server{PacketConn: pc, Handler: o.mux}
+	go dnsServer.ListenAndServe()
+	t.Cleanup(func() { dnsServer.Shutdown(context.Background()) })
+	return o, pc.LocalAddr().String()
+}
+
+func TestOperatorSync(t *testing.T) {
+	f := &fakeKube{zones: []challengeZone{
+		testChallengeZone("a", "example.com"),
+		testChallengeZone("b", "example.org"),
+	}}
+	o, addr := startTestOperator(t, f)
+
+	rv, err := o.sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rv != "1" {
+		t.Errorf("resourceVersion = %q, want 1", rv)
+	}
+	if len(o.zones) != 2 {
+		t.Fatalf("serving %d zones, want 2", len(o.zones))
+	}
+
+	o.zones["default/b"].srv.Store.Replace("token-b")
+	r := query(t, addr, "_acme-challenge.example.org.", dns.TypeTXT)
+	if len(r.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(r.Answer))
+	}
+	if txt := r.Answer[0].(*dns.TXT); txt.Txt[0] != "token-b" {
+		t.Errorf("TXT = %q, want token-b", txt.Txt[0])
+	}
+
+	// Removing a resource stops serving its zone.
+	f.mu.Lock()
+	f.zones = f.zones[:1]
+	f.mu.Unlock()
+	if _, err := o.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := o.zones["default/b"]; ok {
+		t.Error("zone of removed ChallengeZone still served")
+	}
+	r = query(t, addr, "_acme-challenge.example.org.", dns.TypeTXT)
+	if r.Rcode != dns.RcodeRefused {
+		t.Errorf("rcode = %s, want REFUSED", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestOperatorHandle(t *testing.T) {
+	f := &fakeKube{}
+	o, addr := startTestOperator(t, f)
+
+	event := func(typ string, z challengeZone) {
+		t.Helper()
+		obj, err := json.Marshal(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := o.handle(context.Background(), watchEvent{Type: typ, Object: obj}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	z := testChallengeZone("a", "example.com")
+	event("ADDED", z)
+	o.zones["default/a"].srv.Store.Replace("token")
+
+	// Changing the subdomain keeps the token of the zone.
+	z.Spec.Subdomain = "sub"
+	event("MODIFIED", z)
+	r := query(t, addr, "_acme-challenge.sub.example.com.", dns.TypeTXT)
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.TXT).Txt[0] != "token" {
+		t.Errorf("answer = %v, want token", r.Answer)
+	}
+
+	// The name servers of the resource are served.
+	z.Spec.NS = []string{"ns1.example.net"}
+	event("MODIFIED", z)
+	r = query(t, addr, "example.com.", dns.TypeNS)
+	if len(r.Answer) != 1 {
+		t.Errorf("NS answer = %v, want ns1.example.net.", r.Answer)
+	}
+
+	// A second resource for the same zone is rejected.
+	event("ADDED", testChallengeZone("b", "example.com"))
+	if _, ok := o.zones["default/b"]; ok {
+		t.Error("duplicate zone accepted")
+	}
+
+	// A resource referring to a missing secret is rejected.
+	bad := testChallengeZone("c", "example.net")
+	bad.Spec.TsigSecretRef.Name = "missing"
+	event("ADDED", bad)
+	if _, ok := o.zones["default/c"]; ok {
+		t.Error("zone with missing secret accepted")
+	}
+
+	event("DELETED", z)
+	r = query(t, addr, "_acme-challenge.sub.example.com.", dns.TypeTXT)
+	if r.Rcode != dns.RcodeRefused {
+		t.Errorf("rcode = %s, want REFUSED", dns.RcodeToString[r.Rcode])
+	}
+
+	// A zone whose Secret is deleted is no longer served.
+	event("ADDED", z)
+	f.mu.Lock()
+	delete(f.secrets, "/api/v1/namespaces/default/secrets/tsig")
+	f.mu.Unlock()
+	event("MODIFIED", z)
+	if _, ok := o.zones["default/a"]; ok {
+		t.Error("zone with deleted secret still served")
+	}
+
+	if err := o.handle(context.Background(), watchEvent{Type: "ERROR", Object: []byte(`{"code": 410, "message": "too old resource version"}`)}); err == nil {
+		t.Error("ERROR event did not end the watch")
+	}
+}
@@ -0,0 +1,110 @@
+package pajatso
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// activity tracks when challenge values were set, by whom, and when they
+// were last queried, so that it can be told whether a CA ever looked up a
+// token.
+type activity struct {
+	mu        sync.Mutex
+	values    map[string]*valueActivity // by value, for the values in the Store
+	lastSet   time.Time
+	lastQuery time.Time
+	queryFrom string
+	queries   uint64
+}
+
+// valueActivity is the activity of a single challenge value.
+type valueActivity struct {
+	setAt       time.Time
+	setBy       string
+	queriedAt   time.Time
+	queriedFrom string
+	queries     uint64
+}
+
+// recordSet records that value was set through by, a TSIG key name or the
+// API used, e.g. "control".
+func (s *Server) recordSet(value, by string) {
+	now := time.Now()
+	entries := s.Store.Get(s.ChallengeName())
+
+	a := &s.activity
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.values == nil {
+		a.values = map[string]*valueActivity{}
+	}
+	// Forget the values no longer stored.
+	for v := range a.values {
+		if !containsValue(entries, v) {
+			delete(a.values, v)
+		}
+	}
+	a.values[value] = &valueActivity{setAt: now, setBy: by}
+	a.lastSet = now
+}
+
+// recordQuery records that entries were served to remote.
+func (s *Server) recordQuery(entries []Entry, remote net.Addr) {
+	now := time.Now()
+	from := ""
+	if remote != nil {
+		from = remote.String()
+	}
+
+	a := &s.activity
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, e := range entries {
+		if v := a.values[e.Value]; v != nil {
+			v.queriedAt, v.queriedFrom = now, from
+			v.queries++
+		}
+	}
+	a.lastQuery, a.queryFrom = now, from
+	a.queries++
+}
+
+// withActivity returns rec with the activity recorded for its value.
+func (s *Server) withActivity(rec Record) Record {
+	a := &s.activity
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	v := a.values[rec.Value]
+	if v == nil {
+		return rec
+	}
+	rec.SetAt, rec.SetBy = timePtr(v.setAt), v.setBy
+	rec.QueriedAt, rec.QueriedFrom, rec.Queries = timePtr(v.queriedAt), v.queriedFrom, v.queries
+	return rec
+}
+
+func containsValue(entries []Entry, value string) bool {
+	for _, e := range entries {
+		if e.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// timePtr returns a pointer to t, or nil if t is zero.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// unixTime returns t in seconds since the epoch, or 0 if t is zero.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,40 @@
+package pajatso
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestChallengeActivity(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"tracked\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	records := srv.Records()
+	if len(records) != 1 || records[0].SetAt == nil || records[0].SetBy != testTsigName || records[0].QueriedAt != nil {
+		t.Fatalf("expected a set but unqueried record, got %+v", records)
+	}
+
+	query(t, addr, testChallenge, dns.TypeTXT)
+	query(t, addr, testChallenge, dns.TypeTXT)
+	records = srv.Records()
+	if records[0].QueriedAt == nil || records[0].QueriedFrom == "" || records[0].Queries != 2 {
+		t.Fatalf("expected two queries recorded, got %+v", records[0])
+	}
+
+	// The last query is still reported once the record is gone.
+	srv.Store.Clear()
+	rec := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "pajatso_challenge_queries_total 2\n") || strings.Contains(body, "pajatso_challenge_last_query_timestamp_seconds 0\n") {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+}
@@ -0,0 +1,81 @@
+package pajatso
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// ParsePrefixes parses a list of CIDR prefixes or single addresses, e.g.
+// "192.0.2.0/24" or "2001:db8::1".
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix %q", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// addrAllowed reports whether addr is in one of prefixes. An empty list
+// allows all addresses.
+func addrAllowed(prefixes []netip.Prefix, addr net.Addr) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	ip, err := netip.ParseAddr(remoteIP(addr))
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryACL returns a middleware restricting queries by source network. A query
+// is answered if its source is in allow (or allow is empty) and not in deny;
+// others are refused. Updates are not affected, see Server.UpdateAllow.
+func QueryACL(allow, deny []netip.Prefix) Middleware {
+	return func(next dns.Handler) dns.Handler {
+		return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+			if r.Opcode != dns.OpcodeQuery || queryAllowed(allow, deny, w.RemoteAddr()) {
+				next.ServeDNS(ctx, w, r)
+				return
+			}
+			m := new(dns.Msg)
+			dnsutil.SetReply(m, r)
+			m.Rcode = dns.RcodeRefused
+			if r.Unpack() == nil {
+				setEDE(r, m, dns.ExtendedErrorProhibited, "query not allowed")
+			}
+			WriteMsg(w, m)
+		})
+	}
+}
+
+// queryAllowed reports whether addr passes the allow and deny lists of a
+// QueryACL.
+func queryAllowed(allow, deny []netip.Prefix, addr net.Addr) bool {
+	return addrAllowed(allow, addr) && (len(deny) == 0 || !addrAllowed(deny, addr))
+}
@@ -0,0 +1,99 @@
+package pajatso
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	got, err := ParsePrefixes([]string{"192.0.2.1/24", " 2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("2001:db8::1/128")}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"", "192.0.2.0/33", "example.com"} {
+		if _, err := ParsePrefixes([]string{bad}); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestAddrAllowed(t *testing.T) {
+	prefixes := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	for addr, want := range map[string]bool{
+		"192.0.2.10":        true,
+		"::ffff:192.0.2.10": true,
+		"198.51.100.1":      false,
+		"2001:db8::1":       false,
+	} {
+		if got := addrAllowed(prefixes, &net.UDPAddr{IP: net.ParseIP(addr), Port: 53}); got != want {
+			t.Errorf("%s: expected %v, got %v", addr, want, got)
+		}
+	}
+	if !addrAllowed(nil, &net.UDPAddr{IP: net.ParseIP("198.51.100.1")}) {
+		t.Error("expected an empty list to allow all addresses")
+	}
+}
+
+func TestUpdateAllow(t *testing.T) {
+	other := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	local := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	for name, tc := range map[string]struct {
+		srv   *Server
+		rcode uint16
+	}{
+		"global allowed":     {&Server{UpdateAllow: local}, dns.RcodeSuccess},
+		"global not allowed": {&Server{UpdateAllow: other}, dns.RcodeRefused},
+		"key allowed":        {&Server{UpdateAllowKey: map[string][]netip.Prefix{testTsigName: local}}, dns.RcodeSuccess},
+		"key not allowed":    {&Server{UpdateAllowKey: map[string][]netip.Prefix{testTsigName: other}}, dns.RcodeRefused},
+	} {
+		srv := tc.srv
+		srv.Zone, srv.TsigName, srv.TsigSecret, srv.Store = testZone, testTsigName, testTsigSecret, &Store{}
+		addr, _, cleanup := startTestServerFor(t, srv)
+
+		rr, _ := dns.New(testChallenge + " 60 IN TXT \"token\"")
+		if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != tc.rcode {
+			t.Errorf("%s: expected %s, got %s", name, dns.RcodeToString[tc.rcode], dns.RcodeToString[r.Rcode])
+		}
+		cleanup()
+	}
+}
+
+func TestQueryACL(t *testing.T) {
+	local := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	for name, tc := range map[string]struct {
+		allow, deny []netip.Prefix
+		rcode       uint16
+	}{
+		"allowed":     {local, nil, dns.RcodeSuccess},
+		"not allowed": {[]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, nil, dns.RcodeRefused},
+		"denied":      {nil, local, dns.RcodeRefused},
+		"deny wins":   {local, []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, dns.RcodeRefused},
+	} {
+		srv := &Server{
+			Zone:       testZone,
+			TsigName:   testTsigName,
+			TsigSecret: testTsigSecret,
+			Store:      &Store{},
+			Middleware: []Middleware{QueryACL(tc.allow, tc.deny)},
+		}
+		addr, store, cleanup := startTestServerFor(t, srv)
+		store.Replace("token")
+
+		if r := query(t, addr, testChallenge, dns.TypeTXT); r.Rcode != tc.rcode {
+			t.Errorf("%s: expected %s, got %s", name, dns.RcodeToString[tc.rcode], dns.RcodeToString[r.Rcode])
+		}
+		// Updates are not subject to the query ACL.
+		rr, _ := dns.New(testChallenge + " 60 IN TXT \"other\"")
+		if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: expected NOERROR for the update, got %s", name, dns.RcodeToString[r.Rcode])
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,233 @@
+package pajatso
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Record is the JSON representation of a challenge record.
+type Record struct {
+	Name    string     `json:"name"`
+	Type    string     `json:"type"`
+	Value   string     `json:"value"`
+	Expires *time.Time `json:"expires,omitempty"` // nil if the record never expires
+
+	// When the value was set and by whom: the TSIG key name of an RFC 2136
+	// update, or "api", "grpc", "webhook" or "control".
+	SetAt *time.Time `json:"setAt,omitempty"`
+	SetBy string     `json:"setBy,omitempty"`
+
+	// When the value was last served to a query and to which address, nil
+	// if it has not been queried yet.
+	QueriedAt   *time.Time `json:"queriedAt,omitempty"`
+	QueriedFrom string     `json:"queriedFrom,omitempty"`
+	Queries     uint64     `json:"queries,omitempty"` // queries answered with the value
+}
+
+// apiError is the JSON body of an error response.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// APIHandler returns an http.Handler serving the JSON record API. All requests
+// must carry APIToken, one of APIKeys or a JWT accepted by APIJWT as a bearer
+// token or, if none are set, be made with a client certificate verified by
+// the TLS server. Requests with an APIKey are limited to its scope.
+//
+//	GET    /zones/{zone}/records         list current challenge records
+//	GET    /zones/{zone}/records/{name}  get a challenge record
+//	PUT    /zones/{zone}/records/{name}  set a challenge record, body {"value": "..."}
+//	DELETE /zones/{zone}/records/{name}  delete a challenge record
+//
+// The record name may be given as an FQDN or relative to the zone.
+func (s *Server) APIHandler() http.Handler {
+	s.Store.bind(s.ChallengeName())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /zones/{zone}/records", s.apiList)
+	mux.HandleFunc("GET /zones/{zone}/records/{name}", s.apiGet)
+	mux.HandleFunc("PUT /zones/{zone}/records/{name}", s.apiPut)
+	mux.HandleFunc("DELETE /zones/{zone}/records/{name}", s.apiDelete)
+	return httpRequestID(s.requireToken(mux))
+}
+
+// requireToken rejects requests that do not carry APIToken, one of APIKeys
+// or a JWT accepted by APIJWT as a bearer token, or a verified client
+// certificate if none are set. It also enforces APILimits.
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits := s.apiLimits()
+		ip := httpRemoteIP(r)
+		if limits.authRefused(w, "too many failed authentications", "ip:"+ip) {
+			slog.WarnContext(r.Context(), "api refused: authentication backoff", "remote", r.RemoteAddr)
+			return
+		}
+		cred, err := s.authenticate(r)
+		if err != nil {
+			backoff := limits.authFailed("ip:" + ip)
+			slog.WarnContext(r.Context(), "api refused: invalid bearer token", "remote", r.RemoteAddr, "err", err, "backoff", backoff)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, apiError{"invalid bearer token"})
+			return
+		}
+		limits.authSucceeded("ip:" + ip)
+		if r.Method != http.MethodGet && limits.writeLimited(w, "too many requests", cred.name, ip) {
+			slog.WarnContext(r.Context(), "api refused: write rate limit", "credential", cred.name, "remote", r.RemoteAddr)
+			return
+		}
+		next.ServeHTTP(w, withAPICredential(r, cred))
+	})
+}
+
+// authenticate checks the credentials of the API request r.
+func (s *Server) authenticate(r *http.Request) (apiCredential, error) {
+	if s.APIToken == "" && len(s.APIKeys) == 0 && s.APIJWT == nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return apiCredential{name: "cert:" + r.TLS.VerifiedChains[0][0].Subject.String()}, nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return apiCredential{}, errors.New("no bearer token")
+	}
+	if s.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.APIToken)) == 1 {
+		return apiCredential{name: "token"}, nil
+	}
+	if key := s.apiKey(token); key != nil {
+		slog.DebugContext(r.Context(), "api: authenticated API key", "key", key.Name, "remote", r.RemoteAddr)
+		return apiCredential{name: "key:" + key.Name, scope: &key.Scope}, nil
+	}
+	if s.APIJWT != nil && strings.Count(token, ".") == 2 {
+		claims, err := s.APIJWT.Verify(r.Context(), token)
+		if err != nil {
+			return apiCredential{}, err
+		}
+		slog.DebugContext(r.Context(), "api: authenticated JWT", "subject", claims.Subject, "remote", r.RemoteAddr)
+		return apiCredential{name: "jwt:" + claims.Subject}, nil
+	}
+	return apiCredential{}, errors.New("wrong bearer token")
+}
+
+// apiForbidden reports whether the scope of the credential of r excludes
+// the challenge record, or reading and deleting it unless set, writing the
+// response if so.
+func (s *Server) apiForbidden(w http.ResponseWriter, r *http.Request, set bool) bool {
+	scope := apiScope(r)
+	if scope.allows(s.ChallengeName()) && (set || scope.readable()) {
+		return false
+	}
+	writeJSON(w, http.StatusForbidden, apiError{"credential not allowed to access the record"})
+	return true
+}
+
+// apiZone reports whether the {zone} path value names the served zone.
+func (s *Server) apiZone(r *http.Request) bool {
+	return dns.EqualName(dnsutil.Fqdn(asciiName(r.PathValue("zone"))), s.Zone)
+}
+
+// apiName reports whether the {name} path value names the challenge record.
+func (s *Server) apiName(r *http.Request) bool {
+	return s.isChallengeName(r.PathValue("name"))
+}
+
+// Records returns the current challenge records, newest first.
+func (s *Server) Records() []Record {
+	records := []Record{}
+	for _, e := range s.Store.Get(s.ChallengeName()) {
+		rec := Record{Name: s.ChallengeName(), Type: "TXT", Value: e.Value}
+		if !e.Expires.IsZero() {
+			rec.Expires = &e.Expires
+		}
+		records = append(records, s.withActivity(rec))
+	}
+	return records
+}
+
+func (s *Server) apiList(w http.ResponseWriter, r *http.Request) {
+	if !s.apiZone(r) {
+		writeJSON(w, http.StatusNotFound, apiError{"unknown zone"})
+		return
+	}
+	if scope := apiScope(r); !scope.readable() {
+		writeJSON(w, http.StatusForbidden, apiError{"credential not allowed to list records"})
+		return
+	} else if !scope.allows(s.ChallengeName()) {
+		writeJSON(w, http.StatusOK, []Record{})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Records())
+}
+
+func (s *Server) apiGet(w http.ResponseWriter, r *http.Request) {
+	if !s.apiZone(r) || !s.apiName(r) {
+		writeJSON(w, http.StatusNotFound, apiError{"unknown record"})
+		return
+	}
+	if s.apiForbidden(w, r, false) {
+		return
+	}
+	records := s.Records()
+	if len(records) == 0 {
+		writeJSON(w, http.StatusNotFound, apiError{"record not set"})
+		return
+	}
+	writeJSON(w, http.StatusOK, records[0])
+}
+
+func (s *Server) apiPut(w http.ResponseWriter, r *http.Request) {
+	if !s.apiZone(r) || !s.apiName(r) {
+		writeJSON(w, http.StatusNotFound, apiError{"unknown record"})
+		return
+	}
+	if s.apiForbidden(w, r, true) {
+		return
+	}
+	if s.readOnly() {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{errReadOnly.Error()})
+		return
+	}
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{`body must be {"value": "<token>"}`})
+		return
+	}
+	if err := s.checkToken(body.Value); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	s.Store.replace(s.ChallengeName(), body.Value, 0, RequestID(r.Context()))
+	s.recordSet(body.Value, "api")
+	slog.InfoContext(r.Context(), "api: set _acme-challenge TXT", "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.apiZone(r) || !s.apiName(r) {
+		writeJSON(w, http.StatusNotFound, apiError{"unknown record"})
+		return
+	}
+	if s.apiForbidden(w, r, false) {
+		return
+	}
+	if s.readOnly() {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{errReadOnly.Error()})
+		return
+	}
+	s.Store.deleteFor(s.ChallengeName(), "", RequestID(r.Context()))
+	slog.InfoContext(r.Context(), "api: deleted _acme-challenge TXT", "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON encodes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
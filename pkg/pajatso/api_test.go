@@ -0,0 +1,180 @@
+package pajatso
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAPIToken = "test-api-token"
+
+// newTestAPI returns an httptest server serving the HTTP API of a fresh Server.
+func newTestAPI(t *testing.T) (*httptest.Server, *Store) {
+	t.Helper()
+
+	store := &Store{}
+	srv := &Server{
+		Zone:     testZone,
+		TsigName: testTsigName,
+		APIToken: testAPIToken,
+		Store:    store,
+	}
+	ts := httptest.NewServer(srv.APIHandler())
+	t.Cleanup(ts.Close)
+	return ts, store
+}
+
+func apiRequest(t *testing.T, ts *httptest.Server, method, path, token, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAPIUnauthorized(t *testing.T) {
+	ts, _ := newTestAPI(t)
+
+	for _, token := range []string{"", "wrong-token"} {
+		resp := apiRequest(t, ts, "GET", "/zones/example.com/records", token, "")
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("token %q: expected 401, got %d", token, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPIClientCert(t *testing.T) {
+	srv := &Server{Zone: testZone, Store: &Store{}}
+	h := srv.APIHandler()
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	for _, tt := range []struct {
+		state *tls.ConnectionState
+		want  int
+	}{{nil, http.StatusUnauthorized}, {&tls.ConnectionState{}, http.StatusUnauthorized}, {verified, http.StatusOK}} {
+		req := httptest.NewRequest("GET", "/zones/example.com/records", nil)
+		req.TLS = tt.state
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("TLS %+v: expected %d, got %d", tt.state, tt.want, rec.Code)
+		}
+	}
+
+	// With a token, the token is required as well.
+	srv.APIToken = testAPIToken
+	req := httptest.NewRequest("GET", "/zones/example.com/records", nil)
+	req.TLS = verified
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the token, got %d", rec.Code)
+	}
+}
+
+func TestAPISetGetDelete(t *testing.T) {
+	ts, store := newTestAPI(t)
+
+	resp := apiRequest(t, ts, "PUT", "/zones/example.com./records/_acme-challenge", testAPIToken, `{"value": "api-token"}`)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("put: expected 204, got %d", resp.StatusCode)
+	}
+	if val, ok := store.Value(); !ok || val != "api-token" {
+		t.Fatalf("put: expected (api-token, true), got (%q, %v)", val, ok)
+	}
+
+	resp = apiRequest(t, ts, "GET", "/zones/example.com/records/"+testChallenge, testAPIToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get: expected 200, got %d", resp.StatusCode)
+	}
+	var rec Record
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Name != testChallenge || rec.Value != "api-token" || rec.Expires != nil {
+		t.Fatalf("get: unexpected record %+v", rec)
+	}
+
+	resp = apiRequest(t, ts, "DELETE", "/zones/example.com/records/_acme-challenge", testAPIToken, "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", resp.StatusCode)
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("delete: expected record to be deleted")
+	}
+
+	resp = apiRequest(t, ts, "GET", "/zones/example.com/records/_acme-challenge", testAPIToken, "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("get after delete: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIList(t *testing.T) {
+	ts, store := newTestAPI(t)
+	store.TTL = time.Minute
+	store.Replace("listed")
+
+	resp := apiRequest(t, ts, "GET", "/zones/example.com/records", testAPIToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var records []Record
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value != "listed" || records[0].Expires == nil {
+		t.Fatalf("unexpected records %+v", records)
+	}
+}
+
+func TestAPIWrongName(t *testing.T) {
+	ts, _ := newTestAPI(t)
+
+	for _, path := range []string{
+		"/zones/other.com/records/_acme-challenge",
+		"/zones/example.com/records/www",
+	} {
+		resp := apiRequest(t, ts, "PUT", path, testAPIToken, `{"value": "bad"}`)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPIBadBody(t *testing.T) {
+	ts, _ := newTestAPI(t)
+
+	resp := apiRequest(t, ts, "PUT", "/zones/example.com/records/_acme-challenge", testAPIToken, `not json`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIStrictTokens(t *testing.T) {
+	store := &Store{}
+	srv := &Server{Zone: testZone, APIToken: testAPIToken, StrictTokens: true, Store: store}
+	ts := httptest.NewServer(srv.APIHandler())
+	defer ts.Close()
+
+	resp := apiRequest(t, ts, "PUT", "/zones/example.com/records/_acme-challenge", testAPIToken, `{"value": "v=spf1 -all"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("value stored")
+	}
+}
@@ -0,0 +1,130 @@
+package pajatso
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// APIKey is a bearer token of the HTTP API limited to some records and
+// operations, so that several clients can be given tokens of their own.
+type APIKey struct {
+	Name  string // identifies the key in logs
+	Token string
+	Scope APIScope
+}
+
+// APIScope limits what an API credential may do.
+type APIScope struct {
+	// Names are the record names (FQDNs) the credential may access, where
+	// "*.example.com." matches all names below example.com. All names if
+	// empty.
+	Names []string
+
+	// PresentOnly only allows setting records, not listing, reading or
+	// deleting them.
+	PresentOnly bool
+}
+
+// ParseAPIKeys parses API keys of the form
+// "<name> <token> present|full [<name>...]", e.g.
+// "ci s3cret present _acme-challenge.example.com.".
+func ParseAPIKeys(keys []string) ([]APIKey, error) {
+	var parsed []APIKey
+	for _, key := range keys {
+		f := strings.Fields(key)
+		if len(f) < 3 || f[2] != "present" && f[2] != "full" {
+			// Don't echo the token.
+			return nil, errors.New("invalid API key, want <name> <token> present|full [<name>...]")
+		}
+		k := APIKey{Name: f[0], Token: f[1], Scope: APIScope{PresentOnly: f[2] == "present"}}
+		for _, name := range f[3:] {
+			k.Scope.Names = append(k.Scope.Names, dnsutil.Fqdn(strings.ToLower(name)))
+		}
+		parsed = append(parsed, k)
+	}
+	return parsed, nil
+}
+
+// checkAPIKeys checks that keys are complete and distinct.
+func checkAPIKeys(keys []APIKey) error {
+	names := map[string]bool{}
+	tokens := map[string]bool{}
+	for _, k := range keys {
+		switch {
+		case k.Name == "" || k.Token == "":
+			return errors.New("API keys need a name and a token")
+		case names[k.Name]:
+			return fmt.Errorf("API key %s given twice", k.Name)
+		case tokens[k.Token]:
+			return fmt.Errorf("API key %s has the token of another key", k.Name)
+		}
+		names[k.Name], tokens[k.Token] = true, true
+		for _, name := range k.Scope.Names {
+			if !dnsutil.IsName(strings.TrimPrefix(name, "*.")) {
+				return fmt.Errorf("API key %s: invalid name %q", k.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// allows reports whether the scope includes the record name.
+func (sc *APIScope) allows(name string) bool {
+	if sc == nil || len(sc.Names) == 0 {
+		return true
+	}
+	for _, n := range sc.Names {
+		if parent, ok := strings.CutPrefix(n, "*."); ok {
+			if dnsutil.IsBelow(parent, name) && !dns.EqualName(parent, name) {
+				return true
+			}
+		} else if dns.EqualName(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readable reports whether the scope allows reading and deleting records.
+func (sc *APIScope) readable() bool {
+	return sc == nil || !sc.PresentOnly
+}
+
+// apiKey returns the API key with token, comparing all keys in constant
+// time.
+func (s *Server) apiKey(token string) *APIKey {
+	var found *APIKey
+	for i := range s.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.APIKeys[i].Token)) == 1 {
+			found = &s.APIKeys[i]
+		}
+	}
+	return found
+}
+
+// apiCredential is the credential an API request was authenticated with.
+type apiCredential struct {
+	name  string    // identifies the credential in logs and write limits
+	scope *APIScope // nil if not limited
+}
+
+type apiCredentialKey struct{}
+
+// apiScope returns the scope of the credential of the API request r, nil
+// if it isn't limited.
+func apiScope(r *http.Request) *APIScope {
+	cred, _ := r.Context().Value(apiCredentialKey{}).(apiCredential)
+	return cred.scope
+}
+
+// withAPICredential returns r authenticated with cred.
+func withAPICredential(r *http.Request, cred apiCredential) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiCredentialKey{}, cred))
+}
@@ -0,0 +1,105 @@
+package pajatso
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys([]string{
+		"ci s3cret present _acme-challenge.Example.com",
+		"ops t0ken full",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !keys[0].Scope.PresentOnly || keys[0].Scope.Names[0] != testChallenge || keys[1].Scope.PresentOnly || keys[1].Scope.Names != nil {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+
+	for _, key := range []string{"", "ci s3cret", "ci s3cret delete"} {
+		if _, err := ParseAPIKeys([]string{key}); err == nil {
+			t.Errorf("%q: expected an error", key)
+		}
+	}
+	for _, keys := range [][]APIKey{
+		{{Name: "ci"}},
+		{{Name: "ci", Token: "a"}, {Name: "ci", Token: "b"}},
+		{{Name: "ci", Token: "a"}, {Name: "ops", Token: "a"}},
+		{{Name: "ci", Token: "a", Scope: APIScope{Names: []string{"bad..name."}}}},
+	} {
+		if _, err := NewServer(Config{Zone: testZone, APIKeys: keys}); err == nil {
+			t.Errorf("%+v: expected an error", keys)
+		}
+	}
+}
+
+func TestAPIScopeAllows(t *testing.T) {
+	sc := &APIScope{Names: []string{testChallenge, "*.tenant.example.com."}}
+	for name, want := range map[string]bool{
+		testChallenge:                             true,
+		"_ACME-challenge.example.com.":            true,
+		"_acme-challenge.tenant.example.com.":     true,
+		"_acme-challenge.sub.tenant.example.com.": true,
+		"tenant.example.com.":                     false,
+		"_acme-challenge.other.example.com.":      false,
+	} {
+		if got := sc.allows(name); got != want {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+	if !(*APIScope)(nil).allows(testChallenge) || !(&APIScope{}).allows(testChallenge) {
+		t.Error("expected unlimited scopes to allow all names")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys([]string{
+		"present present-token present " + testChallenge,
+		"full full-token full *.example.com.",
+		"other other-token full _acme-challenge.other.example.com.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Zone: testZone, APIToken: testAPIToken, APIKeys: keys, Store: &Store{}}
+	ts := httptest.NewServer(srv.APIHandler())
+	defer ts.Close()
+
+	const records = "/zones/example.com/records"
+	const record = records + "/_acme-challenge"
+	for _, tt := range []struct {
+		token, method, path, body string
+		want                      int
+	}{
+		{"present-token", "PUT", record, `{"value":"one"}`, http.StatusNoContent},
+		{"present-token", "GET", record, "", http.StatusForbidden},
+		{"present-token", "GET", records, "", http.StatusForbidden},
+		{"present-token", "DELETE", record, "", http.StatusForbidden},
+		{"other-token", "PUT", record, `{"value":"two"}`, http.StatusForbidden},
+		{"other-token", "GET", record, "", http.StatusForbidden},
+		{"other-token", "DELETE", record, "", http.StatusForbidden},
+		{"full-token", "GET", record, "", http.StatusOK},
+		{"full-token", "DELETE", record, "", http.StatusNoContent},
+		{"full-token", "PUT", record, `{"value":"three"}`, http.StatusNoContent},
+		{testAPIToken, "GET", record, "", http.StatusOK},
+		{"unknown-token", "GET", record, "", http.StatusUnauthorized},
+	} {
+		if resp := apiRequest(t, ts, tt.method, tt.path, tt.token, tt.body); resp.StatusCode != tt.want {
+			t.Errorf("%s %s with %s: expected %d, got %d", tt.method, tt.path, tt.token, tt.want, resp.StatusCode)
+		}
+	}
+
+	// Lists only show the records in the scope of the key.
+	var list []Record
+	json.NewDecoder(apiRequest(t, ts, "GET", records, "other-token", "").Body).Decode(&list)
+	if len(list) != 0 {
+		t.Fatalf("expected no records for the other key, got %+v", list)
+	}
+	json.NewDecoder(apiRequest(t, ts, "GET", records, "full-token", "").Body).Decode(&list)
+	if len(list) != 1 || list[0].Value != "three" {
+		t.Fatalf("unexpected records %+v", list)
+	}
+}
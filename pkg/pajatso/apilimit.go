@@ -0,0 +1,216 @@
+package pajatso
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxAuthBackoff is the longest a source is refused after failed
+// authentications if APILimits.MaxAuthBackoff is zero.
+const DefaultMaxAuthBackoff = 15 * time.Minute
+
+// APILimits throttles the HTTP API and the acme-dns registration API. Zero
+// values disable the limits.
+type APILimits struct {
+	// Writes allowed per credential and per source address. Writes beyond
+	// them are answered with 429 and a Retry-After doubling with every
+	// write limited in a row.
+	WriteKeyLimit RateLimit
+	WriteIPLimit  RateLimit
+
+	// AuthBackoff is how long a source address, and the acme-dns username
+	// tried, are refused after a failed authentication, doubled with every
+	// further failure up to MaxAuthBackoff, DefaultMaxAuthBackoff if zero.
+	AuthBackoff    time.Duration
+	MaxAuthBackoff time.Duration
+}
+
+// backoffEntry tracks the failures of one key of a backoffList.
+type backoffEntry struct {
+	failures int       // failures in a row
+	until    time.Time // end of the current refusal
+}
+
+// backoffList refuses keys, e.g. source addresses, for a delay doubling
+// with every failure in a row.
+type backoffList struct {
+	base, max time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*backoffEntry
+	pruned  time.Time
+}
+
+func newBackoffList(base, maxDelay time.Duration) *backoffList {
+	if maxDelay == 0 {
+		maxDelay = DefaultMaxAuthBackoff
+	}
+	return &backoffList{base: base, max: maxDelay, now: time.Now, entries: map[string]*backoffEntry{}}
+}
+
+// wait returns how long key is still refused for. A nil backoffList refuses
+// nothing.
+func (b *backoffList) wait(key string) time.Duration {
+	if b == nil || b.base <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e, ok := b.entries[key]; ok {
+		return max(0, e.until.Sub(b.now()))
+	}
+	return 0
+}
+
+// fail records a failure of key and returns how long it is refused for.
+func (b *backoffList) fail(key string) time.Duration {
+	if b == nil || b.base <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.prune(now)
+	e, ok := b.entries[key]
+	if !ok {
+		e = &backoffEntry{}
+		b.entries[key] = e
+	}
+	e.failures++
+	delay := backoffDelay(b.base, b.max, e.failures)
+	e.until = now.Add(delay)
+	return delay
+}
+
+// succeed forgets the failures of key.
+func (b *backoffList) succeed(key string) {
+	if b == nil || b.base <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	delete(b.entries, key)
+	b.mu.Unlock()
+}
+
+// prune removes the entries whose refusal ended longer than the maximum
+// delay ago, at most once per second.
+func (b *backoffList) prune(now time.Time) {
+	if now.Sub(b.pruned) < time.Second {
+		return
+	}
+	b.pruned = now
+	for key, e := range b.entries {
+		if now.Sub(e.until) > b.max {
+			delete(b.entries, key)
+		}
+	}
+}
+
+// backoffDelay returns base doubled for every failure after the first, up
+// to maxDelay.
+func backoffDelay(base, maxDelay time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// apiLimiters holds the state of the APILimits of a Server.
+type apiLimiters struct {
+	once  sync.Once
+	key   *rateLimiter
+	ip    *rateLimiter
+	auths *backoffList
+}
+
+// apiLimits returns the limiters of the APILimits of s, creating them on
+// first use.
+func (s *Server) apiLimits() *apiLimiters {
+	l := &s.apiLimiters
+	l.once.Do(func() {
+		l.key = newRateLimiter(s.APILimits.WriteKeyLimit)
+		l.ip = newRateLimiter(s.APILimits.WriteIPLimit)
+		l.auths = newBackoffList(s.APILimits.AuthBackoff, s.APILimits.MaxAuthBackoff)
+		l.key.now, l.ip.now, l.auths.now = s.now, s.now, s.now
+	})
+	return l
+}
+
+// authRefused reports whether any of keys, e.g. the source address, is
+// refused after failed authentications, writing a 429 response with
+// errMsg if so.
+func (l *apiLimiters) authRefused(w http.ResponseWriter, errMsg string, keys ...string) bool {
+	var wait time.Duration
+	for _, key := range keys {
+		wait = max(wait, l.auths.wait(key))
+	}
+	if wait == 0 {
+		return false
+	}
+	tooManyRequests(w, wait, errMsg)
+	return true
+}
+
+// authFailed records a failed authentication of all keys, returning how
+// long the first is refused for.
+func (l *apiLimiters) authFailed(keys ...string) time.Duration {
+	var delay time.Duration
+	for i, key := range keys {
+		if d := l.auths.fail(key); i == 0 {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// authSucceeded forgets the failed authentications of keys.
+func (l *apiLimiters) authSucceeded(keys ...string) {
+	for _, key := range keys {
+		l.auths.succeed(key)
+	}
+}
+
+// writeLimited reports whether a write with credential from ip exceeds the
+// write limits, writing a 429 response with errMsg if so. An empty
+// credential is only limited by address.
+func (l *apiLimiters) writeLimited(w http.ResponseWriter, errMsg, credential, ip string) bool {
+	ok, n := l.ip.allow(ip)
+	limiter := l.ip
+	if ok && credential != "" {
+		ok, n = l.key.allow(credential)
+		limiter = l.key
+	}
+	if ok {
+		return false
+	}
+	interval := time.Duration(float64(time.Second) / limiter.limit.Rate)
+	tooManyRequests(w, backoffDelay(interval, DefaultMaxAuthBackoff, n), errMsg)
+	return true
+}
+
+// tooManyRequests writes a 429 response asking the client to retry after
+// wait.
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, errMsg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeJSON(w, http.StatusTooManyRequests, apiError{errMsg})
+}
+
+// httpRemoteIP returns the IP address of the client of r without the port.
+func httpRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
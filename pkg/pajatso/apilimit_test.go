@@ -0,0 +1,128 @@
+package pajatso
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffList(t *testing.T) {
+	clock := newFakeClock()
+	b := newBackoffList(time.Second, 5*time.Second)
+	b.now = clock.Now
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := b.fail("a"); got != want {
+			t.Fatalf("expected a backoff of %v, got %v", want, got)
+		}
+	}
+	if got := b.wait("a"); got != 5*time.Second {
+		t.Fatalf("expected to wait 5s, got %v", got)
+	}
+	if got := b.wait("b"); got != 0 {
+		t.Fatalf("expected other keys not to wait, got %v", got)
+	}
+	clock.Advance(5 * time.Second)
+	if got := b.wait("a"); got != 0 {
+		t.Fatalf("expected the backoff to end, got %v", got)
+	}
+	b.succeed("a")
+	if got := b.fail("a"); got != time.Second {
+		t.Fatalf("expected a success to reset the backoff, got %v", got)
+	}
+
+	if d := (*backoffList)(nil).fail("a"); d != 0 {
+		t.Fatalf("expected a nil list to refuse nothing, got %v", d)
+	}
+}
+
+func TestAPILimits(t *testing.T) {
+	clock := newFakeClock()
+	srv := &Server{
+		Zone:     testZone,
+		APIToken: testAPIToken,
+		Store:    &Store{},
+		Clock:    clock,
+		APILimits: APILimits{
+			WriteKeyLimit: RateLimit{Rate: 1, Burst: 1},
+			AuthBackoff:   time.Second,
+		},
+	}
+	h := srv.APIHandler()
+	request := func(method, remote, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/zones/example.com/records/_acme-challenge", strings.NewReader(`{"value":"token"}`))
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Failed authentications back off the source, even for the right
+	// token, but not other sources.
+	if rec := request("GET", "192.0.2.1", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+	if rec := request("GET", "192.0.2.1", testAPIToken); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := request("GET", "192.0.2.2", testAPIToken); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected another source to be served, got %d", rec.Code)
+	}
+	clock.Advance(time.Second)
+	request("GET", "192.0.2.1", "wrong")
+	if rec := request("GET", "192.0.2.1", testAPIToken); rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected the backoff to double, got Retry-After %q", rec.Header().Get("Retry-After"))
+	}
+
+	// Writes beyond the limit of the credential are refused, with a
+	// Retry-After doubling while the client keeps trying.
+	if rec := request("PUT", "192.0.2.2", testAPIToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	for _, want := range []string{"1", "2", "4"} {
+		if rec := request("DELETE", "192.0.2.3", testAPIToken); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != want {
+			t.Fatalf("expected 429 with Retry-After %s, got %d %q", want, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+	if rec := request("GET", "192.0.2.3", testAPIToken); rec.Code != http.StatusOK {
+		t.Fatalf("expected reads not to be limited, got %d", rec.Code)
+	}
+	clock.Advance(time.Second)
+	if rec := request("DELETE", "192.0.2.3", testAPIToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 once the limit refilled, got %d", rec.Code)
+	}
+}
+
+func TestRegistryAuthBackoff(t *testing.T) {
+	clock := newFakeClock()
+	srv := &Server{Zone: testZone, Store: &Store{}, Registry: &Registry{}, Clock: clock, APILimits: APILimits{AuthBackoff: time.Minute}}
+	reg, password, err := srv.Registry.Register(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := srv.RegistryHandler()
+	update := func(remote, key string) int {
+		req := httptest.NewRequest("POST", "/update", strings.NewReader(`{"subdomain": "`+reg.Subdomain+`", "txt": "`+testACMETXT("a")+`"}`))
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set("X-Api-User", reg.Username)
+		req.Header.Set("X-Api-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A wrong password backs off the username from all sources.
+	if code := update("192.0.2.1", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", code)
+	}
+	if code := update("192.0.2.2", password); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", code)
+	}
+	clock.Advance(time.Minute)
+	if code := update("192.0.2.2", password); code != http.StatusOK {
+		t.Fatalf("expected 200 after the backoff, got %d", code)
+	}
+}
@@ -0,0 +1,121 @@
+package pajatso
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BanConfig configures the ban list of a Server: source addresses with
+// Threshold refused updates within Window are banned, and all their requests
+// dropped without a response, for Duration.
+type BanConfig struct {
+	Threshold int           // refused updates that get a source banned, disabled if zero
+	Window    time.Duration // window counting refused updates, 10 minutes if zero
+	Duration  time.Duration // duration of a ban, 1 hour if zero
+}
+
+// banEntry tracks the refused updates of one source address.
+type banEntry struct {
+	failures int       // refused updates since start
+	start    time.Time // start of the current window
+	until    time.Time // end of the ban, zero if not banned
+}
+
+// banList holds the ban state by source address.
+type banList struct {
+	cfg BanConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	sources map[string]*banEntry
+	pruned  time.Time
+
+	bans    atomic.Uint64 // bans imposed
+	dropped atomic.Uint64 // requests dropped from banned sources
+}
+
+func newBanList(cfg BanConfig) *banList {
+	if cfg.Window == 0 {
+		cfg.Window = 10 * time.Minute
+	}
+	if cfg.Duration == 0 {
+		cfg.Duration = time.Hour
+	}
+	return &banList{cfg: cfg, now: time.Now, sources: map[string]*banEntry{}}
+}
+
+// fail records a refused update from ip and reports whether it got the
+// source banned. A nil banList bans nothing.
+func (b *banList) fail(ip string) bool {
+	if b == nil || b.cfg.Threshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.prune(now)
+	e, ok := b.sources[ip]
+	if !ok || now.Sub(e.start) > b.cfg.Window {
+		e = &banEntry{start: now}
+		b.sources[ip] = e
+	}
+	e.failures++
+	if e.failures < b.cfg.Threshold || now.Before(e.until) {
+		return false
+	}
+	e.until = now.Add(b.cfg.Duration)
+	b.bans.Add(1)
+	return true
+}
+
+// banned reports whether requests from ip are to be dropped, counting them.
+func (b *banList) banned(ip string) bool {
+	if b == nil || b.cfg.Threshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	e, ok := b.sources[ip]
+	banned := ok && b.now().Before(e.until)
+	b.mu.Unlock()
+
+	if banned {
+		b.dropped.Add(1)
+	}
+	return banned
+}
+
+// stats returns the number of sources currently banned, bans imposed and
+// requests dropped.
+func (b *banList) stats() (active int, bans, dropped uint64) {
+	if b == nil {
+		return 0, 0, 0
+	}
+
+	b.mu.Lock()
+	now := b.now()
+	for _, e := range b.sources {
+		if now.Before(e.until) {
+			active++
+		}
+	}
+	b.mu.Unlock()
+	return active, b.bans.Load(), b.dropped.Load()
+}
+
+// prune removes the entries with neither a current window nor a ban, at
+// most once per second.
+func (b *banList) prune(now time.Time) {
+	if now.Sub(b.pruned) < time.Second {
+		return
+	}
+	b.pruned = now
+	for ip, e := range b.sources {
+		if now.Sub(e.start) > b.cfg.Window && !now.Before(e.until) {
+			delete(b.sources, ip)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package pajatso
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestBanList(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBanList(BanConfig{Threshold: 2, Window: time.Minute, Duration: time.Hour})
+	b.now = func() time.Time { return now }
+
+	if b.fail("192.0.2.1") || b.banned("192.0.2.1") {
+		t.Fatal("banned below the threshold")
+	}
+	// Failures outside the window don't add up.
+	now = now.Add(2 * time.Minute)
+	if b.fail("192.0.2.1") {
+		t.Fatal("banned with a failure outside the window")
+	}
+	if !b.fail("192.0.2.1") || !b.banned("192.0.2.1") {
+		t.Fatal("not banned at the threshold")
+	}
+	if b.banned("192.0.2.2") {
+		t.Fatal("other source banned")
+	}
+	if active, bans, dropped := b.stats(); active != 1 || bans != 1 || dropped != 1 {
+		t.Fatalf("stats = %d, %d, %d, want 1, 1, 1", active, bans, dropped)
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	if b.banned("192.0.2.1") {
+		t.Fatal("still banned after the duration")
+	}
+
+	var disabled *banList
+	if disabled.fail("192.0.2.1") || disabled.banned("192.0.2.1") {
+		t.Fatal("nil ban list banned")
+	}
+}
+
+func TestBanRefusedUpdates(t *testing.T) {
+	srv := &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		Store:      &Store{},
+		Ban:        BanConfig{Threshold: 2},
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	store.Replace("token")
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"forged\"")
+	for range 2 {
+		sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, "d3JvbmcgU2VjcmV0")
+	}
+
+	// All requests from the banned source are dropped.
+	c := dns.NewClient()
+	c.ReadTimeout = 200 * time.Millisecond
+	if _, _, err := c.Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "udp", addr); err == nil {
+		t.Fatal("expected no response for a banned source")
+	}
+
+	rec := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{"pajatso_banned_sources 1\n", "pajatso_bans_total 1\n", "pajatso_banned_requests_total 1\n"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, rec.Body)
+		}
+	}
+}
+
+func TestBanSharedWithZones(t *testing.T) {
+	const (
+		tenantZone   = "example.org."
+		tenantKey    = "tenant-key."
+		tenantSecret = "dGVuYW50LXNlY3JldC1mb3ItdGVzdGluZw=="
+	)
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}, Ban: BanConfig{Threshold: 2}}
+	tenant := &Server{Zone: tenantZone, TsigName: tenantKey, TsigSecret: tenantSecret, Store: &Store{}}
+	addr := startTestHandler(t, srv.Handler(WithZones(tenant)))
+
+	// Refused updates to the tenant zone ban the source from all zones.
+	rr, _ := dns.New("_acme-challenge." + tenantZone + " 60 IN TXT \"forged\"")
+	for range 2 {
+		sendUpdate(t, addr, tenantZone, []dns.RR{rr}, tenantKey, "d3JvbmcgU2VjcmV0")
+	}
+	c := dns.NewClient()
+	c.ReadTimeout = 200 * time.Millisecond
+	if _, _, err := c.Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "udp", addr); err == nil {
+		t.Fatal("expected no response for a banned source")
+	}
+
+	rec := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "pajatso_bans_total 1\n") {
+		t.Errorf("metrics missing the ban:\n%s", rec.Body)
+	}
+}
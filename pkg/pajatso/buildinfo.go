@@ -0,0 +1,50 @@
+package pajatso
+
+import "runtime/debug"
+
+// dnsModule is the module path of the DNS library, whose version is reported
+// with the build.
+const dnsModule = "codeberg.org/miekg/dns"
+
+// BuildInfo describes the build of the running binary, as recorded by the Go
+// toolchain. Fields it didn't record are empty.
+type BuildInfo struct {
+	Version  string `json:"version"`            // module version, e.g. "v1.2.3", or "(devel)"
+	Commit   string `json:"commit,omitempty"`   // VCS revision built
+	Date     string `json:"date,omitempty"`     // time of the commit built, RFC 3339; Go records no build time
+	Modified bool   `json:"modified,omitempty"` // built with uncommitted changes
+	Go       string `json:"go"`                 // Go version, e.g. "go1.25.0"
+	DNS      string `json:"dns,omitempty"`      // version of the DNS library
+}
+
+// ReadBuildInfo returns the BuildInfo of the running binary.
+func ReadBuildInfo() BuildInfo {
+	b := BuildInfo{Version: "(devel)"}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if info.Main.Version != "" {
+		b.Version = info.Main.Version
+	}
+	b.Go = info.GoVersion
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Commit = s.Value
+		case "vcs.time":
+			b.Date = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == dnsModule {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			b.DNS = dep.Version
+		}
+	}
+	return b
+}
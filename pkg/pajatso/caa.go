@@ -0,0 +1,43 @@
+package pajatso
+
+import (
+	"fmt"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// caaTTL is the TTL of CAA records, which change rarely.
+const caaTTL = 3600
+
+// ParseCAA parses CAA records in presentation format without the owner
+// name, e.g. `0 issue "letsencrypt.org"` or `0 iodef "mailto:ca@example.com"`.
+func ParseCAA(values []string) ([]rdata.CAA, error) {
+	var caa []rdata.CAA
+	for _, v := range values {
+		rr, err := dns.New(". IN CAA " + v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CAA record %q: %w", v, err)
+		}
+		r, ok := rr.(*dns.CAA)
+		if !ok {
+			return nil, fmt.Errorf("invalid CAA record %q", v)
+		}
+		caa = append(caa, r.CAA)
+	}
+	return caa, nil
+}
+
+// answerCAA adds the configured CAA records of the zone apex to m.
+func (s *Server) answerCAA(m *dns.Msg) {
+	for _, caa := range s.CAA {
+		m.Answer = append(m.Answer, &dns.CAA{
+			Hdr: dns.Header{
+				Name:  s.Zone,
+				Class: dns.ClassINET,
+				TTL:   caaTTL,
+			},
+			CAA: caa,
+		})
+	}
+}
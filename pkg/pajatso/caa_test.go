@@ -0,0 +1,42 @@
+package pajatso
+
+import (
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestParseCAA(t *testing.T) {
+	caa, err := ParseCAA([]string{`0 issue "letsencrypt.org"`, `128 iodef "mailto:ca@example.com"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caa) != 2 || caa[0].Tag != "issue" || caa[0].Value != "letsencrypt.org" || caa[1].Flag != 128 {
+		t.Fatalf("unexpected records %+v", caa)
+	}
+	for _, bad := range []string{"", "issue letsencrypt.org", `0 issue "a" extra`} {
+		if _, err := ParseCAA([]string{bad}); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestCAAQuery(t *testing.T) {
+	caa, _ := ParseCAA([]string{`0 issue "letsencrypt.org"`, `0 issuewild ";"`})
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}, CAA: caa}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	r := query(t, addr, testZone, dns.TypeCAA)
+	if len(r.Answer) != 2 {
+		t.Fatalf("expected 2 CAA records, got %d", len(r.Answer))
+	}
+	if rr, ok := r.Answer[0].(*dns.CAA); !ok || rr.Value != "letsencrypt.org" || !dns.EqualName(rr.Hdr.Name, testZone) {
+		t.Fatalf("unexpected answer %v", r.Answer[0])
+	}
+
+	// Only at the zone apex.
+	if r := query(t, addr, testChallenge, dns.TypeCAA); len(r.Answer) != 0 {
+		t.Fatalf("expected no CAA records for the challenge name, got %d", len(r.Answer))
+	}
+}
@@ -0,0 +1,37 @@
+package pajatso
+
+import (
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// answerChaos answers CHAOS class TXT queries for the server identity
+// (id.server. and hostname.bind.) and version (version.server. and
+// version.bind.). Other CHAOS queries, and all of them if Chaos is
+// disabled, are refused.
+func (s *Server) answerChaos(m *dns.Msg, qname string, qtype uint16) {
+	var val string
+	switch qname {
+	case "id.server.", "hostname.bind.":
+		val = s.Identity
+	case "version.server.", "version.bind.":
+		val = s.Version
+	}
+	if !s.Chaos || val == "" {
+		m.Rcode = dns.RcodeRefused
+		return
+	}
+
+	if qtype == dns.TypeTXT || qtype == dns.TypeANY {
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.Header{
+				Name:  qname,
+				Class: dns.ClassCHAOS,
+				TTL:   0,
+			},
+			TXT: rdata.TXT{
+				Txt: []string{val},
+			},
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package pajatso
+
+import (
+	"context"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func queryChaos(t *testing.T, addr, name string) *dns.Msg {
+	t.Helper()
+	m := dns.NewMsg(name, dns.TypeTXT)
+	m.Question[0].Header().Class = dns.ClassCHAOS
+
+	r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	return r
+}
+
+func TestChaos(t *testing.T) {
+	srv := &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		Chaos:      true,
+		Identity:   "ns1",
+		Version:    "dns-pajatso test",
+		Store:      &Store{},
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	for name, want := range map[string]string{
+		"id.server.":     "ns1",
+		"hostname.bind.": "ns1",
+		"version.bind.":  "dns-pajatso test",
+	} {
+		r := queryChaos(t, addr, name)
+		if len(r.Answer) != 1 {
+			t.Fatalf("%s: expected 1 answer, got %d", name, len(r.Answer))
+		}
+		if txt := r.Answer[0].(*dns.TXT); txt.Txt[0] != want {
+			t.Fatalf("%s: expected %q, got %v", name, want, txt.Txt)
+		}
+	}
+
+	if r := queryChaos(t, addr, "authors.bind."); r.Rcode != dns.RcodeRefused {
+		t.Fatalf("authors.bind.: expected REFUSED, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestChaosDisabled(t *testing.T) {
+	addr, _, cleanup := startTestServer(t)
+	defer cleanup()
+
+	r := queryChaos(t, addr, "version.bind.")
+	if r.Rcode != dns.RcodeRefused || len(r.Answer) != 0 {
+		t.Fatalf("expected REFUSED without answers, got %s with %d answers", dns.RcodeToString[r.Rcode], len(r.Answer))
+	}
+}
@@ -0,0 +1,38 @@
+package pajatso
+
+import "time"
+
+// Clock tells the time for the expiry of stored values and the TSIG time
+// checks, so that tests and embedders can simulate its passing instead of
+// waiting. A nil Clock is the system clock.
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine once d has passed, unless the
+	// returned Timer is stopped first.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a call scheduled with Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the call, reporting false if it has already been made
+	// or the Timer stopped.
+	Stop() bool
+}
+
+// SystemClock is the Clock of the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// clockOr returns c, or SystemClock if c is nil.
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
@@ -0,0 +1,74 @@
+package pajatso
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// fakeClock is a Clock that only moves on Advance, calling the functions
+// that have become due synchronously.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c    *fakeClock
+	when time.Time
+	f    func()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	n := len(t.c.timers)
+	t.c.timers = slices.DeleteFunc(t.c.timers, func(o *fakeTimer) bool { return o == t })
+	return len(t.c.timers) < n
+}
+
+// Advance moves the clock forward by d, calling the functions due by then
+// in order.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		i := -1
+		for j, t := range c.timers {
+			if !t.when.After(end) && (i < 0 || t.when.Before(c.timers[i].when)) {
+				i = j
+			}
+		}
+		if i < 0 {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		t := c.timers[i]
+		c.timers = slices.Delete(c.timers, i, i+1)
+		c.now = t.when
+		c.mu.Unlock()
+		t.f()
+	}
+}
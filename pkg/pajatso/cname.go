@@ -0,0 +1,64 @@
+package pajatso
+
+import (
+	"fmt"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+const (
+	cnameTTL      = 300 // TTL of the CNAME records from Server.CNAME
+	maxCNAMEChain = 8   // CNAME records followed in one answer
+)
+
+// cname returns the CNAME record at name, from CNAME or the static records,
+// or nil if there is none.
+func (s *Server) cname(name string) *dns.CNAME {
+	for owner, target := range s.CNAME {
+		if dns.EqualName(owner, name) {
+			return &dns.CNAME{
+				Hdr:   dns.Header{Name: owner, Class: dns.ClassINET, TTL: cnameTTL},
+				CNAME: rdata.CNAME{Target: target},
+			}
+		}
+	}
+	for _, rr := range s.Static {
+		if c, ok := rr.(*dns.CNAME); ok && dns.EqualName(c.Hdr.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// checkCNAME validates CNAME mappings of a zone and returns them with
+// lowercase FQDNs. Owners must be below the zone, but not the apex, the
+// challenge record or a name with static records, which carry other records.
+func checkCNAME(cnames map[string]string, static []dns.RR, zone, challenge string) (map[string]string, error) {
+	checked := map[string]string{}
+	for owner, target := range cnames {
+		owner, target = dnsutil.Fqdn(strings.ToLower(owner)), dnsutil.Fqdn(strings.ToLower(target))
+		switch {
+		case !dnsutil.IsName(owner) || !dnsutil.IsBelow(zone, owner):
+			return nil, fmt.Errorf("CNAME owner %q outside zone %s", owner, zone)
+		case dns.EqualName(owner, zone) || dns.EqualName(owner, challenge) || hasName(static, owner):
+			return nil, fmt.Errorf("CNAME at %s, which has other records", owner)
+		case !dnsutil.IsName(target):
+			return nil, fmt.Errorf("invalid CNAME target %q", target)
+		}
+		checked[owner] = target
+	}
+	return checked, nil
+}
+
+// hasName reports whether rrs has records at name.
+func hasName(rrs []dns.RR, name string) bool {
+	for _, rr := range rrs {
+		if dns.EqualName(rr.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package pajatso
+
+import (
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestCheckCNAME(t *testing.T) {
+	static, err := dns.New("www." + testZone + " IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, cnames := range map[string]map[string]string{
+		"outside zone":   {"foo.example.org": testZone},
+		"apex":           {testZone: "example.net"},
+		"challenge":      {testChallenge: "example.net"},
+		"static":         {"www." + testZone: "example.net"},
+		"invalid target": {"foo." + testZone: "bad..name"},
+	} {
+		if _, err := NewServer(Config{Zone: testZone, CNAME: cnames, Static: []dns.RR{static}}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	cname, err := dns.New("www." + testZone + " IN CNAME example.net.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewServer(Config{Zone: testZone, Static: []dns.RR{static, cname}}); err == nil {
+		t.Error("expected an error for a static CNAME with other records")
+	}
+}
+
+func TestCNAMEQuery(t *testing.T) {
+	srv, err := NewServer(Config{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		CNAME: map[string]string{
+			"_acme-challenge.customer.example.com": "Link.example.com",
+			"link.example.com":                     testChallenge,
+			"external.example.com":                 "_acme-challenge.example.net",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	store.Replace("chained-token")
+
+	r := query(t, addr, "_acme-challenge.customer.example.com.", dns.TypeTXT)
+	if len(r.Answer) != 3 {
+		t.Fatalf("expected 2 CNAME and 1 TXT records, got %v", r.Answer)
+	}
+	if c, ok := r.Answer[1].(*dns.CNAME); !ok || c.Target != testChallenge {
+		t.Fatalf("unexpected second record %v", r.Answer[1])
+	}
+	if txt, ok := r.Answer[2].(*dns.TXT); !ok || txt.Txt[0] != "chained-token" {
+		t.Fatalf("unexpected TXT record %v", r.Answer[2])
+	}
+
+	if r := query(t, addr, "_acme-challenge.customer.example.com.", dns.TypeCNAME); len(r.Answer) != 1 {
+		t.Fatalf("expected only the queried CNAME, got %v", r.Answer)
+	}
+	if r := query(t, addr, "external.example.com.", dns.TypeTXT); len(r.Answer) != 1 || r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected only the CNAME out of the zone, got %v", r)
+	}
+}
@@ -0,0 +1,354 @@
+package pajatso
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Config holds the settings of a Server created by NewServer. Names may be
+// given with or without the trailing dot.
+type Config struct {
+	Zone       string // zone, e.g. "example.com"
+	Subdomain  string // optional subdomain prefix, e.g. "sub" for "_acme-challenge.sub.example.com."
+	TsigName   string // TSIG key name; updates are refused if empty
+	TsigSecret string // Base64-encoded HMAC secret, required with TsigName
+	TsigAlg    string // TSIG algorithm, defaults to dns.HmacSHA512
+	APIToken   string // bearer token for the HTTP API, see APIHandler
+	HealthName string // optional label answering health TXT queries
+
+	APIJWT  *JWTAuth // JWTs accepted by the HTTP API, see Server.APIJWT
+	APIKeys []APIKey // scoped bearer tokens of the HTTP API, see ParseAPIKeys
+
+	// TsigPreviousSecret is the secret being rotated out, accepted next to
+	// TsigSecret until dropped, see Server.TsigPreviousSecret.
+	TsigPreviousSecret string
+
+	StrictTokens   bool // only accept ACME challenge digests as challenge values
+	MaxTokenLength int  // maximum challenge value length in bytes, DefaultMaxTokenLength if zero
+
+	TsigFudge time.Duration // allowed clock skew of signed updates, 5 minutes if zero
+
+	Chaos    bool   // answer CHAOS class identity and version queries
+	Identity string // instance identity for id.server. and hostname.bind.
+	Version  string // version string for version.server. and version.bind.
+	NSID     bool   // return Identity as the NSID when requested
+
+	CAA []string // CAA records for the zone apex, e.g. `0 issue "letsencrypt.org"`
+
+	NS          []string     // name servers of the zone, enabling SOA and NS answers
+	Hostmaster  string       // SOA RNAME, defaults to hostmaster.<zone>
+	NSAddrs     []netip.Addr // addresses of NS[0], which must be inside the zone
+	AuthorityNS bool         // add the NS records to the authority section of positive answers
+	Static      []dns.RR     // static records below the zone, e.g. from ParseZoneFile
+
+	NXDomain    bool          // answer names without records with NXDOMAIN instead of NODATA
+	NegativeTTL time.Duration // SOA minimum, DefaultNegativeTTL if zero
+
+	CNAME map[string]string // CNAME records below the zone, by owner name
+
+	TokenTTL    time.Duration // lifetime of a challenge token, zero means it never expires
+	DeleteGrace time.Duration // time deleted tokens are still served, see Store.DeleteGrace
+
+	Clock Clock // clock of the server and its Store, the system clock if nil
+
+	MaxTCPConns     int           // maximum concurrent TCP connections, zero means unlimited
+	MaxTCPPipelined int           // maximum queries handled at once on a TCP connection, zero means unlimited
+	TCPIdleTimeout  time.Duration // idle time allowed between queries on a TCP connection
+	TCPReadTimeout  time.Duration // time allowed for reading a query over TCP
+	TCPWriteTimeout time.Duration // time allowed for writing a response over TCP
+	RequestTimeout  time.Duration // deadline for handling a request, DefaultRequestTimeout if zero
+	MaxRequests     int           // maximum requests handled at once, zero means unlimited
+
+	SlowRequestThreshold time.Duration // log requests taking at least this long, none if zero
+
+	UDPSize uint16 // EDNS0 UDP payload size, DefaultUDPSize if zero
+
+	UpdateKeyLimit RateLimit // update rate limit per TSIG key, none if zero
+	UpdateIPLimit  RateLimit // update rate limit per source address, none if zero
+
+	UpdateAllow    []netip.Prefix            // networks allowed to send updates, all if empty
+	UpdateAllowKey map[string][]netip.Prefix // networks allowed to send updates by TSIG key name
+
+	UpdatePolicy []Grant // records updates may change, the challenge TXT record if empty
+	GenericTXT   bool    // let updates publish TXT records at any name in the zone
+
+	NotifyZones []string       // zones NOTIFY messages are accepted for, none if empty
+	NotifyAllow []netip.Prefix // networks allowed to send NOTIFY messages, all if empty
+
+	Ban BanConfig // ban list for sources with repeated refused updates, disabled if zero
+
+	APILimits APILimits // throttling of the HTTP and acme-dns APIs, disabled if zero
+}
+
+// NewServer validates cfg and returns a Server with an empty Store, ready
+// to serve: errors in the configuration are returned here rather than
+// surfacing once serving.
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Zone == "" {
+		return nil, errors.New("zone is required")
+	}
+	// Internationalized names are served with A-labels, as queried.
+	zone, err := ToASCII(cfg.Zone)
+	if err != nil {
+		return nil, err
+	}
+	zone = dnsutil.Fqdn(strings.ToLower(zone))
+	subdomain, err := ToASCII(strings.Trim(cfg.Subdomain, "."))
+	if err != nil {
+		return nil, err
+	}
+
+	var tsigName string
+	if cfg.TsigName != "" {
+		tsigName = dnsutil.Fqdn(strings.ToLower(cfg.TsigName))
+	}
+	alg := dns.HmacSHA512
+	if cfg.TsigAlg != "" {
+		alg = dnsutil.Fqdn(strings.ToLower(cfg.TsigAlg))
+	}
+	allowKey := map[string][]netip.Prefix{}
+	for name, prefixes := range cfg.UpdateAllowKey {
+		allowKey[dnsutil.Fqdn(strings.ToLower(name))] = prefixes
+	}
+	var ns []string
+	for _, n := range cfg.NS {
+		ns = append(ns, dnsutil.Fqdn(strings.ToLower(asciiName(n))))
+	}
+	var notifyZones []string
+	for _, z := range cfg.NotifyZones {
+		ascii, err := ToASCII(z)
+		if err != nil {
+			return nil, err
+		}
+		notifyZones = append(notifyZones, dnsutil.Fqdn(strings.ToLower(ascii)))
+	}
+	var hostmaster string
+	if cfg.Hostmaster != "" {
+		hostmaster = dnsutil.Fqdn(strings.ToLower(asciiName(cfg.Hostmaster)))
+	}
+
+	challenge := "_acme-challenge." + zone
+	if subdomain != "" {
+		challenge = "_acme-challenge." + subdomain + "." + zone
+	}
+	cnames, err := checkCNAME(cfg.CNAME, cfg.Static, zone, challenge)
+	if err != nil {
+		return nil, err
+	}
+	caa, err := ParseCAA(cfg.CAA)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Zone:       zone,
+		Subdomain:  subdomain,
+		TsigName:   tsigName,
+		TsigSecret: cfg.TsigSecret,
+		TsigAlg:    alg,
+
+		TsigPreviousSecret: cfg.TsigPreviousSecret,
+		TsigFudge:          cfg.TsigFudge,
+		StrictTokens:       cfg.StrictTokens,
+		MaxTokenLength:     cfg.MaxTokenLength,
+
+		APIToken:   cfg.APIToken,
+		APIJWT:     cfg.APIJWT,
+		APIKeys:    cfg.APIKeys,
+		HealthName: strings.Trim(cfg.HealthName, "."),
+		Chaos:      cfg.Chaos,
+		NSID:       cfg.NSID,
+		CAA:        caa,
+		NS:         ns,
+		Hostmaster: hostmaster,
+		NSAddrs:    cfg.NSAddrs,
+		Static:     cfg.Static,
+		CNAME:      cnames,
+		Identity:   cfg.Identity,
+		Version:    cfg.Version,
+
+		MaxTCPConns:     cfg.MaxTCPConns,
+		MaxTCPPipelined: cfg.MaxTCPPipelined,
+		TCPIdleTimeout:  cfg.TCPIdleTimeout,
+		TCPReadTimeout:  cfg.TCPReadTimeout,
+		TCPWriteTimeout: cfg.TCPWriteTimeout,
+		RequestTimeout:  cfg.RequestTimeout,
+		MaxRequests:     cfg.MaxRequests,
+		UDPSize:         cfg.UDPSize,
+		UpdateKeyLimit:  cfg.UpdateKeyLimit,
+		UpdateIPLimit:   cfg.UpdateIPLimit,
+		UpdateAllow:     cfg.UpdateAllow,
+		UpdateAllowKey:  allowKey,
+		UpdatePolicy:    cfg.UpdatePolicy,
+		GenericTXT:      cfg.GenericTXT,
+		NotifyZones:     notifyZones,
+		NotifyAllow:     cfg.NotifyAllow,
+		Ban:             cfg.Ban,
+		APILimits:       cfg.APILimits,
+
+		SlowRequestThreshold: cfg.SlowRequestThreshold,
+		AuthorityNS:          cfg.AuthorityNS,
+
+		NXDomain:    cfg.NXDomain,
+		NegativeTTL: cfg.NegativeTTL,
+
+		Clock: cfg.Clock,
+		Store: &Store{TTL: cfg.TokenTTL, DeleteGrace: cfg.DeleteGrace, Clock: cfg.Clock},
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Decode the secrets now, so that serving can't fail on them.
+	if tsigName != "" {
+		s.SetTsigSecret(s.TsigSecret)
+		s.SetTsigPreviousSecret(s.TsigPreviousSecret)
+	}
+	return s, nil
+}
+
+// Validate checks the settings of s as NewServer does, for Servers set up
+// without it. Names must be FQDNs. A Server with an invalid TSIG secret
+// still serves queries, but refuses all updates.
+func (s *Server) Validate() error {
+	if s.Zone == "." || !isFQDN(s.Zone) {
+		return fmt.Errorf("invalid zone %q", s.Zone)
+	}
+	if s.Subdomain != "" && !dnsutil.IsName("_acme-challenge."+s.Subdomain+"."+s.Zone) {
+		return fmt.Errorf("invalid subdomain %q", s.Subdomain)
+	}
+	if s.HealthName != "" && !dnsutil.IsName(s.HealthName+"."+s.Zone) {
+		return fmt.Errorf("invalid health name %q", s.HealthName)
+	}
+
+	if s.TsigName != "" {
+		if !isFQDN(s.TsigName) {
+			return fmt.Errorf("invalid TSIG key name %q", s.TsigName)
+		}
+		secret, err := base64.StdEncoding.DecodeString(s.TsigSecret)
+		if err != nil {
+			return fmt.Errorf("invalid TSIG secret: %w", err)
+		}
+		if len(secret) == 0 {
+			return errors.New("TSIG secret is required with a TSIG key name")
+		}
+		if s.TsigPreviousSecret != "" {
+			if prev, err := base64.StdEncoding.DecodeString(s.TsigPreviousSecret); err != nil || len(prev) == 0 {
+				return errors.New("invalid previous TSIG secret")
+			}
+		}
+	} else if s.TsigSecret != "" || s.TsigPreviousSecret != "" {
+		return errors.New("TSIG key name is required with a TSIG secret")
+	}
+	switch s.tsigAlgorithm() {
+	case dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512:
+	default:
+		return fmt.Errorf("unsupported TSIG algorithm %q", s.TsigAlg)
+	}
+	if s.TsigFudge < 0 || s.TsigFudge > 65535*time.Second {
+		return fmt.Errorf("TSIG fudge %v out of range", s.TsigFudge)
+	}
+
+	if s.APIJWT != nil {
+		if err := s.APIJWT.check(); err != nil {
+			return err
+		}
+	}
+	if err := checkAPIKeys(s.APIKeys); err != nil {
+		return err
+	}
+
+	if s.Store == nil {
+		return errors.New("no Store")
+	}
+	if s.Store.TTL < 0 {
+		return fmt.Errorf("negative token TTL %v", s.Store.TTL)
+	}
+	if s.Store.DeleteGrace < 0 {
+		return fmt.Errorf("negative delete grace period %v", s.Store.DeleteGrace)
+	}
+	if s.MaxTokenLength < 0 {
+		return fmt.Errorf("negative maximum token length %d", s.MaxTokenLength)
+	}
+	if s.UDPSize != 0 && s.UDPSize < dns.MinMsgSize {
+		return fmt.Errorf("EDNS0 UDP payload size %d below the minimum of %d", s.UDPSize, dns.MinMsgSize)
+	}
+	for _, l := range []RateLimit{s.UpdateKeyLimit, s.UpdateIPLimit} {
+		if l.Rate < 0 || l.Burst < 0 {
+			return fmt.Errorf("negative update rate limit %+v", l)
+		}
+	}
+	for name := range s.UpdateAllowKey {
+		if !dns.EqualName(name, s.TsigName) {
+			return fmt.Errorf("update allowlist for unknown TSIG key %q", name)
+		}
+	}
+	for _, z := range s.NotifyZones {
+		if z == "." || !isFQDN(z) {
+			return fmt.Errorf("invalid notify zone %q", z)
+		}
+	}
+	if s.Ban.Threshold < 0 || s.Ban.Window < 0 || s.Ban.Duration < 0 {
+		return fmt.Errorf("negative ban settings %+v", s.Ban)
+	}
+	if l := s.APILimits; l.WriteKeyLimit.Rate < 0 || l.WriteKeyLimit.Burst < 0 || l.WriteIPLimit.Rate < 0 || l.WriteIPLimit.Burst < 0 || l.AuthBackoff < 0 || l.MaxAuthBackoff < 0 {
+		return fmt.Errorf("negative API limits %+v", l)
+	}
+
+	for _, n := range s.NS {
+		if !isFQDN(n) {
+			return fmt.Errorf("invalid name server %q", n)
+		}
+	}
+	if len(s.NSAddrs) > 0 && (len(s.NS) == 0 || !dnsutil.IsBelow(s.Zone, s.NS[0])) {
+		return errors.New("name server addresses require a primary name server inside the zone")
+	}
+	if s.AuthorityNS && len(s.NS) == 0 {
+		return errors.New("authority NS records require name servers")
+	}
+	if s.NegativeTTL < 0 || s.NegativeTTL > maxNegativeTTL || s.NegativeTTL%time.Second != 0 {
+		return fmt.Errorf("invalid negative TTL %v, expected whole seconds up to %v", s.NegativeTTL, maxNegativeTTL)
+	}
+	if s.Hostmaster != "" && !isFQDN(s.Hostmaster) {
+		return fmt.Errorf("invalid hostmaster %q", s.Hostmaster)
+	}
+	if err := checkStatic(s.Static, s.Zone, s.ChallengeName()); err != nil {
+		return err
+	}
+	// Resolvers can only reach the name servers inside the zone through
+	// their addresses served here.
+	for _, n := range s.NS {
+		if dnsutil.IsBelow(s.Zone, n) && len(s.glue(n)) == 0 {
+			return fmt.Errorf("name server %s inside the zone without addresses, set them or use static A/AAAA records", n)
+		}
+	}
+	if _, err := checkCNAME(s.CNAME, s.Static, s.Zone, s.ChallengeName()); err != nil {
+		return err
+	}
+	if err := checkPolicy(s.UpdatePolicy, s.Zone, s.TsigName); err != nil {
+		return err
+	}
+
+	if s.MaxTCPConns < 0 {
+		return fmt.Errorf("negative TCP connection limit %d", s.MaxTCPConns)
+	}
+	if s.MaxTCPPipelined < 0 {
+		return fmt.Errorf("negative TCP pipelining limit %d", s.MaxTCPPipelined)
+	}
+	if s.TCPIdleTimeout < 0 || s.TCPReadTimeout < 0 || s.TCPWriteTimeout < 0 {
+		return errors.New("negative TCP timeout")
+	}
+	return nil
+}
+
+// isFQDN reports whether name is a valid domain name with the trailing dot.
+func isFQDN(name string) bool {
+	return dnsutil.IsFqdn(name) && dnsutil.IsName(name)
+}
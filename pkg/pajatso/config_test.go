@@ -0,0 +1,110 @@
+package pajatso
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestNewServer(t *testing.T) {
+	srv, err := NewServer(Config{
+		Zone:       "Example.COM",
+		Subdomain:  "sub.",
+		TsigName:   "acme-update",
+		TsigSecret: testTsigSecret,
+		TsigAlg:    "HMAC-SHA256",
+		TokenTTL:   time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Zone != testZone || srv.Subdomain != testSubdomain || srv.TsigName != testTsigName || srv.TsigAlg != dns.HmacSHA256 {
+		t.Fatalf("names not normalized: %+v", srv)
+	}
+	if srv.ChallengeName() != testSubChallenge {
+		t.Fatalf("expected %s, got %s", testSubChallenge, srv.ChallengeName())
+	}
+	if srv.Store == nil || srv.Store.TTL != time.Minute {
+		t.Fatalf("expected a store with the token TTL, got %+v", srv.Store)
+	}
+}
+
+func TestNewServerDefaults(t *testing.T) {
+	srv, err := NewServer(Config{Zone: testZone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.TsigAlg != dns.HmacSHA512 || srv.TsigName != "" {
+		t.Fatalf("unexpected TSIG settings %q %q", srv.TsigName, srv.TsigAlg)
+	}
+}
+
+func TestNewServerInvalid(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"no zone":            {},
+		"root zone":          {Zone: "."},
+		"empty label":        {Zone: "example..com"},
+		"long label":         {Zone: string(make([]byte, 64)) + ".com"},
+		"bad secret":         {Zone: testZone, TsigName: testTsigName, TsigSecret: "not base64!"},
+		"no secret":          {Zone: testZone, TsigName: testTsigName},
+		"secret no name":     {Zone: testZone, TsigSecret: testTsigSecret},
+		"bad algorithm":      {Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, TsigAlg: "hmac-md5"},
+		"negative ttl":       {Zone: testZone, TokenTTL: -time.Second},
+		"negative grace":     {Zone: testZone, DeleteGrace: -time.Second},
+		"negative conns":     {Zone: testZone, MaxTCPConns: -1},
+		"negative pipelined": {Zone: testZone, MaxTCPPipelined: -1},
+		"negative idle":      {Zone: testZone, TCPIdleTimeout: -time.Second},
+		"small udp size":     {Zone: testZone, UDPSize: 256},
+		"negative rate":      {Zone: testZone, UpdateIPLimit: RateLimit{Rate: -1}},
+		"bad caa":            {Zone: testZone, CAA: []string{"issue letsencrypt.org"}},
+		"bad ns":             {Zone: testZone, NS: []string{"ns..example.com"}},
+		"lame ns":            {Zone: testZone, NS: []string{"ns1.example.com"}},
+		"negative ban":       {Zone: testZone, Ban: BanConfig{Threshold: -1}},
+		"negative fudge":     {Zone: testZone, TsigFudge: -time.Second},
+		"negative len":       {Zone: testZone, MaxTokenLength: -1},
+		"bad previous":       {Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, TsigPreviousSecret: "not base64!"},
+		"allow unknown key": {Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret,
+			UpdateAllowKey: map[string][]netip.Prefix{"other.": nil}},
+		"policy unknown key": {Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret,
+			UpdatePolicy: []Grant{{Key: "other.", Name: testChallenge, Types: []uint16{dns.TypeTXT}}}},
+		"policy outside zone": {Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret,
+			UpdatePolicy: []Grant{{Key: testTsigName, Name: "example.org.", Types: []uint16{dns.TypeTXT}}}},
+	} {
+		if _, err := NewServer(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, srv := range map[string]*Server{
+		"relative zone": {Zone: "example.com", Store: &Store{}},
+		"bad secret":    {Zone: testZone, TsigName: testTsigName, TsigSecret: "not base64!", Store: &Store{}},
+		"no store":      {Zone: testZone},
+	} {
+		if err := srv.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := (&Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvalidSecretServes(t *testing.T) {
+	// Without NewServer, an invalid secret disables updates instead of
+	// panicking.
+	addr, store, cleanup := startTestServerFor(t, &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: "not base64!", Store: &Store{}})
+	defer cleanup()
+
+	store.Replace("token")
+	if r := query(t, addr, testChallenge, dns.TypeTXT); len(r.Answer) != 1 {
+		t.Fatalf("expected the token, got %v", r.Answer)
+	}
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"update\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
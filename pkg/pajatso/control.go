@@ -0,0 +1,141 @@
+package pajatso
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ControlStatus is the JSON body of a control socket status response.
+type ControlStatus struct {
+	Zone          string   `json:"zone"`
+	ChallengeName string   `json:"challengeName"`
+	Mode          Mode     `json:"mode"`
+	Records       []Record `json:"records"`
+
+	// The last time a value was set and a challenge query was answered,
+	// kept after the records are deleted. Nil if it hasn't happened since
+	// the server started.
+	LastSet       *time.Time `json:"lastSet,omitempty"`
+	LastQuery     *time.Time `json:"lastQuery,omitempty"`
+	LastQueryFrom string     `json:"lastQueryFrom,omitempty"`
+	Queries       uint64     `json:"queries"` // challenge queries answered with a token
+
+	Keys []KeyUsage `json:"keys,omitempty"` // use of the TSIG keys, see KeyStats
+
+	// Listeners are the addresses the DNS servers are bound to, as network
+	// and address, e.g. "udp 127.0.0.1:53".
+	Listeners []string `json:"listeners,omitempty"`
+
+	Build BuildInfo `json:"build"` // build of the server binary
+}
+
+// ControlHandler returns an http.Handler for the local control socket. It is
+// unauthenticated; access is restricted by the socket's file permissions.
+//
+//	GET    /status    show the served zone and current challenge records
+//	GET    /record    get the challenge record
+//	PUT    /record    set the challenge record, body {"value": "..."}
+//	DELETE /record    delete the challenge record
+//	GET    /mode      get the operating mode, see SetMode
+//	PUT    /mode      set the operating mode, body {"mode": "..."}
+//	GET    /snapshot  dump the state of the Store, see Snapshot
+//	PUT    /snapshot  restore a dumped Snapshot, see Restore
+//	GET    /zone      export the served zone as a zone file, see WriteZone
+//
+// The records can be changed through the socket in any mode.
+func (s *Server) ControlHandler() http.Handler {
+	s.Store.bind(s.ChallengeName())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		st := ControlStatus{
+			Zone:          s.Zone,
+			ChallengeName: s.ChallengeName(),
+			Mode:          s.Mode(),
+			Records:       s.Records(),
+			Build:         ReadBuildInfo(),
+		}
+		s.activity.mu.Lock()
+		st.LastSet, st.LastQuery = timePtr(s.activity.lastSet), timePtr(s.activity.lastQuery)
+		st.LastQueryFrom, st.Queries = s.activity.queryFrom, s.activity.queries
+		s.activity.mu.Unlock()
+		if s.KeyStats != nil {
+			st.Keys = s.KeyStats.Usage()
+		}
+		for _, a := range s.Addrs() {
+			st.Listeners = append(st.Listeners, a.Network()+" "+a.String())
+		}
+		writeJSON(w, http.StatusOK, st)
+	})
+	mux.HandleFunc("GET /record", func(w http.ResponseWriter, r *http.Request) {
+		records := s.Records()
+		if len(records) == 0 {
+			writeJSON(w, http.StatusNotFound, apiError{"record not set"})
+			return
+		}
+		writeJSON(w, http.StatusOK, records[0])
+	})
+	mux.HandleFunc("PUT /record", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == "" {
+			writeJSON(w, http.StatusBadRequest, apiError{`body must be {"value": "<token>"}`})
+			return
+		}
+		if err := s.checkValue(body.Value); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		s.Store.replace(s.ChallengeName(), body.Value, 0, "")
+		s.recordSet(body.Value, "control")
+		slog.Info("control: set _acme-challenge TXT")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /record", func(w http.ResponseWriter, r *http.Request) {
+		s.Store.Delete(s.ChallengeName(), "")
+		slog.Info("control: deleted _acme-challenge TXT")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /mode", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]Mode{"mode": s.Mode()})
+	})
+	mux.HandleFunc("PUT /mode", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Mode string `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{`body must be {"mode": "<mode>"}`})
+			return
+		}
+		mode, err := ParseMode(body.Mode)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		s.SetMode(mode)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /zone", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/dns")
+		s.WriteZone(w)
+	})
+	mux.HandleFunc("GET /snapshot", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Snapshot())
+	})
+	mux.HandleFunc("PUT /snapshot", func(w http.ResponseWriter, r *http.Request) {
+		var snap Snapshot
+		if err := json.NewDecoder(r.Body).Decode(&snap); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{"malformed snapshot: " + err.Error()})
+			return
+		}
+		if err := s.Restore(snap); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		slog.Info("control: restored snapshot", "records", len(snap.Records), "taken", snap.Time)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
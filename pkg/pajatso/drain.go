@@ -0,0 +1,196 @@
+package pajatso
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// drainState tracks the DNS requests being handled, so that Shutdown can let
+// them finish before closing the sockets.
+//
+// dns.Server.Shutdown closes the UDP sockets right away, failing the
+// responses of running handlers, and waits for TCP connections without a
+// deadline, however long clients keep them open.
+type drainState struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	busy     map[*net.TCPConn]int // requests in flight per TCP connection
+	idle     chan struct{}        // closed once inFlight drops to zero while draining
+	dropped  uint64               // requests dropped while draining or aborted
+}
+
+// trackRequests returns a middleware counting the requests in flight,
+// shedding those beyond MaxRequests or MaxTCPPipelined, and dropping new ones
+// once Shutdown has been called.
+func (s *Server) trackRequests(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		s.listeners.get(w).requests.Add(1)
+		ctx = withRequestID(ctx, "")
+		c, _ := w.Conn().(*net.TCPConn)
+		switch s.beginRequest(c) {
+		case requestDraining:
+			if c != nil {
+				s.closeTCP(c)
+			}
+			return
+		case requestOverload:
+			s.overloaded.Add(1)
+			// UDP clients retry, possibly with another server; over TCP,
+			// answer so that the connection isn't left hanging.
+			if c != nil {
+				m := new(dns.Msg)
+				dnsutil.SetReply(m, r)
+				m.Rcode = dns.RcodeServerFailure
+				s.writeMsg(w, m)
+			}
+			return
+		}
+		defer s.endRequest(c)
+		next.ServeDNS(ctx, w, r)
+	})
+}
+
+// Outcomes of beginRequest.
+const (
+	requestAccepted = iota
+	requestDraining // the server is shutting down
+	requestOverload // MaxRequests are in flight, or MaxTCPPipelined on the connection
+)
+
+// beginRequest registers a request received on c (nil for UDP), unless the
+// server is draining, counting it as dropped, or overloaded.
+func (s *Server) beginRequest(c *net.TCPConn) int {
+	s.drain.mu.Lock()
+	defer s.drain.mu.Unlock()
+
+	if s.drain.draining {
+		s.drain.dropped++
+		return requestDraining
+	}
+	if s.MaxRequests > 0 && s.drain.inFlight >= s.MaxRequests {
+		return requestOverload
+	}
+	if c != nil && s.MaxTCPPipelined > 0 && s.drain.busy[c] >= s.MaxTCPPipelined {
+		return requestOverload
+	}
+	s.drain.inFlight++
+	if c != nil {
+		if s.drain.busy == nil {
+			s.drain.busy = map[*net.TCPConn]int{}
+		}
+		s.drain.busy[c]++
+	}
+	return requestAccepted
+}
+
+// endRequest unregisters a request. While draining, TCP connections are
+// closed after their last response.
+func (s *Server) endRequest(c *net.TCPConn) {
+	s.drain.mu.Lock()
+	s.drain.inFlight--
+	if s.drain.inFlight == 0 && s.drain.idle != nil {
+		close(s.drain.idle)
+		s.drain.idle = nil
+	}
+	done := false
+	if c != nil {
+		s.drain.busy[c]--
+		if s.drain.busy[c] == 0 {
+			delete(s.drain.busy, c)
+			done = s.drain.draining
+		}
+	}
+	s.drain.mu.Unlock()
+
+	if done {
+		s.closeTCP(c)
+	}
+}
+
+// draining reports whether Shutdown has been called.
+func (s *Server) draining() bool {
+	s.drain.mu.Lock()
+	defer s.drain.mu.Unlock()
+	return s.drain.draining
+}
+
+// startDrain stops accepting requests and closes the TCP connections without
+// requests in flight. The returned channel is closed once the requests in
+// flight have finished.
+func (s *Server) startDrain() <-chan struct{} {
+	s.drain.mu.Lock()
+	defer s.drain.mu.Unlock()
+
+	s.drain.draining = true
+	idle := make(chan struct{})
+	if s.drain.inFlight == 0 {
+		close(idle)
+	} else {
+		s.drain.idle = idle
+	}
+
+	s.tcp.mu.Lock()
+	var closing []*net.TCPConn
+	for c := range s.tcp.conns {
+		if s.drain.busy[c] == 0 {
+			closing = append(closing, c)
+		}
+	}
+	s.tcp.mu.Unlock()
+	for _, c := range closing {
+		s.closeTCP(c)
+	}
+	return idle
+}
+
+// Shutdown gracefully stops servers, created by NewDNSServer: new requests
+// are dropped, TCP connections are closed once idle, and the requests in
+// flight are given until ctx is done to finish before the sockets are
+// closed. It returns the number of requests dropped, including those still
+// in flight when ctx was done.
+func (s *Server) Shutdown(ctx context.Context, servers ...*dns.Server) uint64 {
+	idle := s.startDrain()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		s.drain.mu.Lock()
+		aborted := s.drain.inFlight
+		s.drain.dropped += uint64(aborted)
+		s.drain.mu.Unlock()
+		slog.Warn("dns: drain deadline exceeded, aborting requests", "in_flight", aborted)
+	}
+
+	s.tcp.mu.Lock()
+	var conns []*net.TCPConn
+	for c := range s.tcp.conns {
+		conns = append(conns, c)
+	}
+	s.tcp.mu.Unlock()
+	for _, c := range conns {
+		s.closeTCP(c)
+	}
+
+	// dns.Server.Shutdown waits for handlers, which may still be running
+	// after the deadline.
+	done := make(chan struct{})
+	go func() {
+		for _, ds := range servers {
+			ds.Shutdown(ctx)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	s.drain.mu.Lock()
+	defer s.drain.mu.Unlock()
+	return s.drain.dropped
+}
@@ -0,0 +1,136 @@
+package pajatso
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+// blockQueries returns a middleware holding every request until release is
+// closed, signalling each on started.
+func blockQueries(started chan<- struct{}, release <-chan struct{}) Middleware {
+	return func(next dns.Handler) dns.Handler {
+		return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+			started <- struct{}{}
+			<-release
+			next.ServeDNS(ctx, w, r)
+		})
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	srv := &Server{Zone: testZone, Store: &Store{}, TCPIdleTimeout: time.Minute, Middleware: []Middleware{blockQueries(started, release)}}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := srv.NewDNSServer()
+	ds.Net = "tcp"
+	ds.Listener = srv.TCPListener(ln)
+	go ds.ListenAndServe()
+	<-srv.Ready()
+
+	// An idle connection is closed as soon as the server drains.
+	idle, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	answered := make(chan error, 1)
+	go func() {
+		_, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "tcp", ln.Addr().String())
+		answered <- err
+	}()
+	<-started
+
+	dropped := make(chan uint64, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		dropped <- srv.Shutdown(ctx, ds)
+	}()
+
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the idle connection to be closed")
+	}
+	if srv.listening() {
+		t.Error("expected the server not to be ready while draining")
+	}
+
+	// The request in flight is still answered.
+	close(release)
+	if err := <-answered; err != nil {
+		t.Fatalf("query in flight failed: %v", err)
+	}
+	if n := <-dropped; n != 0 {
+		t.Fatalf("expected no dropped requests, got %d", n)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	srv := &Server{Zone: testZone, Store: &Store{}, Middleware: []Middleware{blockQueries(started, release)}}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	t.Cleanup(cleanup)
+
+	c := dns.NewClient()
+	go c.Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "udp", addr)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan uint64, 1)
+	go func() { done <- srv.Shutdown(ctx) }()
+
+	// Requests arriving while draining are dropped.
+	time.Sleep(20 * time.Millisecond)
+	ectx, ecancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer ecancel()
+	if _, _, err := c.Exchange(ectx, dns.NewMsg(testChallenge, dns.TypeTXT), "udp", addr); err == nil {
+		t.Error("expected a query while draining to be dropped")
+	}
+
+	// One request dropped while draining, one aborted at the deadline.
+	if n := <-done; n != 2 {
+		t.Fatalf("expected 2 dropped requests, got %d", n)
+	}
+}
+
+func TestMaxRequests(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	srv := &Server{Zone: testZone, Store: &Store{}, MaxRequests: 1, Middleware: []Middleware{blockQueries(started, release)}}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	t.Cleanup(cleanup)
+	defer close(release)
+	tcpAddr := startTCPTestServer(t, srv)
+
+	c := dns.NewClient()
+	go c.Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "udp", addr)
+	<-started
+
+	// Beyond the limit, TCP requests get SERVFAIL and UDP requests are
+	// dropped.
+	r, _, err := c.Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %s", dns.RcodeToString[r.Rcode])
+	}
+	short := dns.NewClient()
+	short.ReadTimeout = 100 * time.Millisecond
+	if _, _, err := short.Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "udp", addr); err == nil {
+		t.Fatal("expected the UDP request to be dropped")
+	}
+	if n := srv.overloaded.Load(); n != 2 {
+		t.Fatalf("expected 2 shed requests, got %d", n)
+	}
+}
@@ -0,0 +1,94 @@
+package pajatso
+
+import (
+	"encoding/hex"
+	"net"
+
+	"codeberg.org/miekg/dns"
+)
+
+// DefaultUDPSize is the EDNS0 UDP payload size advertised by default, as
+// recommended by DNS Flag Day 2020 to avoid IP fragmentation.
+const DefaultUDPSize = 1232
+
+// udpSize returns the EDNS0 UDP payload size of s.
+func (s *Server) udpSize() uint16 {
+	if s.UDPSize == 0 {
+		return DefaultUDPSize
+	}
+	return s.UDPSize
+}
+
+// writeAnswer sends the answer m to the query r. Over UDP, responses larger
+// than the payload size negotiated with the client (512 bytes without EDNS0)
+// are sent without the optional authority and additional records of a
+// positive answer if that is enough (RFC 2181 9), or else with the TC bit set
+// and their records removed, so that the client retries over TCP instead of
+// using truncated data.
+func (s *Server) writeAnswer(w dns.ResponseWriter, r, m *dns.Msg) {
+	limit := dns.MinMsgSize
+	if r.UDPSize > 0 {
+		m.UDPSize = s.udpSize()
+		limit = int(min(r.UDPSize, m.UDPSize))
+		if s.NSID && s.Identity != "" && hasNSID(r) {
+			m.Pseudo = append(m.Pseudo, &dns.NSID{Nsid: hex.EncodeToString([]byte(s.Identity))})
+		}
+	}
+
+	if err := m.Pack(); err != nil {
+		s.writeFailed(w, m, "pack", err)
+		return
+	}
+	_, udp := w.RemoteAddr().(*net.UDPAddr)
+	if udp && len(m.Data) > limit && len(m.Answer) > 0 && len(m.Ns)+len(m.Extra) > 0 {
+		m.Ns, m.Extra = nil, nil
+		if err := m.Pack(); err != nil {
+			s.writeFailed(w, m, "pack", err)
+			return
+		}
+	}
+	if udp && len(m.Data) > limit {
+		m.Truncated = true
+		m.Answer, m.Ns, m.Extra = nil, nil, nil
+		s.listeners.get(w).truncated.Add(1)
+		if err := m.Pack(); err != nil {
+			s.writeFailed(w, m, "pack", err)
+			return
+		}
+	}
+	s.writePacked(w, m)
+}
+
+// hasNSID reports whether the request r asks for the server's NSID (RFC 5001).
+func hasNSID(r *dns.Msg) bool {
+	for _, rr := range r.Pseudo {
+		if _, ok := rr.(*dns.NSID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setEDE attaches an RFC 8914 extended DNS error to the response m if the
+// request r used EDNS0. The dns package can't TSIG-sign messages with EDNS0
+// options, so it must not be used for signed responses.
+func setEDE(r, m *dns.Msg, code uint16, text string) {
+	if r.UDPSize == 0 {
+		return
+	}
+	if m.UDPSize == 0 {
+		m.UDPSize = DefaultUDPSize
+	}
+	m.Pseudo = append(m.Pseudo, &dns.EDE{InfoCode: code, ExtraText: text})
+}
+
+// txtStrings splits val into the strings of a TXT record, which hold at most
+// 255 bytes each. Updates may set longer values as several strings.
+func txtStrings(val string) []string {
+	var txt []string
+	for len(val) > 255 {
+		txt = append(txt, val[:255])
+		val = val[255:]
+	}
+	return append(txt, val)
+}
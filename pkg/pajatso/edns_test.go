@@ -0,0 +1,136 @@
+package pajatso
+
+import (
+	"context"
+	"encoding/hex"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestEDNSTruncation(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	tcpAddr := startTCPTestServer(t, srv)
+
+	// Too large for 512 bytes, but fits the default EDNS0 payload size.
+	token := strings.Repeat("x", 1000)
+	store.Replace(token)
+
+	exchange := func(proto, addr string, udpSize uint16) *dns.Msg {
+		t.Helper()
+		m := dns.NewMsg(testChallenge, dns.TypeTXT)
+		m.UDPSize = udpSize
+		r, _, err := dns.NewClient().Exchange(context.Background(), m, proto, addr)
+		if err != nil {
+			t.Fatalf("%s query failed: %v", proto, err)
+		}
+		return r
+	}
+	answer := func(r *dns.Msg) string {
+		t.Helper()
+		if r.Truncated || len(r.Answer) != 1 {
+			t.Fatalf("expected a complete answer, got TC=%v with %d records", r.Truncated, len(r.Answer))
+		}
+		return strings.Join(r.Answer[0].(*dns.TXT).Txt, "")
+	}
+
+	r := exchange("udp", addr, 0)
+	if !r.Truncated || len(r.Answer) != 0 {
+		t.Fatalf("expected TC without records for a 512 byte client, got TC=%v with %d records", r.Truncated, len(r.Answer))
+	}
+
+	r = exchange("udp", addr, 4096)
+	if answer(r) != token {
+		t.Fatal("token not returned intact over EDNS0")
+	}
+	if r.UDPSize != DefaultUDPSize {
+		t.Fatalf("expected advertised payload size %d, got %d", DefaultUDPSize, r.UDPSize)
+	}
+
+	if answer(exchange("tcp", tcpAddr, 0)) != token {
+		t.Fatal("token not returned intact over TCP")
+	}
+}
+
+func TestTxtStrings(t *testing.T) {
+	for n, want := range map[int]int{0: 1, 255: 1, 256: 2, 600: 3} {
+		txt := txtStrings(strings.Repeat("a", n))
+		if len(txt) != want || len(strings.Join(txt, "")) != n {
+			t.Errorf("%d bytes: got %d strings, want %d", n, len(txt), want)
+		}
+	}
+}
+
+// exchangeEDNS sends m with EDNS0 over UDP and returns the response.
+func exchangeEDNS(t *testing.T, addr string, m *dns.Msg) *dns.Msg {
+	t.Helper()
+	m.UDPSize = 1232
+	r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestNSID(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}, Identity: "ns1", NSID: true}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	m := dns.NewMsg(testChallenge, dns.TypeTXT)
+	m.Pseudo = []dns.RR{&dns.NSID{}}
+	r := exchangeEDNS(t, addr, m)
+	var nsid string
+	for _, rr := range r.Pseudo {
+		if o, ok := rr.(*dns.NSID); ok {
+			nsid = o.Nsid
+		}
+	}
+	if nsid != hex.EncodeToString([]byte("ns1")) {
+		t.Fatalf("expected the NSID of ns1, got %q", nsid)
+	}
+
+	// Not returned unless requested.
+	if r := exchangeEDNS(t, addr, dns.NewMsg(testChallenge, dns.TypeTXT)); len(r.Pseudo) != 0 {
+		t.Fatalf("expected no EDNS0 options, got %v", r.Pseudo)
+	}
+}
+
+func TestExtendedErrors(t *testing.T) {
+	srv := &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		Store:      &Store{},
+		Middleware: []Middleware{QueryACL(nil, []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})},
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	ede := func(r *dns.Msg) uint16 {
+		for _, rr := range r.Pseudo {
+			if o, ok := rr.(*dns.EDE); ok {
+				return o.InfoCode
+			}
+		}
+		t.Fatalf("no extended error in %s response", dns.RcodeToString[r.Rcode])
+		return 0
+	}
+
+	// An update without TSIG.
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"token\"")
+	r := exchangeEDNS(t, addr, makeUpdateMsg(t, testZone, []dns.RR{rr}, "", ""))
+	if r.Rcode != dns.RcodeRefused || ede(r) != dns.ExtendedErrorProhibited {
+		t.Fatalf("update: expected REFUSED with Prohibited, got %s", r)
+	}
+
+	// A query denied by the ACL.
+	r = exchangeEDNS(t, addr, dns.NewMsg(testChallenge, dns.TypeTXT))
+	if r.Rcode != dns.RcodeRefused || ede(r) != dns.ExtendedErrorProhibited {
+		t.Fatalf("query: expected REFUSED with Prohibited, got %s", r)
+	}
+}
@@ -0,0 +1,73 @@
+package pajatso
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// ZoneRecords returns the records currently served in the zone: the
+// synthesized SOA and NS records and the addresses of the primary name
+// server, the CAA, static and CNAME records, and the TXT values of the
+// challenge record, of the names published with GenericTXT and of the
+// acme-dns registrations. The SOA comes first, as in a zone file.
+func (s *Server) ZoneRecords() []dns.RR {
+	var rrs []dns.RR
+	if len(s.NS) > 0 {
+		zr := s.zoneRecords()
+		rrs = append(rrs, s.soa(soaTTL))
+		rrs = append(rrs, zr.ns...)
+		if dnsutil.IsBelow(s.Zone, s.NS[0]) {
+			rrs = append(rrs, zr.nsAddrs...)
+		}
+	}
+	for _, caa := range s.CAA {
+		rrs = append(rrs, &dns.CAA{Hdr: dns.Header{Name: s.Zone, Class: dns.ClassINET, TTL: caaTTL}, CAA: caa})
+	}
+	rrs = append(rrs, s.Static...)
+	for _, owner := range slices.Sorted(maps.Keys(s.CNAME)) {
+		rrs = append(rrs, s.cname(owner))
+	}
+
+	now := s.Store.now()
+	for _, e := range s.Store.List() {
+		if !dns.EqualName(e.Name, s.ChallengeName()) && !s.genericName(e.Name) {
+			continue
+		}
+		rrs = append(rrs, &dns.TXT{
+			Hdr: dns.Header{Name: e.Name, Class: dns.ClassINET, TTL: tokenTTL(e, now)},
+			TXT: rdata.TXT{Txt: txtStrings(e.Value)},
+		})
+	}
+	if s.Registry != nil {
+		values := s.Registry.values()
+		for _, sub := range slices.Sorted(maps.Keys(values)) {
+			for _, val := range values[sub] {
+				rrs = append(rrs, &dns.TXT{
+					Hdr: dns.Header{Name: sub + "." + s.Zone, Class: dns.ClassINET, TTL: challengeTTL},
+					TXT: rdata.TXT{Txt: []string{val}},
+				})
+			}
+		}
+	}
+	return rrs
+}
+
+// WriteZone writes the ZoneRecords of s to w as an RFC 1035 zone file, e.g.
+// for debugging or to prime a conventional secondary.
+func (s *Server) WriteZone(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "; %s served by dns-pajatso at %s\n", s.Zone, s.Store.now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(bw, "$ORIGIN %s\n", s.Zone)
+	for _, rr := range s.ZoneRecords() {
+		fmt.Fprintln(bw, rr.String())
+	}
+	return bw.Flush()
+}
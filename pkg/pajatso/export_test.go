@@ -0,0 +1,50 @@
+package pajatso
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestWriteZone(t *testing.T) {
+	static, _ := ParseZoneFile(strings.NewReader("www IN A 192.0.2.80\n"), testZone, "test.zone")
+	srv, err := NewServer(Config{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		NS:         []string{"ns1." + testZone, "ns.example.net."},
+		NSAddrs:    []netip.Addr{netip.MustParseAddr("192.0.2.53")},
+		Static:     static,
+		CNAME:      map[string]string{"docs." + testZone: "www." + testZone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Store.Set(testChallenge, "token-1", 0)
+	srv.Store.Set(testChallenge, "token-2", 0)
+
+	var b bytes.Buffer
+	if err := srv.WriteZone(&b); err != nil {
+		t.Fatal(err)
+	}
+	rrs, err := ParseZoneFile(&b, testZone, "export.zone")
+	if err != nil {
+		t.Fatalf("parsing the exported zone: %v\n%s", err, b.String())
+	}
+	count := map[uint16]int{}
+	for _, rr := range rrs {
+		count[dns.RRToType(rr)]++
+	}
+	want := map[uint16]int{dns.TypeSOA: 1, dns.TypeNS: 2, dns.TypeA: 2, dns.TypeCNAME: 1, dns.TypeTXT: 2}
+	for typ, n := range want {
+		if count[typ] != n {
+			t.Errorf("expected %d %s records, got %d", n, dns.TypeToString[typ], count[typ])
+		}
+	}
+	if dns.RRToType(rrs[0]) != dns.TypeSOA {
+		t.Errorf("expected the SOA first, got %v", rrs[0])
+	}
+}
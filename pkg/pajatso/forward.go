@@ -0,0 +1,74 @@
+package pajatso
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// forwarder is the dns.Handler returned by Forward.
+type forwarder struct {
+	addr   string
+	client *dns.Client
+}
+
+// Forward returns a dns.Handler passing requests on to the DNS server at
+// addr (host:port) and relaying its responses. With WithNext, dns-pajatso
+// can be put in front of an existing authoritative server and only
+// intercept the challenge traffic:
+//
+//	srv.NewDNSServer(pajatso.WithNext(pajatso.Forward("192.0.2.53:53")))
+//
+// Requests are forwarded over the protocol they arrived with. If the
+// upstream server doesn't answer before the context of the request is done,
+// see Server.RequestTimeout, the client gets SERVFAIL.
+func Forward(addr string) dns.Handler {
+	return &forwarder{addr: addr, client: dns.NewClient()}
+}
+
+func (f *forwarder) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+	network := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		network = "tcp"
+	}
+
+	// Send the request as received, EDNS0 and TSIG included. The client
+	// reads the response into the buffer of q, sized by UDPSize.
+	q := &dns.Msg{Data: slices.Clone(r.Data)}
+	q.ID, q.UDPSize = r.ID, dns.MaxMsgSize
+	resp, err := f.exchange(ctx, q, network)
+	if err != nil {
+		slog.WarnContext(ctx, "forwarding failed", "upstream", f.addr, "err", err)
+		m := new(dns.Msg)
+		dnsutil.SetReply(m, r)
+		m.Rcode = dns.RcodeServerFailure
+		WriteMsg(w, m)
+		return
+	}
+	WriteMsg(w, resp)
+}
+
+// exchange sends q to the upstream server and reads its response, giving up
+// when ctx is done: the client only checks ctx between reading and writing,
+// and reads with its own timeout.
+func (f *forwarder) exchange(ctx context.Context, q *dns.Msg, network string) (*dns.Msg, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, f.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	resp, _, err := f.client.ExchangeWithConn(ctx, q, conn)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return resp, err
+}
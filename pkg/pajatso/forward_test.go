@@ -0,0 +1,64 @@
+package pajatso
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestForward(t *testing.T) {
+	upstream := startTestHandler(t, zoneHandler)
+
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}}
+	srv.Store.Replace("token")
+	addr := startTestHandler(t, srv.Handler(WithNext(Forward(upstream))))
+
+	r := query(t, addr, "www."+testZone, dns.TypeA)
+	if len(r.Answer) != 1 || dns.RRToType(r.Answer[0]) != dns.TypeA {
+		t.Fatalf("expected the upstream A record, got %v", r.Answer)
+	}
+	r = query(t, addr, testChallenge, dns.TypeTXT)
+	if len(r.Answer) != 1 || dns.RRToType(r.Answer[0]) != dns.TypeTXT {
+		t.Fatalf("expected the challenge TXT record, got %v", r.Answer)
+	}
+}
+
+func TestForwardUnreachable(t *testing.T) {
+	// A closed port.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := pc.LocalAddr().String()
+	pc.Close()
+
+	addr := startTestHandler(t, Forward(upstream))
+	if r := query(t, addr, "www."+testZone, dns.TypeA); r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestForwardTimeout(t *testing.T) {
+	// An upstream that never answers.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	srv := &Server{Zone: testZone, Store: &Store{}, RequestTimeout: 100 * time.Millisecond}
+	addr := startTestHandler(t, srv.Handler(WithNext(Forward(pc.LocalAddr().String()))))
+
+	start := time.Now()
+	if r := query(t, addr, "www."+testZone, dns.TypeA); r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the request to end after the timeout, took %v", d)
+	}
+	if n := srv.timeouts.Load(); n != 1 {
+		t.Errorf("expected 1 timed out request, got %d", n)
+	}
+}
@@ -0,0 +1,30 @@
+package pajatso
+
+import (
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// genericName reports whether updates may publish TXT records at name with
+// GenericTXT: any name in the zone but the challenge and health records,
+// the acme-dns registrations and the names with a CNAME record, which can't
+// have other records.
+func (s *Server) genericName(name string) bool {
+	switch {
+	case !s.GenericTXT || !dnsutil.IsBelow(s.Zone, name):
+		return false
+	case dns.EqualName(name, s.ChallengeName()):
+		return false
+	case s.HealthName != "" && dns.EqualName(name, s.healthName()):
+		return false
+	case s.cname(name) != nil || s.isRegistered(name):
+		return false
+	}
+	return true
+}
+
+// hasGenericTXT reports whether TXT values published with GenericTXT are
+// stored at name.
+func (s *Server) hasGenericTXT(name string) bool {
+	return s.genericName(name) && len(s.Store.Get(name)) > 0
+}
@@ -0,0 +1,82 @@
+package pajatso
+
+import (
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestGenericTXT(t *testing.T) {
+	srv := &Server{
+		Zone:         testZone,
+		TsigName:     testTsigName,
+		TsigSecret:   testTsigSecret,
+		StrictTokens: true,
+		GenericTXT:   true,
+		HealthName:   "health",
+		CNAME:        map[string]string{"alias.example.com.": "target.example.com."},
+		Store:        &Store{},
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	send := func(rr dns.RR) uint16 {
+		t.Helper()
+		return sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret).Rcode
+	}
+	update := func(rr string) uint16 {
+		t.Helper()
+		r, err := dns.New(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return send(r)
+	}
+
+	// Values at other names aren't held to StrictTokens.
+	for _, rr := range []string{
+		`example.com. 60 IN TXT "google-site-verification=abc"`,
+		`_verify.www.example.com. 60 IN TXT "token"`,
+	} {
+		if rcode := update(rr); rcode != dns.RcodeSuccess {
+			t.Fatalf("%s: expected NOERROR, got %s", rr, dns.RcodeToString[rcode])
+		}
+	}
+	r := query(t, addr, "example.com.", dns.TypeTXT)
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.TXT).Txt[0] != "google-site-verification=abc" {
+		t.Fatalf("expected the verification TXT at the apex, got %v", r.Answer)
+	}
+	if r := query(t, addr, "_Verify.www.example.com.", dns.TypeTXT); len(r.Answer) != 1 {
+		t.Fatalf("expected the TXT at _verify.www, got %v", r.Answer)
+	}
+	if rcode := update(`_acme-challenge.example.com. 60 IN TXT "not-a-digest"`); rcode != dns.RcodeRefused {
+		t.Fatalf("expected challenge values to be strict, got %s", dns.RcodeToString[rcode])
+	}
+
+	for _, rr := range []string{
+		`alias.example.com. 60 IN TXT "token"`,
+		`health.example.com. 60 IN TXT "token"`,
+		`example.org. 60 IN TXT "token"`,
+	} {
+		if rcode := update(rr); rcode == dns.RcodeSuccess {
+			t.Errorf("%s: expected the update to be refused", rr)
+		}
+	}
+
+	var zone strings.Builder
+	srv.WriteZone(&zone)
+	if !strings.Contains(zone.String(), "_verify.www.example.com.") {
+		t.Fatalf("expected the generic TXT in the zone, got:\n%s", zone.String())
+	}
+
+	if rcode := send(&dns.TXT{Hdr: dns.Header{Name: "_verify.www.example.com.", Class: dns.ClassANY}}); rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[rcode])
+	}
+	if r := query(t, addr, "_verify.www.example.com.", dns.TypeTXT); len(r.Answer) != 0 {
+		t.Fatalf("expected the TXT deleted, got %v", r.Answer)
+	}
+	if n := len(store.List()); n != 1 {
+		t.Fatalf("expected only the apex TXT left, got %d values", n)
+	}
+}
@@ -0,0 +1,187 @@
+package pajatso
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// The gRPC control-plane API is served as the "pajatso.v1.Challenges" service.
+// Messages are encoded as JSON (content subtype "json", i.e. content type
+// "application/grpc+json"), so clients need no generated code: set
+// grpc.CallContentSubtype("json") and exchange the message types below.
+const grpcServiceName = "pajatso.v1.Challenges"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec is a gRPC codec marshaling messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+// ChallengeRecord is a challenge record as returned by the gRPC API.
+type ChallengeRecord struct {
+	Name    string     `json:"name"`
+	Value   string     `json:"value"`
+	Expires *time.Time `json:"expires,omitempty"` // nil if the record never expires
+}
+
+// SetChallengeRequest sets the challenge record Name to Value.
+type SetChallengeRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// DeleteChallengeRequest deletes the challenge record Name.
+type DeleteChallengeRequest struct {
+	Name string `json:"name"`
+}
+
+// ListChallengesRequest lists the current challenge records.
+type ListChallengesRequest struct{}
+
+// ListChallengesResponse holds the current challenge records.
+type ListChallengesResponse struct {
+	Records []ChallengeRecord `json:"records"`
+}
+
+// GetStatusRequest requests the server status.
+type GetStatusRequest struct{}
+
+// GetStatusResponse describes the served zone and challenge record.
+type GetStatusResponse struct {
+	Zone          string `json:"zone"`
+	ChallengeName string `json:"challengeName"`
+	TokenSet      bool   `json:"tokenSet"`
+}
+
+// WatchChallengesRequest subscribes to changes of the challenge record.
+type WatchChallengesRequest struct{}
+
+// ChallengeEvent is a change of the challenge record streamed by
+// WatchChallenges. Type is "set", "delete" or "expire".
+type ChallengeEvent struct {
+	Type  string    `json:"type"`
+	Name  string    `json:"name"`
+	Value string    `json:"value"`
+	Time  time.Time `json:"time"`
+
+	Request string `json:"request,omitempty"` // ID of the request making the change
+}
+
+// Empty is returned by RPCs without a result.
+type Empty struct{}
+
+// RegisterGRPC registers the control-plane API on g.
+func (s *Server) RegisterGRPC(g *grpc.Server) {
+	s.Store.bind(s.ChallengeName())
+	g.RegisterService(&grpc.ServiceDesc{
+		ServiceName: grpcServiceName,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			grpcMethod("SetChallenge", s.grpcSetChallenge),
+			grpcMethod("DeleteChallenge", s.grpcDeleteChallenge),
+			grpcMethod("ListChallenges", s.grpcListChallenges),
+			grpcMethod("GetStatus", s.grpcGetStatus),
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "WatchChallenges", Handler: s.grpcWatchChallenges, ServerStreams: true},
+		},
+	}, s)
+}
+
+// grpcMethod adapts fn to a unary gRPC method handler.
+func grpcMethod[Req, Resp any](name string, fn func(context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			ctx = grpcRequestID(ctx)
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return fn(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/" + grpcServiceName + "/" + name}
+			return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return fn(ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+func (s *Server) grpcSetChallenge(ctx context.Context, req *SetChallengeRequest) (*Empty, error) {
+	if !s.isChallengeName(req.Name) {
+		return nil, status.Errorf(codes.NotFound, "unknown record %q", req.Name)
+	}
+	if req.Value == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty value")
+	}
+	if s.readOnly() {
+		return nil, status.Error(codes.Unavailable, errReadOnly.Error())
+	}
+	if err := s.checkToken(req.Value); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.Store.replace(s.ChallengeName(), req.Value, 0, RequestID(ctx))
+	s.recordSet(req.Value, "grpc")
+	slog.InfoContext(ctx, "grpc: set _acme-challenge TXT")
+	return &Empty{}, nil
+}
+
+func (s *Server) grpcDeleteChallenge(ctx context.Context, req *DeleteChallengeRequest) (*Empty, error) {
+	if !s.isChallengeName(req.Name) {
+		return nil, status.Errorf(codes.NotFound, "unknown record %q", req.Name)
+	}
+	if s.readOnly() {
+		return nil, status.Error(codes.Unavailable, errReadOnly.Error())
+	}
+	s.Store.deleteFor(s.ChallengeName(), "", RequestID(ctx))
+	slog.InfoContext(ctx, "grpc: deleted _acme-challenge TXT")
+	return &Empty{}, nil
+}
+
+func (s *Server) grpcListChallenges(ctx context.Context, req *ListChallengesRequest) (*ListChallengesResponse, error) {
+	resp := &ListChallengesResponse{Records: []ChallengeRecord{}}
+	for _, rec := range s.Records() {
+		resp.Records = append(resp.Records, ChallengeRecord{Name: rec.Name, Value: rec.Value, Expires: rec.Expires})
+	}
+	return resp, nil
+}
+
+func (s *Server) grpcGetStatus(ctx context.Context, req *GetStatusRequest) (*GetStatusResponse, error) {
+	ok := len(s.Store.Get(s.ChallengeName())) > 0
+	return &GetStatusResponse{Zone: s.Zone, ChallengeName: s.ChallengeName(), TokenSet: ok}, nil
+}
+
+// grpcWatchChallenges streams changes of the challenge record, and of the
+// records published with GenericTXT, until the client goes away.
+func (s *Server) grpcWatchChallenges(_ any, stream grpc.ServerStream) error {
+	var req WatchChallengesRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	events := s.Store.Watch(stream.Context())
+	// Send the headers once subscribed, so clients know no event is missed.
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for e := range events {
+		ev := &ChallengeEvent{Type: string(e.Op), Name: e.Name, Value: e.Value, Time: e.Time, Request: e.Request}
+		if err := stream.SendMsg(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
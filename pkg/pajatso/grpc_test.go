@@ -0,0 +1,119 @@
+package pajatso
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPC returns a client connection to the gRPC API of a fresh Server.
+func newTestGRPC(t *testing.T) (*grpc.ClientConn, *Store) {
+	t.Helper()
+
+	store := &Store{}
+	srv := &Server{Zone: testZone, Store: store}
+
+	ln := bufconn.Listen(1 << 16)
+	g := grpc.NewServer()
+	srv.RegisterGRPC(g)
+	go g.Serve(ln)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, store
+}
+
+func invoke(t *testing.T, conn *grpc.ClientConn, method string, req, resp any) error {
+	t.Helper()
+	return conn.Invoke(context.Background(), "/"+grpcServiceName+"/"+method, req, resp)
+}
+
+func TestGRPCSetListDelete(t *testing.T) {
+	conn, store := newTestGRPC(t)
+
+	if err := invoke(t, conn, "SetChallenge", &SetChallengeRequest{Name: testChallenge, Value: "grpc-token"}, &Empty{}); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if val, ok := store.Value(); !ok || val != "grpc-token" {
+		t.Fatalf("set: expected (grpc-token, true), got (%q, %v)", val, ok)
+	}
+
+	var list ListChallengesResponse
+	if err := invoke(t, conn, "ListChallenges", &ListChallengesRequest{}, &list); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list.Records) != 1 || list.Records[0].Value != "grpc-token" {
+		t.Fatalf("list: unexpected records %+v", list.Records)
+	}
+
+	var st GetStatusResponse
+	if err := invoke(t, conn, "GetStatus", &GetStatusRequest{}, &st); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if st.Zone != testZone || st.ChallengeName != testChallenge || !st.TokenSet {
+		t.Fatalf("status: unexpected %+v", st)
+	}
+
+	if err := invoke(t, conn, "DeleteChallenge", &DeleteChallengeRequest{Name: "_acme-challenge"}, &Empty{}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("delete: expected record to be deleted")
+	}
+}
+
+func TestGRPCWrongName(t *testing.T) {
+	conn, _ := newTestGRPC(t)
+
+	err := invoke(t, conn, "SetChallenge", &SetChallengeRequest{Name: "www.example.com.", Value: "bad"}, &Empty{})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestGRPCWatchChallenges(t *testing.T) {
+	conn, store := newTestGRPC(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/"+grpcServiceName+"/WatchChallenges")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&WatchChallengesRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Replace("watched")
+	store.Clear()
+
+	for _, want := range []ChallengeEvent{{Type: "set", Value: "watched"}, {Type: "delete", Value: "watched"}} {
+		var ev ChallengeEvent
+		if err := stream.RecvMsg(&ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != want.Type || ev.Value != want.Value || ev.Name != testChallenge {
+			t.Fatalf("expected %s of %q, got %+v", want.Type, want.Value, ev)
+		}
+	}
+}
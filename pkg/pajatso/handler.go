@@ -0,0 +1,167 @@
+package pajatso
+
+import (
+	"context"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// HandlerOption configures the handler returned by Server.Handler.
+type HandlerOption func(*handler)
+
+// WithNext passes all queries except those for the challenge and health
+// records, and updates for other zones, to next. This lets an existing
+// authoritative server keep serving the rest of the zone.
+func WithNext(next dns.Handler) HandlerOption {
+	return func(h *handler) { h.next = next }
+}
+
+// WithoutUpdates disables RFC 2136 updates, for when the record is set only
+// through the Store or the HTTP/gRPC APIs. Updates are passed to the next
+// handler if there is one and refused otherwise.
+func WithoutUpdates() HandlerOption {
+	return func(h *handler) { h.noUpdates = true }
+}
+
+// WithZones serves the zones of servers next to the zone of the Server,
+// e.g. for several tenants on one listener. Each zone is served by its own
+// Server, so it is updated only with that Server's TSIG key and its records
+// come from that Server's Store: one tenant's key can never set or read
+// another tenant's tokens. Requests go to the Server with the longest
+// matching zone; the other options apply to all of them. The zones must
+// differ from each other. They share the ban list of the Server, see
+// Config.Ban, so that a source banned for refused updates to any zone is
+// banned from all of them, with the ban settings of the Server.
+func WithZones(servers ...*Server) HandlerOption {
+	return func(h *handler) { h.zones = append(h.zones, servers...) }
+}
+
+// handler is the dns.Handler returned by Server.Handler.
+type handler struct {
+	s         *Server
+	next      dns.Handler
+	noUpdates bool
+	zones     []*Server
+	tenants   map[string]dns.Handler // handlers of zones, by zone
+}
+
+// Handler returns a dns.Handler serving the challenge record, for mounting
+// in a dns.ServeMux of an existing server, e.g.
+//
+//	mux.Handle("example.com.", srv.Handler(pajatso.WithNext(zoneHandler)))
+//
+// Without options it behaves like the Server itself. The handler is wrapped
+// in the Server's Middleware, and requests are handled with a context ending
+// after RequestTimeout.
+func (s *Server) Handler(opts ...HandlerOption) dns.Handler {
+	s.init()
+	h := &handler{s: s}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if len(h.zones) > 0 {
+		var tenantOpts []HandlerOption
+		if h.next != nil {
+			tenantOpts = append(tenantOpts, WithNext(h.next))
+		}
+		if h.noUpdates {
+			tenantOpts = append(tenantOpts, WithoutUpdates())
+		}
+		h.tenants = map[string]dns.Handler{}
+		for _, zs := range h.zones {
+			zs.init()
+			if zs.bans != s.bans {
+				zs.bans = s.bans
+			}
+			h.tenants[zs.Zone] = zs.Handler(tenantOpts...)
+		}
+	}
+	return s.deadline(Chain(h, s.Middleware...))
+}
+
+func (h *handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+	if h.s.bans.banned(remoteIP(w.RemoteAddr())) {
+		return
+	}
+	// The mode of the Server applies to the zones of WithZones as well.
+	if h.s.refuseByMode(w, r) {
+		return
+	}
+	// NOTIFY messages are handled by the Server for all zones, see
+	// NotifyZones.
+	if r.Opcode == dns.OpcodeNotify {
+		if h.next != nil && !h.s.forNotify(r) {
+			h.next.ServeDNS(ctx, w, r)
+			return
+		}
+		h.s.handleNotify(ctx, w, r)
+		return
+	}
+	if t := h.tenant(r); t != nil {
+		t.ServeDNS(ctx, w, r)
+		return
+	}
+
+	if r.Opcode == dns.OpcodeUpdate {
+		switch {
+		case h.next != nil && (h.noUpdates || !h.forZone(r)):
+			h.next.ServeDNS(ctx, w, r)
+		case h.noUpdates:
+			m := new(dns.Msg)
+			dnsutil.SetReply(m, r)
+			m.Rcode = dns.RcodeRefused
+			if r.Unpack() == nil {
+				setEDE(r, m, dns.ExtendedErrorNotSupported, "updates disabled")
+			}
+			h.s.writeMsg(w, m)
+		default:
+			h.s.handleUpdate(ctx, w, r)
+		}
+		return
+	}
+
+	if h.next != nil && !h.forRecord(r) {
+		h.next.ServeDNS(ctx, w, r)
+		return
+	}
+	h.s.handleQuery(ctx, w, r)
+}
+
+// tenant returns the handler of the zone from WithZones that r is for, or
+// nil if r is for the Server's own zone or none of them.
+func (h *handler) tenant(r *dns.Msg) dns.Handler {
+	if len(h.tenants) == 0 || len(r.Question) == 0 {
+		return nil
+	}
+	name := queryName(r.Question[0].Header().Name)
+	best, t := "", dns.Handler(nil)
+	if dnsutil.IsBelow(h.s.Zone, name) {
+		best = h.s.Zone
+	}
+	for zone, zh := range h.tenants {
+		if len(zone) > len(best) && dnsutil.IsBelow(zone, name) {
+			best, t = zone, zh
+		}
+	}
+	return t
+}
+
+// forZone reports whether the update r is for the served zone.
+func (h *handler) forZone(r *dns.Msg) bool {
+	return len(r.Question) == 1 && dns.EqualName(r.Question[0].Header().Name, h.s.Zone)
+}
+
+// forRecord reports whether the query r is for the challenge or health
+// record, for the configured CAA records, a name with static, CNAME or
+// generic TXT records or a registration.
+func (h *handler) forRecord(r *dns.Msg) bool {
+	if len(r.Question) == 0 || r.Question[0].Header().Class != dns.ClassINET {
+		return false
+	}
+	name := queryName(r.Question[0].Header().Name)
+	if len(h.s.CAA) > 0 && dns.RRToType(r.Question[0]) == dns.TypeCAA && dns.EqualName(name, h.s.Zone) {
+		return true
+	}
+	return dns.EqualName(name, h.s.ChallengeName()) || (h.s.HealthName != "" && dns.EqualName(name, h.s.healthName())) || h.s.static(name) || h.s.cname(name) != nil || h.s.isRegistered(name) || h.s.hasGenericTXT(name)
+}
@@ -0,0 +1,125 @@
+package pajatso
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// zoneHandler answers every query with an A record, standing in for the
+// authoritative server an embedder already runs.
+var zoneHandler = dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	dnsutil.SetReply(m, r)
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.Header{Name: r.Question[0].Header().Name, Class: dns.ClassINET, TTL: 300},
+		A:   rdata.A{Addr: netip.MustParseAddr("192.0.2.1")},
+	}}
+	WriteMsg(w, m)
+})
+
+// startTestHandler serves h on a random UDP port. It can't use pajatsotest,
+// which imports this package, and serves any handler rather than a Server.
+func startTestHandler(t *testing.T, h dns.Handler) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: h}
+	server.NotifyStartedFunc = func(context.Context) { close(started) }
+	go server.ListenAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return pc.LocalAddr().String()
+}
+
+func TestHandlerWithNext(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}}
+	srv.Store.Replace("token")
+
+	mux := dns.NewServeMux()
+	mux.Handle(testZone, srv.Handler(WithNext(zoneHandler)))
+	addr := startTestHandler(t, mux)
+
+	r := query(t, addr, testChallenge, dns.TypeTXT)
+	if len(r.Answer) != 1 {
+		t.Fatalf("expected the challenge TXT, got %v", r.Answer)
+	}
+	if _, ok := r.Answer[0].(*dns.TXT); !ok {
+		t.Fatalf("expected TXT, got %T", r.Answer[0])
+	}
+
+	r = query(t, addr, "www.example.com.", dns.TypeA)
+	if len(r.Answer) != 1 {
+		t.Fatalf("expected the next handler's A record, got %v", r.Answer)
+	}
+	if _, ok := r.Answer[0].(*dns.A); !ok {
+		t.Fatalf("expected A, got %T", r.Answer[0])
+	}
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"updated\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if val, _ := srv.Store.Value(); val != "updated" {
+		t.Fatalf("expected updated, got %q", val)
+	}
+}
+
+func TestHandlerWithoutUpdates(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}}
+	addr := startTestHandler(t, srv.Handler(WithoutUpdates()))
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"updated\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if _, ok := srv.Store.Value(); ok {
+		t.Fatal("expected the update to be ignored")
+	}
+}
+
+func TestHandlerWithZones(t *testing.T) {
+	const (
+		tenantZone   = "example.org."
+		tenantKey    = "tenant-key."
+		tenantSecret = "dGVuYW50LXNlY3JldC1mb3ItdGVzdGluZw=="
+	)
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}}
+	tenant := &Server{Zone: tenantZone, TsigName: tenantKey, TsigSecret: tenantSecret, Store: &Store{}}
+	addr := startTestHandler(t, srv.Handler(WithZones(tenant)))
+
+	// Each key can only update its own zone.
+	rr, _ := dns.New("_acme-challenge." + tenantZone + " 60 IN TXT \"tenant\"")
+	if r := sendUpdate(t, addr, tenantZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode == dns.RcodeSuccess {
+		t.Fatal("expected the primary key to be refused for the tenant zone")
+	}
+	if r := sendUpdate(t, addr, tenantZone, []dns.RR{rr}, tenantKey, tenantSecret); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	rr, _ = dns.New(testChallenge + " 60 IN TXT \"primary\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, tenantKey, tenantSecret); r.Rcode == dns.RcodeSuccess {
+		t.Fatal("expected the tenant key to be refused for the primary zone")
+	}
+	if val, _ := tenant.Store.Value(); val != "tenant" {
+		t.Fatalf("expected the tenant token, got %q", val)
+	}
+	if _, ok := srv.Store.Value(); ok {
+		t.Fatal("expected the primary store to be untouched")
+	}
+
+	if r := query(t, addr, "_acme-challenge."+tenantZone, dns.TypeTXT); len(r.Answer) != 1 {
+		t.Fatalf("expected the tenant TXT, got %v", r.Answer)
+	}
+	if r := query(t, addr, testChallenge, dns.TypeTXT); len(r.Answer) != 0 {
+		t.Fatalf("expected no primary TXT, got %v", r.Answer)
+	}
+}
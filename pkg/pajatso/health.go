@@ -0,0 +1,119 @@
+package pajatso
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"codeberg.org/miekg/dns"
+)
+
+// AdminHandler returns an http.Handler for the unauthenticated admin
+// listener, serving Kubernetes-style health probes and metrics:
+//
+//	GET /livez    the process is running
+//	GET /readyz   all DNS listeners are bound and serving
+//	GET /healthz  same as /readyz
+//	GET /metrics  metrics in the Prometheus text format
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /livez", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	ready := func(w http.ResponseWriter, r *http.Request) {
+		if !s.listening() {
+			http.Error(w, "dns listeners not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+	mux.HandleFunc("GET /readyz", ready)
+	mux.HandleFunc("GET /healthz", ready)
+	mux.HandleFunc("GET /metrics", s.metricsHandler)
+	return mux
+}
+
+// listening reports whether every DNS server created by NewDNSServer is
+// bound and serving, and the server isn't shutting down.
+func (s *Server) listening() bool {
+	n := s.dnsServers.Load()
+	return n > 0 && s.dnsListening.Load() == n && !s.draining()
+}
+
+// boundAddrs tracks the addresses of the DNS servers created by NewDNSServer
+// while they are serving.
+type boundAddrs struct {
+	mu    sync.Mutex
+	addrs map[*dns.Server]net.Addr
+	ready chan struct{} // closed by serverStarted once all servers serve
+}
+
+// readyCh returns s.bound.ready, creating it if needed. s.bound.mu must be
+// held.
+func (s *Server) readyCh() chan struct{} {
+	if s.bound.ready == nil {
+		s.bound.ready = make(chan struct{})
+	}
+	return s.bound.ready
+}
+
+// Ready returns a channel that is closed once every DNS server created by
+// NewDNSServer so far is bound and serving. It stays closed after the
+// servers are shut down.
+func (s *Server) Ready() <-chan struct{} {
+	s.bound.mu.Lock()
+	defer s.bound.mu.Unlock()
+	return s.readyCh()
+}
+
+// Addrs returns the addresses the serving DNS servers are bound to, e.g.
+// with the ports chosen for ":0", sorted by network and address.
+func (s *Server) Addrs() []net.Addr {
+	s.bound.mu.Lock()
+	defer s.bound.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(s.bound.addrs))
+	for _, a := range s.bound.addrs {
+		addrs = append(addrs, a)
+	}
+	slices.SortFunc(addrs, func(a, b net.Addr) int {
+		if c := strings.Compare(a.Network(), b.Network()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.String(), b.String())
+	})
+	return addrs
+}
+
+// serverStarted is the NotifyStartedFunc of ds, created by NewDNSServer.
+func (s *Server) serverStarted(ds *dns.Server) {
+	s.bound.mu.Lock()
+	defer s.bound.mu.Unlock()
+
+	if s.bound.addrs == nil {
+		s.bound.addrs = map[*dns.Server]net.Addr{}
+	}
+	switch {
+	case ds.PacketConn != nil:
+		s.bound.addrs[ds] = ds.PacketConn.LocalAddr()
+	case ds.Listener != nil:
+		s.bound.addrs[ds] = ds.Listener.Addr()
+	}
+	if s.dnsListening.Add(1) == s.dnsServers.Load() {
+		ready := s.readyCh()
+		select {
+		case <-ready:
+		default:
+			close(ready)
+		}
+	}
+}
+
+// serverStopped is the NotifyShutdownFunc of ds, created by NewDNSServer.
+func (s *Server) serverStopped(ds *dns.Server) {
+	s.bound.mu.Lock()
+	defer s.bound.mu.Unlock()
+	delete(s.bound.addrs, ds)
+	s.dnsListening.Add(-1)
+}
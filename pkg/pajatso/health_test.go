@@ -0,0 +1,101 @@
+package pajatso
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, h http.Handler, path string) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec.Code
+}
+
+func TestHealthNotReady(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigSecret: testTsigSecret, Store: &Store{}}
+	h := srv.AdminHandler()
+
+	// No DNS listeners at all.
+	if code := probe(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz: expected 503, got %d", code)
+	}
+
+	// A DNS server that was created but never started.
+	srv.NewDNSServer()
+	if code := probe(t, h, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("healthz: expected 503, got %d", code)
+	}
+	if code := probe(t, h, "/livez"); code != http.StatusOK {
+		t.Fatalf("livez: expected 200, got %d", code)
+	}
+}
+
+func TestHealthReady(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigSecret: testTsigSecret, Store: &Store{}}
+	_, _, cleanup := startTestServerFor(t, srv)
+
+	h := srv.AdminHandler()
+	for _, path := range []string{"/livez", "/readyz", "/healthz"} {
+		if code := probe(t, h, path); code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, code)
+		}
+	}
+
+	cleanup()
+	if code := probe(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz after shutdown: expected 503, got %d", code)
+	}
+}
+
+func TestReadyAddrs(t *testing.T) {
+	srv := &Server{Zone: testZone, Store: &Store{}}
+	udp, tcp := srv.NewDNSServer(), srv.NewDNSServer()
+	udp.Addr, udp.Net = "127.0.0.1:0", "udp"
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp.Net, tcp.Listener = "tcp", srv.TCPListener(ln)
+
+	go udp.ListenAndServe()
+	select {
+	case <-srv.Ready():
+		t.Fatal("ready with one of two servers started")
+	case <-time.After(50 * time.Millisecond):
+	}
+	go tcp.ListenAndServe()
+	<-srv.Ready()
+	defer udp.Shutdown(context.Background())
+	defer tcp.Shutdown(context.Background())
+
+	addrs := srv.Addrs()
+	if len(addrs) != 2 || addrs[0].Network() != "tcp" || addrs[0].String() != ln.Addr().String() || addrs[1].Network() != "udp" {
+		t.Fatalf("unexpected addresses %v", addrs)
+	}
+	if port := addrs[1].(*net.UDPAddr).Port; port == 0 {
+		t.Fatal("expected the UDP port chosen for :0")
+	}
+}
+
+func TestMetricsExpiredTokens(t *testing.T) {
+	srv := &Server{Zone: testZone, Store: &Store{TTL: 20 * time.Millisecond}}
+	srv.init()
+	srv.Store.Add("expiring")
+	srv.Store.Add("second")
+	time.Sleep(100 * time.Millisecond)
+	srv.Store.Replace("current")
+
+	rec := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{"pajatso_tokens 1\n", "pajatso_tokens_expired_total 2\n"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package pajatso
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts names for lookup as IDNA2008 with the UTS #46
+// mappings, but allows underscores, as in _acme-challenge.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.StrictDomainName(false))
+
+// ToASCII converts the internationalized labels of name to A-labels, e.g.
+// "bücher.example." to "xn--bcher-kva.example.". ASCII names are returned
+// unchanged.
+func ToASCII(name string) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+	ascii, err := idnaProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized name %q: %w", name, err)
+	}
+	return ascii, nil
+}
+
+// asciiName returns name with A-labels, or as given if it isn't a valid
+// internationalized name, for comparing names given by clients.
+func asciiName(name string) string {
+	if ascii, err := ToASCII(name); err == nil {
+		return ascii
+	}
+	return name
+}
+
+// queryName returns the name a query for qname is answered for: qname
+// lowercased, with A-labels if some client sent UTF-8 labels, so that both
+// forms find the same records.
+func queryName(qname string) string {
+	return strings.ToLower(asciiName(qname))
+}
+
+func isASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package pajatso
+
+import (
+	"net/netip"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestToASCII(t *testing.T) {
+	for name, want := range map[string]string{
+		"bücher.example.":                 "xn--bcher-kva.example.",
+		"_acme-challenge.Bücher.example.": "_acme-challenge.xn--bcher-kva.example.",
+		"xn--bcher-kva.example.":          "xn--bcher-kva.example.",
+		"Example.COM.":                    "Example.COM.",
+	} {
+		if got, err := ToASCII(name); err != nil || got != want {
+			t.Errorf("%s: expected %s, got %s (%v)", name, want, got, err)
+		}
+	}
+	if _, err := ToASCII("-bücher.example."); err == nil {
+		t.Error("expected an invalid label to be refused")
+	}
+}
+
+func TestIDNZone(t *testing.T) {
+	srv, err := NewServer(Config{Zone: "Bücher.example", NS: []string{"ns.bücher.example"}, NSAddrs: []netip.Addr{netip.MustParseAddr("192.0.2.1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Zone != "xn--bcher-kva.example." || srv.NS[0] != "ns.xn--bcher-kva.example." {
+		t.Fatalf("expected the zone with A-labels, got %s and %v", srv.Zone, srv.NS)
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	store.Set(srv.ChallengeName(), "token", 0)
+
+	// Clients sending the UTF-8 name find the same record.
+	for _, name := range []string{"_acme-challenge.xn--bcher-kva.example.", "_acme-challenge.bücher.example."} {
+		if r := query(t, addr, name, dns.TypeTXT); len(r.Answer) != 1 {
+			t.Errorf("%s: expected the challenge TXT, got %v", name, r.Answer)
+		}
+	}
+	if !srv.isChallengeName("_acme-challenge.bücher.example") || !srv.isChallengeName("_acme-challenge") {
+		t.Error("expected the challenge name to match in both forms")
+	}
+}
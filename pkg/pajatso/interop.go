@@ -0,0 +1,49 @@
+package pajatso
+
+import (
+	"slices"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Update clients fill in the parts of an update that RFC 2136 leaves open in
+// different ways. The functions here accept the variants sent by common ACME
+// clients where the RFC doesn't require rejecting them. TestInterop replays
+// the clients' transactions from testdata/interop.
+
+// zoneMatches reports whether zone, the name in the zone section of r, names
+// the zone of s. Besides the apex, a name inside the zone is accepted if it
+// encloses every prerequisite and update, as sent by clients taking the zone
+// from the record's owner name rather than from an SOA lookup.
+func (s *Server) zoneMatches(zone string, r *dns.Msg) bool {
+	if dns.EqualName(zone, s.Zone) {
+		return true
+	}
+	if !dnsutil.IsBelow(s.Zone, zone) {
+		return false
+	}
+	for _, rr := range slices.Concat(r.Answer, r.Ns) {
+		if !dnsutil.IsBelow(zone, rr.Header().Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalAlgorithm lowercases the algorithm name of t. The MAC covers the
+// name in canonical form (RFC 8945 4.3.3), which some clients send in upper
+// case, and the dns package only recognizes the lowercase names.
+func canonicalAlgorithm(t *dns.TSIG) {
+	t.Algorithm = strings.ToLower(t.Algorithm)
+}
+
+// emptyPrereq reports whether rr, a prerequisite of class IN, carries no
+// data. Some clients send "RRset exists" prerequisites this way instead of
+// with class ANY; they are checked as such rather than against an empty
+// value.
+func emptyPrereq(rr dns.RR) bool {
+	txt, ok := rr.(*dns.TXT)
+	return ok && len(txt.Txt) == 0
+}
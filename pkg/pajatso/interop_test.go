@@ -0,0 +1,152 @@
+package pajatso
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+// TestInterop replays the update transactions of the clients in
+// testdata/interop. Each file lists messages as sent by the client:
+//
+//	algorithm NAME    TSIG algorithm name, in the case sent
+//	zone NAME         starts a message with this zone section
+//	prereq RR         adds a prerequisite
+//	update RR         adds an update
+//	expect RCODE ...  sends the message, expecting RCODE and the values stored after it
+//
+// Records without data, e.g. "name 0 ANY TXT", are given as the name, TTL,
+// class and type.
+func TestInterop(t *testing.T) {
+	files, err := filepath.Glob("testdata/interop/*.txt")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no interop transactions: %v", err)
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".txt"), func(t *testing.T) {
+			replayInterop(t, file)
+		})
+	}
+}
+
+func replayInterop(t *testing.T, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var (
+		addr      string
+		store     *Store
+		algorithm string
+		m         *dns.Msg
+	)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch verb {
+		case "algorithm":
+			algorithm = arg
+			var cleanup func()
+			addr, store, cleanup = startTestServerFor(t, &Server{
+				Zone:       testZone,
+				TsigName:   testTsigName,
+				TsigSecret: testTsigSecret,
+				TsigAlg:    strings.ToLower(algorithm),
+				Store:      &Store{},
+			})
+			t.Cleanup(cleanup)
+		case "zone":
+			m = new(dns.Msg)
+			m.ID = dns.ID()
+			m.Opcode = dns.OpcodeUpdate
+			m.Question = []dns.RR{&dns.SOA{Hdr: dns.Header{Name: arg, Class: dns.ClassINET}}}
+		case "prereq":
+			m.Answer = append(m.Answer, parseInteropRR(t, n, arg))
+		case "update":
+			m.Ns = append(m.Ns, parseInteropRR(t, n, arg))
+		case "expect":
+			fields := strings.Fields(arg)
+			r := sendInterop(t, addr, m, algorithm)
+			if got := dns.RcodeToString[r.Rcode]; got != fields[0] {
+				t.Fatalf("line %d: expected %s, got %s", n, fields[0], got)
+			}
+			var stored []string
+			for _, e := range store.Entries() {
+				stored = append(stored, e.Value)
+			}
+			want := fields[1:]
+			slices.Sort(stored)
+			slices.Sort(want)
+			if !slices.Equal(stored, want) {
+				t.Fatalf("line %d: expected values %q, got %q", n, want, stored)
+			}
+		default:
+			t.Fatalf("line %d: unknown verb %q", n, verb)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// parseInteropRR parses a record of a transaction, which may lack data.
+func parseInteropRR(t *testing.T, line int, s string) dns.RR {
+	t.Helper()
+	fields := strings.Fields(s)
+	if len(fields) > 4 {
+		rr, err := dns.New(s)
+		if err != nil {
+			t.Fatalf("line %d: %v", line, err)
+		}
+		return rr
+	}
+	if len(fields) != 4 {
+		t.Fatalf("line %d: malformed record %q", line, s)
+	}
+	hdr := dns.Header{Name: fields[0], Class: dns.StringToClass[fields[2]]}
+	switch fields[3] {
+	case "ANY":
+		return &dns.ANY{Hdr: hdr}
+	case "TXT":
+		return &dns.TXT{Hdr: hdr}
+	}
+	t.Fatalf("line %d: unsupported type %q", line, fields[3])
+	return nil
+}
+
+// sendInterop signs and sends m with the algorithm name written as given.
+// The MAC covers the name in lowercase, which is patched into the packed
+// message after signing.
+func sendInterop(t *testing.T, addr string, m *dns.Msg, algorithm string) *dns.Msg {
+	t.Helper()
+	m.Pseudo = []dns.RR{dns.NewTSIG(testTsigName, strings.ToLower(algorithm), 300)}
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+		t.Fatalf("TSIG sign failed: %v", err)
+	}
+	label := strings.TrimSuffix(algorithm, ".")
+	i := bytes.LastIndex(m.Data, []byte(strings.ToLower(label)))
+	if i < 0 {
+		t.Fatalf("algorithm %s not found in the message", algorithm)
+	}
+	copy(m.Data[i:], label)
+	r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	return r
+}
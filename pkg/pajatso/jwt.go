@@ -0,0 +1,389 @@
+package pajatso
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes of the supported algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The keys of a JWTAuth are refetched after jwksMaxAge, or on a token
+// signed with an unknown key, at most once per jwksMinRefresh.
+const (
+	jwksMaxAge     = time.Hour
+	jwksMinRefresh = time.Minute
+	jwksMaxSize    = 1 << 20     // of a key set or OpenID configuration
+	jwtLeeway      = time.Minute // allowed clock skew for exp and nbf
+)
+
+// JWTAuth authenticates HTTP API requests carrying a JWT as their bearer
+// token, e.g. the identity tokens of cloud workloads or CI jobs, signed by
+// an OpenID Connect issuer. Tokens must be signed with RS256, RS384, RS512,
+// PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA by a key of the issuer's
+// key set, name Issuer and Audience, and be unexpired.
+type JWTAuth struct {
+	Issuer   string // required "iss" claim, e.g. "https://token.actions.githubusercontent.com"
+	Audience string // required in the "aud" claim
+
+	// JWKSURL is the JSON Web Key Set of the issuer's signing keys. If
+	// empty, it is discovered from the issuer's OpenID configuration.
+	JWKSURL string
+
+	// Scopes are required in the space-separated "scope" or the "scp"
+	// claim of the tokens, none if empty.
+	Scopes []string
+
+	Client *http.Client // fetches the keys, http.DefaultClient if nil
+	Clock  Clock        // checks the expiry of tokens, the system clock if nil
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // by key ID
+	fetched time.Time
+}
+
+// JWTClaims are the claims of a JWT validated by JWTAuth.
+type JWTClaims struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	Scopes   []string
+	Expires  time.Time
+}
+
+// jwtHeader is the JOSE header of a JWT.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtPayload holds the registered and scope claims of a JWT. aud and scp may
+// be a string or an array of strings.
+type jwtPayload struct {
+	Iss   string          `json:"iss"`
+	Sub   string          `json:"sub"`
+	Aud   json.RawMessage `json:"aud"`
+	Exp   *float64        `json:"exp"`
+	Nbf   *float64        `json:"nbf"`
+	Scope string          `json:"scope"`
+	Scp   json.RawMessage `json:"scp"`
+}
+
+// check validates the settings of a.
+func (a *JWTAuth) check() error {
+	if a.Issuer == "" {
+		return errors.New("JWT issuer is required")
+	}
+	if a.Audience == "" {
+		return errors.New("JWT audience is required")
+	}
+	return nil
+}
+
+// Verify validates token, returning its claims.
+func (a *JWTAuth) Verify(ctx context.Context, token string) (*JWTClaims, error) {
+	if err := a.check(); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+	var hdr jwtHeader
+	if err := decodeJWTPart(parts[0], &hdr); err != nil {
+		return nil, fmt.Errorf("JWT header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("JWT signature: %w", err)
+	}
+	key, err := a.key(ctx, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(hdr.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var p jwtPayload
+	if err := decodeJWTPart(parts[1], &p); err != nil {
+		return nil, fmt.Errorf("JWT claims: %w", err)
+	}
+	claims := &JWTClaims{Issuer: p.Iss, Subject: p.Sub, Audience: stringOrList(p.Aud)}
+	if scopes := stringOrList(p.Scp); len(scopes) > 0 {
+		claims.Scopes = scopes
+	} else {
+		claims.Scopes = strings.Fields(p.Scope)
+	}
+
+	now := clockOr(a.Clock).Now()
+	switch {
+	case p.Iss != a.Issuer:
+		return nil, fmt.Errorf("JWT issued by %q", p.Iss)
+	case !slices.Contains(claims.Audience, a.Audience):
+		return nil, fmt.Errorf("JWT not for audience %q", a.Audience)
+	case p.Exp == nil:
+		return nil, errors.New("JWT without expiry")
+	}
+	claims.Expires = unixFloat(*p.Exp)
+	if !now.Before(claims.Expires.Add(jwtLeeway)) {
+		return nil, errors.New("JWT expired")
+	}
+	if p.Nbf != nil && now.Add(jwtLeeway).Before(unixFloat(*p.Nbf)) {
+		return nil, errors.New("JWT not valid yet")
+	}
+	for _, scope := range a.Scopes {
+		if !slices.Contains(claims.Scopes, scope) {
+			return nil, fmt.Errorf("JWT without scope %q", scope)
+		}
+	}
+	return claims, nil
+}
+
+// decodeJWTPart decodes a base64url-encoded JSON part of a JWT into v.
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// stringOrList decodes a JSON string or array of strings.
+func stringOrList(raw json.RawMessage) []string {
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil && s != "" {
+		return []string{s}
+	}
+	return nil
+}
+
+// unixFloat converts a JWT NumericDate to a time.
+func unixFloat(secs float64) time.Time {
+	return time.Unix(0, int64(secs*float64(time.Second)))
+}
+
+// jwsHashes are the hashes of the supported JWS algorithms, by suffix.
+var jwsHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// verifyJWS verifies the JWS signature sig of signed with key and the
+// algorithm alg, which must suit the key.
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if pub, ok := key.(ed25519.PublicKey); ok && alg == "EdDSA" {
+		if !ed25519.Verify(pub, signed, sig) {
+			return errors.New("invalid JWT signature")
+		}
+		return nil
+	}
+	if len(alg) != 5 || jwsHashes[alg[2:]] == 0 {
+		return fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	hash := jwsHashes[alg[2:]]
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	valid := false
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			valid = rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+		case "PS":
+			valid = rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		default:
+			return fmt.Errorf("JWT algorithm %q for an RSA key", alg)
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" || hash != ecdsaHash(pub.Curve) {
+			return fmt.Errorf("JWT algorithm %q for a %s key", alg, pub.Curve.Params().Name)
+		}
+		// The signature is R and S as fixed-size big-endian integers.
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) == 2*size {
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			valid = ecdsa.Verify(pub, digest, r, s)
+		}
+	default:
+		return fmt.Errorf("JWT algorithm %q for a %T key", alg, key)
+	}
+	if !valid {
+		return errors.New("invalid JWT signature")
+	}
+	return nil
+}
+
+// ecdsaHash returns the hash ES256, ES384 and ES512 use with curve.
+func ecdsaHash(curve elliptic.Curve) crypto.Hash {
+	switch curve {
+	case elliptic.P256():
+		return crypto.SHA256
+	case elliptic.P384():
+		return crypto.SHA384
+	case elliptic.P521():
+		return crypto.SHA512
+	}
+	return 0
+}
+
+// key returns the signing key with ID kid, fetching the key set if it is
+// stale or lacks the key.
+func (a *JWTAuth) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := clockOr(a.Clock).Now()
+	key, ok := a.lookup(kid)
+	if ok && now.Sub(a.fetched) < jwksMaxAge {
+		return key, nil
+	}
+	if !a.fetched.IsZero() && now.Sub(a.fetched) < jwksMinRefresh {
+		if ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown JWT key %q", kid)
+	}
+	keys, err := a.fetchKeys(ctx)
+	if err != nil {
+		if ok {
+			return key, nil // keep using the stale keys
+		}
+		return nil, fmt.Errorf("fetching JWT keys: %w", err)
+	}
+	a.keys, a.fetched = keys, now
+	if key, ok := a.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown JWT key %q", kid)
+}
+
+// lookup returns the key with ID kid, or the only key if kid is empty. The
+// caller must hold a.mu.
+func (a *JWTAuth) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+// fetchKeys fetches the key set, discovering its URL first if needed.
+func (a *JWTAuth) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	url := a.JWKSURL
+	if url == "" {
+		var conf struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := a.getJSON(ctx, strings.TrimSuffix(a.Issuer, "/")+"/.well-known/openid-configuration", &conf); err != nil {
+			return nil, err
+		}
+		if conf.Issuer != a.Issuer || conf.JWKSURI == "" {
+			return nil, fmt.Errorf("OpenID configuration of issuer %q has no key set", conf.Issuer)
+		}
+		url = conf.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := a.getJSON(ctx, url, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// getJSON fetches the JSON document at url into v.
+func (a *JWTAuth) getJSON(ctx context.Context, url string, v any) error {
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, jwksMaxSize)).Decode(v)
+}
+
+// jwk is a JSON Web Key of a key set, as published by OpenID issuers.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA, ECDSA or Ed25519 public key of k.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err1 := b64(k.N)
+		e, err2 := b64(k.E)
+		if err := errors.Join(err1, err2); err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("malformed RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := b64(k.X)
+		y, err2 := b64(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if err := errors.Join(err1, err2); err != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("malformed EC key")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, slices.Concat([]byte{4}, x, y))
+	case "OKP":
+		x, err := b64(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("unsupported or malformed OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
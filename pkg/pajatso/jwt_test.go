@@ -0,0 +1,198 @@
+package pajatso
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OpenID Connect issuer serving its configuration and the
+// public keys of keys, by key ID.
+type testIssuer struct {
+	*httptest.Server
+	keys    atomic.Pointer[map[string]crypto.Signer]
+	fetches atomic.Int32
+}
+
+func newTestIssuer(t *testing.T, keys map[string]crypto.Signer) *testIssuer {
+	t.Helper()
+	iss := &testIssuer{}
+	iss.keys.Store(&keys)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/keys"})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		iss.fetches.Add(1)
+		var set []map[string]string
+		for kid, key := range *iss.keys.Load() {
+			set = append(set, testJWK(kid, key.Public()))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": set})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func testJWK(kid string, pub crypto.PublicKey) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		b, _ := pub.Bytes()
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(b[1:33]), "y": b64(b[33:])}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(pub)}
+	}
+	panic("unsupported key")
+}
+
+// signJWT returns a JWT of claims signed with key as kid.
+func signJWT(t *testing.T, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	alg := "RS256"
+	switch key.(type) {
+	case *ecdsa.PrivateKey:
+		alg = "ES256"
+	case ed25519.PrivateKey:
+		alg = "EdDSA"
+	}
+	hdr, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	var err error
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, []byte(signed))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err2 := ecdsa.Sign(rand.Reader, key, digest[:])
+		sig, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), err2
+	default:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	iss := newTestIssuer(t, map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey, "ed": edKey})
+
+	clock := newFakeClock()
+	auth := &JWTAuth{Issuer: iss.URL, Audience: "dns-pajatso", Scopes: []string{"acme"}, Clock: clock}
+	claims := func(mod func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss":   iss.URL,
+			"sub":   "ci-job",
+			"aud":   []string{"other", "dns-pajatso"},
+			"exp":   clock.Now().Add(10 * time.Minute).Unix(),
+			"scope": "read acme",
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+	ctx := context.Background()
+
+	for kid, key := range map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey, "ed": edKey} {
+		c, err := auth.Verify(ctx, signJWT(t, kid, key, claims(nil)))
+		if err != nil {
+			t.Fatalf("%s: %v", kid, err)
+		}
+		if c.Subject != "ci-job" {
+			t.Fatalf("%s: unexpected claims %+v", kid, c)
+		}
+	}
+	if n := iss.fetches.Load(); n != 1 {
+		t.Fatalf("expected the keys fetched once, got %d", n)
+	}
+
+	for name, tt := range map[string]struct {
+		kid    string
+		key    crypto.Signer
+		claims map[string]any
+	}{
+		"issuer":    {"ec", ecKey, claims(func(c map[string]any) { c["iss"] = "https://evil.example" })},
+		"audience":  {"ec", ecKey, claims(func(c map[string]any) { c["aud"] = "other" })},
+		"expired":   {"ec", ecKey, claims(func(c map[string]any) { c["exp"] = clock.Now().Add(-2 * time.Minute).Unix() })},
+		"no expiry": {"ec", ecKey, claims(func(c map[string]any) { delete(c, "exp") })},
+		"not yet":   {"ec", ecKey, claims(func(c map[string]any) { c["nbf"] = clock.Now().Add(5 * time.Minute).Unix() })},
+		"scope":     {"ec", ecKey, claims(func(c map[string]any) { c["scope"] = "read" })},
+		"key id":    {"rsa", ecKey, claims(nil)},
+	} {
+		if _, err := auth.Verify(ctx, signJWT(t, tt.kid, tt.key, tt.claims)); err == nil {
+			t.Errorf("%s: expected the token to be refused", name)
+		}
+	}
+
+	// Tokens without a signature aren't accepted.
+	token := signJWT(t, "ec", ecKey, claims(nil))
+	hdr := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"ec"}`))
+	if _, err := auth.Verify(ctx, hdr+token[strings.Index(token, "."):strings.LastIndex(token, ".")]+"."); err == nil {
+		t.Error("expected an unsigned token to be refused")
+	}
+
+	// Keys added by the issuer are fetched once tokens signed with them
+	// arrive, but not more than once a minute.
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	iss.keys.Store(&map[string]crypto.Signer{"new": newKey})
+	if _, err := auth.Verify(ctx, signJWT(t, "new", newKey, claims(nil))); err == nil {
+		t.Fatal("expected the keys not to be refetched right away")
+	}
+	clock.Advance(2 * time.Minute)
+	if _, err := auth.Verify(ctx, signJWT(t, "new", newKey, claims(nil))); err != nil {
+		t.Fatalf("expected the new key to be fetched, got %v", err)
+	}
+}
+
+func TestAPIJWT(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	iss := newTestIssuer(t, map[string]crypto.Signer{"k": key})
+	srv := &Server{
+		Zone:     testZone,
+		APIToken: testAPIToken,
+		APIJWT:   &JWTAuth{Issuer: iss.URL, Audience: "dns-pajatso"},
+		Store:    &Store{},
+	}
+	ts := httptest.NewServer(srv.APIHandler())
+	defer ts.Close()
+
+	token := signJWT(t, "k", key, map[string]any{"iss": iss.URL, "aud": "dns-pajatso", "exp": time.Now().Add(time.Minute).Unix()})
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{{token, http.StatusOK}, {testAPIToken, http.StatusOK}, {token[:len(token)-4], http.StatusUnauthorized}} {
+		if resp := apiRequest(t, ts, "GET", "/zones/example.com/records", tt.token, ""); resp.StatusCode != tt.want {
+			t.Errorf("expected %d, got %d", tt.want, resp.StatusCode)
+		}
+	}
+
+	if _, err := NewServer(Config{Zone: testZone, APIJWT: &JWTAuth{Issuer: iss.URL}}); err == nil {
+		t.Error("expected a JWT issuer without audience to be refused")
+	}
+}
@@ -0,0 +1,73 @@
+package pajatso
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyStats counts the use of TSIG keys, so that stale or abused keys can be
+// told apart and revoked. It may be shared between Servers, e.g. tenants, to
+// report the keys of all of them together.
+type KeyStats struct {
+	mu   sync.Mutex
+	keys map[string]*KeyUsage // by lowercased key name
+}
+
+// KeyUsage is the use of a TSIG key since the server started.
+type KeyUsage struct {
+	Key        string     `json:"key"`
+	Queries    uint64     `json:"queries"` // challenge queries answered with a token of the key's zone
+	Updates    uint64     `json:"updates"` // updates applied
+	Refused    uint64     `json:"refused"` // updates naming the key that were rejected
+	LastUpdate *time.Time `json:"lastUpdate,omitempty"`
+}
+
+// usage returns the counters of key, creating them if needed. k.mu must be
+// held.
+func (k *KeyStats) usage(key string) *KeyUsage {
+	name := strings.ToLower(key)
+	u := k.keys[name]
+	if u == nil {
+		if k.keys == nil {
+			k.keys = map[string]*KeyUsage{}
+		}
+		u = &KeyUsage{Key: name}
+		k.keys[name] = u
+	}
+	return u
+}
+
+// countUpdate counts an update signed with key, applied or rejected.
+func (k *KeyStats) countUpdate(key string, applied bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	u := k.usage(key)
+	if !applied {
+		u.Refused++
+		return
+	}
+	now := time.Now()
+	u.Updates++
+	u.LastUpdate = &now
+}
+
+// countQuery counts a challenge query for the records of key.
+func (k *KeyStats) countQuery(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.usage(key).Queries++
+}
+
+// Usage returns the use of each key seen, ordered by key name.
+func (k *KeyStats) Usage() []KeyUsage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	usage := make([]KeyUsage, 0, len(k.keys))
+	for _, u := range k.keys {
+		usage = append(usage, *u)
+	}
+	slices.SortFunc(usage, func(a, b KeyUsage) int { return strings.Compare(a.Key, b.Key) })
+	return usage
+}
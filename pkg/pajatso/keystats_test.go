@@ -0,0 +1,47 @@
+package pajatso
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestKeyStats(t *testing.T) {
+	stats := &KeyStats{}
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}, KeyStats: stats}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"token\"")
+	sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret)
+	query(t, addr, testChallenge, dns.TypeTXT)
+
+	// Refused: a bad signature and a name outside the policy. Updates with
+	// unknown key names aren't counted.
+	sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, "d3Jvbmctc2VjcmV0")
+	other, _ := dns.New("other.example.com. 60 IN TXT \"token\"")
+	sendUpdate(t, addr, testZone, []dns.RR{other}, testTsigName, testTsigSecret)
+	sendUpdate(t, addr, testZone, []dns.RR{rr}, "unknown.", testTsigSecret)
+
+	usage := stats.Usage()
+	if len(usage) != 1 {
+		t.Fatalf("expected the usage of one key, got %+v", usage)
+	}
+	if u := usage[0]; u.Key != testTsigName || u.Queries != 1 || u.Updates != 1 || u.Refused != 2 || u.LastUpdate == nil {
+		t.Fatalf("unexpected usage %+v", u)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`pajatso_key_queries_total{key="acme-update."} 1`,
+		`pajatso_key_updates_total{key="acme-update.",result="applied"} 1`,
+		`pajatso_key_updates_total{key="acme-update.",result="refused"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), want+"\n") {
+			t.Errorf("metrics missing %q:\n%s", want, rec.Body)
+		}
+	}
+}
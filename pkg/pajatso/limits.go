@@ -0,0 +1,197 @@
+package pajatso
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+// errTooManyConns is returned by tcpListener.Accept for rejected connections.
+var errTooManyConns = errors.New("too many TCP connections")
+
+// tcpConns tracks the TCP connections accepted through TCPListener, each with
+// a timer closing it once it has been idle for too long.
+//
+// The dns package keeps a connection open across read timeouts (up to
+// MaxTCPQueries of them), so a client that never sends anything would hold it
+// for minutes. It also type-switches on *net.TCPConn, so connections can't be
+// wrapped to notice reads or closes; instead, the timers are reset for every
+// query handled on a connection.
+type tcpConns struct {
+	mu    sync.Mutex
+	conns map[*net.TCPConn]*time.Timer
+}
+
+// tcpListener registers accepted connections with its Server.
+type tcpListener struct {
+	net.Listener
+	s *Server
+}
+
+// Accept accepts the next connection, closing it and returning
+// errTooManyConns if MaxTCPConns connections are open. The dns server keeps
+// accepting after errors.
+func (l *tcpListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok && !l.s.trackTCP(tc) {
+		slog.Warn("tcp: connection limit reached, rejecting", "remote", c.RemoteAddr(), "max", l.s.MaxTCPConns)
+		c.Close()
+		return nil, errTooManyConns
+	}
+	return c, nil
+}
+
+// TCPListener wraps ln to enforce the TCP connection limit and timeouts of s.
+// Use it for the Listener of servers created by NewDNSServer: the dns package
+// ignores listeners replaced in ListenFunc.
+func (s *Server) TCPListener(ln net.Listener) net.Listener {
+	return &tcpListener{Listener: ln, s: s}
+}
+
+// tcpReadTimeout returns the time allowed for the first query on a connection.
+func (s *Server) tcpReadTimeout() time.Duration {
+	if s.TCPReadTimeout > 0 {
+		return s.TCPReadTimeout
+	}
+	return 2 * time.Second // as the dns package
+}
+
+// tcpIdleTimeout returns the time allowed between queries on a connection.
+func (s *Server) tcpIdleTimeout() time.Duration {
+	if s.TCPIdleTimeout > 0 {
+		return s.TCPIdleTimeout
+	}
+	return 8 * time.Second // as the dns package, see RFC 7766
+}
+
+// trackTCP registers c, or reports false if the connection limit is reached.
+func (s *Server) trackTCP(c *net.TCPConn) bool {
+	s.tcp.mu.Lock()
+	defer s.tcp.mu.Unlock()
+
+	if s.tcp.conns == nil {
+		s.tcp.conns = map[*net.TCPConn]*time.Timer{}
+	}
+	if s.MaxTCPConns > 0 && len(s.tcp.conns) >= s.MaxTCPConns {
+		// Connections closed by the dns server are only noticed here.
+		for other, t := range s.tcp.conns {
+			if connClosed(other) {
+				t.Stop()
+				delete(s.tcp.conns, other)
+			}
+		}
+		if len(s.tcp.conns) >= s.MaxTCPConns {
+			return false
+		}
+	}
+	s.tcp.conns[c] = time.AfterFunc(s.tcpReadTimeout(), func() { s.closeTCP(c) })
+	return true
+}
+
+// touchTCP restarts the idle timer of c after a query has been read from it.
+func (s *Server) touchTCP(c *net.TCPConn) {
+	s.tcp.mu.Lock()
+	defer s.tcp.mu.Unlock()
+	if t, ok := s.tcp.conns[c]; ok {
+		t.Reset(s.tcpIdleTimeout())
+	}
+}
+
+// closeTCP closes c and stops tracking it.
+func (s *Server) closeTCP(c *net.TCPConn) {
+	s.tcp.mu.Lock()
+	if t, ok := s.tcp.conns[c]; ok {
+		t.Stop()
+		delete(s.tcp.conns, c)
+	}
+	s.tcp.mu.Unlock()
+	c.Close()
+}
+
+// connClosed reports whether c has been closed, without side effects on an
+// open connection.
+func connClosed(c *net.TCPConn) bool {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return true
+	}
+	return rc.Control(func(uintptr) {}) != nil
+}
+
+// tcpLimits returns a middleware restarting the idle timer of TCP
+// connections for every query and bounding the time for writing the
+// response, so clients that stop reading don't hold a handler forever.
+//
+// The dns package reads the next query of a connection while the previous
+// ones are handled, each in its own goroutine, and writes every response
+// with a single Write, so pipelined queries (RFC 7766, section 6.2.1.1) are
+// answered in the order they finish. As their handlers share the
+// connection, the write deadline is set right before each response is
+// written rather than when its query arrives, which would cut short a slow
+// response once a later query's deadline has passed.
+func (s *Server) tcpLimits(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		if c, ok := w.Conn().(*net.TCPConn); ok {
+			s.touchTCP(c)
+			if s.TCPWriteTimeout > 0 {
+				w = &tcpWriter{ResponseWriter: w, conn: c, timeout: s.TCPWriteTimeout}
+			}
+		}
+		next.ServeDNS(ctx, w, r)
+	})
+}
+
+// tcpWriter applies TCPWriteTimeout to the responses written to a TCP
+// connection. dns.Msg.WriteTo calls SetWriteDeadline before writing, which
+// would otherwise apply the dns package's fixed 2 seconds.
+type tcpWriter struct {
+	dns.ResponseWriter
+	conn    *net.TCPConn
+	timeout time.Duration
+}
+
+// SetWriteDeadline implements dns.ResponseController.
+func (w *tcpWriter) SetWriteDeadline() error {
+	return w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+}
+
+// DefaultRequestTimeout is the deadline for handling a DNS request if
+// Server.RequestTimeout is zero. Resolvers give up on a server after a few
+// seconds.
+const DefaultRequestTimeout = 5 * time.Second
+
+// requestTimeout returns the deadline for handling a request.
+func (s *Server) requestTimeout() time.Duration {
+	if s.RequestTimeout > 0 {
+		return s.RequestTimeout
+	}
+	return DefaultRequestTimeout
+}
+
+// deadline returns a handler passing requests to next with a context ending
+// after the request timeout, counting those that exceed it. Contexts that
+// already have a deadline, e.g. set by an outer Server for its tenants, are
+// passed on unchanged.
+func (s *Server) deadline(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		if _, ok := ctx.Deadline(); ok {
+			next.ServeDNS(ctx, w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, s.requestTimeout())
+		defer cancel()
+		next.ServeDNS(ctx, w, r)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			s.timeouts.Add(1)
+			slog.WarnContext(ctx, "dns: request exceeded the timeout", "remote", w.RemoteAddr(), "id", r.ID, "timeout", s.requestTimeout())
+		}
+	})
+}
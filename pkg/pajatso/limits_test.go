@@ -0,0 +1,182 @@
+package pajatso
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+// startTCPTestServer serves srv on a random TCP port through TCPListener.
+func startTCPTestServer(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsServer := srv.NewDNSServer()
+	dnsServer.Net = "tcp"
+	dnsServer.Listener = srv.TCPListener(ln)
+	go dnsServer.ListenAndServe()
+	t.Cleanup(func() { dnsServer.Shutdown(context.Background()) })
+	<-srv.Ready()
+	return ln.Addr().String()
+}
+
+func TestTCPConnectionLimit(t *testing.T) {
+	addr := startTCPTestServer(t, &Server{
+		Zone:           testZone,
+		TsigName:       testTsigName,
+		TsigSecret:     testTsigSecret,
+		MaxTCPConns:    1,
+		TCPIdleTimeout: time.Minute,
+		TCPReadTimeout: time.Minute,
+		Store:          &Store{},
+	})
+
+	// Hold the only connection open without sending a query.
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection beyond the limit to be closed, got %v", err)
+	}
+
+	// Once the first connection is gone, new ones are served again.
+	first.Close()
+	time.Sleep(50 * time.Millisecond)
+	r, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "tcp", addr)
+	if err != nil {
+		t.Fatalf("query after closing the first connection failed: %v", err)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestTCPReadTimeout(t *testing.T) {
+	addr := startTCPTestServer(t, &Server{
+		Zone:           testZone,
+		TsigName:       testTsigName,
+		TsigSecret:     testTsigSecret,
+		TCPReadTimeout: 100 * time.Millisecond,
+		Store:          &Store{},
+	})
+
+	// A client that never sends a query is disconnected.
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("connection closed after %v, expected the read timeout", d)
+	}
+}
+
+func TestTCPPipelining(t *testing.T) {
+	// Queries for slow.<zone> are held until released.
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	slow := func(next dns.Handler) dns.Handler {
+		return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+			if dns.EqualName(r.Question[0].Header().Name, "slow."+testZone) {
+				started <- struct{}{}
+				<-release
+			}
+			next.ServeDNS(ctx, w, r)
+		})
+	}
+	addr := startTCPTestServer(t, &Server{
+		Zone:            testZone,
+		Store:           &Store{},
+		MaxTCPPipelined: 2,
+		TCPIdleTimeout:  time.Minute,
+		TCPWriteTimeout: time.Minute,
+		Middleware:      []Middleware{slow},
+	})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// A query behind a slow one on the same connection is answered first.
+	first := writeTCPQuery(t, c, "slow."+testZone)
+	<-started
+	second := writeTCPQuery(t, c, testChallenge)
+	if r := readTCPResponse(t, c); r.ID != second {
+		t.Fatalf("expected the response to %d first, got %d", second, r.ID)
+	}
+
+	// Queries beyond the pipelining limit of the connection are shed.
+	third := writeTCPQuery(t, c, "slow."+testZone)
+	<-started
+	fourth := writeTCPQuery(t, c, testChallenge)
+	if r := readTCPResponse(t, c); r.ID != fourth || r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL for %d, got %s for %d", fourth, dns.RcodeToString[r.Rcode], r.ID)
+	}
+
+	close(release)
+	got := map[uint16]bool{}
+	for range 2 {
+		r := readTCPResponse(t, c)
+		if r.Rcode == dns.RcodeServerFailure {
+			t.Fatalf("unexpected SERVFAIL for %d", r.ID)
+		}
+		got[r.ID] = true
+	}
+	if !got[first] || !got[third] {
+		t.Fatalf("expected the responses to %d and %d, got %v", first, third, got)
+	}
+}
+
+// writeTCPQuery sends a TXT query for name over c, returning its ID.
+func writeTCPQuery(t *testing.T, c net.Conn, name string) uint16 {
+	t.Helper()
+	m := dns.NewMsg(name, dns.TypeTXT)
+	if err := m.Pack(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(m.Data))), m.Data...)); err != nil {
+		t.Fatal(err)
+	}
+	return m.ID
+}
+
+// readTCPResponse reads the next response from c.
+func readTCPResponse(t *testing.T, c net.Conn) *dns.Msg {
+	t.Helper()
+	var l [2]byte
+	if _, err := io.ReadFull(c, l[:]); err != nil {
+		t.Fatal(err)
+	}
+	r := &dns.Msg{Data: make([]byte, binary.BigEndian.Uint16(l[:]))}
+	if _, err := io.ReadFull(c, r.Data); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
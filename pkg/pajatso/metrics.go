@@ -0,0 +1,76 @@
+package pajatso
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// metricsHandler serves the server's metrics in the Prometheus text format.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	active, bans, dropped := s.bans.stats()
+	writeMetric(w, "pajatso_banned_sources", "gauge", "Source addresses currently banned.", active)
+	writeMetric(w, "pajatso_bans_total", "counter", "Bans imposed after repeated refused updates.", bans)
+	writeMetric(w, "pajatso_banned_requests_total", "counter", "Requests dropped from banned sources.", dropped)
+	writeMetric(w, "pajatso_tokens", "gauge", "Challenge tokens currently served.", len(s.Store.Get(s.ChallengeName())))
+	writeMetric(w, "pajatso_response_pack_errors_total", "counter", "Responses not sent because they failed to pack or sign.", s.packErrors.Load())
+	writeMetric(w, "pajatso_response_write_errors_total", "counter", "Responses that failed to send.", s.writeErrors.Load())
+	s.drain.mu.Lock()
+	inFlight := s.drain.inFlight
+	s.drain.mu.Unlock()
+	writeMetric(w, "pajatso_requests_in_flight", "gauge", "DNS requests being handled.", inFlight)
+	writeMetric(w, "pajatso_requests_overloaded_total", "counter", "DNS requests shed because too many were in flight.", s.overloaded.Load())
+	writeMetric(w, "pajatso_request_panics_total", "counter", "DNS requests answered with SERVFAIL after their handling panicked.", s.panics.Load())
+	writeMetric(w, "pajatso_requests_slow_total", "counter", "DNS requests logged for exceeding the slow request threshold.", s.slow.Load())
+	writeMetric(w, "pajatso_request_timeouts_total", "counter", "DNS requests that exceeded the request timeout.", s.timeouts.Load())
+	writeMetric(w, "pajatso_notifies_total", "counter", "NOTIFY messages accepted for the monitored zones.", s.notifies.Load())
+	writeMetric(w, "pajatso_tokens_expired_total", "counter", "Challenge tokens removed after their TTL elapsed.", s.expired.Load())
+
+	s.activity.mu.Lock()
+	lastSet, lastQuery, queries := s.activity.lastSet, s.activity.lastQuery, s.activity.queries
+	s.activity.mu.Unlock()
+	writeMetric(w, "pajatso_challenge_last_set_timestamp_seconds", "gauge", "Time a challenge token was last set, 0 if never.", unixTime(lastSet))
+	writeMetric(w, "pajatso_challenge_last_query_timestamp_seconds", "gauge", "Time a challenge query was last answered with a token, 0 if never.", unixTime(lastQuery))
+	writeMetric(w, "pajatso_challenge_queries_total", "counter", "Challenge queries answered with a token.", queries)
+
+	writeListenerMetrics(w, s.listeners.usage())
+
+	if s.KeyStats != nil {
+		writeKeyMetrics(w, s.KeyStats.Usage())
+	}
+}
+
+// writeKeyMetrics writes the per-key counters of usage, labeled with the key
+// name.
+func writeKeyMetrics(w io.Writer, usage []KeyUsage) {
+	fmt.Fprint(w, "# HELP pajatso_key_queries_total Challenge queries answered for the records of a TSIG key.\n# TYPE pajatso_key_queries_total counter\n")
+	for _, u := range usage {
+		fmt.Fprintf(w, "pajatso_key_queries_total{key=%q} %d\n", u.Key, u.Queries)
+	}
+	fmt.Fprint(w, "# HELP pajatso_key_updates_total Updates signed with a TSIG key, by result.\n# TYPE pajatso_key_updates_total counter\n")
+	for _, u := range usage {
+		fmt.Fprintf(w, "pajatso_key_updates_total{key=%q,result=\"applied\"} %d\n", u.Key, u.Updates)
+		fmt.Fprintf(w, "pajatso_key_updates_total{key=%q,result=\"refused\"} %d\n", u.Key, u.Refused)
+	}
+}
+
+// writeListenerMetrics writes the per-listener counters of usage, labeled
+// with the transport and the listener address.
+func writeListenerMetrics(w io.Writer, usage []listenerUsage) {
+	fmt.Fprint(w, "# HELP pajatso_requests_total DNS requests received, by transport and listener.\n# TYPE pajatso_requests_total counter\n")
+	for _, u := range usage {
+		fmt.Fprintf(w, "pajatso_requests_total{transport=%q,listener=%q} %d\n", u.transport, u.addr, u.requests)
+	}
+	fmt.Fprint(w, "# HELP pajatso_responses_truncated_total UDP answers truncated for exceeding the payload size of the client, by listener.\n# TYPE pajatso_responses_truncated_total counter\n")
+	for _, u := range usage {
+		if u.transport == "udp" {
+			fmt.Fprintf(w, "pajatso_responses_truncated_total{transport=%q,listener=%q} %d\n", u.transport, u.addr, u.truncated)
+		}
+	}
+}
+
+// writeMetric writes a metric without labels with its HELP and TYPE lines.
+func writeMetric(w io.Writer, name, typ, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
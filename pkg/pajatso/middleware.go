@@ -0,0 +1,110 @@
+package pajatso
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+// Middleware wraps a dns.Handler with a processing stage such as logging or
+// access control. A middleware may answer a request itself instead of
+// calling next.
+type Middleware func(next dns.Handler) dns.Handler
+
+// Chain wraps h in mws, with mws[0] as the outermost stage.
+func Chain(h dns.Handler, mws ...Middleware) dns.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// msgWriter is implemented by response writers that inspect the response
+// message, such as ResponseRecorder.
+type msgWriter interface {
+	WriteMsg(m *dns.Msg) error
+}
+
+// WriteMsg packs m and sends it to w. Handlers and middlewares should write
+// responses with it rather than io.Copy, so that a ResponseRecorder further
+// out in the chain sees them: dns.Msg.WriteTo writes UDP responses directly
+// to the connection, bypassing the ResponseWriter. Responses may be reused
+// once WriteMsg returns, so writers must not keep m.
+func WriteMsg(w dns.ResponseWriter, m *dns.Msg) error {
+	if err := m.Pack(); err != nil {
+		return err
+	}
+	return writePacked(w, m)
+}
+
+// writePacked sends m, already packed, to w.
+func writePacked(w dns.ResponseWriter, m *dns.Msg) error {
+	if mw, ok := w.(msgWriter); ok {
+		return mw.WriteMsg(m)
+	}
+	_, err := m.WriteTo(w)
+	return err
+}
+
+// ResponseRecorder is a dns.ResponseWriter recording the response written
+// through it with WriteMsg, for middlewares that inspect the outcome of a
+// request.
+type ResponseRecorder struct {
+	dns.ResponseWriter
+
+	Written bool   // a response has been written
+	Rcode   uint16 // response code of the written response
+	Size    int    // size of the written response in bytes
+}
+
+// WriteMsg records m and passes it on. m is packed unless it has been
+// already, e.g. by WriteMsg or for signing.
+func (r *ResponseRecorder) WriteMsg(m *dns.Msg) error {
+	if len(m.Data) == 0 {
+		if err := m.Pack(); err != nil {
+			return err
+		}
+	}
+	r.Written = true
+	r.Rcode = m.Rcode
+	r.Size = len(m.Data)
+	return writePacked(r.ResponseWriter, m)
+}
+
+// Logging returns a middleware logging every request with its response code
+// and duration, and the transport and listener it was received on, to
+// logger.
+func Logging(logger *slog.Logger) Middleware {
+	return func(next dns.Handler) dns.Handler {
+		return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+			start := time.Now()
+			rec := &ResponseRecorder{ResponseWriter: w}
+			next.ServeDNS(ctx, rec, r)
+
+			attrs := append(requestAttrs(w, r), "duration", time.Since(start))
+			if rec.Written {
+				attrs = append(attrs, "rcode", dns.RcodeToString[rec.Rcode], "size", rec.Size)
+			}
+			logger.InfoContext(ctx, "request", attrs...)
+		})
+	}
+}
+
+// requestAttrs returns the log attributes identifying the request r answered
+// through w: the client, transport, listener, opcode and question.
+func requestAttrs(w dns.ResponseWriter, r *dns.Msg) []any {
+	attrs := []any{
+		"remote", w.RemoteAddr(),
+		"transport", Transport(w),
+		"listener", Listener(w),
+		"id", r.ID,
+		"opcode", dns.OpcodeToString[r.Opcode],
+	}
+	if len(r.Question) > 0 {
+		q := r.Question[0]
+		attrs = append(attrs, "name", q.Header().Name, "type", dns.TypeToString[dns.RRToType(q)])
+	}
+	return attrs
+}
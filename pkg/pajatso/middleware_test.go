@@ -0,0 +1,100 @@
+package pajatso
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	stage := func(name string) Middleware {
+		return func(next dns.Handler) dns.Handler {
+			return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+				order = append(order, name)
+				next.ServeDNS(ctx, w, r)
+			})
+		}
+	}
+	h := Chain(dns.HandlerFunc(func(context.Context, dns.ResponseWriter, *dns.Msg) {
+		order = append(order, "handler")
+	}), stage("outer"), stage("inner"))
+
+	h.ServeDNS(context.Background(), nil, new(dns.Msg))
+	if got := strings.Join(order, ","); got != "outer,inner,handler" {
+		t.Fatalf("unexpected order %s", got)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	// A middleware refusing all requests, like an ACL would.
+	deny := func(next dns.Handler) dns.Handler {
+		return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			dnsutil.SetReply(m, r)
+			m.Rcode = dns.RcodeRefused
+			WriteMsg(w, m)
+		})
+	}
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}, Middleware: []Middleware{deny}}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	store.Replace("token")
+
+	r := query(t, addr, testChallenge, dns.TypeTXT)
+	if r.Rcode != dns.RcodeRefused || len(r.Answer) != 0 {
+		t.Fatalf("expected REFUSED without answers, got %s with %d answers", dns.RcodeToString[r.Rcode], len(r.Answer))
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf syncBuffer
+	srv := &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		Store:      &Store{},
+		Middleware: []Middleware{Logging(slog.New(slog.NewTextHandler(&buf, nil)))},
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	query(t, addr, testChallenge, dns.TypeTXT)
+
+	// The request is logged after the response has been sent.
+	deadline := time.Now().Add(time.Second)
+	for buf.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	out := buf.String()
+	for _, want := range []string{"msg=request", "name=" + testChallenge, "type=TXT", "rcode=NOERROR", "opcode=QUERY", "transport=udp", "listener=" + addr} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("log missing %q: %s", want, out)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
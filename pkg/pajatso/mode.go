@@ -0,0 +1,89 @@
+package pajatso
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Mode is the operating mode of a Server, switched at runtime with SetMode,
+// e.g. through the control socket, for failovers and planned migrations.
+type Mode string
+
+const (
+	ModeNormal   Mode = "normal"    // queries and updates are served
+	ModeReadOnly Mode = "read-only" // queries are served, updates refused
+
+	// ModeMaintenance answers every DNS request but health record queries
+	// with SERVFAIL, so that resolvers try other servers, and refuses
+	// updates like ModeReadOnly.
+	ModeMaintenance Mode = "maintenance"
+)
+
+// errReadOnly is the error of changes refused outside ModeNormal.
+var errReadOnly = errors.New("server is read-only")
+
+// ParseMode parses the name of a Mode.
+func ParseMode(name string) (Mode, error) {
+	switch m := Mode(name); m {
+	case ModeNormal, ModeReadOnly, ModeMaintenance:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown mode %q, want %s, %s or %s", name, ModeNormal, ModeReadOnly, ModeMaintenance)
+}
+
+// Mode returns the current operating mode.
+func (s *Server) Mode() Mode {
+	if m, ok := s.mode.Load().(Mode); ok {
+		return m
+	}
+	return ModeNormal
+}
+
+// SetMode switches the operating mode to m. The records are only changed
+// through the control socket outside ModeNormal: DNS updates and the HTTP,
+// gRPC, webhook and acme-dns APIs are refused.
+func (s *Server) SetMode(m Mode) {
+	if old := s.Mode(); old != m {
+		s.mode.Store(m)
+		slog.Warn("mode changed", "zone", s.Zone, "mode", m, "previous", old)
+	}
+}
+
+// readOnly reports whether changes other than through the control socket
+// are refused.
+func (s *Server) readOnly() bool {
+	return s.Mode() != ModeNormal
+}
+
+// refuseByMode answers r if the mode of s doesn't serve it, reporting
+// whether it did: updates outside ModeNormal are REFUSED, and everything but
+// health record queries is answered with SERVFAIL in ModeMaintenance.
+func (s *Server) refuseByMode(w dns.ResponseWriter, r *dns.Msg) bool {
+	mode := s.Mode()
+	if mode == ModeNormal || mode == ModeReadOnly && r.Opcode != dns.OpcodeUpdate || s.isHealthQuery(r) {
+		return false
+	}
+	m := new(dns.Msg)
+	dnsutil.SetReply(m, r)
+	m.Rcode = dns.RcodeRefused
+	code, text := dns.ExtendedErrorProhibited, errReadOnly.Error()
+	if mode == ModeMaintenance {
+		m.Rcode = dns.RcodeServerFailure
+		code, text = dns.ExtendedErrorNotReady, "server in maintenance"
+	}
+	if r.Unpack() == nil {
+		setEDE(r, m, code, text)
+	}
+	s.writeMsg(w, m)
+	return true
+}
+
+// isHealthQuery reports whether r is a query for the health record.
+func (s *Server) isHealthQuery(r *dns.Msg) bool {
+	return s.HealthName != "" && r.Opcode == dns.OpcodeQuery && len(r.Question) == 1 &&
+		dns.EqualName(r.Question[0].Header().Name, s.healthName())
+}
@@ -0,0 +1,106 @@
+package pajatso
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestParseMode(t *testing.T) {
+	for _, name := range []string{"normal", "read-only", "maintenance"} {
+		if m, err := ParseMode(name); err != nil || string(m) != name {
+			t.Errorf("%s: got %q, %v", name, m, err)
+		}
+	}
+	if _, err := ParseMode("readonly"); err == nil {
+		t.Error("expected an unknown mode to fail")
+	}
+}
+
+func TestModes(t *testing.T) {
+	srv := &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		APIToken:   testAPIToken,
+		HealthName: "health",
+		Store:      &Store{},
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	store.Replace("token")
+	control := srv.ControlHandler()
+	api := httptest.NewServer(srv.APIHandler())
+	defer api.Close()
+
+	setMode := func(mode string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		control.ServeHTTP(rec, httptest.NewRequest("PUT", "/mode", strings.NewReader(`{"mode": "`+mode+`"}`)))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("setting mode %s: expected 204, got %d: %s", mode, rec.Code, rec.Body)
+		}
+	}
+	update := func() int {
+		rr, _ := dns.New(testChallenge + " 60 IN TXT \"updated\"")
+		return int(sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret).Rcode)
+	}
+
+	// Read-only: queries are served, updates and API writes refused.
+	setMode("read-only")
+	if r := query(t, addr, testChallenge, dns.TypeTXT); r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+		t.Fatalf("expected the token to be served, got %s with %d answers", dns.RcodeToString[r.Rcode], len(r.Answer))
+	}
+	if rcode := update(); rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED, got %s", dns.RcodeToString[uint16(rcode)])
+	}
+	resp := apiRequest(t, api, "PUT", "/zones/"+testZone+"/records/"+testChallenge, testAPIToken, `{"value": "api"}`)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("api: expected 503, got %d", resp.StatusCode)
+	}
+	if v, _ := store.Value(); v != "token" {
+		t.Fatalf("expected the record unchanged, got %q", v)
+	}
+
+	// The control socket can still change the record.
+	rec := httptest.NewRecorder()
+	control.ServeHTTP(rec, httptest.NewRequest("PUT", "/record", strings.NewReader(`{"value": "control"}`)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("control: expected 204, got %d", rec.Code)
+	}
+
+	// Maintenance: everything but the health record fails.
+	setMode("maintenance")
+	if r := query(t, addr, testChallenge, dns.TypeTXT); r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if rcode := update(); rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL for an update, got %s", dns.RcodeToString[uint16(rcode)])
+	}
+	r := query(t, addr, "health."+testZone, dns.TypeTXT)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+		t.Fatalf("expected the health record, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if txt := r.Answer[0].(*dns.TXT).Txt; txt[len(txt)-1] != "mode=maintenance" {
+		t.Fatalf("expected the mode in the health TXT, got %v", txt)
+	}
+	rec = httptest.NewRecorder()
+	control.ServeHTTP(rec, httptest.NewRequest("GET", "/mode", nil))
+	if !strings.Contains(rec.Body.String(), `"maintenance"`) {
+		t.Fatalf("unexpected mode %s", rec.Body)
+	}
+
+	setMode("normal")
+	if rcode := update(); rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR after returning to normal, got %s", dns.RcodeToString[uint16(rcode)])
+	}
+
+	rec = httptest.NewRecorder()
+	control.ServeHTTP(rec, httptest.NewRequest("PUT", "/mode", strings.NewReader(`{"mode": "off"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown mode, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,47 @@
+package pajatso
+
+import (
+	"sync"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// maxPooledMsg is the largest packed size of a response kept in msgPool, so
+// that rare large TCP answers don't pin their buffers.
+const maxPooledMsg = 4096
+
+// msgPool recycles query responses with their section slices and packing
+// buffer. CAs validate from several vantage points at once, and every
+// response would otherwise allocate them anew.
+var msgPool = sync.Pool{New: func() any { return new(dns.Msg) }}
+
+// newReply returns a reply to r from msgPool. It must be handed back with
+// putMsg once written, and not be used after that.
+func newReply(r *dns.Msg) *dns.Msg {
+	m := msgPool.Get().(*dns.Msg)
+	dnsutil.SetReply(m, r)
+	return m
+}
+
+// putMsg resets m and returns it to msgPool.
+func putMsg(m *dns.Msg) {
+	if cap(m.Data) > maxPooledMsg {
+		return
+	}
+	// Drop the references to records and the request, the slices are
+	// reused.
+	answer, ns, extra, pseudo := m.Answer, m.Ns, m.Extra, m.Pseudo
+	clear(answer)
+	clear(ns)
+	clear(extra)
+	clear(pseudo)
+	*m = dns.Msg{
+		Answer: answer[:0],
+		Ns:     ns[:0],
+		Extra:  extra[:0],
+		Pseudo: pseudo[:0],
+		Data:   m.Data[:0],
+	}
+	msgPool.Put(m)
+}
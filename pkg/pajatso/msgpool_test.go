@@ -0,0 +1,31 @@
+package pajatso
+
+import (
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestMsgPoolReset(t *testing.T) {
+	r := new(dns.Msg)
+	r.ID = 1
+	r.Question = []dns.RR{&dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassINET}}}
+
+	m := newReply(r)
+	m.Rcode = dns.RcodeNameError
+	m.UDPSize = 1232
+	m.Answer = append(m.Answer, &dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassINET}})
+	m.Ns = append(m.Ns, &dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassINET}})
+	if err := m.Pack(); err != nil {
+		t.Fatal(err)
+	}
+	putMsg(m)
+
+	// Whether or not the pool hands m out again, a reply starts out empty.
+	r.ID = 2
+	m = newReply(r)
+	defer putMsg(m)
+	if m.ID != 2 || m.Rcode != dns.RcodeSuccess || m.UDPSize != 0 || len(m.Answer) != 0 || len(m.Ns) != 0 || len(m.Data) != 0 {
+		t.Fatalf("reply not reset: %v", m)
+	}
+}
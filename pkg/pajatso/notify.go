@@ -0,0 +1,72 @@
+package pajatso
+
+import (
+	"context"
+	"log/slog"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// notifyZone reports whether NOTIFY messages are accepted for zone.
+func (s *Server) notifyZone(zone string) bool {
+	for _, z := range s.NotifyZones {
+		if dns.EqualName(z, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// forNotify reports whether r is a NOTIFY for one of NotifyZones.
+func (s *Server) forNotify(r *dns.Msg) bool {
+	return len(r.Question) == 1 && s.notifyZone(queryName(r.Question[0].Header().Name))
+}
+
+// handleNotify acknowledges a NOTIFY (RFC 1996) for one of NotifyZones and
+// reports it to OnNotify. The zone isn't transferred: the server only
+// passes the change on, e.g. to trigger revalidation elsewhere.
+func (s *Server) handleNotify(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	dnsutil.SetReply(m, r)
+
+	if len(r.Question) != 1 || r.Unpack() != nil {
+		m.Rcode = dns.RcodeFormatError
+		s.writeMsg(w, m)
+		return
+	}
+	q := r.Question[0]
+	zone := queryName(q.Header().Name)
+	switch {
+	case !s.notifyZone(zone):
+		slog.WarnContext(ctx, "notify refused: zone not monitored", "zone", zone, "remote", w.RemoteAddr())
+		m.Rcode = dns.RcodeNotAuth
+		s.writeMsg(w, m)
+		return
+	case !addrAllowed(s.NotifyAllow, w.RemoteAddr()):
+		slog.WarnContext(ctx, "notify refused: source not allowed", "zone", zone, "remote", w.RemoteAddr())
+		m.Rcode = dns.RcodeRefused
+		setEDE(r, m, dns.ExtendedErrorProhibited, "source not allowed")
+		s.writeMsg(w, m)
+		return
+	case dns.RRToType(q) != dns.TypeSOA:
+		m.Rcode = dns.RcodeNotImplemented
+		s.writeMsg(w, m)
+		return
+	}
+
+	// The new serial is optional (RFC 1996 3.7), zero if not given.
+	var serial uint32
+	for _, rr := range r.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && dns.EqualName(soa.Header().Name, zone) {
+			serial = soa.Serial
+		}
+	}
+	s.notifies.Add(1)
+	slog.InfoContext(ctx, "zone change notified", "zone", zone, "serial", serial, "remote", w.RemoteAddr())
+	m.Authoritative = true
+	s.writeMsg(w, m)
+	if s.OnNotify != nil {
+		s.OnNotify(w.RemoteAddr(), zone, serial)
+	}
+}
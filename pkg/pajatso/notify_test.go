@@ -0,0 +1,63 @@
+package pajatso
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+func sendNotify(t *testing.T, addr, zone string, serial uint32) *dns.Msg {
+	t.Helper()
+	m := dns.NewMsg(zone, dns.TypeSOA)
+	m.Opcode = dns.OpcodeNotify
+	m.Authoritative = true
+	if serial != 0 {
+		m.Answer = []dns.RR{&dns.SOA{Hdr: dns.Header{Name: zone, Class: dns.ClassINET, TTL: 3600}, SOA: rdata.SOA{Ns: "ns.example.net.", Mbox: "hostmaster.example.net.", Serial: serial}}}
+	}
+	r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+	return r
+}
+
+func TestNotify(t *testing.T) {
+	type notified struct {
+		zone   string
+		serial uint32
+	}
+	got := make(chan notified, 1)
+	srv := &Server{
+		Zone:        testZone,
+		Store:       &Store{},
+		NotifyZones: []string{"example.net."},
+		NotifyAllow: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		OnNotify: func(_ net.Addr, zone string, serial uint32) {
+			got <- notified{zone, serial}
+		},
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	r := sendNotify(t, addr, "Example.NET.", 42)
+	if r.Rcode != dns.RcodeSuccess || !r.Authoritative || r.Opcode != dns.OpcodeNotify {
+		t.Fatalf("expected an authoritative NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if n := <-got; n.zone != "example.net." || n.serial != 42 {
+		t.Fatalf("expected example.net. with serial 42, got %+v", n)
+	}
+	if r := sendNotify(t, addr, testZone, 0); r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH for a zone not monitored, got %s", dns.RcodeToString[r.Rcode])
+	}
+
+	other := &Server{Zone: testZone, Store: &Store{}, NotifyZones: []string{"example.net."}, NotifyAllow: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}
+	addr, _, cleanup = startTestServerFor(t, other)
+	defer cleanup()
+	if r := sendNotify(t, addr, "example.net.", 0); r.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED for a source not allowed, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
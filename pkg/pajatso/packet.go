@@ -0,0 +1,83 @@
+package pajatso
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Limits on requests, checked on the header before they are fully unpacked.
+// Legitimate updates change a few TXT records, and queries carry at most an
+// OPT and a TSIG record.
+const (
+	maxUpdateSize    = 16 << 10 // bytes of an update
+	maxUpdateRecords = 64       // records in the prerequisite and update sections
+	maxQueryRecords  = 8        // records in the answer, authority and additional sections of a query
+)
+
+// sectionCounts returns the numbers of records in the answer (prerequisite),
+// authority (update) and additional sections from the header of data.
+func sectionCounts(data []byte) (an, ns, ar int, ok bool) {
+	if len(data) < 12 {
+		return 0, 0, 0, false
+	}
+	an = int(binary.BigEndian.Uint16(data[6:]))
+	ns = int(binary.BigEndian.Uint16(data[8:]))
+	ar = int(binary.BigEndian.Uint16(data[10:]))
+	return an, ns, ar, true
+}
+
+// checkUpdate checks the size and record counts of the packed update r
+// before it is unpacked.
+func checkUpdate(r *dns.Msg) error {
+	if len(r.Data) > maxUpdateSize {
+		return fmt.Errorf("update of %d bytes exceeds %d", len(r.Data), maxUpdateSize)
+	}
+	an, ns, _, ok := sectionCounts(r.Data)
+	if !ok {
+		return fmt.Errorf("short message")
+	}
+	if an+ns > maxUpdateRecords {
+		return fmt.Errorf("update of %d records exceeds %d", an+ns, maxUpdateRecords)
+	}
+	return nil
+}
+
+// checkQuery checks the record counts of the packed query r before it is
+// unpacked.
+func checkQuery(r *dns.Msg) error {
+	an, ns, ar, ok := sectionCounts(r.Data)
+	if !ok {
+		return fmt.Errorf("short message")
+	}
+	if an+ns+ar > maxQueryRecords {
+		return fmt.Errorf("query with %d records exceeds %d", an+ns+ar, maxQueryRecords)
+	}
+	return nil
+}
+
+// recoverPanics returns a middleware answering requests whose handling
+// panicked with SERVFAIL, logging the stack with the request and counting
+// them, so that a crafted packet hitting a bug can't take down the server.
+func (s *Server) recoverPanics(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			s.panics.Add(1)
+			slog.ErrorContext(ctx, "dns: panic handling request", append(requestAttrs(w, r), "panic", v, "stack", string(debug.Stack()))...)
+			m := new(dns.Msg)
+			dnsutil.SetReply(m, r)
+			m.Rcode = dns.RcodeServerFailure
+			s.writeMsg(w, m)
+		}()
+		next.ServeDNS(ctx, w, r)
+	})
+}
@@ -0,0 +1,124 @@
+package pajatso
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// discardWriter is a dns.ResponseWriter dropping the responses.
+type discardWriter struct{ dns.ResponseWriter }
+
+func (discardWriter) RemoteAddr() net.Addr        { return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53} }
+func (discardWriter) Conn() net.Conn              { return nil }
+func (discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func TestUpdateLimits(t *testing.T) {
+	store := &Store{}
+	addr := startTCPTestServer(t, &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: store})
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	update := func(rrs []dns.RR) uint16 {
+		t.Helper()
+		m := makeUpdateMsg(t, testZone, rrs, testTsigName, testTsigSecret)
+		if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+			t.Fatal(err)
+		}
+		r, _, err := dns.NewClient().Exchange(context.Background(), m, "tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Rcode
+	}
+
+	var rrs []dns.RR
+	for i := range maxUpdateRecords + 1 {
+		rr, _ := dns.New(fmt.Sprintf("%s 60 IN TXT \"token-%d\"", testChallenge, i))
+		rrs = append(rrs, rr)
+	}
+	if rcode := update(rrs); rcode != dns.RcodeFormatError {
+		t.Fatalf("expected FORMERR for %d records, got %s", len(rrs), dns.RcodeToString[rcode])
+	}
+	if len(store.Entries()) != 0 {
+		t.Fatal("expected no records to be stored")
+	}
+
+	// A delete of a value longer than any that can be stored.
+	long := &dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassNONE}}
+	for range 5 {
+		long.Txt = append(long.Txt, strings.Repeat("x", 255))
+	}
+	if rcode := update([]dns.RR{long}); rcode != dns.RcodeFormatError {
+		t.Fatalf("expected FORMERR for a long TXT record, got %s", dns.RcodeToString[rcode])
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	srv := &Server{Zone: testZone, Store: &Store{}, Middleware: []Middleware{func(dns.Handler) dns.Handler {
+		return dns.HandlerFunc(func(context.Context, dns.ResponseWriter, *dns.Msg) { panic("boom") })
+	}}}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	for range 2 {
+		if r := query(t, addr, testChallenge, dns.TypeTXT); r.Rcode != dns.RcodeServerFailure {
+			t.Fatalf("expected SERVFAIL, got %s", dns.RcodeToString[r.Rcode])
+		}
+	}
+	if n := srv.panics.Load(); n != 2 {
+		t.Fatalf("expected 2 panics, got %d", n)
+	}
+}
+
+// fuzzRequest unpacks data as the dns server does before handing it to a
+// handler, and serves it with a fresh Server.
+func fuzzRequest(t *testing.T, data []byte) {
+	r := &dns.Msg{Data: data}
+	r.Options = dns.MsgOptionUnpackQuestion
+	if r.Unpack() != nil {
+		return
+	}
+	r.Options = dns.MsgOptionUnpack
+
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, NS: []string{"ns1.example.net."}, Store: &Store{}}
+	srv.Store.Replace("token")
+	srv.Handler().ServeDNS(context.Background(), discardWriter{}, r)
+}
+
+func FuzzQuery(f *testing.F) {
+	for _, qtype := range []uint16{dns.TypeTXT, dns.TypeSOA, dns.TypeNS, dns.TypeA} {
+		m := dns.NewMsg(testChallenge, qtype)
+		m.UDPSize = 1232
+		if err := m.Pack(); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(m.Data)
+	}
+	f.Fuzz(fuzzRequest)
+}
+
+func FuzzUpdate(f *testing.F) {
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	add, _ := dns.New(testChallenge + " 60 IN TXT \"token\"")
+	for _, rr := range []dns.RR{
+		add,
+		&dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassNONE}, TXT: rdata.TXT{Txt: []string{"token"}}},
+		&dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassANY}},
+	} {
+		m := new(dns.Msg)
+		m.ID, m.Opcode = dns.ID(), dns.OpcodeUpdate
+		m.Question = []dns.RR{&dns.SOA{Hdr: dns.Header{Name: testZone, Class: dns.ClassINET}}}
+		m.Ns = []dns.RR{rr}
+		m.Pseudo = []dns.RR{dns.NewTSIG(testTsigName, dns.HmacSHA512, 300)}
+		if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(m.Data)
+	}
+	f.Fuzz(fuzzRequest)
+}
@@ -0,0 +1,106 @@
+package pajatso
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Grant is an update-policy rule in the style of BIND, allowing updates
+// signed with Key to the records of Types at Name, or also below it.
+type Grant struct {
+	Key       string   // TSIG key name (FQDN)
+	Subdomain bool     // match Name and the names below it, not just Name
+	Name      string   // FQDN
+	Types     []uint16 // record types that may be updated
+}
+
+// ParsePolicy parses update-policy rules of the form
+// "grant <key> name|subdomain <fqdn> <type>...", e.g.
+// "grant acme-update. name _acme-challenge.example.com. TXT".
+func ParsePolicy(rules []string) ([]Grant, error) {
+	var grants []Grant
+	for _, rule := range rules {
+		f := strings.Fields(rule)
+		if len(f) < 5 || f[0] != "grant" || f[2] != "name" && f[2] != "subdomain" {
+			return nil, fmt.Errorf("invalid update-policy rule %q, want grant <key> name|subdomain <fqdn> <type>...", rule)
+		}
+		g := Grant{
+			Key:       dnsutil.Fqdn(strings.ToLower(f[1])),
+			Subdomain: f[2] == "subdomain",
+			Name:      dnsutil.Fqdn(strings.ToLower(f[3])),
+		}
+		if !dnsutil.IsName(g.Key) || !dnsutil.IsName(g.Name) {
+			return nil, fmt.Errorf("invalid update-policy rule %q", rule)
+		}
+		for _, t := range f[4:] {
+			rrtype, ok := dns.StringToType[strings.ToUpper(t)]
+			if !ok {
+				return nil, fmt.Errorf("update-policy rule %q: unknown record type %q", rule, t)
+			}
+			g.Types = append(g.Types, rrtype)
+		}
+		grants = append(grants, g)
+	}
+	return grants, nil
+}
+
+func (g Grant) String() string {
+	match := "name"
+	if g.Subdomain {
+		match = "subdomain"
+	}
+	types := make([]string, len(g.Types))
+	for i, t := range g.Types {
+		types[i] = dns.TypeToString[t]
+	}
+	return "grant " + g.Key + " " + match + " " + g.Name + " " + strings.Join(types, " ")
+}
+
+// allows reports whether g allows key to update the records of rrtype at
+// name.
+func (g Grant) allows(key, name string, rrtype uint16) bool {
+	if !dns.EqualName(g.Key, key) || !slices.Contains(g.Types, rrtype) {
+		return false
+	}
+	if g.Subdomain {
+		return dnsutil.IsBelow(g.Name, name)
+	}
+	return dns.EqualName(g.Name, name)
+}
+
+// granted reports whether the update policy of s allows key to update the
+// records of rrtype at name. Without an UpdatePolicy, the key of s may
+// update the challenge TXT record only, or all TXT records in the zone with
+// GenericTXT.
+func (s *Server) granted(key, name string, rrtype uint16) bool {
+	if len(s.UpdatePolicy) == 0 {
+		if !dns.EqualName(key, s.TsigName) || rrtype != dns.TypeTXT {
+			return false
+		}
+		return dns.EqualName(name, s.ChallengeName()) || s.GenericTXT && dnsutil.IsBelow(s.Zone, name)
+	}
+	for _, g := range s.UpdatePolicy {
+		if g.allows(key, name, rrtype) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPolicy validates the grants of a zone: they must be for its key and
+// names in the zone.
+func checkPolicy(grants []Grant, zone, key string) error {
+	for _, g := range grants {
+		if !dns.EqualName(g.Key, key) {
+			return fmt.Errorf("update-policy rule %q for unknown TSIG key %s", g, g.Key)
+		}
+		if !dnsutil.IsBelow(zone, g.Name) {
+			return fmt.Errorf("update-policy rule %q outside zone %s", g, zone)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package pajatso
+
+import (
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestParsePolicy(t *testing.T) {
+	grants, err := ParsePolicy([]string{
+		"grant Acme-Update name _acme-challenge.example.com TXT",
+		"grant acme-update. subdomain sub.example.com. txt A",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 2 {
+		t.Fatalf("expected 2 grants, got %d", len(grants))
+	}
+	if g := grants[0].String(); g != "grant acme-update. name _acme-challenge.example.com. TXT" {
+		t.Errorf("unexpected first grant %q", g)
+	}
+	if g := grants[1].String(); g != "grant acme-update. subdomain sub.example.com. TXT A" {
+		t.Errorf("unexpected second grant %q", g)
+	}
+
+	for _, rule := range []string{
+		"",
+		"grant acme-update. name example.com.",
+		"allow acme-update. name example.com. TXT",
+		"grant acme-update. wildcard example.com. TXT",
+		"grant acme-update. name example..com. TXT",
+		"grant acme-update. name example.com. NOTATYPE",
+	} {
+		if _, err := ParsePolicy([]string{rule}); err == nil {
+			t.Errorf("%q: expected an error", rule)
+		}
+	}
+}
+
+func TestGrantAllows(t *testing.T) {
+	grants, _ := ParsePolicy([]string{
+		"grant acme-update. name _acme-challenge.example.com. TXT",
+		"grant acme-update. subdomain sub.example.com. TXT",
+	})
+	srv := &Server{Zone: testZone, TsigName: testTsigName, UpdatePolicy: grants}
+	for _, tt := range []struct {
+		key, name string
+		rrtype    uint16
+		want      bool
+	}{
+		{"acme-update.", "_acme-challenge.example.com.", dns.TypeTXT, true},
+		{"ACME-UPDATE.", "_ACME-challenge.example.com.", dns.TypeTXT, true},
+		{"acme-update.", "_acme-challenge.example.com.", dns.TypeA, false},
+		{"other.", "_acme-challenge.example.com.", dns.TypeTXT, false},
+		{"acme-update.", "x._acme-challenge.example.com.", dns.TypeTXT, false},
+		{"acme-update.", "sub.example.com.", dns.TypeTXT, true},
+		{"acme-update.", "_acme-challenge.sub.example.com.", dns.TypeTXT, true},
+		{"acme-update.", "_acme-challenge.other.example.com.", dns.TypeTXT, false},
+	} {
+		if got := srv.granted(tt.key, tt.name, tt.rrtype); got != tt.want {
+			t.Errorf("granted(%s, %s, %s) = %v, want %v", tt.key, tt.name, dns.TypeToString[tt.rrtype], got, tt.want)
+		}
+	}
+}
+
+func TestUpdatePolicy(t *testing.T) {
+	grants, _ := ParsePolicy([]string{"grant acme-update. subdomain sub.example.com. TXT"})
+	addr, store, cleanup := startTestServerFor(t, &Server{
+		Zone:         testZone,
+		Subdomain:    testSubdomain,
+		TsigName:     testTsigName,
+		TsigSecret:   testTsigSecret,
+		UpdatePolicy: grants,
+		Store:        &Store{},
+	})
+	defer cleanup()
+
+	rr, _ := dns.New("_acme-challenge.sub.example.com. 60 IN TXT \"granted\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if val, _ := store.Value(); val != "granted" {
+		t.Fatalf("expected granted, got %q", val)
+	}
+
+	rr, _ = dns.New("_acme-challenge.example.com. 60 IN TXT \"denied\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
@@ -0,0 +1,102 @@
+package pajatso
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token bucket rate limit.
+type RateLimit struct {
+	Rate  float64 // events allowed per second, unlimited if zero
+	Burst int     // events allowed at once, max(Rate, 1) if zero
+}
+
+// tokenBucket is the bucket of one key of a rateLimiter.
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	limited int // events limited since the last allowed one
+}
+
+// rateLimiter holds token buckets by key, e.g. client address.
+type rateLimiter struct {
+	limit RateLimit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, now: time.Now, buckets: map[string]*tokenBucket{}}
+}
+
+// burst returns the capacity of the buckets.
+func (l *rateLimiter) burst() float64 {
+	if l.limit.Burst > 0 {
+		return float64(l.limit.Burst)
+	}
+	return max(l.limit.Rate, 1)
+}
+
+// allow takes a token from the bucket of key. If there is none, it returns
+// false and the number of events limited in a row, including this one. A nil
+// rateLimiter allows everything.
+func (l *rateLimiter) allow(key string) (bool, int) {
+	if l == nil || l.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst(), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst(), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = 0
+		return true, 0
+	}
+	b.limited++
+	return false, b.limited
+}
+
+// prune removes the buckets idle for long enough to have refilled
+// completely, as they would be recreated the same, at most once per second.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Second {
+		return
+	}
+	l.pruned = now
+	refill := time.Duration(float64(time.Second) * l.burst() / l.limit.Rate)
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// remoteIP returns the IP address of addr without the port.
+func remoteIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
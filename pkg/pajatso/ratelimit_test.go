@@ -0,0 +1,67 @@
+package pajatso
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(RateLimit{Rate: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	for i, want := range []bool{true, true, false, false} {
+		if ok, _ := l.allow("a"); ok != want {
+			t.Fatalf("event %d: allowed = %v, want %v", i, ok, want)
+		}
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Fatal("other key limited")
+	}
+	now = now.Add(time.Second)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("token not refilled after a second")
+	}
+
+	var unlimited *rateLimiter
+	if ok, _ := unlimited.allow("a"); !ok {
+		t.Fatal("nil limiter limited")
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	for addr, want := range map[net.Addr]string{
+		&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}:   "192.0.2.1",
+		&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}: "2001:db8::1",
+	} {
+		if got := remoteIP(addr); got != want {
+			t.Errorf("remoteIP(%v) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestUpdateRateLimit(t *testing.T) {
+	for name, srv := range map[string]*Server{
+		"key": {UpdateKeyLimit: RateLimit{Rate: 0.001, Burst: 1}},
+		"ip":  {UpdateIPLimit: RateLimit{Rate: 0.001, Burst: 1}},
+	} {
+		srv.Zone, srv.TsigName, srv.TsigSecret, srv.Store = testZone, testTsigName, testTsigSecret, &Store{}
+		addr, store, cleanup := startTestServerFor(t, srv)
+
+		rr, _ := dns.New(testChallenge + " 60 IN TXT \"first\"")
+		if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeSuccess {
+			t.Fatalf("%s: expected NOERROR, got %s", name, dns.RcodeToString[r.Rcode])
+		}
+		rr, _ = dns.New(testChallenge + " 60 IN TXT \"second\"")
+		if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeRefused {
+			t.Fatalf("%s: expected REFUSED beyond the limit, got %s", name, dns.RcodeToString[r.Rcode])
+		}
+		if val, _ := store.Value(); val != "first" {
+			t.Fatalf("%s: expected first, got %q", name, val)
+		}
+		cleanup()
+	}
+}
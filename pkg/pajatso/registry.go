@@ -0,0 +1,370 @@
+package pajatso
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Registration is a client of the acme-dns compatible registration API,
+// owning the challenge TXT record at <Subdomain>.<zone>. ACME clients CNAME
+// the _acme-challenge record of their domain to it.
+type Registration struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"passwordHash"` // bcrypt hash of the password
+	Subdomain    string   `json:"subdomain"`
+	AllowFrom    []string `json:"allowFrom,omitempty"` // networks allowed to update, all if empty
+}
+
+// errForbidden is returned by Registry.Update for invalid credentials.
+var errForbidden = errors.New("forbidden")
+
+// acmeTXT matches ACME DNS-01 challenge values: base64url SHA-256 digests.
+var acmeTXT = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// Registry holds acme-dns style registrations, so that one instance can serve
+// challenges for any number of unrelated domains. Like acme-dns, each
+// registration serves the two most recent TXT values, for a domain and its
+// wildcard. Registrations are persisted to Path, values are kept in memory.
+// It is safe for concurrent use.
+type Registry struct {
+	Path string // JSON file the registrations are saved to, none if empty
+
+	mu     sync.RWMutex
+	regs   map[string]*registration // by subdomain
+	saveMu sync.Mutex               // serializes saves
+}
+
+// registration is a Registration with its current TXT values, newest first.
+type registration struct {
+	Registration
+	allow []netip.Prefix
+	txt   []string
+}
+
+// LoadRegistry returns a Registry persisted to path, loading the
+// registrations saved there if the file exists.
+func LoadRegistry(path string) (*Registry, error) {
+	r := &Registry{Path: path}
+	if path == "" {
+		return r, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var regs []Registration
+	if err := json.Unmarshal(b, &regs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, reg := range regs {
+		if err := r.add(reg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return r, nil
+}
+
+// checkRegistration validates reg, returning its parsed AllowFrom.
+func checkRegistration(reg Registration) ([]netip.Prefix, error) {
+	if strings.Contains(reg.Subdomain, ".") || reg.Subdomain == "" {
+		return nil, fmt.Errorf("invalid subdomain %q", reg.Subdomain)
+	}
+	return ParsePrefixes(reg.AllowFrom)
+}
+
+// add adds reg to r without saving.
+func (r *Registry) add(reg Registration) error {
+	allow, err := checkRegistration(reg)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.regs == nil {
+		r.regs = map[string]*registration{}
+	}
+	r.regs[strings.ToLower(reg.Subdomain)] = &registration{Registration: reg, allow: allow}
+	return nil
+}
+
+// Register creates a registration with a random subdomain and credentials,
+// accepting updates from the networks in allowFrom (all if empty). It
+// returns the registration and its password.
+func (r *Registry) Register(allowFrom []string) (Registration, string, error) {
+	password := rand.Text()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return Registration{}, "", err
+	}
+	reg := Registration{
+		Username:     uuid(),
+		PasswordHash: string(hash),
+		Subdomain:    uuid(),
+		AllowFrom:    allowFrom,
+	}
+	if err := r.add(reg); err != nil {
+		return Registration{}, "", err
+	}
+	if err := r.save(); err != nil {
+		return Registration{}, "", err
+	}
+	return reg, password, nil
+}
+
+// Import adds existing registrations, e.g. migrated from acme-dns, whose
+// password hashes are bcrypt hashes like those of Register, and saves them.
+// Registrations of subdomains already registered are skipped. It returns
+// the number of registrations added.
+func (r *Registry) Import(regs []Registration) (int, error) {
+	var added []Registration
+	for _, reg := range regs {
+		if _, err := checkRegistration(reg); err != nil {
+			return 0, fmt.Errorf("registration %s: %w", reg.Subdomain, err)
+		}
+		if _, err := bcrypt.Cost([]byte(reg.PasswordHash)); err != nil {
+			return 0, fmt.Errorf("registration %s: password hash: %w", reg.Subdomain, err)
+		}
+		if _, ok := r.lookup(reg.Subdomain); !ok {
+			added = append(added, reg)
+		}
+	}
+	for _, reg := range added {
+		r.add(reg) // validated above
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	return len(added), r.save()
+}
+
+// Update sets txt as the newest TXT value of the registration at subdomain,
+// authenticated with the username and password of the registration.
+func (r *Registry) Update(username, password, subdomain, txt string, remote netip.Addr) error {
+	r.mu.RLock()
+	reg, ok := r.regs[strings.ToLower(subdomain)]
+	r.mu.RUnlock()
+
+	if !ok || reg.Username != username ||
+		bcrypt.CompareHashAndPassword([]byte(reg.PasswordHash), []byte(password)) != nil {
+		return errForbidden
+	}
+	if !addrAllowed(reg.allow, &net.TCPAddr{IP: remote.AsSlice()}) {
+		return errForbidden
+	}
+
+	r.mu.Lock()
+	reg.txt = append([]string{txt}, reg.txt...)[:min(len(reg.txt)+1, 2)]
+	r.mu.Unlock()
+	return nil
+}
+
+// lookup returns the TXT values of the registration at subdomain.
+func (r *Registry) lookup(subdomain string) ([]string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reg, ok := r.regs[strings.ToLower(subdomain)]
+	if !ok {
+		return nil, false
+	}
+	return reg.txt, true
+}
+
+// values returns the TXT values of the registrations that have any, by
+// subdomain.
+func (r *Registry) values() map[string][]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values := map[string][]string{}
+	for sub, reg := range r.regs {
+		if len(reg.txt) > 0 {
+			values[sub] = slices.Clone(reg.txt)
+		}
+	}
+	return values
+}
+
+// save writes the registrations to Path, replacing the file atomically.
+func (r *Registry) save() error {
+	if r.Path == "" {
+		return nil
+	}
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.mu.RLock()
+	regs := make([]Registration, 0, len(r.regs))
+	for _, reg := range r.regs {
+		regs = append(regs, reg.Registration)
+	}
+	r.mu.RUnlock()
+
+	b, err := json.MarshalIndent(regs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.Path), ".registry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.Path)
+}
+
+// uuid returns a random (version 4) UUID.
+func uuid() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// isRegistered reports whether name is the record of a registration.
+func (s *Server) isRegistered(name string) bool {
+	_, ok := s.registrySubdomain(name)
+	return ok
+}
+
+// registrySubdomain returns the registration subdomain a query for qname
+// is for, if it is a single label below the zone.
+func (s *Server) registrySubdomain(qname string) (string, bool) {
+	if s.Registry == nil {
+		return "", false
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(qname), "."+s.Zone)
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return "", false
+	}
+	if _, ok := s.Registry.lookup(label); !ok {
+		return "", false
+	}
+	return label, true
+}
+
+// RegistryHandler returns an http.Handler serving the acme-dns compatible
+// registration API of Registry, for ACME clients with acme-dns support:
+//
+//	POST /register  register, body {"allowfrom": ["192.0.2.0/24"]} (optional)
+//	POST /update    set a TXT value, body {"subdomain": "...", "txt": "..."},
+//	                authenticated with the X-Api-User and X-Api-Key headers
+//	GET  /health    the API is up
+//
+// Registration is open, as in acme-dns; restrict access to the listener if
+// needed.
+func (s *Server) RegistryHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /register", s.registryRegister)
+	mux.HandleFunc("POST /update", s.registryUpdate)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return httpRequestID(mux)
+}
+
+func (s *Server) registryRegister(w http.ResponseWriter, r *http.Request) {
+	if s.readOnly() {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{"read_only"})
+		return
+	}
+	if s.apiLimits().writeLimited(w, "too_many_requests", "", httpRemoteIP(r)) {
+		slog.WarnContext(r.Context(), "registry: register rate limited", "remote", r.RemoteAddr)
+		return
+	}
+	var body struct {
+		AllowFrom []string `json:"allowfrom"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{"malformed_json_payload"})
+			return
+		}
+	}
+	if _, err := ParsePrefixes(body.AllowFrom); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"invalid_allowfrom_cidr"})
+		return
+	}
+	reg, password, err := s.Registry.Register(body.AllowFrom)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "registry: register failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, apiError{"internal_error"})
+		return
+	}
+	slog.InfoContext(r.Context(), "registry: registered", "subdomain", reg.Subdomain, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"username":   reg.Username,
+		"password":   password,
+		"fulldomain": reg.Subdomain + "." + strings.TrimSuffix(s.Zone, "."),
+		"subdomain":  reg.Subdomain,
+		"allowfrom":  reg.AllowFrom,
+	})
+}
+
+func (s *Server) registryUpdate(w http.ResponseWriter, r *http.Request) {
+	if s.readOnly() {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{"read_only"})
+		return
+	}
+	var body struct {
+		Subdomain string `json:"subdomain"`
+		TXT       string `json:"txt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"malformed_json_payload"})
+		return
+	}
+	if !acmeTXT.MatchString(body.TXT) {
+		writeJSON(w, http.StatusBadRequest, apiError{"bad_txt"})
+		return
+	}
+	// Failed updates back off both the source and the username tried, so
+	// that neither many usernames from one source nor one username from
+	// many sources can be brute-forced.
+	limits := s.apiLimits()
+	user, ip := r.Header.Get("X-Api-User"), httpRemoteIP(r)
+	if limits.authRefused(w, "too_many_requests", "ip:"+ip, "user:"+user) {
+		slog.WarnContext(r.Context(), "registry: update refused, authentication backoff", "remote", r.RemoteAddr)
+		return
+	}
+	if limits.writeLimited(w, "too_many_requests", "user:"+user, ip) {
+		slog.WarnContext(r.Context(), "registry: update rate limited", "subdomain", body.Subdomain, "remote", r.RemoteAddr)
+		return
+	}
+	remote, _ := netip.ParseAddrPort(r.RemoteAddr)
+	err := s.Registry.Update(user, r.Header.Get("X-Api-Key"), body.Subdomain, body.TXT, remote.Addr().Unmap())
+	if err != nil {
+		backoff := limits.authFailed("ip:"+ip, "user:"+user)
+		slog.WarnContext(r.Context(), "registry: update refused", "subdomain", body.Subdomain, "remote", r.RemoteAddr, "backoff", backoff)
+		writeJSON(w, http.StatusUnauthorized, apiError{err.Error()})
+		return
+	}
+	limits.authSucceeded("ip:"+ip, "user:"+user)
+	slog.InfoContext(r.Context(), "registry: set TXT", "subdomain", body.Subdomain)
+	writeJSON(w, http.StatusOK, map[string]string{"txt": body.TXT})
+}
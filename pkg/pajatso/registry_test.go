@@ -0,0 +1,138 @@
+package pajatso
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testACMETXT returns a valid ACME challenge value made of c.
+func testACMETXT(c string) string {
+	return strings.Repeat(c, 43)
+}
+
+func TestRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, password, err := r.Register([]string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside, outside := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("198.51.100.1")
+
+	if err := r.Update(reg.Username, "wrong", reg.Subdomain, "a", inside); err == nil {
+		t.Fatal("expected an error for a wrong password")
+	}
+	if err := r.Update(reg.Username, password, reg.Subdomain, "a", outside); err == nil {
+		t.Fatal("expected an error for a source outside allowfrom")
+	}
+	for _, txt := range []string{"a", "b", "c"} {
+		if err := r.Update(reg.Username, password, reg.Subdomain, txt, inside); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if txt, _ := r.lookup(reg.Subdomain); len(txt) != 2 || txt[0] != "c" || txt[1] != "b" {
+		t.Fatalf("expected the two newest values, got %v", txt)
+	}
+
+	// Registrations survive a restart, values don't.
+	r, err = LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txt, ok := r.lookup(reg.Subdomain); !ok || len(txt) != 0 {
+		t.Fatalf("expected the registration without values, got %v, %v", txt, ok)
+	}
+	if err := r.Update(reg.Username, password, reg.Subdomain, "d", inside); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryImport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r, err := LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, _, err := r.Register(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("migrated"), bcrypt.MinCost)
+	regs := []Registration{
+		{Username: "user", PasswordHash: string(hash), Subdomain: "migrated", AllowFrom: []string{"192.0.2.0/24"}},
+		existing,
+	}
+	if n, err := r.Import(regs); err != nil || n != 1 {
+		t.Fatalf("expected 1 registration imported, got %d, %v", n, err)
+	}
+
+	// The imported password works after a restart.
+	r, err = LoadRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Update("user", "migrated", "migrated", "a", netip.MustParseAddr("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Import([]Registration{{Username: "plain", PasswordHash: "secret", Subdomain: "plain"}}); err == nil {
+		t.Fatal("expected a password that isn't a bcrypt hash to be refused")
+	}
+	if _, ok := r.lookup("plain"); ok {
+		t.Fatal("expected nothing imported after an error")
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}, Registry: &Registry{}}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	h := srv.RegistryHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/register", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register: expected 201, got %d", rec.Code)
+	}
+	var reg struct {
+		Username, Password, Fulldomain, Subdomain string
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&reg); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(key, txt string) int {
+		body := `{"subdomain": "` + reg.Subdomain + `", "txt": "` + txt + `"}`
+		req := httptest.NewRequest("POST", "/update", strings.NewReader(body))
+		req.Header.Set("X-Api-User", reg.Username)
+		req.Header.Set("X-Api-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := update(reg.Password, "short"); code != http.StatusBadRequest {
+		t.Fatalf("update with a bad value: expected 400, got %d", code)
+	}
+	if code := update("wrong", testACMETXT("a")); code != http.StatusUnauthorized {
+		t.Fatalf("update with a wrong key: expected 401, got %d", code)
+	}
+	if code := update(reg.Password, testACMETXT("a")); code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d", code)
+	}
+
+	r := query(t, addr, reg.Fulldomain+".", dns.TypeTXT)
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.TXT).Txt[0] != testACMETXT("a") {
+		t.Fatalf("expected the registration's TXT record, got %v", r.Answer)
+	}
+}
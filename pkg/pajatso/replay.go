@@ -0,0 +1,45 @@
+package pajatso
+
+import (
+	"sync"
+	"time"
+)
+
+// replayCache remembers the signatures of accepted updates until they
+// expire, so that a captured update can't be resent within the TSIG fudge
+// window, e.g. to re-publish a stale token.
+type replayCache struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	seen   map[string]time.Time // expiry by key name and MAC
+	pruned time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{now: time.Now, seen: map[string]time.Time{}}
+}
+
+// replayed records the signature mac of key, valid until expires, and
+// reports whether it was seen before.
+func (c *replayCache) replayed(key, mac string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.pruned) > time.Minute {
+		for k, until := range c.seen {
+			if now.After(until) {
+				delete(c.seen, k)
+			}
+		}
+		c.pruned = now
+	}
+
+	k := key + " " + mac
+	if until, ok := c.seen[k]; ok && !now.After(until) {
+		return true
+	}
+	c.seen[k] = expires
+	return false
+}
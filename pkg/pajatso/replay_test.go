@@ -0,0 +1,171 @@
+package pajatso
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestReplayCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newReplayCache()
+	c.now = func() time.Time { return now }
+
+	if c.replayed("key.", "aa", now.Add(time.Minute)) {
+		t.Fatal("first use reported as a replay")
+	}
+	if !c.replayed("key.", "aa", now.Add(time.Minute)) {
+		t.Fatal("second use not reported as a replay")
+	}
+	if c.replayed("other.", "aa", now.Add(time.Minute)) {
+		t.Fatal("MAC of another key reported as a replay")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if c.replayed("key.", "aa", now.Add(time.Minute)) {
+		t.Fatal("expired signature reported as a replay")
+	}
+	if len(c.seen) != 1 {
+		t.Fatalf("expected expired entries to be pruned, have %d", len(c.seen))
+	}
+}
+
+func TestUpdateReplay(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"captured\"")
+	m := makeUpdateMsg(t, testZone, []dns.RR{rr}, testTsigName, testTsigSecret)
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dns.NewClient()
+	r, _, err := c.Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	store.Clear()
+
+	// Resending the captured message is refused.
+	r, _, err = c.Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH for a replay, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("expected the replay to be ignored")
+	}
+}
+
+func TestUpdateBadTime(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+	wide := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, TsigFudge: 15 * time.Minute, Store: &Store{}}
+	wideAddr, _, wideCleanup := startTestServerFor(t, wide)
+	defer wideCleanup()
+
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	send := func(addr string, signed time.Time, fudge uint16) *dns.Msg {
+		t.Helper()
+		rr, _ := dns.New(testChallenge + " 60 IN TXT \"skewed\"")
+		m := makeUpdateMsg(t, testZone, []dns.RR{rr}, "", "")
+		m.Pseudo = []dns.RR{dns.NewTSIG(testTsigName, dns.HmacSHA512, fudge, signed.Unix())}
+		if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+			t.Fatal(err)
+		}
+		r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// A large client fudge doesn't widen the server's window.
+	r := send(addr, time.Now().Add(-10*time.Minute), 3600)
+	if r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH, got %s", dns.RcodeToString[r.Rcode])
+	}
+	tsig, ok := r.Pseudo[len(r.Pseudo)-1].(*dns.TSIG)
+	if !ok || tsig.Error != dns.RcodeBadTime || tsig.OtherLen != 6 {
+		t.Fatalf("expected a BADTIME TSIG with the server time, got %v", r.Pseudo)
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("expected the update to be ignored")
+	}
+
+	// A wider server fudge accepts skewed clients.
+	if r := send(wideAddr, time.Now().Add(-10*time.Minute), 300); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestUpdateClock(t *testing.T) {
+	clock := newFakeClock()
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Clock: clock, Store: &Store{Clock: clock, TTL: time.Hour}}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"clocked\"")
+	signed := func(at time.Time) *dns.Msg {
+		m := makeUpdateMsg(t, testZone, []dns.RR{rr}, "", "")
+		m.Pseudo = []dns.RR{dns.NewTSIG(testTsigName, dns.HmacSHA512, 300, at.Unix())}
+		if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	send := func(m *dns.Msg) *dns.Msg {
+		t.Helper()
+		r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// Signatures are checked against the server's clock, not the system's.
+	r := send(signed(time.Now()))
+	tsig, ok := r.Pseudo[len(r.Pseudo)-1].(*dns.TSIG)
+	if !ok || tsig.Error != dns.RcodeBadTime {
+		t.Fatalf("expected BADTIME, got %v", r.Pseudo)
+	}
+	if want := fmt.Sprintf("%012x", clock.Now().Unix()); tsig.OtherData != want {
+		t.Fatalf("expected the server time %s, got %s", want, tsig.OtherData)
+	}
+
+	m := signed(clock.Now())
+	if r := send(m); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if r := send(m); r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH for a replay, got %s", dns.RcodeToString[r.Rcode])
+	}
+
+	// Past the fudge window, the same signature is too old.
+	clock.Advance(301 * time.Second)
+	r = send(m)
+	if tsig, ok := r.Pseudo[len(r.Pseudo)-1].(*dns.TSIG); !ok || tsig.Error != dns.RcodeBadTime {
+		t.Fatalf("expected BADTIME, got %v", r.Pseudo)
+	}
+
+	// The token expires with the Store's clock.
+	if _, ok := store.Value(); !ok {
+		t.Fatal("expected the token to be stored")
+	}
+	clock.Advance(time.Hour)
+	if _, ok := store.Value(); ok {
+		t.Fatal("expected the token to have expired")
+	}
+}
@@ -0,0 +1,104 @@
+package pajatso
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// requestIDHeader is the HTTP header, and gRPC metadata key, carrying the ID
+// of a request, as given by the client or proxy or generated.
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request handled with ctx, empty if none.
+// Every DNS, HTTP API and gRPC request gets one, which the log records
+// written through LogHandler and the Store events of the changes made by
+// the request carry, so that the handling of a challenge can be followed
+// across the update, the validation queries and the cleanup.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID returns ctx carrying the request ID id, or a new one if id
+// isn't a valid ID.
+func withRequestID(ctx context.Context, id string) context.Context {
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// newRequestID returns a random request ID of 16 hex digits.
+func newRequestID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// validRequestID reports whether an ID given by a client may be used: up to
+// 64 letters, digits, dashes, dots and underscores, so that it can't forge
+// log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// httpRequestID returns a handler giving every request an ID, from the
+// X-Request-Id header if valid, which it returns in the same header.
+func httpRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withRequestID(r.Context(), r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, RequestID(ctx))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// grpcRequestID returns ctx with the ID of the gRPC request, from the
+// x-request-id metadata if valid.
+func grpcRequestID(ctx context.Context) context.Context {
+	var id string
+	if ids := metadata.ValueFromIncomingContext(ctx, requestIDHeader); len(ids) > 0 {
+		id = ids[0]
+	}
+	return withRequestID(ctx, id)
+}
+
+// LogHandler returns h adding the ID of the request a record is logged for,
+// see RequestID, as the "request" attribute. The handling of requests logs
+// with the context of the request.
+func LogHandler(h slog.Handler) slog.Handler {
+	return requestLogHandler{h}
+}
+
+type requestLogHandler struct {
+	slog.Handler
+}
+
+func (h requestLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestLogHandler) WithGroup(name string) slog.Handler {
+	return requestLogHandler{h.Handler.WithGroup(name)}
+}
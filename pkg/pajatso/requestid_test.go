@@ -0,0 +1,68 @@
+package pajatso
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"0123456789abcdef":      true,
+		"req-1.a_B":             true,
+		"":                      false,
+		"a b":                   false,
+		"x\nlevel=ERROR":        false,
+		strings.Repeat("a", 65): false,
+	} {
+		if got := validRequestID(id); got != want {
+			t.Errorf("%q: expected %v, got %v", id, want, got)
+		}
+	}
+	if id := newRequestID(); len(id) != 16 || !validRequestID(id) {
+		t.Errorf("invalid generated ID %q", id)
+	}
+}
+
+func TestRequestIDEvents(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, APIToken: testAPIToken, Store: &Store{}}
+	events := srv.Store.Watch(t.Context())
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"my-token\"")
+	sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret)
+	if e := <-events; e.Op != OpSet || !validRequestID(e.Request) {
+		t.Fatalf("expected a set event with a request ID, got %+v", e)
+	}
+
+	// API clients may pass their own IDs.
+	req := httptest.NewRequest("DELETE", "/zones/example.com/records/_acme-challenge", nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	req.Header.Set("X-Request-Id", "cleanup-1")
+	rec := httptest.NewRecorder()
+	srv.APIHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("X-Request-Id") != "cleanup-1" {
+		t.Fatalf("expected 204 with the request ID, got %d %q", rec.Code, rec.Header().Get("X-Request-Id"))
+	}
+	if e := <-events; e.Op != OpDelete || e.Request != "cleanup-1" {
+		t.Fatalf("expected a delete event for cleanup-1, got %+v", e)
+	}
+}
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(LogHandler(slog.NewTextHandler(&buf, nil))).With("zone", testZone)
+	logger.InfoContext(withRequestID(context.Background(), "abc"), "handled")
+	logger.Info("unrelated")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "zone=example.com. request=abc") || strings.Contains(lines[1], "request=") {
+		t.Fatalf("expected only the first record tagged, got:\n%s", buf.String())
+	}
+}
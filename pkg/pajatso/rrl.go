@@ -0,0 +1,82 @@
+package pajatso
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// RRLConfig configures response rate limiting, see RRL.
+type RRLConfig struct {
+	ResponsesPerSecond float64 // responses allowed per second and bucket, with a burst of the same size (at least one)
+	Slip               int     // answer every Slip-th limited query with TC instead of dropping it, never if zero
+	IPv4PrefixLen      int     // prefix length grouping IPv4 clients, 24 if zero
+	IPv6PrefixLen      int     // prefix length grouping IPv6 clients, 56 if zero
+}
+
+// rrl holds the buckets of the RRL middleware.
+type rrl struct {
+	cfg     RRLConfig
+	limiter *rateLimiter
+}
+
+// RRL returns a middleware implementing BIND-style response rate limiting for
+// UDP queries, so that the server can't be abused for reflection attacks with
+// spoofed source addresses. Responses are counted per client prefix and query
+// name in token buckets; queries beyond the rate are dropped, except every
+// Slip-th, which gets an empty truncated response so that legitimate clients
+// retry over TCP. TCP queries and updates are not limited.
+func RRL(cfg RRLConfig) Middleware {
+	if cfg.IPv4PrefixLen == 0 {
+		cfg.IPv4PrefixLen = 24
+	}
+	if cfg.IPv6PrefixLen == 0 {
+		cfg.IPv6PrefixLen = 56
+	}
+	l := &rrl{cfg: cfg, limiter: newRateLimiter(RateLimit{Rate: cfg.ResponsesPerSecond})}
+	return l.middleware
+}
+
+func (l *rrl) middleware(next dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		addr, udp := w.RemoteAddr().(*net.UDPAddr)
+		if !udp || r.Opcode != dns.OpcodeQuery || len(r.Question) == 0 {
+			next.ServeDNS(ctx, w, r)
+			return
+		}
+
+		allow, slip := l.allow(l.key(addr, r.Question[0].Header().Name))
+		switch {
+		case allow:
+			next.ServeDNS(ctx, w, r)
+		case slip:
+			m := new(dns.Msg)
+			dnsutil.SetReply(m, r)
+			m.Truncated = true
+			WriteMsg(w, m)
+		}
+	})
+}
+
+// key returns the bucket key of a query for name from addr.
+func (l *rrl) key(addr *net.UDPAddr, name string) string {
+	ip, _ := netip.AddrFromSlice(addr.IP)
+	ip = ip.Unmap()
+	bits := l.cfg.IPv6PrefixLen
+	if ip.Is4() {
+		bits = l.cfg.IPv4PrefixLen
+	}
+	prefix, _ := ip.Prefix(bits)
+	return prefix.String() + " " + strings.ToLower(name)
+}
+
+// allow takes a token from the bucket of key. If there is none, slip reports
+// whether to answer with TC anyway.
+func (l *rrl) allow(key string) (allow, slip bool) {
+	ok, limited := l.limiter.allow(key)
+	return ok, !ok && l.cfg.Slip > 0 && limited%l.cfg.Slip == 0
+}
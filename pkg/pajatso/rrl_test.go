@@ -0,0 +1,74 @@
+package pajatso
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestRRLBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := &rrl{
+		cfg:     RRLConfig{ResponsesPerSecond: 2, Slip: 2, IPv4PrefixLen: 24, IPv6PrefixLen: 56},
+		limiter: newRateLimiter(RateLimit{Rate: 2}),
+	}
+	l.limiter.now = func() time.Time { return now }
+
+	key := l.key(&net.UDPAddr{IP: net.ParseIP("192.0.2.1")}, "Example.COM.")
+	if other := l.key(&net.UDPAddr{IP: net.ParseIP("192.0.2.200")}, "example.com."); other != key {
+		t.Fatalf("keys differ within the prefix: %q, %q", key, other)
+	}
+	if other := l.key(&net.UDPAddr{IP: net.ParseIP("2001:db8:0:1::1")}, "example.com."); other == key {
+		t.Fatal("IPv6 client shares the IPv4 bucket")
+	}
+
+	type result struct{ allow, slip bool }
+	want := []result{{true, false}, {true, false}, {false, false}, {false, true}, {false, false}}
+	for i, w := range want {
+		if allow, slip := l.allow(key); allow != w.allow || slip != w.slip {
+			t.Fatalf("query %d: got allow=%v slip=%v, want %+v", i, allow, slip, w)
+		}
+	}
+
+	now = now.Add(time.Second)
+	if allow, _ := l.allow(key); !allow {
+		t.Fatal("bucket not refilled after a second")
+	}
+}
+
+func TestRRLMiddleware(t *testing.T) {
+	srv := &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		Store:      &Store{},
+		Middleware: []Middleware{RRL(RRLConfig{ResponsesPerSecond: 1, Slip: 1})},
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	store.Replace("token")
+
+	r := query(t, addr, testChallenge, dns.TypeTXT)
+	if r.Truncated || len(r.Answer) != 1 {
+		t.Fatalf("expected an answer, got TC=%v with %d records", r.Truncated, len(r.Answer))
+	}
+	r = query(t, addr, testChallenge, dns.TypeTXT)
+	if !r.Truncated || len(r.Answer) != 0 {
+		t.Fatalf("expected a slipped TC response, got TC=%v with %d records", r.Truncated, len(r.Answer))
+	}
+
+	// TCP queries are not limited.
+	tcpAddr := startTCPTestServer(t, srv)
+	for range 3 {
+		m, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "tcp", tcpAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Answer) != 1 {
+			t.Fatalf("expected an answer over TCP, got %d records", len(m.Answer))
+		}
+	}
+}
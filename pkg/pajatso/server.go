@@ -0,0 +1,828 @@
+// Package pajatso implements a minimal authoritative DNS server answering
+// ACME DNS-01 challenges. It serves a single _acme-challenge TXT record from
+// a Store, set through TSIG-authenticated RFC 2136 updates or one of the
+// HTTP, gRPC and cert-manager webhook APIs, so it can be embedded in other
+// Go programs instead of running the dns-pajatso binary.
+package pajatso
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// Server is a DNS server that serves _acme-challenge TXT records
+// and accepts RFC 2136 dynamic updates authenticated with TSIG.
+type Server struct {
+	Zone       string // FQDN of the zone, e.g. "example.com."
+	Subdomain  string // optional subdomain prefix, e.g. "sub" for "_acme-challenge.sub.example.com."
+	TsigName   string // TSIG key name, e.g. "acme-update."
+	TsigSecret string // Base64-encoded HMAC secret
+	TsigAlg    string // TSIG algorithm, e.g. dns.HmacSHA256, defaults to dns.HmacSHA512
+	APIToken   string // bearer token for the HTTP API, see APIHandler
+	HealthName string // optional label answering health TXT queries, e.g. "health" for "health.example.com."
+
+	// APIJWT, if set, accepts JWTs of an OpenID Connect issuer as bearer
+	// tokens of the HTTP API, next to APIToken.
+	APIJWT *JWTAuth
+
+	// APIKeys are further bearer tokens of the HTTP API, each limited to
+	// the records and operations of its scope, see ParseAPIKeys.
+	APIKeys []APIKey
+
+	// APILimits throttles writes and failed authentications on the HTTP
+	// API and the acme-dns registration API.
+	APILimits APILimits
+
+	// TsigPreviousSecret, if set, is the base64-encoded secret being rotated
+	// out: it is accepted next to TsigSecret so that clients can be migrated
+	// gradually, and dropped once none use it.
+	TsigPreviousSecret string
+
+	// StrictTokens, if set, only accepts ACME challenge digests (43
+	// base64url characters) as challenge values, so that a leaked key can't
+	// publish arbitrary TXT content, e.g. SPF records. The control API is
+	// exempt.
+	StrictTokens bool
+
+	// MaxTokenLength is the maximum length of a challenge value in bytes,
+	// DefaultMaxTokenLength if zero. Longer values and values with control
+	// characters are rejected.
+	MaxTokenLength int
+
+	// TsigFudge is the allowed clock skew of signed updates, 5 minutes if
+	// zero. Updates signed outside of it get BADTIME with the server's time,
+	// whatever fudge the client asked for.
+	TsigFudge time.Duration
+
+	// Clock is the time TSIG signatures are checked and made against, the
+	// system clock if nil. The Store has a Clock of its own.
+	Clock Clock
+
+	Chaos    bool   // answer CHAOS class identity and version queries
+	Identity string // instance identity for id.server. and hostname.bind.
+	Version  string // version string for version.server. and version.bind.
+	NSID     bool   // return Identity as the NSID (RFC 5001) when requested
+
+	// CAA records served at the zone apex, so that CAs checking CAA for the
+	// challenge zone see the intended policy.
+	CAA []rdata.CAA
+
+	// NS lists the name servers (FQDNs) of the zone. If set, the zone apex
+	// answers SOA and NS queries, and empty answers carry the SOA.
+	NS         []string
+	Hostmaster string // SOA RNAME, e.g. "hostmaster.example.com.", defaults to hostmaster.<zone>
+
+	// NSAddrs are the addresses of the primary name server NS[0] if it is
+	// inside the zone, served for it and as glue with NS answers.
+	NSAddrs []netip.Addr
+
+	// AuthorityNS adds the NS records of the zone, and their glue, to the
+	// authority section of positive answers, as some strict resolvers and
+	// DNS checkers expect from authoritative servers. It requires NS.
+	AuthorityNS bool
+
+	// NXDomain answers queries for names in the zone that have no records,
+	// and no records below them, with NXDOMAIN rather than an empty answer
+	// (NODATA). The challenge name is never answered with NXDOMAIN.
+	NXDomain bool
+
+	// NegativeTTL is the SOA minimum, how long resolvers cache negative
+	// answers, DefaultNegativeTTL if zero.
+	NegativeTTL time.Duration
+
+	// Static records served alongside the challenge record, e.g. from
+	// ParseZoneFile.
+	Static []dns.RR
+
+	// CNAME maps names below the zone (FQDNs) to CNAME targets, e.g. to
+	// point names of a dedicated challenge domain at registrations. Targets
+	// in the zone are followed when answering.
+	CNAME map[string]string
+
+	// Registry, if set, serves the TXT records of acme-dns style
+	// registrations at <subdomain>.<zone>, see RegistryHandler.
+	Registry *Registry
+
+	// TCP limits enforced on listeners wrapped with TCPListener. Zero values
+	// mean no limits and the dns package's default timeouts.
+	MaxTCPConns     int           // maximum concurrent TCP connections
+	MaxTCPPipelined int           // maximum queries handled at once on a connection, see tcpLimits
+	TCPIdleTimeout  time.Duration // idle time allowed between queries on a connection
+	TCPReadTimeout  time.Duration // time allowed for the first query on a connection
+	TCPWriteTimeout time.Duration // time allowed for writing a response
+
+	// MaxRequests limits the DNS requests handled at once by the servers
+	// created by NewDNSServer, zero means unlimited. Beyond it, UDP
+	// requests are dropped and TCP requests answered with SERVFAIL, so that
+	// a flood can't exhaust the memory of small instances.
+	MaxRequests int
+
+	// RequestTimeout is the deadline of the context passed down with every
+	// DNS request, DefaultRequestTimeout if zero. It bounds calls to
+	// upstream servers, so that a stuck one can't pile up handlers.
+	RequestTimeout time.Duration
+
+	// Update rate limits, beyond which updates are refused. Zero values
+	// disable them.
+	UpdateKeyLimit RateLimit // per TSIG key
+	UpdateIPLimit  RateLimit // per source address
+
+	// Networks allowed to send updates, in addition to authenticating with
+	// TSIG: UpdateAllow for all updates, UpdateAllowKey for the updates
+	// signed with a key, by key name (FQDN). Empty lists allow all addresses.
+	UpdateAllow    []netip.Prefix
+	UpdateAllowKey map[string][]netip.Prefix
+
+	// UpdatePolicy restricts the records updates signed with TsigName may
+	// change, see ParsePolicy. If empty, only the challenge TXT record may be
+	// updated.
+	UpdatePolicy []Grant
+
+	// GenericTXT, if set, lets updates publish TXT records at any name in
+	// the zone the update policy grants, not just the challenge record,
+	// e.g. for the domain verification of other providers. Names with
+	// CNAME records, the health record and registrations are excluded, and
+	// StrictTokens only applies to the challenge record.
+	GenericTXT bool
+
+	// NotifyZones are the zones (FQDNs) NOTIFY messages (RFC 1996) are
+	// accepted for, from the networks of NotifyAllow, all if empty: e.g. the
+	// parent zone, with the server listed as a stealth secondary so that its
+	// primary notifies it of changes. Accepted messages are reported to
+	// OnNotify; the zones aren't transferred. Other NOTIFY messages are
+	// refused.
+	NotifyZones []string
+	NotifyAllow []netip.Prefix
+
+	// Ban drops all requests from source addresses with repeated refused
+	// updates for a while, disabled if Ban.Threshold is zero.
+	Ban BanConfig
+
+	// SlowRequestThreshold, if positive, logs the requests taking at least
+	// this long to be handled, with the time spent in the Store and
+	// verifying TSIG, to find the causes of tail latencies.
+	SlowRequestThreshold time.Duration
+
+	// UDPSize is the EDNS0 UDP payload size advertised in responses and the
+	// limit for UDP responses, DefaultUDPSize if zero.
+	UDPSize uint16
+
+	Store *Store
+
+	// KeyStats counts the queries and updates of TsigName, created when
+	// serving if nil. Servers may share one to report their keys together.
+	KeyStats *KeyStats
+
+	// OnChallengeQuery, if set, is called after a challenge TXT query has
+	// been answered with a token.
+	OnChallengeQuery func(remote net.Addr)
+
+	// OnUpdateRefused, if set, is called when an update is rejected, with
+	// the context of the request, carrying its RequestID, and the reason
+	// logged for it.
+	OnUpdateRefused func(ctx context.Context, remote net.Addr, reason string)
+
+	// OnNotify, if set, is called for every accepted NOTIFY with the zone
+	// and the SOA serial it carried, zero if none.
+	OnNotify func(remote net.Addr, zone string, serial uint32)
+
+	// Middleware wraps the handling of every DNS request, with Middleware[0]
+	// as the outermost stage. It must be set before NewDNSServer or Handler.
+	Middleware []Middleware
+
+	tsigSigner   atomic.Pointer[dns.HmacTSIG] // initialized in NewDNSServer, replaced by SetTsigSecret
+	tsigPrevious atomic.Pointer[dns.HmacTSIG] // nil without a previous secret
+
+	started      time.Time    // set by the first NewDNSServer call
+	dnsServers   atomic.Int32 // number of DNS servers created by NewDNSServer
+	dnsListening atomic.Int32 // number of those currently serving
+	tcp          tcpConns     // connections accepted through TCPListener
+	bound        boundAddrs   // addresses of the serving DNS servers
+	drain        drainState   // requests in flight, for Shutdown
+	mode         atomic.Value // Mode, see SetMode
+
+	updateKeyLimiter *rateLimiter // initialized in NewDNSServer
+	updateIPLimiter  *rateLimiter
+	bans             *banList
+	replays          *replayCache
+	unsubscribe      func()        // cancels the subscription of storeEvent, see Close
+	apiLimiters      apiLimiters   // see apiLimits
+	expired          atomic.Uint64 // tokens removed by the Store's expiry
+	activity         activity      // when challenge values were set and queried
+	packErrors       atomic.Uint64 // responses that failed to pack or sign
+	writeErrors      atomic.Uint64 // responses that failed to send
+	timeouts         atomic.Uint64 // requests that exceeded RequestTimeout
+	overloaded       atomic.Uint64 // requests shed beyond MaxRequests
+	panics           atomic.Uint64 // requests whose handling panicked
+	notifies         atomic.Uint64 // NOTIFY messages accepted
+	listeners        listenerStats // requests by transport and listener
+	slow             atomic.Uint64 // requests logged as slow
+
+	updateMu sync.Mutex // serializes updates, see handleUpdate
+
+	zoneOnce sync.Once
+	zone     *zoneRecords               // built by zoneRecords
+	soaCache atomic.Pointer[soaRecords] // SOA records for the current Store serial
+}
+
+// ChallengeName returns the FQDN for the _acme-challenge record.
+func (s *Server) ChallengeName() string {
+	if s.Subdomain != "" {
+		return "_acme-challenge." + s.Subdomain + "." + s.Zone
+	}
+	return "_acme-challenge." + s.Zone
+}
+
+// tsigAlgorithm returns the configured TSIG algorithm.
+func (s *Server) tsigAlgorithm() string {
+	if s.TsigAlg == "" {
+		return dns.HmacSHA512
+	}
+	return s.TsigAlg
+}
+
+// tsigFudge returns the allowed clock skew of signed updates.
+func (s *Server) tsigFudge() time.Duration {
+	if s.TsigFudge == 0 {
+		return 300 * time.Second
+	}
+	return s.TsigFudge
+}
+
+// healthName returns the FQDN of the health record, or "" if disabled.
+func (s *Server) healthName() string {
+	if s.HealthName == "" {
+		return ""
+	}
+	return s.HealthName + "." + s.Zone
+}
+
+// isChallengeName reports whether name refers to the challenge record, either
+// as an FQDN (with or without the trailing dot) or relative to the zone.
+func (s *Server) isChallengeName(name string) bool {
+	name = strings.TrimRight(asciiName(name), ".")
+	return dns.EqualName(name+".", s.ChallengeName()) || dns.EqualName(name+"."+s.Zone, s.ChallengeName())
+}
+
+// writeMsg packs and sends a DNS message to w.
+func (s *Server) writeMsg(w dns.ResponseWriter, m *dns.Msg) {
+	if err := m.Pack(); err != nil {
+		s.writeFailed(w, m, "pack", err)
+		return
+	}
+	s.writePacked(w, m)
+}
+
+// writePacked sends m, already packed or signed, to w.
+func (s *Server) writePacked(w dns.ResponseWriter, m *dns.Msg) {
+	if err := writePacked(w, m); err != nil {
+		s.writeFailed(w, m, "write", err)
+	}
+}
+
+// writeFailed logs and counts a response that couldn't be sent at stage,
+// "pack", "sign" or "write". The client will retry or time out.
+func (s *Server) writeFailed(w dns.ResponseWriter, m *dns.Msg, stage string, err error) {
+	if stage == "write" {
+		s.writeErrors.Add(1)
+	} else {
+		s.packErrors.Add(1)
+	}
+	slog.Warn("response not sent", "stage", stage, "remote", w.RemoteAddr(), "id", m.ID, "rcode", dns.RcodeToString[m.Rcode], "err", err)
+}
+
+// writeSigned TSIG-signs a response with key using the request MAC, then
+// sends it.
+func (s *Server) writeSigned(w dns.ResponseWriter, m *dns.Msg, key dns.HmacTSIG, requestMAC string) {
+	m.Pseudo = []dns.RR{dns.NewTSIG(s.TsigName, s.tsigAlgorithm(), uint16(s.tsigFudge().Seconds()), s.now().Unix())}
+	s.signAndWrite(w, m, key, requestMAC)
+}
+
+// signAndWrite signs m, with its TSIG record set up, with key and sends it.
+// TSIGSign packs m, which is not packed again.
+func (s *Server) signAndWrite(w dns.ResponseWriter, m *dns.Msg, key dns.HmacTSIG, requestMAC string) {
+	m.Data = nil
+	if err := dns.TSIGSign(m, key, &dns.TSIGOption{RequestMAC: requestMAC}); err != nil {
+		s.writeFailed(w, m, "sign", err)
+		return
+	}
+	s.writePacked(w, m)
+}
+
+// writeBadTime responds to the update signed with t outside the fudge
+// window with a BADTIME error carrying the server's time (RFC 8945 5.2.3),
+// so that clients can tell their clock is off.
+func (s *Server) writeBadTime(w dns.ResponseWriter, m *dns.Msg, key dns.HmacTSIG, t *dns.TSIG) {
+	m.Rcode = dns.RcodeNotAuth
+	bt := dns.NewTSIG(s.TsigName, s.tsigAlgorithm(), uint16(s.tsigFudge().Seconds()), int64(t.TimeSigned))
+	bt.Error = dns.RcodeBadTime
+	bt.OtherLen = 6
+	bt.OtherData = fmt.Sprintf("%012x", s.now().Unix())
+	m.Pseudo = []dns.RR{bt}
+	s.signAndWrite(w, m, key, t.MAC)
+}
+
+// ServeDNS handles DNS queries, RFC 2136 updates and NOTIFY messages.
+func (s *Server) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+	if s.refuseByMode(w, r) {
+		return
+	}
+	switch r.Opcode {
+	case dns.OpcodeUpdate:
+		s.handleUpdate(ctx, w, r)
+		return
+	case dns.OpcodeNotify:
+		s.handleNotify(ctx, w, r)
+		return
+	}
+
+	s.handleQuery(ctx, w, r)
+}
+
+// handleQuery responds to TXT queries for the _acme-challenge record.
+func (s *Server) handleQuery(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+	m := newReply(r)
+	defer putMsg(m)
+
+	// The server framework only unpacks header+question. Unpack the rest for
+	// the EDNS0 payload size.
+	if len(r.Question) == 0 || checkQuery(r) != nil || r.Unpack() != nil {
+		m.Rcode = dns.RcodeFormatError
+		s.writeMsg(w, m)
+		return
+	}
+
+	q := r.Question[0]
+	qname := queryName(q.Header().Name)
+	qtype := dns.RRToType(q)
+
+	if q.Header().Class == dns.ClassCHAOS {
+		s.answerChaos(m, qname, qtype)
+		matchQueryCase(m, q.Header().Name)
+		s.writeAnswer(w, r, m)
+		return
+	}
+
+	// Follow CNAME records within the zone.
+	name := qname
+	for range maxCNAMEChain {
+		cname := s.cname(name)
+		if cname == nil {
+			break
+		}
+		m.Answer = append(m.Answer, cname)
+		name = cname.Target
+		if qtype == dns.TypeCNAME || !dnsutil.IsBelow(s.Zone, name) {
+			name = ""
+			break
+		}
+	}
+	if name != "" {
+		start := time.Now()
+		s.answerName(ctx, w, m, name, qtype)
+		timing := timingFrom(ctx)
+		timing.storeSince(start)
+	}
+
+	s.addNegativeSOA(m, qname)
+	s.addAuthorityNS(m, qname)
+	matchQueryCase(m, q.Header().Name)
+	s.writeAnswer(w, r, m)
+}
+
+// matchQueryCase gives the records of m owned by the query name the exact
+// case of the question, as resolvers randomizing it (0x20 encoding) may
+// discard responses that don't echo it. Records are copied before, as some
+// are shared between responses.
+func matchQueryCase(m *dns.Msg, qname string) {
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		for i, rr := range section {
+			if name := rr.Header().Name; name != qname && dns.EqualName(name, qname) {
+				rr = rr.Clone()
+				rr.Header().Name = qname
+				section[i] = rr
+			}
+		}
+	}
+}
+
+// challengeTTL is the TTL of challenge TXT records, short so that new tokens
+// are seen quickly.
+const challengeTTL = 60
+
+// tokenTTL returns the TTL to answer the token e with: challengeTTL, or the
+// time left until it expires if shorter, so that resolvers don't cache it
+// past its lifetime.
+func tokenTTL(e Entry, now time.Time) uint32 {
+	if e.Expires.IsZero() {
+		return challengeTTL
+	}
+	return uint32(min(challengeTTL, max(0, e.Expires.Sub(now).Seconds())))
+}
+
+// answerName adds the records at name matching qtype to m.
+func (s *Server) answerName(ctx context.Context, w dns.ResponseWriter, m *dns.Msg, name string, qtype uint16) {
+	if dns.EqualName(name, s.ChallengeName()) && (qtype == dns.TypeTXT || qtype == dns.TypeANY) {
+		if entries := s.Store.Get(s.ChallengeName()); len(entries) > 0 {
+			for _, e := range entries {
+				m.Answer = append(m.Answer, &dns.TXT{
+					Hdr: dns.Header{
+						Name:  s.ChallengeName(),
+						Class: dns.ClassINET,
+						TTL:   tokenTTL(e, s.Store.now()),
+					},
+					TXT: rdata.TXT{
+						Txt: txtStrings(e.Value),
+					},
+				})
+			}
+			slog.InfoContext(ctx, "query: served _acme-challenge TXT", "values", len(entries))
+			s.recordQuery(entries, w.RemoteAddr())
+			if s.TsigName != "" {
+				s.KeyStats.countQuery(s.TsigName)
+			}
+			if s.OnChallengeQuery != nil {
+				s.OnChallengeQuery(w.RemoteAddr())
+			}
+		} else {
+			slog.WarnContext(ctx, "query: _acme-challenge TXT requested but no value set")
+		}
+	}
+
+	if s.genericName(name) && (qtype == dns.TypeTXT || qtype == dns.TypeANY) {
+		now := s.Store.now()
+		for _, e := range s.Store.Get(name) {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.Header{Name: name, Class: dns.ClassINET, TTL: tokenTTL(e, now)},
+				TXT: rdata.TXT{Txt: txtStrings(e.Value)},
+			})
+		}
+	}
+
+	if sub, ok := s.registrySubdomain(name); ok && (qtype == dns.TypeTXT || qtype == dns.TypeANY) {
+		txt, _ := s.Registry.lookup(sub)
+		for _, val := range txt {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.Header{Name: name, Class: dns.ClassINET, TTL: challengeTTL},
+				TXT: rdata.TXT{Txt: []string{val}},
+			})
+		}
+	}
+
+	if dns.EqualName(name, s.Zone) && (qtype == dns.TypeCAA || qtype == dns.TypeANY) {
+		s.answerCAA(m)
+	}
+	s.answerZone(m, name, qtype)
+
+	if s.HealthName != "" && dns.EqualName(name, s.healthName()) && (qtype == dns.TypeTXT || qtype == dns.TypeANY) {
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.Header{
+				Name:  s.healthName(),
+				Class: dns.ClassINET,
+				TTL:   0, // never cache health answers
+			},
+			TXT: rdata.TXT{
+				Txt: []string{
+					"ok",
+					fmt.Sprintf("uptime=%ds", int(time.Since(s.started).Seconds())),
+					fmt.Sprintf("serial=%d", s.Store.Serial()),
+				},
+			},
+		})
+		if mode := s.Mode(); mode != ModeNormal {
+			txt := m.Answer[len(m.Answer)-1].(*dns.TXT)
+			txt.Txt = append(txt.Txt, "mode="+string(mode))
+		}
+	}
+}
+
+// handleUpdate processes RFC 2136 dynamic update requests.
+func (s *Server) handleUpdate(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	dnsutil.SetReply(m, r)
+
+	// Bound the work spent on crafted updates before unpacking them.
+	if err := checkUpdate(r); err != nil {
+		m.Rcode = dns.RcodeFormatError
+		s.refused(ctx, w, "malformed update", "err", err)
+		s.writeMsg(w, m)
+		return
+	}
+
+	// The server framework only unpacks header+question. Fully unpack the rest.
+	if err := r.Unpack(); err != nil {
+		m.Rcode = dns.RcodeFormatError
+		s.refused(ctx, w, "format error")
+		s.writeMsg(w, m)
+		return
+	}
+
+	// Only accept updates from allowed networks, even with a valid TSIG.
+	if !addrAllowed(s.UpdateAllow, w.RemoteAddr()) {
+		s.refuse(ctx, w, r, m, dns.RcodeRefused, "source address not allowed", "remote", w.RemoteAddr())
+		return
+	}
+
+	// Throttle clients stuck in retry loops.
+	if ok, _ := s.updateIPLimiter.allow(remoteIP(w.RemoteAddr())); !ok {
+		s.refuse(ctx, w, r, m, dns.RcodeRefused, "rate limited", "remote", w.RemoteAddr())
+		return
+	}
+
+	// Verify TSIG authentication.
+	t := hasTSIG(r)
+	if t == nil {
+		s.refuse(ctx, w, r, m, dns.RcodeRefused, "missing TSIG record")
+		return
+	}
+
+	// Verify the TSIG key name matches.
+	if !dns.EqualName(t.Hdr.Name, s.TsigName) {
+		s.refuse(ctx, w, r, m, dns.RcodeNotAuth, "wrong TSIG key name", "name", t.Hdr.Name, "expected", s.TsigName)
+		return
+	}
+
+	// Count the outcome for the key from here on, unknown key names aren't.
+	applied := false
+	defer func() { s.KeyStats.countUpdate(s.TsigName, applied) }()
+
+	// Verify the TSIG algorithm matches.
+	if !dns.EqualName(t.Algorithm, s.tsigAlgorithm()) {
+		s.refuse(ctx, w, r, m, dns.RcodeNotAuth, "wrong TSIG algorithm", "algorithm", t.Algorithm, "expected", s.tsigAlgorithm())
+		return
+	}
+	canonicalAlgorithm(t)
+
+	// Verify the TSIG MAC and time.
+	timing := timingFrom(ctx)
+	start := time.Now()
+	key, err := s.verifyTSIG(r, t)
+	timing.tsigSince(start)
+	if errors.Is(err, dns.ErrTime) {
+		skew := s.now().Sub(time.Unix(int64(t.TimeSigned), 0)).Round(time.Second)
+		s.refused(ctx, w, "TSIG time outside the fudge window", "skew", skew, "fudge", s.tsigFudge())
+		s.writeBadTime(w, m, key, t)
+		return
+	} else if err != nil {
+		s.refuse(ctx, w, r, m, dns.RcodeNotAuth, "TSIG authentication failed")
+		return
+	}
+	if s.replays.replayed(s.TsigName, t.MAC, time.Unix(int64(t.TimeSigned), 0).Add(s.tsigFudge())) {
+		s.refuse(ctx, w, r, m, dns.RcodeNotAuth, "replayed TSIG signature")
+		return
+	}
+	if !addrAllowed(s.UpdateAllowKey[s.TsigName], w.RemoteAddr()) {
+		m.Rcode = dns.RcodeRefused
+		s.refused(ctx, w, "source address not allowed for key", "remote", w.RemoteAddr(), "key", s.TsigName)
+		s.writeSigned(w, m, key, t.MAC)
+		return
+	}
+	if ok, _ := s.updateKeyLimiter.allow(s.TsigName); !ok {
+		m.Rcode = dns.RcodeRefused
+		s.refused(ctx, w, "rate limited", "key", s.TsigName)
+		s.writeSigned(w, m, key, t.MAC)
+		return
+	}
+
+	// Check the zone, prerequisite and update sections before changing
+	// anything, answering with the RFC 2136 rcode of the first failure.
+	if e := s.checkZone(r); e != nil {
+		s.rejectUpdate(ctx, w, m, key, t, e)
+		return
+	}
+
+	// Serialize updates from checking their prerequisites until they are
+	// applied, so that concurrent updates can't both pass the same
+	// prerequisites (RFC 2136 3.7). The response is sent after unlocking.
+	s.updateMu.Lock()
+	start = time.Now()
+	e := s.checkPrereqs(r.Answer)
+	timing.storeSince(start)
+	if e != nil {
+		s.updateMu.Unlock()
+		// Unmet prerequisites are an expected outcome, not abuse.
+		m.Rcode = e.rcode
+		slog.InfoContext(ctx, "update: "+e.reason, e.args...)
+		s.writeSigned(w, m, key, t.MAC)
+		return
+	}
+	if e := s.prescan(r.Ns); e != nil {
+		s.updateMu.Unlock()
+		s.rejectUpdate(ctx, w, m, key, t, e)
+		return
+	}
+	// Don't change the Store for a client that has given up: it would
+	// retry the update, or clean up after assuming it failed.
+	if err := ctx.Err(); err != nil {
+		s.updateMu.Unlock()
+		m.Rcode = dns.RcodeServerFailure
+		slog.WarnContext(ctx, "update: not applied", "key", s.TsigName, "err", err)
+		s.writeSigned(w, m, key, t.MAC)
+		return
+	}
+	start = time.Now()
+	s.applyUpdates(ctx, r.Ns)
+	s.updateMu.Unlock()
+	timing.storeSince(start)
+	applied = true
+
+	// Success.
+	m.Rcode = dns.RcodeSuccess
+	s.writeSigned(w, m, key, t.MAC)
+}
+
+// errNoSecret is returned by verifyTSIG if the Server has no valid secret.
+var errNoSecret = errors.New("no valid TSIG secret")
+
+// verifyTSIG verifies the TSIG t of r with the current secret, or the
+// previous one during a rotation, and returns the key that matched, with
+// which the response must be signed. Signatures made outside the fudge
+// window of s, rather than the one chosen by the client, fail with
+// dns.ErrTime, still returning the key.
+func (s *Server) verifyTSIG(r *dns.Msg, t *dns.TSIG) (dns.HmacTSIG, error) {
+	signer, prev := s.tsigSigner.Load(), s.tsigPrevious.Load()
+	if signer == nil {
+		return dns.HmacTSIG{}, errNoSecret
+	}
+	key := *signer
+	// TSIGVerify strips the TSIG record from r.Data, keep it for a retry.
+	var data []byte
+	if prev != nil {
+		data = slices.Clone(r.Data)
+	}
+	err := s.verifyWith(r, t, key)
+	if err == nil || errors.Is(err, dns.ErrTime) {
+		slog.Debug("update: TSIG verified", "key", s.TsigName, "secret", "current")
+		return key, err
+	}
+	if prev == nil {
+		return dns.HmacTSIG{}, err
+	}
+	r.Data = data
+	if err := s.verifyWith(r, t, *prev); err == nil || errors.Is(err, dns.ErrTime) {
+		slog.Info("update: TSIG verified", "key", s.TsigName, "secret", "previous")
+		return *prev, err
+	}
+	return dns.HmacTSIG{}, err
+}
+
+// verifyWith verifies the TSIG t of r with key and the fudge window of s.
+func (s *Server) verifyWith(r *dns.Msg, t *dns.TSIG, key dns.HmacTSIG) error {
+	// TSIGVerify checks the MAC before the time, so ErrTime means a valid
+	// signature outside the client's fudge.
+	if err := dns.TSIGVerify(r, key, &dns.TSIGOption{}); err != nil && !errors.Is(err, dns.ErrTime) {
+		return err
+	}
+	if s.now().Sub(time.Unix(int64(t.TimeSigned), 0)).Abs() > s.tsigFudge() {
+		return dns.ErrTime
+	}
+	return nil
+}
+
+// refuse responds to an update rejected before it could be authenticated
+// with rcode and the reason as a "Prohibited" extended DNS error, see refused.
+func (s *Server) refuse(ctx context.Context, w dns.ResponseWriter, r, m *dns.Msg, rcode uint16, reason string, args ...any) {
+	m.Rcode = rcode
+	s.refused(ctx, w, reason, args...)
+	setEDE(r, m, dns.ExtendedErrorProhibited, reason)
+	s.writeMsg(w, m)
+}
+
+// refused logs a rejected update and reports it to OnUpdateRefused.
+func (s *Server) refused(ctx context.Context, w dns.ResponseWriter, reason string, args ...any) {
+	slog.WarnContext(ctx, "update refused: "+reason, args...)
+	if ip := remoteIP(w.RemoteAddr()); s.bans.fail(ip) {
+		slog.WarnContext(ctx, "source banned after repeated refused updates", "ip", ip, "duration", s.bans.cfg.Duration)
+	}
+	if s.OnUpdateRefused != nil {
+		s.OnUpdateRefused(ctx, w.RemoteAddr(), reason)
+	}
+}
+
+// hasTSIG returns the TSIG record from the message's Pseudo section, or nil.
+func hasTSIG(m *dns.Msg) *dns.TSIG {
+	for _, rr := range m.Pseudo {
+		if t, ok := rr.(*dns.TSIG); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// SetTsigSecret replaces the base64-encoded HMAC secret authenticating
+// updates, e.g. after the key has been rotated. It is safe to call while
+// serving.
+func (s *Server) SetTsigSecret(secret string) error {
+	b, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return fmt.Errorf("invalid TSIG secret: %w", err)
+	}
+	if len(b) == 0 {
+		return errors.New("empty TSIG secret")
+	}
+	s.tsigSigner.Store(&dns.HmacTSIG{Secret: b})
+	return nil
+}
+
+// SetTsigPreviousSecret replaces the previous secret that is accepted during
+// a rotation, see TsigPreviousSecret. An empty secret drops it. It is safe to
+// call while serving.
+func (s *Server) SetTsigPreviousSecret(secret string) error {
+	if secret == "" {
+		s.tsigPrevious.Store(nil)
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return fmt.Errorf("invalid previous TSIG secret: %w", err)
+	}
+	if len(b) == 0 {
+		return errors.New("empty previous TSIG secret")
+	}
+	s.tsigPrevious.Store(&dns.HmacTSIG{Secret: b})
+	return nil
+}
+
+// init prepares s for serving: it decodes the TSIG secrets, unless done by
+// NewServer or SetTsigSecret, creates the rate limiters and KeyStats and
+// records the start time. An invalid secret, which Validate reports, is
+// logged and disables updates rather than stopping the server.
+func (s *Server) init() {
+	if s.TsigName != "" && s.tsigSigner.Load() == nil {
+		if err := s.SetTsigSecret(s.TsigSecret); err != nil {
+			slog.Error("updates disabled", "zone", s.Zone, "err", err)
+		} else if err := s.SetTsigPreviousSecret(s.TsigPreviousSecret); err != nil {
+			slog.Error("previous TSIG secret ignored", "zone", s.Zone, "err", err)
+		}
+	}
+	if s.updateKeyLimiter == nil {
+		s.updateKeyLimiter = newRateLimiter(s.UpdateKeyLimit)
+		s.updateIPLimiter = newRateLimiter(s.UpdateIPLimit)
+		s.bans = newBanList(s.Ban)
+		s.replays = newReplayCache()
+		s.replays.now = s.now
+		s.unsubscribe = s.Store.Subscribe(s.storeEvent)
+	}
+	s.Store.bind(s.ChallengeName())
+	if s.KeyStats == nil {
+		s.KeyStats = &KeyStats{}
+	}
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+}
+
+// Close cancels the Server's subscription to its Store, for a Store passed
+// on to a new Server when reconfiguring a zone. The Server must not serve
+// afterwards.
+func (s *Server) Close() {
+	if s.unsubscribe != nil {
+		s.unsubscribe()
+	}
+}
+
+// now returns the time of the Server's Clock.
+func (s *Server) now() time.Time {
+	return clockOr(s.Clock).Now()
+}
+
+// storeEvent logs and counts expired tokens. The Store removes them as soon
+// as their TTL has elapsed, not on the next lookup.
+func (s *Server) storeEvent(e Event) {
+	if e.Op == OpExpire {
+		s.expired.Add(1)
+		slog.Info("store: TXT value expired", "name", e.Name)
+	}
+}
+
+// NewDNSServer returns a configured dns.Server (caller must set Addr and Net),
+// serving Handler with opts.
+func (s *Server) NewDNSServer(opts ...HandlerOption) *dns.Server {
+	mux := dns.NewServeMux()
+	mux.Handle(".", s.trackRequests(s.logSlow(s.recoverPanics(s.tcpLimits(s.Handler(opts...))))))
+
+	s.dnsServers.Add(1)
+	ds := &dns.Server{
+		Handler:     mux,
+		UDPSize:     int(s.udpSize()),
+		ReadTimeout: s.TCPReadTimeout,
+		IdleTimeout: s.TCPIdleTimeout,
+	}
+	ds.NotifyStartedFunc = func(context.Context) { s.serverStarted(ds) }
+	ds.NotifyShutdownFunc = func(context.Context) { s.serverStopped(ds) }
+	return ds
+}
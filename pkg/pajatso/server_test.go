@@ -1,4 +1,4 @@
-package main
+package pajatso
 
 import (
 	"context"
@@ -37,14 +37,18 @@ func startTestServer(t *testing.T) (string, *Store, func()) {
 func startTestServerWithSubdomain(t *testing.T, subdomain string) (string, *Store, func()) {
 	t.Helper()
 
-	store := &Store{}
-	srv := &Server{
+	return startTestServerFor(t, &Server{
 		Zone:       testZone,
 		Subdomain:  subdomain,
 		TsigName:   testTsigName,
 		TsigSecret: testTsigSecret,
-		Store:      store,
-	}
+		Store:      &Store{},
+	})
+}
+
+// startTestServerFor starts srv on a random UDP port.
+func startTestServerFor(t *testing.T, srv *Server) (string, *Store, func()) {
+	t.Helper()
 
 	// Use a random available port.
 	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
@@ -57,11 +61,9 @@ func startTestServerWithSubdomain(t *testing.T, subdomain string) (string, *Stor
 	dnsServer.PacketConn = pc
 
 	go dnsServer.ListenAndServe()
+	<-srv.Ready()
 
-	// Wait for the server to be ready.
-	time.Sleep(50 * time.Millisecond)
-
-	return addr, store, func() {
+	return addr, srv.Store, func() {
 		dnsServer.Shutdown(context.Background())
 	}
 }
@@ -95,7 +97,7 @@ func TestQueryChallengeTXTSet(t *testing.T) {
 	addr, store, cleanup := startTestServer(t)
 	defer cleanup()
 
-	store.Set("test-validation-token")
+	store.Replace("test-validation-token")
 
 	r := query(t, addr, testChallenge, dns.TypeTXT)
 	if r.Rcode != dns.RcodeSuccess {
@@ -113,6 +115,38 @@ func TestQueryChallengeTXTSet(t *testing.T) {
 	}
 }
 
+func TestQueryCase(t *testing.T) {
+	srv := &Server{
+		Zone:  testZone,
+		NS:    []string{"ns.example.org."},
+		CNAME: map[string]string{"alias.example.com.": testChallenge},
+		Store: &Store{},
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	store.Set(testChallenge, "token", 0)
+
+	for _, tt := range []struct {
+		qname string
+		qtype uint16
+	}{
+		{"_ACME-Challenge.Example.COM.", dns.TypeTXT},
+		{"ALIAS.example.com.", dns.TypeTXT},
+		{"EXAMPLE.com.", dns.TypeNS},
+		{"EXAMPLE.com.", dns.TypeTXT}, // the SOA of the empty answer
+	} {
+		r := query(t, addr, tt.qname, tt.qtype)
+		if rrs := append(r.Answer, r.Ns...); len(rrs) == 0 || rrs[0].Header().Name != tt.qname {
+			t.Errorf("%s: expected the case of the query name, got %v", tt.qname, rrs)
+		}
+	}
+
+	// The records shared between responses keep their own case.
+	if r := query(t, addr, "example.com.", dns.TypeNS); r.Answer[0].Header().Name != "example.com." {
+		t.Errorf("expected the NS records unchanged, got %v", r.Answer)
+	}
+}
+
 func TestQueryUnknownName(t *testing.T) {
 	addr, _, cleanup := startTestServer(t)
 	defer cleanup()
@@ -173,7 +207,7 @@ func TestUpdateAddTXT(t *testing.T) {
 		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
 	}
 
-	val, ok := store.Get()
+	val, ok := store.Value()
 	if !ok || val != "my-token" {
 		t.Fatalf("expected (my-token, true), got (%q, %v)", val, ok)
 	}
@@ -183,7 +217,7 @@ func TestUpdateDeleteTXT(t *testing.T) {
 	addr, store, cleanup := startTestServer(t)
 	defer cleanup()
 
-	store.Set("to-delete")
+	store.Replace("to-delete")
 
 	// Delete specific RR: class NONE.
 	rr := &dns.TXT{
@@ -201,7 +235,7 @@ func TestUpdateDeleteTXT(t *testing.T) {
 		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
 	}
 
-	_, ok := store.Get()
+	_, ok := store.Value()
 	if ok {
 		t.Fatal("expected record to be deleted")
 	}
@@ -211,7 +245,7 @@ func TestUpdateDeleteAny(t *testing.T) {
 	addr, store, cleanup := startTestServer(t)
 	defer cleanup()
 
-	store.Set("to-delete-any")
+	store.Replace("to-delete-any")
 
 	// Delete all RRsets: class ANY, type ANY.
 	rr := &dns.ANY{
@@ -226,7 +260,7 @@ func TestUpdateDeleteAny(t *testing.T) {
 		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
 	}
 
-	_, ok := store.Get()
+	_, ok := store.Value()
 	if ok {
 		t.Fatal("expected record to be deleted")
 	}
@@ -362,3 +396,169 @@ func TestSubdomainUpdateWrongName(t *testing.T) {
 		t.Fatalf("expected REFUSED, got %s", dns.RcodeToString[r.Rcode])
 	}
 }
+
+func TestQueryHealth(t *testing.T) {
+	srv := &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		HealthName: "health",
+		Store:      &Store{},
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	r := query(t, addr, "health."+testZone, dns.TypeTXT)
+	if len(r.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(r.Answer))
+	}
+	txt := r.Answer[0].(*dns.TXT)
+	if len(txt.Txt) != 3 || txt.Txt[0] != "ok" || txt.Txt[2] != "serial=0" {
+		t.Fatalf("unexpected health TXT %v", txt.Txt)
+	}
+}
+
+func TestUpdateWrongTSIGAlgorithm(t *testing.T) {
+	addr, store, cleanup := startTestServerFor(t, &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		TsigAlg:    dns.HmacSHA256,
+		Store:      &Store{},
+	})
+	defer cleanup()
+
+	// sendUpdate signs with HMAC-SHA512.
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"wrong-alg\"")
+	r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret)
+	if r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("expected no record to be set")
+	}
+}
+
+func TestSetTsigSecret(t *testing.T) {
+	srv := &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		Store:      &Store{},
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	rotated := base64.StdEncoding.EncodeToString([]byte("rotated-key"))
+	if err := srv.SetTsigSecret(rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetTsigSecret("not base64!"); err == nil {
+		t.Fatal("expected an error for an invalid secret")
+	}
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"old-key\"")
+	r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret)
+	if r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH with the old secret, got %s", dns.RcodeToString[r.Rcode])
+	}
+
+	rr, _ = dns.New(testChallenge + " 60 IN TXT \"new-key\"")
+	r = sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, rotated)
+	if r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR with the rotated secret, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if val, _ := store.Value(); val != "new-key" {
+		t.Fatalf("expected new-key, got %q", val)
+	}
+}
+
+func TestTsigPreviousSecret(t *testing.T) {
+	current := base64.StdEncoding.EncodeToString([]byte("current-key"))
+	srv := &Server{
+		Zone:               testZone,
+		TsigName:           testTsigName,
+		TsigSecret:         current,
+		TsigPreviousSecret: testTsigSecret,
+		Store:              &Store{},
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	for _, secret := range []string{testTsigSecret, current} {
+		rr, _ := dns.New(testChallenge + " 60 IN TXT \"" + secret + "\"")
+		if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, secret); r.Rcode != dns.RcodeSuccess {
+			t.Fatalf("expected NOERROR with %s, got %s", secret, dns.RcodeToString[r.Rcode])
+		}
+		if val, _ := store.Value(); val != secret {
+			t.Fatalf("expected %s, got %q", secret, val)
+		}
+	}
+
+	// Once dropped, the previous secret is refused.
+	if err := srv.SetTsigPreviousSecret(""); err != nil {
+		t.Fatal(err)
+	}
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"dropped\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH with the dropped secret, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestTokenTTL(t *testing.T) {
+	for _, tt := range []struct {
+		expires time.Time
+		want    uint32
+	}{
+		{time.Time{}, challengeTTL},
+		{time.Now().Add(time.Hour), challengeTTL},
+		{time.Now().Add(30*time.Second + 500*time.Millisecond), 30},
+		{time.Now().Add(-time.Second), 0},
+	} {
+		if got := tokenTTL(Entry{Expires: tt.expires}, time.Now()); got != tt.want {
+			t.Errorf("expiring in %v: got TTL %d, want %d", time.Until(tt.expires).Round(time.Second), got, tt.want)
+		}
+	}
+}
+
+// failingWriter is a dns.ResponseWriter over a connection that has gone away.
+type failingWriter struct{ dns.ResponseWriter }
+
+func (failingWriter) RemoteAddr() net.Addr      { return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53} }
+func (failingWriter) Conn() net.Conn            { return nil }
+func (failingWriter) Write([]byte) (int, error) { return 0, net.ErrClosed }
+
+func TestWriteErrors(t *testing.T) {
+	srv := &Server{Zone: testZone, TsigName: testTsigName, TsigSecret: testTsigSecret, Store: &Store{}}
+	srv.init()
+
+	r := new(dns.Msg)
+	r.Question = []dns.RR{&dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassINET}}}
+	srv.handleQuery(context.Background(), failingWriter{}, r)
+	if n := srv.writeErrors.Load(); n != 1 {
+		t.Fatalf("expected 1 write error, got %d", n)
+	}
+
+	// A response that can't be packed is not sent at all.
+	m := new(dns.Msg)
+	m.Answer = []dns.RR{&dns.TXT{Hdr: dns.Header{Name: "not..a.name.", Class: dns.ClassINET}, TXT: rdata.TXT{Txt: []string{"x"}}}}
+	srv.writeMsg(failingWriter{}, m)
+	if n, w := srv.packErrors.Load(), srv.writeErrors.Load(); n != 1 || w != 1 {
+		t.Fatalf("expected 1 pack error and no further write error, got %d and %d", n, w)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	old := &Server{Zone: testZone, Store: &Store{}}
+	old.Handler()
+	srv := &Server{Zone: testZone, Store: old.Store}
+	srv.Handler()
+	old.Close()
+
+	old.Store.mu.RLock()
+	subs := len(old.Store.subs)
+	old.Store.mu.RUnlock()
+	if subs != 1 {
+		t.Errorf("store has %d subscribers, want 1", subs)
+	}
+}
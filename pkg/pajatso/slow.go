@@ -0,0 +1,66 @@
+package pajatso
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+// requestTiming collects the time spent in the stages of handling a request
+// that may be slow, for the log of SlowRequestThreshold.
+type requestTiming struct {
+	store time.Duration // reading and changing the Store
+	tsig  time.Duration // verifying the TSIG signature of an update
+}
+
+type requestTimingKey struct{}
+
+// timingFrom returns the requestTiming of the request handled with ctx, nil
+// if slow requests aren't logged.
+func timingFrom(ctx context.Context) *requestTiming {
+	t, _ := ctx.Value(requestTimingKey{}).(*requestTiming)
+	return t
+}
+
+// storeSince and tsigSince add the time since start to the stages of t, if
+// t isn't nil.
+func (t *requestTiming) storeSince(start time.Time) {
+	if t != nil {
+		t.store += time.Since(start)
+	}
+}
+
+func (t *requestTiming) tsigSince(start time.Time) {
+	if t != nil {
+		t.tsig += time.Since(start)
+	}
+}
+
+// logSlow returns a middleware logging the requests taking longer than
+// SlowRequestThreshold to be handled, with the time spent in the Store and
+// verifying TSIG, and counting them. It is next itself if the threshold is
+// zero.
+func (s *Server) logSlow(next dns.Handler) dns.Handler {
+	if s.SlowRequestThreshold <= 0 {
+		return next
+	}
+	return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+		start := time.Now()
+		timing := &requestTiming{}
+		rec := &ResponseRecorder{ResponseWriter: w}
+		next.ServeDNS(context.WithValue(ctx, requestTimingKey{}, timing), rec, r)
+
+		d := time.Since(start)
+		if d < s.SlowRequestThreshold {
+			return
+		}
+		s.slow.Add(1)
+		attrs := append(requestAttrs(w, r), "duration", d, "store", timing.store, "tsig", timing.tsig)
+		if rec.Written {
+			attrs = append(attrs, "rcode", dns.RcodeToString[rec.Rcode])
+		}
+		slog.WarnContext(ctx, "slow request", attrs...)
+	})
+}
@@ -0,0 +1,49 @@
+package pajatso
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestLogSlow(t *testing.T) {
+	timings := make(chan *requestTiming, 1)
+	srv := &Server{
+		Zone:                 testZone,
+		TsigName:             testTsigName,
+		TsigSecret:           testTsigSecret,
+		Store:                &Store{},
+		SlowRequestThreshold: time.Nanosecond,
+		Middleware: []Middleware{func(next dns.Handler) dns.Handler {
+			return dns.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+				next.ServeDNS(ctx, w, r)
+				timings <- timingFrom(ctx)
+			})
+		}},
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	rr, _ := dns.New(testChallenge + " 60 IN TXT \"my-token\"")
+	if r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if timing := <-timings; timing == nil || timing.tsig <= 0 || timing.store <= 0 {
+		t.Fatalf("expected the TSIG and store times of the update, got %+v", timing)
+	}
+
+	// The request is counted once the response has been sent.
+	deadline := time.Now().Add(time.Second)
+	for srv.slow.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "pajatso_requests_slow_total 1\n") {
+		t.Errorf("expected the slow request to be counted:\n%s", rec.Body)
+	}
+}
@@ -0,0 +1,82 @@
+package pajatso
+
+import (
+	"fmt"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Snapshot is the state of a Server's Store: the TXT values with their
+// expiry times and, for the challenge record, when they were set and
+// queried. It is serialized as JSON to inspect a running server or to
+// migrate its state to another host.
+type Snapshot struct {
+	Zone    string    `json:"zone"`
+	Time    time.Time `json:"time"`   // when the snapshot was taken
+	Serial  uint32    `json:"serial"` // of the Store, see Store.Serial
+	Records []Record  `json:"records"`
+}
+
+// Snapshot returns the current state of the Store.
+func (s *Server) Snapshot() Snapshot {
+	snap := Snapshot{Zone: s.Zone, Time: s.Store.now(), Serial: s.Store.Serial(), Records: []Record{}}
+	for _, e := range s.Store.List() {
+		rec := Record{Name: e.Name, Type: "TXT", Value: e.Value}
+		if !e.Expires.IsZero() {
+			rec.Expires = &e.Expires
+		}
+		if dns.EqualName(e.Name, s.ChallengeName()) {
+			rec = s.withActivity(rec)
+		}
+		snap.Records = append(snap.Records, rec)
+	}
+	return snap
+}
+
+// Restore adds the values of snap to the Store, keeping the values already
+// stored, and raises the serial of the Store to at least that of snap so
+// that secondaries see the zone as changed. Values expired since the
+// snapshot was taken are skipped. snap must be of the Server's zone.
+func (s *Server) Restore(snap Snapshot) error {
+	if snap.Zone != "" && !dns.EqualName(snap.Zone, s.Zone) {
+		return fmt.Errorf("snapshot of zone %s, not %s", snap.Zone, s.Zone)
+	}
+	var entries []Entry
+	for _, rec := range snap.Records {
+		if rec.Type != "TXT" {
+			return fmt.Errorf("unsupported record type %q", rec.Type)
+		}
+		if rec.Name != "" && !dnsutil.IsBelow(s.Zone, dnsutil.Fqdn(rec.Name)) {
+			return fmt.Errorf("record %s outside of zone %s", rec.Name, s.Zone)
+		}
+		if err := s.checkValue(rec.Value); err != nil {
+			return fmt.Errorf("record %s: %w", rec.Name, err)
+		}
+		e := Entry{Name: rec.Name, Value: rec.Value}
+		if rec.Expires != nil {
+			e.Expires = *rec.Expires
+		}
+		entries = append(entries, e)
+	}
+	s.Store.restore(entries, snap.Serial)
+
+	a := &s.activity
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, rec := range snap.Records {
+		if rec.SetAt == nil || !dns.EqualName(rec.Name, s.ChallengeName()) {
+			continue
+		}
+		if a.values == nil {
+			a.values = map[string]*valueActivity{}
+		}
+		v := &valueActivity{setAt: *rec.SetAt, setBy: rec.SetBy, queriedFrom: rec.QueriedFrom, queries: rec.Queries}
+		if rec.QueriedAt != nil {
+			v.queriedAt = *rec.QueriedAt
+		}
+		a.values[rec.Value] = v
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package pajatso
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	clock := newFakeClock()
+	src := &Server{Zone: testZone, Store: &Store{Clock: clock}}
+	src.Store.bind(src.ChallengeName())
+	src.Store.Set(testChallenge, "short", time.Minute)
+	src.Store.Set(testChallenge, "long", time.Hour)
+	src.Store.Set("other."+testZone, "static", 0)
+	src.recordSet("long", "acme-update.")
+
+	b, err := json.Marshal(src.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Records) != 3 || snap.Serial != 3 {
+		t.Fatalf("unexpected snapshot %s", b)
+	}
+
+	// Restored on another host a while later, the expiry times are kept.
+	clock.Advance(2 * time.Minute)
+	dst := &Server{Zone: testZone, Store: &Store{Clock: clock}}
+	dst.Store.bind(dst.ChallengeName())
+	if err := dst.Restore(snap); err != nil {
+		t.Fatal(err)
+	}
+	entries := dst.Store.Get(testChallenge)
+	if len(entries) != 1 || entries[0].Value != "long" || !entries[0].Expires.Equal(*snap.Records[0].Expires) {
+		t.Fatalf("expected the long-lived value with its expiry, got %+v", entries)
+	}
+	if other := dst.Store.Get("other." + testZone); len(other) != 1 || !other[0].Expires.IsZero() {
+		t.Fatalf("expected the value without expiry, got %+v", other)
+	}
+	if recs := dst.Records(); len(recs) != 1 || recs[0].SetBy != "acme-update." {
+		t.Fatalf("expected the activity to be restored, got %+v", recs)
+	}
+	if dst.Store.Serial() < snap.Serial {
+		t.Fatalf("expected serial of at least %d, got %d", snap.Serial, dst.Store.Serial())
+	}
+	clock.Advance(time.Hour)
+	if n := len(dst.Store.Get(testChallenge)); n != 0 {
+		t.Fatalf("expected the restored value to expire, got %d values", n)
+	}
+
+	snap.Zone = "example.org."
+	if err := dst.Restore(snap); err == nil {
+		t.Fatal("expected a snapshot of another zone to be refused")
+	}
+	snap.Zone = testZone
+	snap.Records = []Record{{Name: "www.example.org.", Type: "TXT", Value: "x"}}
+	if err := dst.Restore(snap); err == nil {
+		t.Fatal("expected a record outside of the zone to be refused")
+	}
+}
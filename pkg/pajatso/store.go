@@ -0,0 +1,516 @@
+package pajatso
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/miekg/dns/dnsutil"
+)
+
+// Op is the kind of change made to a Store.
+type Op string
+
+const (
+	OpSet    Op = "set"
+	OpDelete Op = "delete"
+	OpExpire Op = "expire"
+)
+
+// Event describes a change made to a Store.
+type Event struct {
+	Op    Op
+	Name  string // owner name of the record changed
+	Value string // the new value for OpSet, the removed value otherwise
+	Time  time.Time
+
+	// Request is the ID of the request making the change, see RequestID,
+	// empty for expiries and changes through the Store's methods.
+	Request string
+}
+
+// maxStoreValues is the number of values a record of a Store holds at most;
+// adding more drops the oldest.
+const maxStoreValues = 16
+
+// Store holds the values of TXT records by owner name, e.g. the challenge
+// tokens for a domain and its wildcard, which share the challenge record.
+// Its methods are safe for concurrent use, but writes to the fields TTL,
+// DeleteGrace and Clock aren't synchronized: set them before the Store is
+// used, e.g. from a Config, and don't change them while it is served.
+type Store struct {
+	TTL time.Duration // default lifetime of a stored value, zero means it never expires
+
+	// DeleteGrace keeps deleted values served for this long, or until they
+	// expire if sooner, for CAs re-checking the record right after the ACME
+	// client cleaned up. Zero removes them immediately.
+	DeleteGrace time.Duration
+
+	// Clock times TTL and DeleteGrace, the system clock if nil. It must be
+	// set before the first value is stored.
+	Clock Clock
+
+	// Name is the owner name of the record that Replace, Add, Remove,
+	// Clear, Value, Lookup and Entries, which predate the keyed methods,
+	// refer to. A Server serving the Store sets it to its challenge name if
+	// empty.
+	Name string
+
+	mu      sync.RWMutex
+	records map[string][]*entry // by lowercase owner name, newest first
+	serial  uint32              // incremented on every change
+
+	subs    map[int]func(Event)
+	nextSub int
+}
+
+// entry is a value held by a Store.
+type entry struct {
+	name    string // key of the record in Store.records
+	value   string
+	expires time.Time // zero if the value never expires
+	timer   Timer     // fires when the value expires
+	deleted bool      // deleted, served until it expires
+}
+
+// Entry is a TXT value held by a Store, with its owner name and expiry
+// time, which is zero if the value never expires.
+type Entry struct {
+	Name    string
+	Value   string
+	Expires time.Time
+}
+
+// storeKey returns the key of the record at name, which names are compared
+// by case-insensitively.
+func storeKey(name string) string {
+	if name == "" {
+		return ""
+	}
+	return strings.ToLower(dnsutil.Fqdn(name))
+}
+
+// Get returns the current values of the TXT record at name, newest first.
+func (s *Store) Get(name string) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries(storeKey(name))
+}
+
+// List returns the current values of all records, ordered by name and
+// newest first, e.g. for status reporting.
+func (s *Store) List() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []Entry
+	for _, key := range slices.Sorted(maps.Keys(s.records)) {
+		entries = append(entries, s.entries(key)...)
+	}
+	return entries
+}
+
+// entries returns the current values of the record at key. The caller must
+// hold s.mu.
+func (s *Store) entries(key string) []Entry {
+	var entries []Entry
+	now := s.now()
+	for _, e := range s.records[key] {
+		if !e.expired(now) {
+			entries = append(entries, Entry{e.name, e.value, e.expires})
+		}
+	}
+	return entries
+}
+
+// Set adds value to the TXT record at name, renewing it if it is already
+// stored. The value expires after ttl, or the Store's TTL if ttl is zero.
+// If the record is full, its oldest value is dropped.
+func (s *Store) Set(name, value string, ttl time.Duration) {
+	s.setFor(name, value, ttl, "")
+}
+
+// setFor is Set for the request with the ID request.
+func (s *Store) setFor(name, value string, ttl time.Duration, request string) {
+	key := storeKey(name)
+	s.mu.Lock()
+	now := s.now()
+	var dropped []string
+	values := slices.DeleteFunc(s.records[key], func(e *entry) bool {
+		if e.value == value {
+			e.stopTimer()
+			return true
+		}
+		return false
+	})
+	values = slices.Insert(values, 0, s.newEntry(key, value, ttl, now))
+	for len(values) > maxStoreValues {
+		e := values[len(values)-1]
+		e.stopTimer()
+		dropped = append(dropped, e.value)
+		values = values[:len(values)-1]
+	}
+	s.setRecord(key, values)
+	s.serial++
+	s.mu.Unlock()
+
+	s.publish(Event{Op: OpSet, Name: key, Value: value, Time: now, Request: request})
+	for _, v := range dropped {
+		s.publish(Event{Op: OpDelete, Name: key, Value: v, Time: now, Request: request})
+	}
+}
+
+// replace replaces all values of the record at name with value, without
+// DeleteGrace for the old ones, for the request with the ID request.
+func (s *Store) replace(name, value string, ttl time.Duration, request string) {
+	key := storeKey(name)
+	s.mu.Lock()
+	now := s.now()
+	for _, e := range s.records[key] {
+		e.stopTimer()
+	}
+	s.setRecord(key, []*entry{s.newEntry(key, value, ttl, now)})
+	s.serial++
+	s.mu.Unlock()
+
+	s.publish(Event{Op: OpSet, Name: key, Value: value, Time: now, Request: request})
+}
+
+// Delete removes value from the TXT record at name, or all of its values
+// if value is empty, after DeleteGrace. It reports whether a value was
+// removed.
+func (s *Store) Delete(name, value string) bool {
+	return s.deleteFor(name, value, "")
+}
+
+// deleteFor is Delete for the request with the ID request.
+func (s *Store) deleteFor(name, value, request string) bool {
+	if value != "" {
+		return s.deleteValue(storeKey(name), value, request)
+	}
+
+	key := storeKey(name)
+	s.mu.Lock()
+	var old []*entry
+	for i := len(s.records[key]) - 1; i >= 0; i-- {
+		if e := s.records[key][i]; !e.deleted {
+			old = slices.Insert(old, 0, e)
+			s.remove(key, i)
+		}
+	}
+	s.serial++
+	s.mu.Unlock()
+
+	now := s.now()
+	if len(old) == 0 {
+		s.publish(Event{Op: OpDelete, Name: key, Time: now, Request: request})
+	}
+	for _, e := range old {
+		s.publish(Event{Op: OpDelete, Name: key, Value: e.value, Time: now, Request: request})
+	}
+	return len(old) > 0
+}
+
+// deleteValue removes value from the record at key, after DeleteGrace.
+func (s *Store) deleteValue(key, value, request string) bool {
+	s.mu.Lock()
+	i := slices.IndexFunc(s.records[key], func(e *entry) bool { return e.value == value && !e.deleted })
+	if i < 0 {
+		s.mu.Unlock()
+		return false
+	}
+	s.remove(key, i)
+	s.serial++
+	s.mu.Unlock()
+
+	s.publish(Event{Op: OpDelete, Name: key, Value: value, Time: s.now(), Request: request})
+	return true
+}
+
+// restore adds the values of entries with their expiry times, skipping those
+// already expired, and raises the serial to at least serial, e.g. to resume
+// the state of another Store.
+func (s *Store) restore(entries []Entry, serial uint32) {
+	s.mu.Lock()
+	now := s.now()
+	var restored []Entry
+	for _, e := range entries {
+		if e.Value == "" || !e.Expires.IsZero() && !now.Before(e.Expires) {
+			continue
+		}
+		key := storeKey(e.Name)
+		values := slices.DeleteFunc(s.records[key], func(old *entry) bool {
+			if old.value == e.Value {
+				old.stopTimer()
+				return true
+			}
+			return false
+		})
+		ne := &entry{name: key, value: e.Value, expires: e.Expires}
+		if !e.Expires.IsZero() {
+			ne.timer = clockOr(s.Clock).AfterFunc(e.Expires.Sub(now), func() { s.expire(ne) })
+		}
+		values = append(values, ne)
+		for len(values) > maxStoreValues {
+			values[len(values)-1].stopTimer()
+			values = values[:len(values)-1]
+		}
+		s.setRecord(key, values)
+		restored = append(restored, Entry{key, e.Value, e.Expires})
+	}
+	s.serial = max(s.serial+1, serial)
+	s.mu.Unlock()
+
+	for _, e := range restored {
+		s.publish(Event{Op: OpSet, Name: e.Name, Value: e.Value, Time: now})
+	}
+}
+
+// Serial returns a counter that is incremented on every change to the store.
+func (s *Store) Serial() uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.serial
+}
+
+// Replace replaces all values of the record at Name with value.
+func (s *Store) Replace(value string) { s.replace(s.name(), value, 0, "") }
+
+// Add adds value to the record at Name, see Set.
+func (s *Store) Add(value string) { s.Set(s.name(), value, 0) }
+
+// Remove removes value from the record at Name, after DeleteGrace, and
+// reports whether it was stored.
+func (s *Store) Remove(value string) bool {
+	return value != "" && s.Delete(s.name(), value)
+}
+
+// Clear removes all values of the record at Name, after DeleteGrace.
+func (s *Store) Clear() { s.Delete(s.name(), "") }
+
+// Value returns the newest value of the record at Name if one is set.
+func (s *Store) Value() (string, bool) {
+	value, _, ok := s.Lookup()
+	return value, ok
+}
+
+// Lookup returns the newest value of the record at Name and its expiry
+// time if one is set. The expiry time is zero if the value never expires.
+func (s *Store) Lookup() (string, time.Time, bool) {
+	entries := s.Entries()
+	if len(entries) == 0 {
+		return "", time.Time{}, false
+	}
+	return entries[0].Value, entries[0].Expires, true
+}
+
+// Entries returns the current values of the record at Name, newest first.
+func (s *Store) Entries() []Entry { return s.Get(s.name()) }
+
+// name returns Name, read under s.mu as bind may set it.
+func (s *Store) name() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Name
+}
+
+// bind sets Name to name unless it is already set, moving the values
+// stored without a name to it.
+func (s *Store) bind(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Name != "" {
+		return
+	}
+	s.Name = name
+	key := storeKey(name)
+	if values, ok := s.records[""]; ok && key != "" {
+		delete(s.records, "")
+		for _, e := range values {
+			e.name = key
+		}
+		s.setRecord(key, append(s.records[key], values...))
+	}
+}
+
+// Rename moves the values of the record at from to the record at to,
+// replacing its values, and makes to the Name if from was.
+func (s *Store) Rename(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if storeKey(s.Name) == storeKey(from) {
+		s.Name = to
+	}
+	fromKey, toKey := storeKey(from), storeKey(to)
+	if fromKey == toKey {
+		return
+	}
+	values := s.records[fromKey]
+	delete(s.records, fromKey)
+	for _, e := range s.records[toKey] {
+		e.stopTimer()
+	}
+	for _, e := range values {
+		e.name = toKey
+	}
+	s.setRecord(toKey, values)
+	s.serial++
+}
+
+// setRecord sets the values of the record at key, dropping the record if
+// there are none. The caller must hold s.mu.
+func (s *Store) setRecord(key string, values []*entry) {
+	if len(values) == 0 {
+		delete(s.records, key)
+		return
+	}
+	if s.records == nil {
+		s.records = map[string][]*entry{}
+	}
+	s.records[key] = values
+}
+
+// newEntry returns an entry for value of the record at key, set at now and
+// expiring after ttl, or the TTL if zero. The caller must hold s.mu.
+func (s *Store) newEntry(key, value string, ttl time.Duration, now time.Time) *entry {
+	if ttl <= 0 {
+		ttl = s.TTL
+	}
+	e := &entry{name: key, value: value}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+		e.timer = clockOr(s.Clock).AfterFunc(ttl, func() { s.expire(e) })
+	}
+	return e
+}
+
+// remove removes the value at index i of the record at key, or marks it
+// deleted and keeps it until DeleteGrace has passed. The caller must hold
+// s.mu.
+func (s *Store) remove(key string, i int) {
+	e := s.records[key][i]
+	if s.DeleteGrace <= 0 {
+		e.stopTimer()
+		s.setRecord(key, slices.Delete(s.records[key], i, i+1))
+		return
+	}
+	e.deleted = true
+	if until := s.now().Add(s.DeleteGrace); e.expires.IsZero() || until.Before(e.expires) {
+		e.stopTimer()
+		e.expires = until
+		e.timer = clockOr(s.Clock).AfterFunc(s.DeleteGrace, func() { s.expire(e) })
+	}
+}
+
+// expire removes e once its TTL or DeleteGrace has elapsed, unless it has
+// been removed or replaced since. The deletion of values removed after
+// DeleteGrace has already been published.
+func (s *Store) expire(e *entry) {
+	s.mu.Lock()
+	key := e.name
+	i := slices.Index(s.records[key], e)
+	if i < 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.setRecord(key, slices.Delete(s.records[key], i, i+1))
+	s.serial++
+	s.mu.Unlock()
+
+	if !e.deleted {
+		s.publish(Event{Op: OpExpire, Name: key, Value: e.value, Time: s.now()})
+	}
+}
+
+// stopTimer stops the pending expiry, if any. The caller must hold the
+// Store's mu.
+func (e *entry) stopTimer() {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+}
+
+// expired reports whether e has outlived its TTL at now.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// now returns the time of the Store's Clock.
+func (s *Store) now() time.Time {
+	return clockOr(s.Clock).Now()
+}
+
+// watchBuffer is the number of events buffered for a slow Watch receiver.
+const watchBuffer = 64
+
+// Watch returns a channel receiving every change made to the store until ctx
+// is done, when the channel is closed. Events are dropped if the receiver
+// falls more than watchBuffer events behind.
+func (s *Store) Watch(ctx context.Context) <-chan Event {
+	ch := make(chan Event, watchBuffer)
+
+	var mu sync.Mutex // guards ch against sends after close
+	closed := false
+	cancel := s.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+
+	go func() {
+		<-ctx.Done()
+		cancel()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(ch)
+	}()
+	return ch
+}
+
+// Subscribe registers fn to be called after every change to the store and
+// returns a function removing it again. fn is called synchronously by the
+// goroutine making the change and must not block.
+func (s *Store) Subscribe(fn func(Event)) (cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subs == nil {
+		s.subs = make(map[int]func(Event))
+	}
+	id := s.nextSub
+	s.nextSub++
+	s.subs[id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+// publish calls all subscribers with e. The caller must not hold s.mu.
+func (s *Store) publish(e Event) {
+	s.mu.RLock()
+	subs := make([]func(Event), 0, len(s.subs))
+	for _, fn := range s.subs {
+		subs = append(subs, fn)
+	}
+	s.mu.RUnlock()
+
+	for _, fn := range subs {
+		fn(e)
+	}
+}
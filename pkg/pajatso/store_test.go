@@ -0,0 +1,316 @@
+package pajatso
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStoreEmpty(t *testing.T) {
+	var s Store
+	val, ok := s.Value()
+	if ok {
+		t.Fatalf("expected empty store, got %q", val)
+	}
+}
+
+func TestStoreSetGet(t *testing.T) {
+	var s Store
+	s.Replace("test-token")
+
+	val, ok := s.Value()
+	if !ok || val != "test-token" {
+		t.Fatalf("expected (test-token, true), got (%q, %v)", val, ok)
+	}
+}
+
+func TestStoreOverwrite(t *testing.T) {
+	var s Store
+	s.Replace("first")
+	s.Replace("second")
+
+	val, ok := s.Value()
+	if !ok || val != "second" {
+		t.Fatalf("expected (second, true), got (%q, %v)", val, ok)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	var s Store
+	s.Replace("to-delete")
+	s.Clear()
+
+	val, ok := s.Value()
+	if ok {
+		t.Fatalf("expected deleted, got %q", val)
+	}
+}
+
+func TestStoreDeleteNoop(t *testing.T) {
+	var s Store
+	s.Clear() // should not panic
+}
+
+func TestStoreExpiry(t *testing.T) {
+	clock := newFakeClock()
+	s := Store{TTL: time.Minute, Clock: clock}
+	s.Replace("expiring")
+
+	val, expires, ok := s.Lookup()
+	if !ok || val != "expiring" || !expires.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("expected (expiring, <expiry>, true), got (%q, %v, %v)", val, expires, ok)
+	}
+
+	clock.Advance(time.Minute - time.Second)
+	if _, ok := s.Value(); !ok {
+		t.Fatal("expected the value to be served until its TTL has passed")
+	}
+	clock.Advance(time.Second)
+	if val, ok := s.Value(); ok {
+		t.Fatalf("expected expired, got %q", val)
+	}
+}
+
+func TestStoreNoExpiry(t *testing.T) {
+	var s Store
+	s.Replace("forever")
+
+	_, expires, ok := s.Lookup()
+	if !ok || !expires.IsZero() {
+		t.Fatalf("expected no expiry, got (%v, %v)", expires, ok)
+	}
+}
+
+func TestStoreSerial(t *testing.T) {
+	var s Store
+	if n := s.Serial(); n != 0 {
+		t.Fatalf("expected serial 0, got %d", n)
+	}
+	s.Replace("a")
+	s.Clear()
+	if n := s.Serial(); n != 2 {
+		t.Fatalf("expected serial 2, got %d", n)
+	}
+}
+
+func TestStoreSubscribe(t *testing.T) {
+	var s Store
+	var events []Event
+	cancel := s.Subscribe(func(e Event) { events = append(events, e) })
+
+	s.Replace("token")
+	s.Clear()
+	cancel()
+	s.Replace("ignored")
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Op != OpSet || events[0].Value != "token" {
+		t.Fatalf("expected set of token, got %+v", events[0])
+	}
+	if events[1].Op != OpDelete || events[1].Value != "token" {
+		t.Fatalf("expected delete of token, got %+v", events[1])
+	}
+}
+
+func TestStoreExpireEvent(t *testing.T) {
+	clock := newFakeClock()
+	s := Store{TTL: time.Minute, Clock: clock}
+	var events []Event
+	s.Subscribe(func(e Event) { events = append(events, e) })
+
+	s.Replace("expiring")
+	clock.Advance(time.Minute)
+
+	if len(events) != 2 || events[1].Op != OpExpire || events[1].Value != "expiring" || !events[1].Time.Equal(clock.Now()) {
+		t.Fatalf("expected expire of expiring, got %+v", events)
+	}
+	if _, ok := s.Value(); ok {
+		t.Fatal("expected the value to be gone")
+	}
+}
+
+func TestStoreExpireAfterOverwrite(t *testing.T) {
+	clock := newFakeClock()
+	s := Store{TTL: time.Minute, Clock: clock}
+	s.Replace("first")
+	s.TTL = 0
+	s.Replace("second")
+
+	clock.Advance(time.Hour)
+	if val, ok := s.Value(); !ok || val != "second" {
+		t.Fatalf("expected (second, true), got (%q, %v)", val, ok)
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	var s Store
+	ctx, cancel := context.WithCancel(context.Background())
+	events := s.Watch(ctx)
+
+	s.Replace("token")
+	if e := <-events; e.Op != OpSet || e.Value != "token" {
+		t.Fatalf("expected set of token, got %+v", e)
+	}
+
+	cancel()
+	for range events {
+		// Drain until closed.
+	}
+	s.Replace("after") // must not panic on the closed channel
+}
+
+func TestStoreAddRemove(t *testing.T) {
+	var s Store
+	var events []Event
+	s.Subscribe(func(e Event) { events = append(events, e) })
+
+	s.Add("domain")
+	s.Add("wildcard")
+	s.Add("domain") // renewed, not duplicated
+
+	entries := s.Entries()
+	if len(entries) != 2 || entries[0].Value != "domain" || entries[1].Value != "wildcard" {
+		t.Fatalf("expected [domain wildcard], got %+v", entries)
+	}
+	if !s.Remove("wildcard") || s.Remove("wildcard") {
+		t.Fatal("expected wildcard to be removed once")
+	}
+	if val, ok := s.Value(); !ok || val != "domain" {
+		t.Fatalf("expected (domain, true), got (%q, %v)", val, ok)
+	}
+	if len(events) != 4 || events[3].Op != OpDelete || events[3].Value != "wildcard" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	for i := range maxStoreValues {
+		s.Add(string(rune('a' + i)))
+	}
+	if entries := s.Entries(); len(entries) != maxStoreValues || entries[len(entries)-1].Value != "a" {
+		t.Fatalf("expected the oldest value to be dropped, got %+v", entries)
+	}
+}
+
+func TestStoreExpireOne(t *testing.T) {
+	clock := newFakeClock()
+	s := Store{TTL: time.Minute, Clock: clock}
+	s.Add("expiring")
+	s.TTL = 0
+	s.Add("forever")
+
+	clock.Advance(time.Hour)
+	if entries := s.Entries(); len(entries) != 1 || entries[0].Value != "forever" {
+		t.Fatalf("expected [forever], got %+v", entries)
+	}
+}
+
+func TestStoreDeleteGrace(t *testing.T) {
+	clock := newFakeClock()
+	s := Store{DeleteGrace: time.Minute, Clock: clock}
+	var events []Event
+	s.Subscribe(func(e Event) { events = append(events, e) })
+
+	s.Add("cleaned-up")
+	s.Add("kept")
+	if !s.Remove("cleaned-up") || s.Remove("cleaned-up") {
+		t.Fatal("expected cleaned-up to be removed once")
+	}
+	if entries := s.Entries(); len(entries) != 2 {
+		t.Fatalf("expected the deleted value to be served during the grace period, got %+v", entries)
+	}
+
+	clock.Advance(time.Minute)
+	if entries := s.Entries(); len(entries) != 1 || entries[0].Value != "kept" {
+		t.Fatalf("expected [kept], got %+v", entries)
+	}
+	if len(events) != 3 || events[2].Op != OpDelete {
+		t.Fatalf("expected the deletion to be published once, got %+v", events)
+	}
+}
+
+func TestStoreDeleteGraceExpiry(t *testing.T) {
+	clock := newFakeClock()
+	s := Store{TTL: time.Minute, DeleteGrace: time.Hour, Clock: clock}
+	s.Replace("token")
+	s.Clear()
+
+	// The value still expires with its TTL.
+	if _, expires, ok := s.Lookup(); !ok || !expires.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("expected the value to be served until it expires, got (%v, %v)", expires, ok)
+	}
+	clock.Advance(time.Minute)
+	if _, ok := s.Value(); ok {
+		t.Fatal("expected the value to be gone")
+	}
+}
+
+func TestStoreNames(t *testing.T) {
+	s := Store{TTL: time.Hour}
+	var events []Event
+	s.Subscribe(func(e Event) { events = append(events, e) })
+
+	s.Set("_acme-challenge.example.com.", "apex", 0)
+	s.Set("_ACME-challenge.example.com", "apex-wildcard", time.Minute)
+	s.Set("_acme-challenge.www.example.com.", "www", 0)
+
+	entries := s.Get("_acme-challenge.Example.com.")
+	if len(entries) != 2 || entries[0].Value != "apex-wildcard" || entries[1].Value != "apex" {
+		t.Fatalf("expected [apex-wildcard apex], got %+v", entries)
+	}
+	if d := time.Until(entries[0].Expires); d > time.Minute {
+		t.Fatalf("expected the value to expire after its own TTL, got %v", d)
+	}
+	if d := time.Until(entries[1].Expires); d < 59*time.Minute {
+		t.Fatalf("expected the value to expire after the Store's TTL, got %v", d)
+	}
+	if events[1].Name != "_acme-challenge.example.com." {
+		t.Fatalf("expected the event for the canonical name, got %+v", events[1])
+	}
+
+	var names []string
+	for _, e := range s.List() {
+		names = append(names, e.Name+" "+e.Value)
+	}
+	want := []string{
+		"_acme-challenge.example.com. apex-wildcard",
+		"_acme-challenge.example.com. apex",
+		"_acme-challenge.www.example.com. www",
+	}
+	if !slices.Equal(names, want) {
+		t.Fatalf("expected %q, got %q", want, names)
+	}
+
+	if !s.Delete("_acme-challenge.example.com.", "apex") || s.Delete("_acme-challenge.example.com.", "apex") {
+		t.Fatal("expected apex to be deleted once")
+	}
+	if !s.Delete("_acme-challenge.example.com.", "") || s.Delete("_acme-challenge.example.com.", "") {
+		t.Fatal("expected the record to be deleted once")
+	}
+	if entries := s.List(); len(entries) != 1 || entries[0].Value != "www" {
+		t.Fatalf("expected the other record to be kept, got %+v", entries)
+	}
+}
+
+func TestStoreBind(t *testing.T) {
+	var s Store
+	s.Add("before")
+	s.bind(testChallenge)
+	s.bind("ignored.example.com.")
+
+	if s.Name != testChallenge {
+		t.Fatalf("expected Name %s, got %s", testChallenge, s.Name)
+	}
+	if entries := s.Get(testChallenge); len(entries) != 1 || entries[0].Value != "before" {
+		t.Fatalf("expected the unnamed values to move to the name, got %+v", entries)
+	}
+
+	s.Rename(testChallenge, "_acme-challenge.sub."+testZone)
+	if len(s.Get(testChallenge)) != 0 || s.Name != "_acme-challenge.sub."+testZone {
+		t.Fatalf("expected the record and Name to move, got %+v and %s", s.List(), s.Name)
+	}
+	if val, ok := s.Value(); !ok || val != "before" {
+		t.Fatalf("expected (before, true), got (%q, %v)", val, ok)
+	}
+}
@@ -0,0 +1,73 @@
+package pajatso
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// DefaultMaxTokenLength is the maximum length of a challenge value in bytes
+// by default, well above the 43 bytes of ACME challenge digests.
+const DefaultMaxTokenLength = 1024
+
+// errNotACMEToken is returned by checkToken for values other than ACME
+// challenge digests with StrictTokens.
+var errNotACMEToken = errors.New("value is not an ACME challenge token")
+
+// maxTokenLength returns the maximum challenge value length of s.
+func (s *Server) maxTokenLength() int {
+	if s.MaxTokenLength == 0 {
+		return DefaultMaxTokenLength
+	}
+	return s.MaxTokenLength
+}
+
+// checkValue validates a challenge value before it is stored: it must not
+// be longer than MaxTokenLength or contain control characters, so that
+// answers can't be abused to emit pathological records.
+func (s *Server) checkValue(value string) error {
+	b := unescapeTXT(value)
+	if len(b) > s.maxTokenLength() {
+		return fmt.Errorf("value longer than %d bytes", s.maxTokenLength())
+	}
+	if i := bytes.IndexFunc(b, func(r rune) bool { return r < 0x20 || r == 0x7f }); i >= 0 {
+		return fmt.Errorf("value contains control character %q", b[i])
+	}
+	return nil
+}
+
+// unescapeTXT returns the bytes a TXT string in presentation format, as
+// values are stored and as the dns package unpacks them, is sent as:
+// \DDD escapes are decimal bytes and \X is X.
+func unescapeTXT(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		if i+3 <= len(s) {
+			if n, err := strconv.ParseUint(s[i:i+3], 10, 8); err == nil {
+				b = append(b, byte(n))
+				i += 2
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return b
+}
+
+// checkToken validates a challenge value set through one of the update
+// APIs with checkValue and, with StrictTokens, as an ACME challenge digest.
+func (s *Server) checkToken(value string) error {
+	if err := s.checkValue(value); err != nil {
+		return err
+	}
+	if s.StrictTokens && !acmeTXT.MatchString(value) {
+		return errNotACMEToken
+	}
+	return nil
+}
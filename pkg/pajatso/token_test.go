@@ -0,0 +1,27 @@
+package pajatso
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckValue(t *testing.T) {
+	s := &Server{MaxTokenLength: 8}
+	for value, ok := range map[string]bool{
+		"token":                   true,
+		"12345678":                true,
+		"123456789":               false,
+		`\049\050\051`:            true, // "123"
+		`\"quoted\"`:              true, // 8 bytes once unescaped
+		"line\nbreak":             false,
+		`line\010`:                false, // escaped newline
+		"del\x7f":                 false,
+		`tab\009`:                 false,
+		`back\\slash`:             false, // 10 bytes
+		strings.Repeat(`\065`, 8): true,
+	} {
+		if err := s.checkValue(value); (err == nil) != ok {
+			t.Errorf("%q: got %v", value, err)
+		}
+	}
+}
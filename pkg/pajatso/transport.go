@@ -0,0 +1,90 @@
+package pajatso
+
+import (
+	"cmp"
+	"crypto/tls"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"codeberg.org/miekg/dns"
+)
+
+// Transport returns the transport the request answered through w was
+// received over: "udp", "tcp" or "dot" (DNS over TLS, RFC 7858).
+func Transport(w dns.ResponseWriter) string {
+	switch w.Conn().(type) {
+	case *tls.Conn:
+		return "dot"
+	case *net.TCPConn:
+		return "tcp"
+	}
+	return "udp"
+}
+
+// Listener returns the local address the request answered through w was
+// received on, empty if unknown. For TCP listeners on a wildcard address,
+// this is the address the client connected to.
+func Listener(w dns.ResponseWriter) string {
+	if a := w.LocalAddr(); a != nil {
+		return a.String()
+	}
+	return ""
+}
+
+// listenerKey identifies a listener in listenerStats.
+type listenerKey struct {
+	transport, addr string
+}
+
+// listenerCounters count the requests received on a listener.
+type listenerCounters struct {
+	requests  atomic.Uint64
+	truncated atomic.Uint64 // UDP responses truncated for the client to retry over TCP
+}
+
+// listenerStats counts requests by transport and listener, so that it shows
+// which path queries arrive over, e.g. whether clients retry truncated UDP
+// responses over TCP.
+type listenerStats struct {
+	mu sync.Mutex
+	m  map[listenerKey]*listenerCounters
+}
+
+// get returns the counters of the listener of w.
+func (l *listenerStats) get(w dns.ResponseWriter) *listenerCounters {
+	key := listenerKey{Transport(w), Listener(w)}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.m[key]
+	if c == nil {
+		if l.m == nil {
+			l.m = map[listenerKey]*listenerCounters{}
+		}
+		c = &listenerCounters{}
+		l.m[key] = c
+	}
+	return c
+}
+
+// listenerUsage is a snapshot of the counters of a listener.
+type listenerUsage struct {
+	listenerKey
+	requests, truncated uint64
+}
+
+// usage returns the counters of all listeners, sorted by transport and
+// address.
+func (l *listenerStats) usage() []listenerUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var usage []listenerUsage
+	for key, c := range l.m {
+		usage = append(usage, listenerUsage{key, c.requests.Load(), c.truncated.Load()})
+	}
+	slices.SortFunc(usage, func(a, b listenerUsage) int {
+		return cmp.Or(cmp.Compare(a.transport, b.transport), cmp.Compare(a.addr, b.addr))
+	})
+	return usage
+}
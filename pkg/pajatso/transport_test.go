@@ -0,0 +1,46 @@
+package pajatso
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestListenerMetrics(t *testing.T) {
+	srv := &Server{Zone: testZone, Store: &Store{}}
+	udp, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	tcp := startTCPTestServer(t, srv)
+
+	// Answers too large for UDP without EDNS0 are truncated, and retried
+	// over TCP.
+	for i := range maxStoreValues {
+		store.Add(testACMETXT(fmt.Sprint(i)))
+	}
+	if r := query(t, udp, testChallenge, dns.TypeTXT); !r.Truncated {
+		t.Fatal("expected a truncated answer")
+	}
+	r, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(testChallenge, dns.TypeTXT), "tcp", tcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Answer) != maxStoreValues {
+		t.Fatalf("expected %d answers over TCP, got %d", maxStoreValues, len(r.Answer))
+	}
+
+	rec := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		fmt.Sprintf("pajatso_requests_total{transport=\"udp\",listener=%q} 1\n", udp),
+		fmt.Sprintf("pajatso_requests_total{transport=\"tcp\",listener=%q} 1\n", tcp),
+		fmt.Sprintf("pajatso_responses_truncated_total{transport=\"udp\",listener=%q} 1\n", udp),
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, rec.Body)
+		}
+	}
+}
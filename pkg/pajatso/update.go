@@ -0,0 +1,314 @@
+package pajatso
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// updateError is an update rejected with an RFC 2136 rcode.
+type updateError struct {
+	rcode  uint16
+	reason string
+	args   []any
+}
+
+func reject(rcode uint16, reason string, args ...any) *updateError {
+	return &updateError{rcode, reason, args}
+}
+
+// rejectUpdate responds to an authenticated update rejected with e, signing
+// the response with key.
+func (s *Server) rejectUpdate(ctx context.Context, w dns.ResponseWriter, m *dns.Msg, key dns.HmacTSIG, t *dns.TSIG, e *updateError) {
+	m.Rcode = e.rcode
+	s.refused(ctx, w, e.reason, e.args...)
+	s.writeSigned(w, m, key, t.MAC)
+}
+
+// checkZone validates the zone section of an update (RFC 2136 3.1).
+func (s *Server) checkZone(r *dns.Msg) *updateError {
+	if len(r.Question) != 1 || dns.RRToType(r.Question[0]) != dns.TypeSOA {
+		return reject(dns.RcodeFormatError, "malformed zone section", "questions", len(r.Question))
+	}
+	if name := r.Question[0].Header().Name; !s.zoneMatches(name, r) {
+		return reject(dns.RcodeNotZone, "wrong zone", "zone", name, "expected", s.Zone)
+	}
+	return nil
+}
+
+// checkPrereqs checks the prerequisite section of an update (RFC 2136
+// 3.2). RRsets that must exist with given values are compared as a whole.
+func (s *Server) checkPrereqs(prereqs []dns.RR) *updateError {
+	type rrset struct {
+		name   string
+		rrtype uint16
+	}
+	var (
+		order []rrset
+		want  = map[rrset][]dns.RR{}
+	)
+	for _, rr := range prereqs {
+		hdr := rr.Header()
+		rrtype := dns.RRToType(rr)
+		args := []any{"name", hdr.Name, "type", dns.TypeToString[rrtype], "class", dns.ClassToString[hdr.Class]}
+		if hdr.TTL != 0 {
+			return reject(dns.RcodeFormatError, "prerequisite with non-zero TTL", args...)
+		}
+		if !dnsutil.IsBelow(s.Zone, hdr.Name) {
+			return reject(dns.RcodeNotZone, "prerequisite outside the zone", args...)
+		}
+		existing := s.records(hdr.Name)
+		switch hdr.Class {
+		case dns.ClassANY:
+			if rrtype == dns.TypeANY && len(existing) == 0 {
+				return reject(dns.RcodeNameError, "prerequisite name not in use", args...)
+			}
+			if rrtype != dns.TypeANY && !hasType(existing, rrtype) {
+				return reject(dns.RcodeNXRrset, "prerequisite RRset does not exist", args...)
+			}
+		case dns.ClassNONE:
+			if rrtype == dns.TypeANY && len(existing) > 0 {
+				return reject(dns.RcodeYXDomain, "prerequisite name in use", args...)
+			}
+			if rrtype != dns.TypeANY && hasType(existing, rrtype) {
+				return reject(dns.RcodeYXRrset, "prerequisite RRset exists", args...)
+			}
+		case dns.ClassINET:
+			if emptyPrereq(rr) {
+				if !hasType(existing, rrtype) {
+					return reject(dns.RcodeNXRrset, "prerequisite RRset does not exist", args...)
+				}
+				continue
+			}
+			set := rrset{strings.ToLower(hdr.Name), rrtype}
+			if _, ok := want[set]; !ok {
+				order = append(order, set)
+			}
+			want[set] = append(want[set], rr)
+		default:
+			return reject(dns.RcodeFormatError, "prerequisite with unknown class", args...)
+		}
+	}
+
+	for _, set := range order {
+		var have []dns.RR
+		for _, rr := range s.records(set.name) {
+			if dns.RRToType(rr) == set.rrtype {
+				have = append(have, rr)
+			}
+		}
+		if !sameRRset(have, want[set]) {
+			return reject(dns.RcodeNXRrset, "prerequisite RRset differs", "name", set.name, "type", dns.TypeToString[set.rrtype])
+		}
+	}
+	return nil
+}
+
+// prescan validates the update section before anything is changed (RFC
+// 2136 3.4.1), so that an update is applied entirely or not at all. Each
+// record must be granted by the update policy, and only the challenge TXT
+// record, and the TXT records of genericName with GenericTXT, have a Store
+// to update.
+func (s *Server) prescan(updates []dns.RR) *updateError {
+	for _, rr := range updates {
+		hdr := rr.Header()
+		rrtype := dns.RRToType(rr)
+		args := []any{"name", hdr.Name, "type", dns.TypeToString[rrtype], "class", dns.ClassToString[hdr.Class]}
+		if !dnsutil.IsBelow(s.Zone, hdr.Name) {
+			return reject(dns.RcodeNotZone, "name outside the zone", args...)
+		}
+		// Deleting all records at a name deletes its TXT records, the only
+		// ones that can be updated.
+		granted := rrtype
+		if rrtype == dns.TypeANY {
+			granted = dns.TypeTXT
+		}
+		if !s.granted(s.TsigName, hdr.Name, granted) {
+			return reject(dns.RcodeRefused, "denied by update policy", append(args, "key", s.TsigName)...)
+		}
+		challenge := dns.EqualName(hdr.Name, s.ChallengeName())
+		if !challenge && !s.genericName(hdr.Name) {
+			return reject(dns.RcodeRefused, "wrong name", append(args, "expected", s.ChallengeName())...)
+		}
+
+		txt, _ := rr.(*dns.TXT)
+		switch hdr.Class {
+		case dns.ClassINET:
+			// Add to an RRset.
+			if rrtype != dns.TypeTXT {
+				return reject(dns.RcodeRefused, "wrong record type", args...)
+			}
+			if txt == nil || len(txt.Txt) == 0 {
+				return reject(dns.RcodeFormatError, "unable to parse TXT record", args...)
+			}
+			// StrictTokens is about the challenge record: the values at
+			// other names are whatever other providers ask to publish.
+			check := s.checkToken
+			if !challenge {
+				check = s.checkValue
+			}
+			if err := check(strings.Join(txt.Txt, "")); errors.Is(err, errNotACMEToken) {
+				return reject(dns.RcodeRefused, "not an ACME challenge token", args...)
+			} else if err != nil {
+				return reject(dns.RcodeFormatError, "invalid TXT record", append(args, "err", err)...)
+			}
+		case dns.ClassNONE:
+			// Delete an RR from an RRset.
+			if hdr.TTL != 0 {
+				return reject(dns.RcodeFormatError, "delete with non-zero TTL", args...)
+			}
+			if rrtype != dns.TypeTXT {
+				return reject(dns.RcodeRefused, "wrong record type", args...)
+			}
+			if txt == nil || len(txt.Txt) == 0 {
+				return reject(dns.RcodeFormatError, "unable to parse TXT record", args...)
+			}
+			// No longer value can be stored, and deleting it would only
+			// cost a scan of the Store.
+			if n := len(unescapeTXT(strings.Join(txt.Txt, ""))); n > s.maxTokenLength() {
+				return reject(dns.RcodeFormatError, "TXT record too long", append(args, "length", n)...)
+			}
+		case dns.ClassANY:
+			// Delete an RRset, or all RRsets of a name.
+			if hdr.TTL != 0 || txt != nil && len(txt.Txt) > 0 {
+				return reject(dns.RcodeFormatError, "delete with non-zero TTL or data", args...)
+			}
+			if rrtype != dns.TypeANY && rrtype != dns.TypeTXT {
+				return reject(dns.RcodeRefused, "wrong record type", args...)
+			}
+		default:
+			return reject(dns.RcodeFormatError, "unknown class", args...)
+		}
+	}
+	return nil
+}
+
+// applyUpdates applies the update section, which has passed prescan, in
+// order. Added values join the ones already stored, so that a domain and
+// its wildcard can be validated at once.
+func (s *Server) applyUpdates(ctx context.Context, updates []dns.RR) {
+	for _, rr := range updates {
+		name := rr.Header().Name
+		if !dns.EqualName(name, s.ChallengeName()) {
+			s.applyGeneric(ctx, rr)
+			continue
+		}
+		switch rr.Header().Class {
+		case dns.ClassINET:
+			value := strings.Join(rr.(*dns.TXT).Txt, "")
+			s.Store.setFor(s.ChallengeName(), value, 0, RequestID(ctx))
+			s.recordSet(value, s.TsigName)
+			slog.InfoContext(ctx, "update: added _acme-challenge TXT")
+
+		case dns.ClassNONE:
+			// Only delete the value named, so that the cleanup of an earlier
+			// challenge doesn't remove the token of the next one.
+			value := strings.Join(rr.(*dns.TXT).Txt, "")
+			if value != "" && s.Store.deleteFor(s.ChallengeName(), value, RequestID(ctx)) {
+				slog.InfoContext(ctx, "update: deleted _acme-challenge TXT")
+			} else {
+				slog.InfoContext(ctx, "update: _acme-challenge TXT to delete not present")
+			}
+
+		case dns.ClassANY:
+			s.Store.deleteFor(s.ChallengeName(), "", RequestID(ctx))
+			slog.InfoContext(ctx, "update: deleted _acme-challenge TXT (class ANY)")
+		}
+	}
+}
+
+// applyGeneric applies an update of the TXT record of a genericName.
+func (s *Server) applyGeneric(ctx context.Context, rr dns.RR) {
+	name := rr.Header().Name
+	switch rr.Header().Class {
+	case dns.ClassINET:
+		s.Store.setFor(name, strings.Join(rr.(*dns.TXT).Txt, ""), 0, RequestID(ctx))
+		slog.InfoContext(ctx, "update: added TXT", "name", name)
+	case dns.ClassNONE:
+		if s.Store.deleteFor(name, strings.Join(rr.(*dns.TXT).Txt, ""), RequestID(ctx)) {
+			slog.InfoContext(ctx, "update: deleted TXT", "name", name)
+		}
+	case dns.ClassANY:
+		s.Store.deleteFor(name, "", RequestID(ctx))
+		slog.InfoContext(ctx, "update: deleted TXT (class ANY)", "name", name)
+	}
+}
+
+// records returns the records at name, as far as prerequisites can refer
+// to them.
+func (s *Server) records(name string) []dns.RR {
+	m := new(dns.Msg)
+	if dns.EqualName(name, s.ChallengeName()) || s.genericName(name) {
+		for _, e := range s.Store.Get(name) {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.Header{Name: name, Class: dns.ClassINET},
+				TXT: rdata.TXT{Txt: []string{e.Value}},
+			})
+		}
+	}
+	if sub, ok := s.registrySubdomain(name); ok {
+		txt, _ := s.Registry.lookup(sub)
+		for _, val := range txt {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.Header{Name: name, Class: dns.ClassINET},
+				TXT: rdata.TXT{Txt: []string{val}},
+			})
+		}
+	}
+	if cname := s.cname(name); cname != nil {
+		m.Answer = append(m.Answer, cname)
+	}
+	if dns.EqualName(name, s.Zone) {
+		s.answerCAA(m)
+	}
+	s.answerZone(m, name, dns.TypeANY)
+	return m.Answer
+}
+
+// hasType reports whether rrs has a record of type rrtype.
+func hasType(rrs []dns.RR, rrtype uint16) bool {
+	for _, rr := range rrs {
+		if dns.RRToType(rr) == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRRset reports whether a and b hold the same records, ignoring TTLs
+// and how TXT values are split into strings.
+func sameRRset(a, b []dns.RR) bool {
+	contains := func(rrs []dns.RR, rr dns.RR) bool {
+		for _, other := range rrs {
+			if sameRR(rr, other) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rr := range a {
+		if !contains(b, rr) {
+			return false
+		}
+	}
+	for _, rr := range b {
+		if !contains(a, rr) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameRR(a, b dns.RR) bool {
+	at, aok := a.(*dns.TXT)
+	bt, bok := b.(*dns.TXT)
+	if aok && bok {
+		return dns.EqualName(at.Hdr.Name, bt.Hdr.Name) && strings.Join(at.Txt, "") == strings.Join(bt.Txt, "")
+	}
+	return dns.Equal(a, b)
+}
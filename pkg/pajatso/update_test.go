@@ -0,0 +1,270 @@
+package pajatso
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// sendUpdateMsg TSIG-signs and sends the update m.
+func sendUpdateMsg(t *testing.T, addr string, m *dns.Msg) *dns.Msg {
+	t.Helper()
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+		t.Fatalf("TSIG sign failed: %v", err)
+	}
+	r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	return r
+}
+
+func TestUpdateRcodes(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+
+	set := func(value string) dns.RR {
+		rr, _ := dns.New(testChallenge + " 60 IN TXT \"" + value + "\"")
+		return rr
+	}
+	prereq := func(class, rrtype uint16, txt ...string) dns.RR {
+		hdr := dns.Header{Name: testChallenge, Class: class}
+		if rrtype == dns.TypeANY {
+			return &dns.ANY{Hdr: hdr}
+		}
+		return &dns.TXT{Hdr: hdr, TXT: rdata.TXT{Txt: txt}}
+	}
+
+	tests := []struct {
+		name    string
+		zone    string
+		prereqs []dns.RR
+		updates []dns.RR
+		stored  string // value set before the update, none if empty
+		want    uint16
+	}{
+		{name: "wrong zone", zone: "example.org.", updates: []dns.RR{set("a")}, want: dns.RcodeNotZone},
+		{name: "name outside zone", updates: []dns.RR{must(dns.New("x.example.org. 60 IN TXT \"a\""))}, want: dns.RcodeNotZone},
+		{name: "wrong name", updates: []dns.RR{must(dns.New("x.example.com. 60 IN TXT \"a\""))}, want: dns.RcodeRefused},
+		{name: "delete with TTL", updates: []dns.RR{&dns.ANY{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassANY, TTL: 60}}}, want: dns.RcodeFormatError},
+		{name: "unknown class", updates: []dns.RR{&dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassCHAOS}, TXT: rdata.TXT{Txt: []string{"a"}}}}, want: dns.RcodeFormatError},
+		{name: "atomic", updates: []dns.RR{set("a"), must(dns.New(testChallenge + " 60 IN A 192.0.2.1"))}, want: dns.RcodeRefused},
+
+		{name: "name in use", prereqs: []dns.RR{prereq(dns.ClassANY, dns.TypeANY)}, stored: "old", updates: []dns.RR{set("a")}, want: dns.RcodeSuccess},
+		{name: "name not in use", prereqs: []dns.RR{prereq(dns.ClassANY, dns.TypeANY)}, updates: []dns.RR{set("a")}, want: dns.RcodeNameError},
+		{name: "rrset missing", prereqs: []dns.RR{prereq(dns.ClassANY, dns.TypeTXT)}, updates: []dns.RR{set("a")}, want: dns.RcodeNXRrset},
+		{name: "rrset must not exist", prereqs: []dns.RR{prereq(dns.ClassNONE, dns.TypeTXT)}, stored: "old", updates: []dns.RR{set("a")}, want: dns.RcodeYXRrset},
+		{name: "name must not exist", prereqs: []dns.RR{prereq(dns.ClassNONE, dns.TypeANY)}, stored: "old", updates: []dns.RR{set("a")}, want: dns.RcodeYXDomain},
+		{name: "value matches", prereqs: []dns.RR{prereq(dns.ClassINET, dns.TypeTXT, "old")}, stored: "old", updates: []dns.RR{set("a")}, want: dns.RcodeSuccess},
+		{name: "value differs", prereqs: []dns.RR{prereq(dns.ClassINET, dns.TypeTXT, "other")}, stored: "old", updates: []dns.RR{set("a")}, want: dns.RcodeNXRrset},
+		{name: "empty value, rrset exists", prereqs: []dns.RR{prereq(dns.ClassINET, dns.TypeTXT)}, stored: "old", updates: []dns.RR{set("a")}, want: dns.RcodeSuccess},
+		{name: "empty value, rrset missing", prereqs: []dns.RR{prereq(dns.ClassINET, dns.TypeTXT)}, updates: []dns.RR{set("a")}, want: dns.RcodeNXRrset},
+		{name: "zone at the record", zone: testChallenge, updates: []dns.RR{set("a")}, want: dns.RcodeSuccess},
+		{name: "zone beside the record", zone: "www.example.com.", updates: []dns.RR{set("a")}, want: dns.RcodeNotZone},
+		{name: "prerequisite with TTL", prereqs: []dns.RR{set("old")}, stored: "old", updates: []dns.RR{set("a")}, want: dns.RcodeFormatError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.Clear()
+			if tt.stored != "" {
+				store.Replace(tt.stored)
+			}
+			zone := tt.zone
+			if zone == "" {
+				zone = testZone
+			}
+			m := makeUpdateMsg(t, zone, tt.updates, testTsigName, testTsigSecret)
+			m.Answer = tt.prereqs
+
+			r := sendUpdateMsg(t, addr, m)
+			if r.Rcode != tt.want {
+				t.Fatalf("expected %s, got %s", dns.RcodeToString[tt.want], dns.RcodeToString[r.Rcode])
+			}
+			val, _ := store.Value()
+			if tt.want == dns.RcodeSuccess && val != "a" {
+				t.Errorf("update not applied, have %q", val)
+			} else if tt.want != dns.RcodeSuccess && val != tt.stored {
+				t.Errorf("rejected update changed the value to %q", val)
+			}
+		})
+	}
+}
+
+// slowClock is the system clock, slowed down to widen the windows between
+// reading and changing the Store.
+type slowClock struct{ Clock }
+
+func (c slowClock) Now() time.Time {
+	time.Sleep(time.Millisecond)
+	return c.Clock.Now()
+}
+
+func TestUpdateSerialized(t *testing.T) {
+	addr, store, cleanup := startTestServerFor(t, &Server{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		Store:      &Store{Clock: slowClock{SystemClock}},
+	})
+	defer cleanup()
+
+	// Of concurrent updates requiring the record not to exist, only one
+	// may pass the prerequisite and be applied.
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	var msgs []*dns.Msg
+	for i := range 16 {
+		m := makeUpdateMsg(t, testZone, []dns.RR{must(dns.New(fmt.Sprintf("%s 60 IN TXT \"token-%d\"", testChallenge, i)))}, testTsigName, testTsigSecret)
+		m.Answer = []dns.RR{&dns.TXT{Hdr: dns.Header{Name: testChallenge, Class: dns.ClassNONE}}}
+		if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, m)
+	}
+	rcodes := make(chan uint16, len(msgs))
+	var wg sync.WaitGroup
+	for _, m := range msgs {
+		wg.Go(func() {
+			r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rcodes <- r.Rcode
+		})
+	}
+	wg.Wait()
+	close(rcodes)
+
+	applied := 0
+	for rcode := range rcodes {
+		if rcode == dns.RcodeSuccess {
+			applied++
+		}
+	}
+	if n := len(store.Entries()); applied != 1 || n != 1 {
+		t.Fatalf("expected one update applied, got %d with %d values stored", applied, n)
+	}
+}
+
+func TestUpdateDeleteOtherValue(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+
+	store.Replace("new-token")
+
+	// Cleaning up an earlier challenge leaves the current token alone.
+	rr := &dns.TXT{
+		Hdr: dns.Header{Name: testChallenge, Class: dns.ClassNONE},
+		TXT: rdata.TXT{Txt: []string{"old-token"}},
+	}
+	r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret)
+	if r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if val, ok := store.Value(); !ok || val != "new-token" {
+		t.Fatalf("expected new-token to remain, got (%q, %v)", val, ok)
+	}
+}
+
+func must(rr dns.RR, err error) dns.RR {
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+func TestUpdateStrictTokens(t *testing.T) {
+	addr, store, cleanup := startTestServerFor(t, &Server{
+		Zone:         testZone,
+		TsigName:     testTsigName,
+		TsigSecret:   testTsigSecret,
+		StrictTokens: true,
+		Store:        &Store{},
+	})
+	defer cleanup()
+
+	for value, want := range map[string]uint16{
+		"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0":  dns.RcodeSuccess,
+		"v=spf1 include:evil.example -all":             dns.RcodeRefused,
+		"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0=": dns.RcodeRefused,
+	} {
+		store.Clear()
+		rr := &dns.TXT{
+			Hdr: dns.Header{Name: testChallenge, Class: dns.ClassINET, TTL: 60},
+			TXT: rdata.TXT{Txt: []string{value}},
+		}
+		r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret)
+		if r.Rcode != want {
+			t.Errorf("%q: expected %s, got %s", value, dns.RcodeToString[want], dns.RcodeToString[r.Rcode])
+		}
+		if _, ok := store.Value(); ok != (want == dns.RcodeSuccess) {
+			t.Errorf("%q: stored %v", value, ok)
+		}
+	}
+}
+
+func TestUpdateInvalidValue(t *testing.T) {
+	addr, store, cleanup := startTestServerFor(t, &Server{
+		Zone:           testZone,
+		TsigName:       testTsigName,
+		TsigSecret:     testTsigSecret,
+		MaxTokenLength: 16,
+		Store:          &Store{},
+	})
+	defer cleanup()
+
+	for _, value := range []string{"0123456789abcdefg", "line\nbreak", "nul\x00", "del\x7f"} {
+		rr := &dns.TXT{
+			Hdr: dns.Header{Name: testChallenge, Class: dns.ClassINET, TTL: 60},
+			TXT: rdata.TXT{Txt: []string{value}},
+		}
+		r := sendUpdate(t, addr, testZone, []dns.RR{rr}, testTsigName, testTsigSecret)
+		if r.Rcode != dns.RcodeFormatError {
+			t.Errorf("%q: expected FORMERR, got %s", value, dns.RcodeToString[r.Rcode])
+		}
+		if _, ok := store.Value(); ok {
+			t.Fatalf("%q: value stored", value)
+		}
+	}
+}
+
+func TestUpdateMultipleValues(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+
+	// A certificate for a domain and its wildcard needs two tokens.
+	domain, _ := dns.New(testChallenge + " 60 IN TXT \"domain-token\"")
+	wildcard, _ := dns.New(testChallenge + " 60 IN TXT \"wildcard-token\"")
+	r := sendUpdate(t, addr, testZone, []dns.RR{domain, wildcard}, testTsigName, testTsigSecret)
+	if r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+
+	r = query(t, addr, testChallenge, dns.TypeTXT)
+	var values []string
+	for _, rr := range r.Answer {
+		values = append(values, rr.(*dns.TXT).Txt...)
+	}
+	if len(values) != 2 || values[0] != "wildcard-token" || values[1] != "domain-token" {
+		t.Fatalf("expected both tokens, got %q", values)
+	}
+
+	// Cleaning up one challenge leaves the other.
+	del := &dns.TXT{
+		Hdr: dns.Header{Name: testChallenge, Class: dns.ClassNONE},
+		TXT: rdata.TXT{Txt: []string{"domain-token"}},
+	}
+	if r := sendUpdate(t, addr, testZone, []dns.RR{del}, testTsigName, testTsigSecret); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if entries := store.Entries(); len(entries) != 1 || entries[0].Value != "wildcard-token" {
+		t.Fatalf("expected [wildcard-token], got %+v", entries)
+	}
+}
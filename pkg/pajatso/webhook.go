@@ -0,0 +1,140 @@
+package pajatso
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"codeberg.org/miekg/dns"
+)
+
+// webhookSolverName is the solver name to reference in a cert-manager issuer:
+//
+//	dns01:
+//	  webhook:
+//	    groupName: <group>
+//	    solverName: dns-pajatso
+const webhookSolverName = "dns-pajatso"
+
+// challengeReview mirrors the subset of cert-manager's
+// webhook.acme.cert-manager.io/v1alpha1 ChallengeReview that is used here.
+type challengeReview struct {
+	APIVersion string                   `json:"apiVersion"`
+	Kind       string                   `json:"kind"`
+	Request    *challengeReviewRequest  `json:"request,omitempty"`
+	Response   *challengeReviewResponse `json:"response,omitempty"`
+}
+
+type challengeReviewRequest struct {
+	UID          string `json:"uid"`
+	Action       string `json:"action"` // "Present" or "CleanUp"
+	Type         string `json:"type"`   // "dns-01"
+	DNSName      string `json:"dnsName"`
+	Key          string `json:"key"`
+	ResolvedFQDN string `json:"resolvedFQDN"`
+	ResolvedZone string `json:"resolvedZone"`
+}
+
+type challengeReviewResponse struct {
+	UID     string         `json:"uid"`
+	Success bool           `json:"success"`
+	Status  *webhookStatus `json:"status,omitempty"`
+}
+
+// webhookStatus mirrors the fields of a Kubernetes metav1.Status used for errors.
+type webhookStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// WebhookHandler returns an http.Handler implementing the cert-manager DNS01
+// webhook solver API for the given API group. It is meant to be served over
+// TLS behind the Kubernetes API aggregation layer, registered with an
+// APIService for <group>/v1alpha1.
+func (s *Server) WebhookHandler(group string) http.Handler {
+	s.Store.bind(s.ChallengeName())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("GET /apis/"+group, func(w http.ResponseWriter, r *http.Request) {
+		version := map[string]string{"groupVersion": group + "/v1alpha1", "version": "v1alpha1"}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"kind":             "APIGroup",
+			"apiVersion":       "v1",
+			"name":             group,
+			"versions":         []any{version},
+			"preferredVersion": version,
+		})
+	})
+	mux.HandleFunc("GET /apis/"+group+"/v1alpha1", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"kind":         "APIResourceList",
+			"apiVersion":   "v1",
+			"groupVersion": group + "/v1alpha1",
+			"resources": []any{map[string]any{
+				"name":       webhookSolverName,
+				"kind":       "ChallengeReview",
+				"namespaced": false,
+				"verbs":      []string{"create"},
+			}},
+		})
+	})
+	mux.HandleFunc("POST /apis/"+group+"/v1alpha1/"+webhookSolverName, s.webhookSolve)
+	return httpRequestID(mux)
+}
+
+// webhookSolve handles a ChallengeReview by presenting or cleaning up the
+// challenge record.
+func (s *Server) webhookSolve(w http.ResponseWriter, r *http.Request) {
+	var review challengeReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		writeJSON(w, http.StatusBadRequest, webhookStatus{Status: "Failure", Message: "malformed ChallengeReview"})
+		return
+	}
+	req := review.Request
+
+	review.Response = &challengeReviewResponse{UID: req.UID, Success: true}
+	review.Request = nil
+
+	fail := func(msg string, args ...any) {
+		slog.WarnContext(r.Context(), "webhook refused: "+msg, args...)
+		review.Response.Success = false
+		review.Response.Status = &webhookStatus{Status: "Failure", Message: msg}
+		writeJSON(w, http.StatusOK, review)
+	}
+
+	if req.Type != "dns-01" {
+		fail("unsupported challenge type", "type", req.Type)
+		return
+	}
+	if !dns.EqualName(req.ResolvedFQDN, s.ChallengeName()) {
+		fail("wrong name", "name", req.ResolvedFQDN, "expected", s.ChallengeName())
+		return
+	}
+	if s.readOnly() {
+		fail(errReadOnly.Error())
+		return
+	}
+
+	switch req.Action {
+	case "Present":
+		if err := s.checkToken(req.Key); err != nil {
+			fail(err.Error())
+			return
+		}
+		s.Store.setFor(s.ChallengeName(), req.Key, 0, RequestID(r.Context()))
+		s.recordSet(req.Key, "webhook")
+		slog.InfoContext(r.Context(), "webhook: set _acme-challenge TXT", "dnsName", req.DNSName)
+	case "CleanUp":
+		// Leave other tokens for the same name in place.
+		if req.Key != "" && s.Store.deleteFor(s.ChallengeName(), req.Key, RequestID(r.Context())) {
+			slog.InfoContext(r.Context(), "webhook: deleted _acme-challenge TXT", "dnsName", req.DNSName)
+		}
+	default:
+		fail("unknown action", "action", req.Action)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, review)
+}
@@ -0,0 +1,97 @@
+package pajatso
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testWebhookGroup = "acme.example.com"
+
+func solve(t *testing.T, h http.Handler, action, fqdn, key string) challengeReviewResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(challengeReview{
+		APIVersion: "webhook.acme.cert-manager.io/v1alpha1",
+		Kind:       "ChallengeReview",
+		Request: &challengeReviewRequest{
+			UID:          "uid-1",
+			Action:       action,
+			Type:         "dns-01",
+			DNSName:      "example.com",
+			Key:          key,
+			ResolvedFQDN: fqdn,
+			ResolvedZone: testZone,
+		},
+	})
+	req := httptest.NewRequest("POST", "/apis/"+testWebhookGroup+"/v1alpha1/"+webhookSolverName, strings.NewReader(string(body)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var review challengeReview
+	if err := json.NewDecoder(rec.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response == nil || review.Response.UID != "uid-1" {
+		t.Fatalf("unexpected response %+v", review.Response)
+	}
+	return *review.Response
+}
+
+func TestWebhookPresentCleanUp(t *testing.T) {
+	store := &Store{}
+	h := (&Server{Zone: testZone, Store: store}).WebhookHandler(testWebhookGroup)
+
+	if resp := solve(t, h, "Present", testChallenge, "webhook-token"); !resp.Success {
+		t.Fatalf("present: expected success, got %+v", resp.Status)
+	}
+	if val, ok := store.Value(); !ok || val != "webhook-token" {
+		t.Fatalf("present: expected (webhook-token, true), got (%q, %v)", val, ok)
+	}
+
+	// Cleaning up a different key must leave the current token alone.
+	if resp := solve(t, h, "CleanUp", testChallenge, "other-token"); !resp.Success {
+		t.Fatalf("cleanup other: expected success, got %+v", resp.Status)
+	}
+	if _, ok := store.Value(); !ok {
+		t.Fatal("cleanup other: expected record to be kept")
+	}
+
+	if resp := solve(t, h, "CleanUp", testChallenge, "webhook-token"); !resp.Success {
+		t.Fatalf("cleanup: expected success, got %+v", resp.Status)
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("cleanup: expected record to be deleted")
+	}
+}
+
+func TestWebhookWrongName(t *testing.T) {
+	store := &Store{}
+	h := (&Server{Zone: testZone, Store: store}).WebhookHandler(testWebhookGroup)
+
+	if resp := solve(t, h, "Present", "_acme-challenge.other.com.", "bad"); resp.Success {
+		t.Fatal("expected failure")
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("expected no record to be set")
+	}
+}
+
+func TestWebhookDiscovery(t *testing.T) {
+	h := (&Server{Zone: testZone, Store: &Store{}}).WebhookHandler(testWebhookGroup)
+
+	req := httptest.NewRequest("GET", "/apis/"+testWebhookGroup+"/v1alpha1", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"name":"`+webhookSolverName+`"`) {
+		t.Fatalf("solver missing from discovery: %s", rec.Body.String())
+	}
+}
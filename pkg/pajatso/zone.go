@@ -0,0 +1,293 @@
+package pajatso
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/dnsutil"
+	"codeberg.org/miekg/dns/rdata"
+)
+
+// SOA timers of the synthesized SOA record. The zone has no secondaries, so
+// refresh, retry and expire are nominal; the minimum is the negative TTL,
+// unless set with Server.NegativeTTL.
+const (
+	soaTTL     = 3600
+	soaRefresh = 3600
+	soaRetry   = 600
+	soaExpire  = 604800
+	soaMinimum = 60
+)
+
+// DefaultNegativeTTL is the SOA minimum if Server.NegativeTTL is zero, short
+// so that a token set after a resolver saw it missing is seen soon.
+const DefaultNegativeTTL = soaMinimum * time.Second
+
+// maxNegativeTTL is the longest negative TTL accepted, the maximum
+// recommended by RFC 2308.
+const maxNegativeTTL = 3 * time.Hour
+
+// ParseZoneFile parses static records in RFC 1035 zone file format, with
+// relative names below zone. The records are validated by NewServer.
+func ParseZoneFile(r io.Reader, zone, file string) ([]dns.RR, error) {
+	var rrs []dns.RR
+	zp := dns.NewZoneParser(r, dnsutil.Fqdn(zone), file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return rrs, nil
+}
+
+// checkStatic validates the static records of a zone: they must be below it,
+// the SOA, apex NS and challenge records are synthesized, and CNAME records
+// can't have other records at their name.
+func checkStatic(rrs []dns.RR, zone, challenge string) error {
+	for _, rr := range rrs {
+		name := rr.Header().Name
+		switch {
+		case !dnsutil.IsBelow(zone, name):
+			return fmt.Errorf("static record %q outside zone %s", rr, zone)
+		case dns.EqualName(name, challenge):
+			return fmt.Errorf("static record %q at the challenge name", rr)
+		case dns.RRToType(rr) == dns.TypeSOA:
+			return fmt.Errorf("static SOA record %q, the SOA is synthesized", rr)
+		case dns.RRToType(rr) == dns.TypeNS && dns.EqualName(name, zone):
+			return fmt.Errorf("static apex NS record %q, set the name servers instead", rr)
+		case dns.RRToType(rr) == dns.TypeCNAME && dns.EqualName(name, zone):
+			return fmt.Errorf("static CNAME record %q at the zone apex", rr)
+		}
+		if dns.RRToType(rr) != dns.TypeCNAME {
+			continue
+		}
+		for _, other := range rrs {
+			if other != rr && dns.EqualName(other.Header().Name, name) {
+				return fmt.Errorf("static CNAME record %q with other records at its name", rr)
+			}
+		}
+	}
+	return nil
+}
+
+// hostmaster returns the SOA RNAME of s.
+func (s *Server) hostmaster() string {
+	if s.Hostmaster == "" {
+		return "hostmaster." + s.Zone
+	}
+	return s.Hostmaster
+}
+
+// soaRecords are the synthesized SOA records for a Store serial, with the
+// TTLs of answers and of negative answers.
+type soaRecords struct {
+	serial           uint32
+	answer, negative *dns.SOA
+}
+
+// zoneRecords are the synthesized records that don't change while serving,
+// built once rather than for every query.
+type zoneRecords struct {
+	ns      []dns.RR            // apex NS records
+	glue    map[string][]dns.RR // addresses of the name servers in the zone, by NS
+	nsAddrs []dns.RR            // A and AAAA records of NS[0]
+}
+
+// negativeTTL returns the SOA minimum of the zone in seconds.
+func (s *Server) negativeTTL() uint32 {
+	if s.NegativeTTL == 0 {
+		return soaMinimum
+	}
+	return uint32(s.NegativeTTL.Seconds())
+}
+
+// soa returns the synthesized SOA record with the given TTL, soaTTL or
+// negativeTTL. The records are only rebuilt when the Store's serial changes.
+func (s *Server) soa(ttl uint32) *dns.SOA {
+	serial := s.Store.Serial()
+	c := s.soaCache.Load()
+	if c == nil || c.serial != serial {
+		c = &soaRecords{serial: serial, answer: s.newSOA(soaTTL, serial), negative: s.newSOA(s.negativeTTL(), serial)}
+		s.soaCache.Store(c)
+	}
+	if ttl == s.negativeTTL() {
+		return c.negative
+	}
+	return c.answer
+}
+
+// newSOA builds the SOA record with the given TTL. The serial combines the
+// start time with the Store's serial, so it grows with every change and
+// across restarts.
+func (s *Server) newSOA(ttl, serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.Header{Name: s.Zone, Class: dns.ClassINET, TTL: ttl},
+		SOA: rdata.SOA{
+			Ns:      s.NS[0],
+			Mbox:    s.hostmaster(),
+			Serial:  uint32(s.started.Unix()) + serial,
+			Refresh: soaRefresh,
+			Retry:   soaRetry,
+			Expire:  soaExpire,
+			Minttl:  s.negativeTTL(),
+		},
+	}
+}
+
+// answerZone adds the synthesized SOA and NS records and the static records
+// matching qname and qtype to m. Static CNAME records are added by
+// handleQuery.
+func (s *Server) answerZone(m *dns.Msg, qname string, qtype uint16) {
+	apex := dns.EqualName(qname, s.Zone)
+	if len(s.NS) > 0 && apex && (qtype == dns.TypeSOA || qtype == dns.TypeANY) {
+		m.Answer = append(m.Answer, s.soa(soaTTL))
+	}
+	zr := s.zoneRecords()
+	if apex && (qtype == dns.TypeNS || qtype == dns.TypeANY) {
+		m.Answer = append(m.Answer, zr.ns...)
+		for _, ns := range s.NS {
+			m.Extra = append(m.Extra, zr.glue[ns]...)
+		}
+	}
+	if len(s.NS) > 0 && dns.EqualName(qname, s.NS[0]) {
+		for _, rr := range zr.nsAddrs {
+			if qtype == dns.TypeANY || dns.RRToType(rr) == qtype {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+	}
+	for _, rr := range s.Static {
+		t := dns.RRToType(rr)
+		if t != dns.TypeCNAME && dns.EqualName(rr.Header().Name, qname) && (qtype == dns.TypeANY || t == qtype) {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+}
+
+// zoneRecords returns the synthesized NS and address records of s, built on
+// first use.
+func (s *Server) zoneRecords() *zoneRecords {
+	s.zoneOnce.Do(func() {
+		zr := &zoneRecords{glue: map[string][]dns.RR{}}
+		for _, ns := range s.NS {
+			zr.ns = append(zr.ns, &dns.NS{
+				Hdr: dns.Header{Name: s.Zone, Class: dns.ClassINET, TTL: soaTTL},
+				NS:  rdata.NS{Ns: ns},
+			})
+			zr.glue[ns] = s.glue(ns)
+		}
+		if len(s.NS) > 0 {
+			zr.nsAddrs = s.nsAddrs()
+		}
+		s.zone = zr
+	})
+	return s.zone
+}
+
+// nsAddrs returns the A and AAAA records of the primary name server NS[0]
+// from NSAddrs.
+func (s *Server) nsAddrs() []dns.RR {
+	var rrs []dns.RR
+	for _, addr := range s.NSAddrs {
+		hdr := dns.Header{Name: s.NS[0], Class: dns.ClassINET, TTL: soaTTL}
+		if addr.Is4() {
+			rrs = append(rrs, &dns.A{Hdr: hdr, A: rdata.A{Addr: addr}})
+		} else {
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr, AAAA: rdata.AAAA{Addr: addr}})
+		}
+	}
+	return rrs
+}
+
+// glue returns the A and AAAA records of the name server ns if it is in
+// the zone, from NSAddrs and the static records.
+func (s *Server) glue(ns string) []dns.RR {
+	if !dnsutil.IsBelow(s.Zone, ns) {
+		return nil
+	}
+	var rrs []dns.RR
+	if dns.EqualName(ns, s.NS[0]) {
+		rrs = s.nsAddrs()
+	}
+	for _, rr := range s.Static {
+		if t := dns.RRToType(rr); (t == dns.TypeA || t == dns.TypeAAAA) && dns.EqualName(rr.Header().Name, ns) {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// addNegativeSOA adds the SOA to the authority section of an empty answer
+// for a name in the zone, so that resolvers cache it for the SOA minimum.
+// With NXDomain, names that don't exist are answered with NXDOMAIN.
+func (s *Server) addNegativeSOA(m *dns.Msg, qname string) {
+	if len(m.Answer) > 0 || !dnsutil.IsBelow(s.Zone, qname) {
+		return
+	}
+	if s.NXDomain && !s.nameExists(qname) {
+		m.Rcode = dns.RcodeNameError
+	}
+	if len(s.NS) > 0 {
+		m.Ns = append(m.Ns, s.soa(s.negativeTTL()))
+	}
+}
+
+// nameExists reports whether the name in the zone has records or names
+// below it do, making it an empty non-terminal. The challenge name always
+// exists, so that resolvers asking before a token is set don't cache that
+// the name is missing, which would also hide the token added later.
+func (s *Server) nameExists(name string) bool {
+	names := append([]string{s.Zone, s.ChallengeName(), s.healthName()}, s.NS...)
+	for owner := range s.CNAME {
+		names = append(names, owner)
+	}
+	for _, rr := range s.Static {
+		names = append(names, rr.Header().Name)
+	}
+	if s.GenericTXT {
+		for _, e := range s.Store.List() {
+			names = append(names, e.Name)
+		}
+	}
+	for _, n := range names {
+		if n != "" && dnsutil.IsBelow(name, n) {
+			return true
+		}
+	}
+	return s.isRegistered(name)
+}
+
+// addAuthorityNS adds the NS records of the zone to the authority section of
+// a positive answer for a name in the zone with AuthorityNS, and their glue
+// not in the answer already to the additional section.
+func (s *Server) addAuthorityNS(m *dns.Msg, qname string) {
+	if !s.AuthorityNS || len(s.NS) == 0 || len(m.Answer) == 0 || !dnsutil.IsBelow(s.Zone, qname) {
+		return
+	}
+	zr := s.zoneRecords()
+	if containsRR(m.Answer, zr.ns[0]) {
+		return // an NS answer
+	}
+	m.Ns = append(m.Ns, zr.ns...)
+	for _, ns := range s.NS {
+		for _, rr := range zr.glue[ns] {
+			if !containsRR(m.Answer, rr) && !containsRR(m.Extra, rr) {
+				m.Extra = append(m.Extra, rr)
+			}
+		}
+	}
+}
+
+// containsRR reports whether rrs contains a record equal to rr.
+func containsRR(rrs []dns.RR, rr dns.RR) bool {
+	return slices.ContainsFunc(rrs, func(x dns.RR) bool { return dns.Equal(x, rr) })
+}
+
+// static reports whether s has static records at name.
+func (s *Server) static(name string) bool {
+	return hasName(s.Static, name)
+}
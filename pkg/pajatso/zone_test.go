@@ -0,0 +1,206 @@
+package pajatso
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+)
+
+const testZoneFile = `$TTL 300
+ns1   IN A    192.0.2.53
+ns1   IN AAAA 2001:db8::53
+@     IN MX   10 mail
+@     IN TXT  "v=spf1 -all"
+`
+
+func TestParseZoneFile(t *testing.T) {
+	rrs, err := ParseZoneFile(strings.NewReader(testZoneFile), "Example.COM", "test.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rrs) != 4 || !dns.EqualName(rrs[0].Header().Name, "ns1.example.com.") || rrs[0].Header().TTL != 300 {
+		t.Fatalf("unexpected records %v", rrs)
+	}
+	if _, err := ParseZoneFile(strings.NewReader("ns1 IN A not-an-address\n"), testZone, "bad.zone"); err == nil {
+		t.Fatal("expected an error for an invalid record")
+	}
+}
+
+func TestCheckStatic(t *testing.T) {
+	for name, rr := range map[string]string{
+		"outside zone": "ns1.example.org. IN A 192.0.2.1",
+		"challenge":    testChallenge + " IN TXT \"x\"",
+		"soa":          testZone + " IN SOA ns1 hostmaster 1 2 3 4 5",
+		"apex ns":      testZone + " IN NS ns1." + testZone,
+	} {
+		r, err := dns.New(rr)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := NewServer(Config{Zone: testZone, Static: []dns.RR{r}}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestZoneQueries(t *testing.T) {
+	static, err := ParseZoneFile(strings.NewReader(testZoneFile), testZone, "test.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(Config{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		NS:         []string{"ns1." + testZone, "ns2.example.net"},
+		Static:     static,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	r := query(t, addr, testZone, dns.TypeSOA)
+	soa, ok := r.Answer[0].(*dns.SOA)
+	if len(r.Answer) != 1 || !ok || soa.Ns != "ns1."+testZone || soa.Mbox != "hostmaster."+testZone {
+		t.Fatalf("unexpected SOA answer %v", r.Answer)
+	}
+	// The serial follows changes to the Store.
+	srv.Store.Replace("token")
+	if r := query(t, addr, testZone, dns.TypeSOA); r.Answer[0].(*dns.SOA).Serial != soa.Serial+1 {
+		t.Fatalf("expected serial %d, got %v", soa.Serial+1, r.Answer)
+	}
+	if r := query(t, addr, testZone, dns.TypeNS); len(r.Answer) != 2 {
+		t.Fatalf("expected 2 NS records, got %v", r.Answer)
+	}
+	if r := query(t, addr, "ns1."+testZone, dns.TypeAAAA); len(r.Answer) != 1 {
+		t.Fatalf("expected the static AAAA record, got %v", r.Answer)
+	}
+	if r := query(t, addr, testZone, dns.TypeMX); len(r.Answer) != 1 {
+		t.Fatalf("expected the static MX record, got %v", r.Answer)
+	}
+
+	// Empty answers in the zone carry the SOA for negative caching.
+	r = query(t, addr, "unknown."+testZone, dns.TypeA)
+	if len(r.Answer) != 0 || len(r.Ns) != 1 || r.Ns[0].Header().TTL != soaMinimum {
+		t.Fatalf("expected the SOA in the authority section, got %v", r.Ns)
+	}
+	if r := query(t, addr, "example.org.", dns.TypeA); len(r.Ns) != 0 {
+		t.Fatalf("expected no SOA outside the zone, got %v", r.Ns)
+	}
+}
+
+func TestGlue(t *testing.T) {
+	static, _ := ParseZoneFile(strings.NewReader("ns2 IN A 192.0.2.54\n"), testZone, "test.zone")
+	srv, err := NewServer(Config{
+		Zone:       testZone,
+		TsigName:   testTsigName,
+		TsigSecret: testTsigSecret,
+		NS:         []string{"ns1." + testZone, "ns2." + testZone, "ns.example.net."},
+		NSAddrs:    []netip.Addr{netip.MustParseAddr("192.0.2.53"), netip.MustParseAddr("2001:db8::53")},
+		Static:     static,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	r := query(t, addr, "ns1."+testZone, dns.TypeA)
+	if a, ok := r.Answer[0].(*dns.A); len(r.Answer) != 1 || !ok || a.Addr != netip.MustParseAddr("192.0.2.53") {
+		t.Fatalf("unexpected A answer %v", r.Answer)
+	}
+	if r := query(t, addr, "ns1."+testZone, dns.TypeAAAA); len(r.Answer) != 1 {
+		t.Fatalf("expected the AAAA record, got %v", r.Answer)
+	}
+
+	// Glue for the in-zone name servers only.
+	r = query(t, addr, testZone, dns.TypeNS)
+	if len(r.Answer) != 3 || len(r.Extra) != 3 {
+		t.Fatalf("expected 3 NS records with 3 glue records, got %v and %v", r.Answer, r.Extra)
+	}
+
+	if _, err := NewServer(Config{Zone: testZone, NS: []string{"ns.example.net"}, NSAddrs: srv.NSAddrs}); err == nil {
+		t.Fatal("expected an error for addresses of an out-of-zone name server")
+	}
+}
+
+func TestNXDomain(t *testing.T) {
+	static, _ := ParseZoneFile(strings.NewReader("a.b IN A 192.0.2.1\n"), testZone, "test.zone")
+	srv, err := NewServer(Config{
+		Zone:        testZone,
+		NS:          []string{"ns.example.net."},
+		Static:      static,
+		NXDomain:    true,
+		NegativeTTL: 5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+
+	for name, want := range map[string]uint16{
+		"unknown." + testZone:         dns.RcodeNameError,
+		"x.a.b." + testZone:           dns.RcodeNameError,
+		"a.b." + testZone:             dns.RcodeSuccess,
+		"b." + testZone:               dns.RcodeSuccess, // empty non-terminal
+		testZone:                      dns.RcodeSuccess,
+		"_ACME-challenge." + testZone: dns.RcodeSuccess, // no token set yet
+	} {
+		r := query(t, addr, name, dns.TypeTXT)
+		if r.Rcode != want {
+			t.Errorf("%s: expected %s, got %s", name, dns.RcodeToString[want], dns.RcodeToString[r.Rcode])
+		}
+		if len(r.Ns) != 1 {
+			t.Fatalf("%s: expected the SOA, got %v", name, r.Ns)
+		}
+		if soa := r.Ns[0].(*dns.SOA); soa.Hdr.TTL != 300 || soa.Minttl != 300 {
+			t.Errorf("%s: expected the SOA with the negative TTL, got %v", name, soa)
+		}
+	}
+
+	if _, err := NewServer(Config{Zone: testZone, NegativeTTL: 4 * time.Hour}); err == nil {
+		t.Fatal("expected an error for a negative TTL above 3h")
+	}
+}
+
+func TestAuthorityNS(t *testing.T) {
+	srv, err := NewServer(Config{
+		Zone:        testZone,
+		NS:          []string{"ns1." + testZone, "ns.example.net."},
+		NSAddrs:     []netip.Addr{netip.MustParseAddr("192.0.2.53")},
+		AuthorityNS: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, store, cleanup := startTestServerFor(t, srv)
+	defer cleanup()
+	store.Set(srv.ChallengeName(), "token", 0)
+
+	r := query(t, addr, srv.ChallengeName(), dns.TypeTXT)
+	if len(r.Answer) != 1 || len(r.Ns) != 2 || len(r.Extra) != 1 {
+		t.Fatalf("expected the TXT with 2 NS records and the glue, got %v, %v and %v", r.Answer, r.Ns, r.Extra)
+	}
+
+	// The NS answer and the glue for the answered name server aren't
+	// repeated, and negative answers carry the SOA only.
+	if r := query(t, addr, testZone, dns.TypeNS); len(r.Ns) != 0 || len(r.Extra) != 1 {
+		t.Fatalf("expected no authority records for NS answers, got %v and %v", r.Ns, r.Extra)
+	}
+	if r := query(t, addr, "ns1."+testZone, dns.TypeA); len(r.Ns) != 2 || len(r.Extra) != 0 {
+		t.Fatalf("expected the A answer not to be repeated as glue, got %v and %v", r.Ns, r.Extra)
+	}
+	if r := query(t, addr, "www."+testZone, dns.TypeTXT); len(r.Ns) != 1 || dns.RRToType(r.Ns[0]) != dns.TypeSOA {
+		t.Fatalf("expected the SOA in the authority section, got %v", r.Ns)
+	}
+
+	if _, err := NewServer(Config{Zone: testZone, AuthorityNS: true}); err == nil {
+		t.Fatal("expected an error for authority NS records without name servers")
+	}
+}
@@ -0,0 +1,139 @@
+// Package pajatsotest runs dns-pajatso servers in-process for integration
+// tests, like net/http/httptest does for HTTP handlers.
+//
+//	addr, store, teardown := pajatsotest.Start(t, pajatsotest.Config())
+//	defer teardown()
+//	store.Set(pajatsotest.Challenge, "token", 0)
+//	r := pajatsotest.Query(t, addr, pajatsotest.Challenge, dns.TypeTXT)
+package pajatsotest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// The zone and TSIG key of Config.
+const (
+	Zone      = "example.com."
+	Challenge = "_acme-challenge.example.com."
+	TsigName  = "acme-update."
+)
+
+// TsigSecret is the base64 secret of TsigName, a fixed key for tests only.
+var TsigSecret = base64.StdEncoding.EncodeToString(hmac.New(sha512.New, []byte("pajatsotest")).Sum(nil))
+
+// Config returns the configuration of a server for Zone, accepting updates
+// signed with TsigName, for tests to adjust before Start.
+func Config() pajatso.Config {
+	return pajatso.Config{
+		Zone:       Zone,
+		TsigName:   TsigName,
+		TsigSecret: TsigSecret,
+	}
+}
+
+// Start serves a Server created from cfg, see StartServer, failing t if cfg
+// is invalid.
+func Start(t testing.TB, cfg pajatso.Config) (addr string, store *pajatso.Store, teardown func()) {
+	t.Helper()
+	srv, err := pajatso.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("pajatsotest: %v", err)
+	}
+	return StartServer(t, srv)
+}
+
+// StartServer serves srv over UDP and TCP on a random port of the loopback
+// interface, returning once it answers. It returns the address and Store of
+// srv, and a teardown function shutting srv down, which is also called when
+// the test ends.
+func StartServer(t testing.TB, srv *pajatso.Server) (addr string, store *pajatso.Store, teardown func()) {
+	t.Helper()
+	if srv.Store == nil {
+		srv.Store = &pajatso.Store{}
+	}
+	pc, ln := listen(t)
+
+	udp := srv.NewDNSServer()
+	udp.Net, udp.PacketConn = "udp", pc
+	tcp := srv.NewDNSServer()
+	tcp.Net, tcp.Listener = "tcp", srv.TCPListener(ln)
+	go udp.ListenAndServe()
+	go tcp.ListenAndServe()
+	<-srv.Ready()
+
+	var once sync.Once
+	teardown = func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(ctx, udp, tcp)
+		})
+	}
+	t.Cleanup(teardown)
+	return pc.LocalAddr().String(), srv.Store, teardown
+}
+
+// listen binds a UDP socket and a TCP listener on the same random loopback
+// port, retrying if the TCP port is taken.
+func listen(t testing.TB) (net.PacketConn, net.Listener) {
+	t.Helper()
+	var err error
+	for range 10 {
+		var pc net.PacketConn
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			break
+		}
+		var ln net.Listener
+		ln, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			return pc, ln
+		}
+		pc.Close()
+	}
+	t.Fatalf("pajatsotest: listening: %v", err)
+	return nil, nil
+}
+
+// Query sends a query for name and qtype to addr over UDP, failing t if it
+// isn't answered.
+func Query(t testing.TB, addr, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	r, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(name, qtype), "udp", addr)
+	if err != nil {
+		t.Fatalf("pajatsotest: query failed: %v", err)
+	}
+	return r
+}
+
+// Update sends an update of Zone to addr with the update section rrs,
+// signed with TsigName, and returns the response. Records of class NONE and
+// ANY delete values and RRsets (RFC 2136 2.5).
+func Update(t testing.TB, addr string, rrs ...dns.RR) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.ID = dns.ID()
+	m.Opcode = dns.OpcodeUpdate
+	m.Question = []dns.RR{&dns.SOA{Hdr: dns.Header{Name: Zone, Class: dns.ClassINET}}}
+	m.Ns = rrs
+	m.Pseudo = []dns.RR{dns.NewTSIG(TsigName, dns.HmacSHA512, 300)}
+	secret, _ := base64.StdEncoding.DecodeString(TsigSecret)
+	if err := dns.TSIGSign(m, dns.HmacTSIG{Secret: secret}, &dns.TSIGOption{}); err != nil {
+		t.Fatalf("pajatsotest: signing update: %v", err)
+	}
+	r, _, err := dns.NewClient().Exchange(context.Background(), m, "udp", addr)
+	if err != nil {
+		t.Fatalf("pajatsotest: update failed: %v", err)
+	}
+	return r
+}
@@ -0,0 +1,54 @@
+package pajatsotest_test
+
+import (
+	"context"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+	"github.com/twelho/dns-pajatso/pkg/pajatsotest"
+)
+
+func TestStart(t *testing.T) {
+	addr, store, teardown := pajatsotest.Start(t, pajatsotest.Config())
+
+	txt := &dns.TXT{Hdr: dns.Header{Name: pajatsotest.Challenge, Class: dns.ClassINET, TTL: 60}, TXT: rdata.TXT{Txt: []string{"updated"}}}
+	if r := pajatsotest.Update(t, addr, txt); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if entries := store.Get(pajatsotest.Challenge); len(entries) != 1 || entries[0].Value != "updated" {
+		t.Fatalf("expected the update to be stored, got %+v", entries)
+	}
+
+	store.Set(pajatsotest.Challenge, "set", 0)
+	r := pajatsotest.Query(t, addr, pajatsotest.Challenge, dns.TypeTXT)
+	if len(r.Answer) != 2 {
+		t.Fatalf("expected 2 answers, got %v", r.Answer)
+	}
+
+	// TCP is served on the same port.
+	r, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(pajatsotest.Challenge, dns.TypeTXT), "tcp", addr)
+	if err != nil || len(r.Answer) != 2 {
+		t.Fatalf("expected 2 answers over TCP, got %v, %v", r, err)
+	}
+
+	teardown()
+	teardown() // again at the end of the test
+	if _, _, err := dns.NewClient().Exchange(context.Background(), dns.NewMsg(pajatsotest.Challenge, dns.TypeTXT), "tcp", addr); err == nil {
+		t.Fatal("expected the server to be stopped")
+	}
+}
+
+func TestStartServer(t *testing.T) {
+	srv := &pajatso.Server{Zone: pajatsotest.Zone, Subdomain: "sub"}
+	addr, store, _ := pajatsotest.StartServer(t, srv)
+	if store != srv.Store || store == nil {
+		t.Fatal("expected the Store of the server")
+	}
+	store.Add("token")
+	r := pajatsotest.Query(t, addr, "_acme-challenge.sub."+pajatsotest.Zone, dns.TypeTXT)
+	if len(r.Answer) != 1 {
+		t.Fatalf("expected the token, got %v", r.Answer)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// runAs is the user and group to run as after binding the listeners.
+type runAs struct {
+	uid, gid int
+}
+
+// lookupCredentials resolves the user and group names (or numeric IDs) given
+// with --user and --group. The group defaults to the user's primary group,
+// the user to the current one.
+func lookupCredentials(userName, groupName string) (*runAs, error) {
+	c := &runAs{uid: os.Getuid(), gid: os.Getgid()}
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			if u, err = user.LookupId(userName); err != nil {
+				return nil, fmt.Errorf("unknown user %q", userName)
+			}
+		}
+		if c.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return nil, fmt.Errorf("user %q: non-numeric uid %q", userName, u.Uid)
+		}
+		if c.gid, err = strconv.Atoi(u.Gid); err != nil {
+			return nil, fmt.Errorf("user %q: non-numeric gid %q", userName, u.Gid)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return nil, fmt.Errorf("unknown group %q", groupName)
+			}
+		}
+		if c.gid, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, fmt.Errorf("group %q: non-numeric gid %q", groupName, g.Gid)
+		}
+	}
+	return c, nil
+}
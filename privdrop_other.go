@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// drop is not supported on this platform.
+func (c *runAs) drop() error {
+	return errors.New("dropping privileges is not supported on this platform")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"testing"
+)
+
+func TestLookupCredentials(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, name := range []string{u.Username, u.Uid} {
+		c, err := lookupCredentials(name, "")
+		if err != nil {
+			t.Fatalf("lookupCredentials(%q): %v", name, err)
+		}
+		if c.uid != os.Getuid() {
+			t.Errorf("lookupCredentials(%q): uid = %d, want %d", name, c.uid, os.Getuid())
+		}
+	}
+
+	c, err := lookupCredentials("", u.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.uid != os.Getuid() || c.gid != os.Getgid() {
+		t.Errorf("group only: got %d:%d, want %d:%d", c.uid, c.gid, os.Getuid(), os.Getgid())
+	}
+
+	if _, err := lookupCredentials("no-such-user-pajatso", ""); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+	if _, err := lookupCredentials("", "no-such-group-pajatso"); err == nil {
+		t.Error("expected an error for an unknown group")
+	}
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// drop switches the process to c, giving up root and supplementary groups.
+func (c *runAs) drop() error {
+	if err := syscall.Setgroups([]int{c.gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(c.gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(c.uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	if c.uid != 0 && syscall.Setuid(0) == nil {
+		return errors.New("could regain root after dropping privileges")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// propagationMonitor polls recursive resolvers after every new value of a
+// record until they return it, logging how long propagation took. This shows
+// whether a CA seeing NXDOMAIN was caused by stale resolver caches.
+type propagationMonitor struct {
+	resolvers []string // host:port of the recursive resolvers
+	interval  time.Duration
+	timeout   time.Duration
+	client    *dns.Client
+
+	mu    sync.Mutex
+	polls map[propagationKey]*propagationPoll // values being polled for
+}
+
+// propagationKey identifies a value polled for by its record and value.
+type propagationKey struct{ name, value string }
+
+// propagationPoll stops the polls for a value.
+type propagationPoll struct{ cancel context.CancelFunc }
+
+// newPropagationMonitor returns a monitor polling resolvers. Resolvers
+// without a port default to port 53.
+func newPropagationMonitor(resolvers []string, interval, timeout time.Duration) *propagationMonitor {
+	p := &propagationMonitor{
+		interval: interval,
+		timeout:  timeout,
+		client:   dns.NewClient(),
+		polls:    map[propagationKey]*propagationPoll{},
+	}
+	p.client.Dialer = &net.Dialer{Timeout: 2 * time.Second}
+	p.client.ReadTimeout = 2 * time.Second
+	for _, r := range resolvers {
+		if _, _, err := net.SplitHostPort(r); err != nil {
+			r = net.JoinHostPort(r, "53")
+		}
+		p.resolvers = append(p.resolvers, r)
+	}
+	return p
+}
+
+// handle starts monitoring a newly set value, restarting if it is set again,
+// and stops when the value is deleted or expires. Other values, such as the
+// token for the wildcard set next to the one for the domain, keep being
+// monitored.
+func (p *propagationMonitor) handle(e pajatso.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := propagationKey{strings.ToLower(e.Name), e.Value}
+	if e.Op != pajatso.OpSet {
+		for k, poll := range p.polls {
+			// A delete without a value removed the whole record.
+			if k == key || e.Value == "" && k.name == key.name {
+				poll.cancel()
+				delete(p.polls, k)
+			}
+		}
+		return
+	}
+	if poll := p.polls[key]; poll != nil {
+		poll.cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	poll := &propagationPoll{cancel}
+	p.polls[key] = poll
+	var wg sync.WaitGroup
+	for _, resolver := range p.resolvers {
+		wg.Go(func() {
+			latency, err := p.poll(ctx, resolver, e.Name, e.Value, e.Time)
+			switch {
+			case err == nil:
+				slog.Info("propagated", "resolver", resolver, "record", e.Name, "latency", latency.Round(time.Millisecond))
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				slog.Warn("not propagated", "resolver", resolver, "record", e.Name, "timeout", p.timeout, "last", err)
+			}
+		})
+	}
+
+	// Forget the value once all its polls are done.
+	go func() {
+		wg.Wait()
+		cancel()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.polls[key] == poll {
+			delete(p.polls, key)
+		}
+	}()
+}
+
+// poll queries resolver every interval until it returns value for the TXT
+// record at name, and returns the time elapsed since set.
+func (p *propagationMonitor) poll(ctx context.Context, resolver, name, value string, set time.Time) (time.Duration, error) {
+	var lastErr error
+	for {
+		if ok, err := p.resolves(ctx, resolver, name, value); ok {
+			return time.Since(set), nil
+		} else if err != nil {
+			lastErr = err
+		}
+
+		select {
+		case <-time.After(p.interval):
+		case <-ctx.Done():
+			if lastErr == nil {
+				lastErr = ctx.Err()
+			}
+			return 0, lastErr
+		}
+	}
+}
+
+// resolves reports whether resolver answers the TXT record at name with
+// value.
+func (p *propagationMonitor) resolves(ctx context.Context, resolver, name, value string) (bool, error) {
+	r, _, err := p.client.Exchange(ctx, dns.NewMsg(name, dns.TypeTXT), "udp", resolver)
+	if err != nil {
+		return false, err
+	}
+	var values []string
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+	return slices.Contains(values, value), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+func TestPropagationPoll(t *testing.T) {
+	// The test server stands in for a recursive resolver.
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+
+	p := newPropagationMonitor([]string{addr}, 10*time.Millisecond, time.Second)
+	set := time.Now()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		store.Replace("token")
+	}()
+
+	latency, err := p.poll(context.Background(), addr, testChallenge, "token", set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latency < 50*time.Millisecond {
+		t.Fatalf("expected latency of at least 50ms, got %v", latency)
+	}
+}
+
+func TestPropagationPollTimeout(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+	store.Replace("other")
+
+	p := newPropagationMonitor([]string{addr}, 10*time.Millisecond, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := p.poll(ctx, addr, testChallenge, "token", time.Now()); err == nil {
+		t.Fatal("expected an error for a token that never propagates")
+	}
+}
+
+func TestPropagationHandle(t *testing.T) {
+	// The resolver never answers, so the polls run until stopped.
+	p := newPropagationMonitor([]string{"127.0.0.1:1"}, 10*time.Millisecond, time.Minute)
+	polling := func() []propagationKey {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		var keys []propagationKey
+		for k := range p.polls {
+			keys = append(keys, k)
+		}
+		slices.SortFunc(keys, func(a, b propagationKey) int { return strings.Compare(a.name+a.value, b.name+b.value) })
+		return keys
+	}
+	event := func(op pajatso.Op, name, value string) {
+		p.handle(pajatso.Event{Op: op, Name: name, Value: value, Time: time.Now()})
+	}
+
+	// The tokens of a domain and its wildcard, and a value at another
+	// name, are polled for at once.
+	event(pajatso.OpSet, testChallenge, "domain")
+	event(pajatso.OpSet, testChallenge, "wildcard")
+	event(pajatso.OpSet, "_verify."+testZone, "other")
+	want := []propagationKey{{testChallenge, "domain"}, {testChallenge, "wildcard"}, {"_verify." + testZone, "other"}}
+	if got := polling(); !slices.Equal(got, want) {
+		t.Fatalf("expected polls for %v, got %v", want, got)
+	}
+
+	// Deleting a value stops its polls only, deleting the record all of
+	// its values.
+	event(pajatso.OpDelete, testChallenge, "domain")
+	if got := polling(); !slices.Equal(got, want[1:]) {
+		t.Fatalf("expected polls for %v, got %v", want[1:], got)
+	}
+	event(pajatso.OpExpire, "_verify."+testZone, "other")
+	event(pajatso.OpDelete, testChallenge, "")
+	if got := polling(); len(got) != 0 {
+		t.Fatalf("expected no polls, got %v", got)
+	}
+}
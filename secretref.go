@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// secretRef refers to a key of a Kubernetes Secret holding a base64 TSIG
+// secret, as printed by keygen.
+type secretRef struct {
+	Namespace, Name, Key string
+}
+
+// parseSecretRef parses a namespace/name/key reference.
+func parseSecretRef(s string) (secretRef, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return secretRef{}, fmt.Errorf("invalid secret reference %q, want namespace/name/key", s)
+	}
+	return secretRef{parts[0], parts[1], parts[2]}, nil
+}
+
+func (r secretRef) String() string {
+	return r.Namespace + "/" + r.Name + "/" + r.Key
+}
+
+// kubeSecret is a Secret as returned by the API server.
+type kubeSecret struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// value returns the TSIG secret of s referred to by r.
+func (r secretRef) value(s *kubeSecret) (string, error) {
+	data, ok := s.Data[r.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %q", r.Namespace, r.Name, r.Key)
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("secret %s/%s: %w", r.Namespace, r.Name, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// read returns the TSIG secret r refers to and the resource version of its
+// Secret.
+func (r secretRef) read(ctx context.Context, k *kubeClient) (string, string, error) {
+	var s kubeSecret
+	if err := k.get(ctx, "/api/v1/namespaces/"+r.Namespace+"/secrets/"+r.Name, &s); err != nil {
+		return "", "", fmt.Errorf("reading secret %s/%s: %w", r.Namespace, r.Name, err)
+	}
+	value, err := r.value(&s)
+	return value, s.Metadata.ResourceVersion, err
+}
+
+// watch calls fn with the TSIG secret r refers to whenever it changes, until
+// ctx is done. Invalid values are logged and skipped.
+func (r secretRef) watch(ctx context.Context, k *kubeClient, current string, fn func(string)) {
+	path := "/api/v1/namespaces/" + r.Namespace + "/secrets?" +
+		url.Values{"fieldSelector": {"metadata.name=" + r.Name}}.Encode()
+
+	for ctx.Err() == nil {
+		value, rv, err := r.read(ctx, k)
+		if err == nil {
+			if value != current {
+				current = value
+				fn(value)
+			}
+			err = k.watch(ctx, path, rv, func(e watchEvent) error {
+				switch e.Type {
+				case "ADDED", "MODIFIED":
+					var s kubeSecret
+					if err := json.Unmarshal(e.Object, &s); err != nil {
+						return err
+					}
+					value, err := r.value(&s)
+					if err != nil {
+						slog.Error("tsig secret ref: ignoring invalid secret", "ref", r, "err", err)
+					} else if value != current {
+						current = value
+						fn(value)
+					}
+				case "ERROR":
+					var status kubeError
+					json.Unmarshal(e.Object, &status)
+					return &status
+				}
+				return nil
+			})
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("tsig secret ref: watch failed, retrying", "ref", r, "err", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
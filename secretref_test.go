@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	ref, err := parseSecretRef("dns/tsig/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != (secretRef{"dns", "tsig", "secret"}) {
+		t.Errorf("ref = %+v", ref)
+	}
+	for _, s := range []string{"", "dns/tsig", "dns//secret", "dns/tsig/secret/extra"} {
+		if _, err := parseSecretRef(s); err == nil {
+			t.Errorf("parseSecretRef(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSecretRefWatch(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	k := newTestKubeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/dns/secrets/tsig":
+			fmt.Fprintf(w, `{"metadata": {"resourceVersion": "1"}, "data": {"secret": %q}}`, b64("old\n"))
+		case "/api/v1/namespaces/dns/secrets":
+			if got := r.URL.Query().Get("fieldSelector"); got != "metadata.name=tsig" {
+				t.Errorf("fieldSelector = %q", got)
+			}
+			fmt.Fprintf(w, `{"type": "MODIFIED", "object": {"data": {"other": "x"}}}`+"\n")
+			fmt.Fprintf(w, `{"type": "MODIFIED", "object": {"data": {"secret": %q}}}`+"\n", b64("new"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	ref := secretRef{"dns", "tsig", "secret"}
+	value, rv, err := ref.read(context.Background(), k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "old" || rv != "1" {
+		t.Fatalf("read = %q, %q, want old, 1", value, rv)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan string, 1)
+	go ref.watch(ctx, k, value, func(s string) { got <- s })
+	select {
+	case s := <-got:
+		if s != "new" {
+			t.Errorf("rotated secret = %q, want new", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("rotation not observed")
+	}
+}
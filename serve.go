@@ -0,0 +1,1168 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// serveOptions are the flags of the serve command.
+type serveOptions struct {
+	zone       zoneFlags
+	key        keyFlags
+	tsigPrev   string
+	tsigFudge  time.Duration
+	vaultPoll  time.Duration
+	awsPoll    time.Duration
+	tenants    []string
+	listen     string
+	protocols  []string
+	udpWorkers int
+	tokenTTL   time.Duration
+	strict     bool
+	grace      time.Duration
+	maxToken   int
+	apiListen  string
+	apiToken   string
+
+	apiCert       string
+	apiKey        string
+	apiVault      string
+	apiClientCA   string
+	apiClientSANs []string
+
+	apiJWTIssuer   string
+	apiJWTAudience string
+	apiJWTKeys     string
+	apiJWTScopes   []string
+	apiKeys        []string
+
+	acmeDNSListen   string
+	acmeDNSRegistry string
+
+	tcpMaxConns     int
+	tcpPipelined    int
+	tcpIdleTimeout  time.Duration
+	tcpReadTimeout  time.Duration
+	tcpWriteTimeout time.Duration
+	ednsUDPSize     uint16
+	drainTimeout    time.Duration
+	bindRetryWindow time.Duration
+	requestTimeout  time.Duration
+	maxRequests     int
+
+	rrlRate       float64
+	rrlSlip       int
+	rrlIPv4Prefix int
+	rrlIPv6Prefix int
+
+	updateKeyRate  float64
+	updateKeyBurst int
+	updateIPRate   float64
+	updateIPBurst  int
+	updateAllow    []string
+	updateAllowKey []string
+	updatePolicy   []string
+	genericTXT     bool
+	acceptNotify   []string
+	notifyAllow    []string
+	queryAllow     []string
+	queryDeny      []string
+	banThreshold   int
+	banWindow      time.Duration
+	banDuration    time.Duration
+
+	apiWriteKeyRate  float64
+	apiWriteKeyBurst int
+	apiWriteIPRate   float64
+	apiWriteIPBurst  int
+	apiAuthBackoff   time.Duration
+	apiAuthMaxWait   time.Duration
+
+	webhookListen   string
+	webhookGroup    string
+	webhookCert     string
+	webhookKey      string
+	webhookVault    string
+	webhookClientCA string
+	webhookInsecure bool
+
+	grpcListen   string
+	grpcCert     string
+	grpcKey      string
+	grpcVault    string
+	grpcClientCA string
+	grpcSANs     []string
+
+	controlSocket *string // flag of the root command
+	adminListen   string
+	healthName    string
+	mode          string
+	dumpFile      string
+
+	chaos       bool
+	nsid        bool
+	caa         []string
+	ns          []string
+	hostmaster  string
+	authorityNS bool
+	nxdomain    bool
+	negativeTTL time.Duration
+	nsIPv4      []string
+	nsIPv6      []string
+	zonefile    string
+	cnames      []string
+	upstream    string
+	logQueries  bool
+	logSlow     time.Duration
+	identity    string
+	version     string
+
+	oneShot        bool
+	oneShotToken   string
+	oneShotTimeout time.Duration
+	oneShotLinger  time.Duration
+
+	testACMEDirectory   string
+	testACMEDirectoryCA string
+	testACMETimeout     time.Duration
+
+	propagationResolvers []string
+	propagationInterval  time.Duration
+	propagationTimeout   time.Duration
+
+	onSet       string
+	onDelete    string
+	onExpire    string
+	hookTimeout time.Duration
+
+	notifyURL     string
+	notifySecret  string
+	notifyRetries int
+
+	natsURL     string
+	natsCreds   string
+	natsSubject string
+
+	runUser  string
+	runGroup string
+
+	dryRun bool
+}
+
+// serveCommand returns the serve subcommand, using the control socket at
+// *controlSocket, a flag of the root command.
+func serveCommand(controlSocket *string) *cobra.Command {
+	o := &serveOptions{controlSocket: controlSocket}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the zone and accept updates to its challenge record (the default without a subcommand)",
+		Args:  cobra.NoArgs,
+		RunE:  func(cmd *cobra.Command, args []string) error { return o.run(cmd) },
+	}
+	o.register(cmd.Flags())
+	cmd.MarkFlagRequired("zone")
+	cmd.MarkFlagRequired("tsig-name")
+	return cmd
+}
+
+// run serves until interrupted, or until the one-shot or ACME test mode is
+// done. The steps are ordered so that everything reading the store and the
+// callbacks of the servers is in place before serving, and that privileges
+// are dropped only once every listener is bound.
+func (o *serveOptions) run(cmd *cobra.Command) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	var dropTo *runAs
+	if o.runUser != "" || o.runGroup != "" {
+		var err error
+		if dropTo, err = lookupCredentials(o.runUser, o.runGroup); err != nil {
+			return err
+		}
+	}
+
+	// Read the TSIG secret, following rotations of the Kubernetes, Vault
+	// or AWS secret it comes from below.
+	tsigSecret, source, err := o.key.resolve(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	srv, zones, err := o.newServers(tsigSecret)
+	if err != nil {
+		return err
+	}
+	if o.dryRun {
+		return o.checkDryRun(cmd.OutOrStdout(), srv, zones)
+	}
+
+	// Set up signal handling.
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	dumpOnSignal(srv, o.dumpFile, ctx.Done())
+
+	dnsServers, err := o.dnsServers(ctx, srv, zones)
+	if err != nil {
+		return err
+	}
+
+	// Shutdown functions of all started servers, given until the drain
+	// deadline.
+	stoppers, err := o.wireEvents(srv, zones)
+	if err != nil {
+		return err
+	}
+	oneShotCh := o.startOneShot(ctx, srv)
+
+	errCh := make(chan error, len(dnsServers)+7)
+	if interval := watchdogInterval(); interval > 0 {
+		// Stop feeding the watchdog if the store deadlocks.
+		go runWatchdog(ctx, interval, func() bool { srv.Store.Serial(); return true })
+	}
+	for _, ds := range dnsServers {
+		go func() {
+			err := ds.ListenAndServe()
+			if err != nil {
+				err = &bindError{ds.Net, ds.Addr, err}
+			}
+			errCh <- err
+		}()
+	}
+	stoppers = append(stoppers, func(ctx context.Context) {
+		if dropped := srv.Shutdown(ctx, dnsServers...); dropped > 0 {
+			slog.Warn("dropped requests while shutting down", "count", dropped)
+		}
+	})
+
+	started, err := o.startListeners(ctx, srv, dropTo, cmd.Flags().Changed("control-socket"), errCh)
+	stoppers = append(stoppers, started...)
+	if err != nil {
+		return err
+	}
+	go dropWhenReady(srv, dropTo, errCh)
+
+	o.followSecret(ctx, srv, tsigSecret, source)
+
+	slog.Info("server started", "zone", srv.Zone, "record", srv.ChallengeName(), "listen", o.listen, "protocols", o.protocols)
+	for _, zs := range zones {
+		slog.Info("serving tenant zone", "zone", zs.Zone, "record", zs.ChallengeName(), "key", zs.TsigName)
+	}
+
+	acmeTestCh, err := o.startACMETest(ctx, srv)
+	if err != nil {
+		return err
+	}
+
+	shutdown := func() {
+		slog.Info("shutting down", "drain_timeout", o.drainTimeout)
+		sdNotify("STOPPING=1")
+		ctx, cancel := context.WithTimeout(context.Background(), o.drainTimeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, stop := range stoppers {
+			wg.Go(func() { stop(ctx) })
+		}
+		wg.Wait()
+	}
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("server error: %w", err)
+	case err := <-oneShotCh:
+		shutdown()
+		return err
+	case err := <-acmeTestCh:
+		shutdown()
+		return err
+	case <-ctx.Done():
+		shutdown()
+		return nil
+	}
+}
+
+// validate checks the flags that don't need parsing.
+func (o *serveOptions) validate() error {
+	if o.apiListen != "" && o.apiToken == "" && len(o.apiKeys) == 0 && o.apiClientCA == "" && o.apiJWTIssuer == "" {
+		return fmt.Errorf("--api-token, --api-key, --api-jwt-issuer or --api-client-ca is required with --api-listen")
+	}
+	if (o.apiJWTIssuer == "") != (o.apiJWTAudience == "") {
+		return fmt.Errorf("--api-jwt-issuer and --api-jwt-audience are required together")
+	}
+	if (o.apiCert == "") != (o.apiKey == "") || o.apiClientCA != "" && o.apiCert == "" && o.apiVault == "" {
+		return fmt.Errorf("--api-tls-cert and --api-tls-key are required together, and with --api-client-ca unless --api-tls-vault")
+	}
+	if o.apiVault != "" && o.apiCert != "" || o.webhookVault != "" && o.webhookCert != "" || o.grpcVault != "" && o.grpcCert != "" {
+		return fmt.Errorf("--*-tls-vault and --*-tls-cert of a listener are mutually exclusive")
+	}
+	if o.webhookListen != "" && (o.webhookGroup == "" || (o.webhookCert == "" || o.webhookKey == "") && o.webhookVault == "") {
+		return fmt.Errorf("--webhook-group, and --webhook-tls-cert and --webhook-tls-key or --webhook-tls-vault are required with --webhook-listen")
+	}
+	// Anyone reaching the solver could publish tokens for the zone.
+	if o.webhookListen != "" && o.webhookClientCA == "" && !o.webhookInsecure {
+		return fmt.Errorf("--webhook-client-ca is required with --webhook-listen, or --webhook-insecure to accept unauthenticated requests")
+	}
+	if o.grpcListen != "" && ((o.grpcCert == "" || o.grpcKey == "") && o.grpcVault == "" || o.grpcClientCA == "") {
+		return fmt.Errorf("--grpc-tls-cert and --grpc-tls-key or --grpc-tls-vault, and --grpc-client-ca are required with --grpc-listen")
+	}
+
+	for _, p := range o.protocols {
+		if p != "udp" && p != "tcp" {
+			return fmt.Errorf("unknown protocol %q in --protocols, want udp or tcp", p)
+		}
+	}
+	if len(o.protocols) == 0 {
+		return fmt.Errorf("--protocols must include udp or tcp")
+	}
+	if o.rrlRate < 0 || o.rrlSlip < 0 || o.rrlIPv4Prefix < 1 || o.rrlIPv4Prefix > 32 || o.rrlIPv6Prefix < 1 || o.rrlIPv6Prefix > 128 {
+		return fmt.Errorf("invalid --rrl-* settings")
+	}
+	if o.udpWorkers < 1 {
+		return fmt.Errorf("--udp-workers must be at least 1")
+	}
+	if o.oneShot && o.testACMEDirectory != "" {
+		return fmt.Errorf("--one-shot and --test-acme-directory are mutually exclusive")
+	}
+	if (o.key.vault != "" || o.apiVault != "" || o.webhookVault != "" || o.grpcVault != "") && o.vaultPoll <= 0 {
+		return fmt.Errorf("--vault-refresh must be positive")
+	}
+	if o.key.aws != "" && o.awsPoll <= 0 {
+		return fmt.Errorf("--aws-refresh must be positive")
+	}
+	return nil
+}
+
+// config returns the configuration of the main zone, and the update policy
+// rules and allowlists of all keys.
+func (o *serveOptions) config(tsigSecret string) (pajatso.Config, []pajatso.Grant, map[string][]netip.Prefix, error) {
+	var cfg pajatso.Config
+	allow, err := pajatso.ParsePrefixes(o.updateAllow)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("--update-allow: %w", err)
+	}
+	allowKey := map[string][]netip.Prefix{}
+	for _, entry := range o.updateAllowKey {
+		name, list, ok := strings.Cut(entry, "=")
+		if !ok {
+			return cfg, nil, nil, fmt.Errorf("--update-allow-key: want KEY=CIDR[,CIDR...], got %q", entry)
+		}
+		prefixes, err := pajatso.ParsePrefixes(strings.Split(list, ","))
+		if err != nil {
+			return cfg, nil, nil, fmt.Errorf("--update-allow-key: %w", err)
+		}
+		allowKey[name] = append(allowKey[name], prefixes...)
+	}
+
+	notifyAllowed, err := pajatso.ParsePrefixes(o.notifyAllow)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("--accept-notify-allow: %w", err)
+	}
+
+	policy, err := pajatso.ParsePolicy(o.updatePolicy)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("--update-policy: %w", err)
+	}
+
+	var nsAddrs []netip.Addr
+	for _, a := range o.nsIPv4 {
+		addr, err := netip.ParseAddr(a)
+		if err != nil || !addr.Is4() {
+			return cfg, nil, nil, fmt.Errorf("invalid IPv4 address %q in --ns-ipv4", a)
+		}
+		nsAddrs = append(nsAddrs, addr)
+	}
+	for _, a := range o.nsIPv6 {
+		addr, err := netip.ParseAddr(a)
+		if err != nil || !addr.Is6() || addr.Is4In6() {
+			return cfg, nil, nil, fmt.Errorf("invalid IPv6 address %q in --ns-ipv6", a)
+		}
+		nsAddrs = append(nsAddrs, addr)
+	}
+
+	var static []dns.RR
+	if o.zonefile != "" {
+		f, err := os.Open(o.zonefile)
+		if err != nil {
+			return cfg, nil, nil, err
+		}
+		static, err = pajatso.ParseZoneFile(f, o.zone.zone, o.zonefile)
+		f.Close()
+		if err != nil {
+			return cfg, nil, nil, err
+		}
+	}
+
+	cnameMap := map[string]string{}
+	for _, entry := range o.cnames {
+		owner, target, ok := strings.Cut(entry, "=")
+		if !ok {
+			return cfg, nil, nil, fmt.Errorf("--cname: want NAME=TARGET, got %q", entry)
+		}
+		cnameMap[owner] = target
+	}
+
+	var apiJWT *pajatso.JWTAuth
+	if o.apiJWTIssuer != "" {
+		apiJWT = &pajatso.JWTAuth{Issuer: o.apiJWTIssuer, Audience: o.apiJWTAudience, JWKSURL: o.apiJWTKeys, Scopes: o.apiJWTScopes}
+	}
+	scopedKeys, err := pajatso.ParseAPIKeys(o.apiKeys)
+	if err != nil {
+		return cfg, nil, nil, fmt.Errorf("--api-key: %w", err)
+	}
+
+	cfg = pajatso.Config{
+		Zone:       o.zone.zone,
+		Subdomain:  o.zone.subdomain,
+		TsigName:   o.key.name,
+		TsigSecret: tsigSecret,
+		TsigAlg:    o.key.algorithm,
+		APIToken:   o.apiToken,
+		APIJWT:     apiJWT,
+		APIKeys:    scopedKeys,
+		HealthName: o.healthName,
+		Chaos:      o.chaos,
+		NSID:       o.nsid,
+		CAA:        o.caa,
+		NS:         o.ns,
+		Hostmaster: o.hostmaster,
+		NSAddrs:    nsAddrs,
+		Static:     static,
+		CNAME:      cnameMap,
+		Identity:   o.identity,
+		Version:    o.version,
+		TokenTTL:   o.tokenTTL,
+
+		TsigPreviousSecret: o.tsigPrev,
+		TsigFudge:          o.tsigFudge,
+		StrictTokens:       o.strict,
+		MaxTokenLength:     o.maxToken,
+		DeleteGrace:        o.grace,
+
+		MaxTCPConns:     o.tcpMaxConns,
+		MaxTCPPipelined: o.tcpPipelined,
+		TCPIdleTimeout:  o.tcpIdleTimeout,
+		TCPReadTimeout:  o.tcpReadTimeout,
+		TCPWriteTimeout: o.tcpWriteTimeout,
+		RequestTimeout:  o.requestTimeout,
+		MaxRequests:     o.maxRequests,
+		UDPSize:         o.ednsUDPSize,
+		UpdateKeyLimit:  pajatso.RateLimit{Rate: o.updateKeyRate, Burst: o.updateKeyBurst},
+		UpdateIPLimit:   pajatso.RateLimit{Rate: o.updateIPRate, Burst: o.updateIPBurst},
+		UpdateAllow:     allow,
+		UpdateAllowKey:  allowFor(allowKey, o.key.name),
+		UpdatePolicy:    grantsFor(policy, o.key.name),
+		GenericTXT:      o.genericTXT,
+		NotifyZones:     o.acceptNotify,
+		NotifyAllow:     notifyAllowed,
+		Ban:             pajatso.BanConfig{Threshold: o.banThreshold, Window: o.banWindow, Duration: o.banDuration},
+		APILimits: pajatso.APILimits{
+			WriteKeyLimit:  pajatso.RateLimit{Rate: o.apiWriteKeyRate, Burst: o.apiWriteKeyBurst},
+			WriteIPLimit:   pajatso.RateLimit{Rate: o.apiWriteIPRate, Burst: o.apiWriteIPBurst},
+			AuthBackoff:    o.apiAuthBackoff,
+			MaxAuthBackoff: o.apiAuthMaxWait,
+		},
+		SlowRequestThreshold: o.logSlow,
+		AuthorityNS:          o.authorityNS,
+
+		NXDomain:    o.nxdomain,
+		NegativeTTL: o.negativeTTL,
+	}
+	return cfg, policy, allowKey, nil
+}
+
+// grantsFor returns the rules of policy for key, as each zone is given the
+// rules for its key.
+func grantsFor(policy []pajatso.Grant, key string) []pajatso.Grant {
+	return slices.DeleteFunc(slices.Clone(policy), func(g pajatso.Grant) bool { return !dns.EqualName(g.Key, ensureFQDN(key)) })
+}
+
+// allowFor returns the update allowlist of allowKey for key, as each zone is
+// given the allowlist of its key only.
+func allowFor(allowKey map[string][]netip.Prefix, key string) map[string][]netip.Prefix {
+	allow := map[string][]netip.Prefix{}
+	for name, prefixes := range allowKey {
+		if dns.EqualName(ensureFQDN(name), ensureFQDN(key)) {
+			allow[name] = append(allow[name], prefixes...)
+		}
+	}
+	return allow
+}
+
+// newServers returns the Server of the main zone, with its mode, registry
+// and middleware, and those of the tenant zones.
+func (o *serveOptions) newServers(tsigSecret string) (*pajatso.Server, []*pajatso.Server, error) {
+	cfg, policy, allowKey, err := o.config(tsigSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv, err := pajatso.NewServer(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	startMode, err := pajatso.ParseMode(o.mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv.SetMode(startMode)
+	if o.acmeDNSListen != "" {
+		if srv.Registry, err = pajatso.LoadRegistry(o.acmeDNSRegistry); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	zones, err := o.tenantServers(srv, cfg, policy, allowKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, g := range policy {
+		if !slices.ContainsFunc(append(zones, srv), func(zs *pajatso.Server) bool { return dns.EqualName(zs.TsigName, g.Key) }) {
+			return nil, nil, fmt.Errorf("--update-policy: rule %q for unknown TSIG key %s", g, g.Key)
+		}
+	}
+	for name := range allowKey {
+		if !slices.ContainsFunc(append(zones, srv), func(zs *pajatso.Server) bool { return dns.EqualName(zs.TsigName, ensureFQDN(name)) }) {
+			return nil, nil, fmt.Errorf("--update-allow-key: allowlist for unknown TSIG key %s", name)
+		}
+	}
+
+	if o.logQueries {
+		srv.Middleware = append(srv.Middleware, pajatso.Logging(slog.Default()))
+	}
+	if o.rrlRate > 0 {
+		srv.Middleware = append(srv.Middleware, pajatso.RRL(pajatso.RRLConfig{
+			ResponsesPerSecond: o.rrlRate,
+			Slip:               o.rrlSlip,
+			IPv4PrefixLen:      o.rrlIPv4Prefix,
+			IPv6PrefixLen:      o.rrlIPv6Prefix,
+		}))
+	}
+	qAllow, err := pajatso.ParsePrefixes(o.queryAllow)
+	if err != nil {
+		return nil, nil, fmt.Errorf("--query-allow: %w", err)
+	}
+	qDeny, err := pajatso.ParsePrefixes(o.queryDeny)
+	if err != nil {
+		return nil, nil, fmt.Errorf("--query-deny: %w", err)
+	}
+	if len(qAllow) > 0 || len(qDeny) > 0 {
+		srv.Middleware = append(srv.Middleware, pajatso.QueryACL(qAllow, qDeny))
+	}
+	return srv, zones, nil
+}
+
+// tenantServers returns the Servers of the tenant zones, with their own keys
+// and stores, counting the use of all keys together with srv. They share the
+// settings of cfg not tied to the main zone, but not its glue, static
+// records or CNAMEs, and get the rules of policy and allowKey for their keys.
+func (o *serveOptions) tenantServers(srv *pajatso.Server, cfg pajatso.Config, policy []pajatso.Grant, allowKey map[string][]netip.Prefix) ([]*pajatso.Server, error) {
+	srv.KeyStats = &pajatso.KeyStats{}
+	var zones []*pajatso.Server
+	for _, entry := range o.tenants {
+		tzone, key, ok := strings.Cut(entry, "=")
+		name, secret, ok2 := strings.Cut(key, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("--tenant: want ZONE=KEYNAME:SECRET, got %q", entry)
+		}
+		zs, err := pajatso.NewServer(pajatso.Config{
+			Zone:           tzone,
+			Subdomain:      cfg.Subdomain,
+			TsigName:       name,
+			TsigSecret:     secret,
+			TsigAlg:        cfg.TsigAlg,
+			TsigFudge:      cfg.TsigFudge,
+			StrictTokens:   cfg.StrictTokens,
+			MaxTokenLength: cfg.MaxTokenLength,
+			TokenTTL:       cfg.TokenTTL,
+			DeleteGrace:    cfg.DeleteGrace,
+			UpdateKeyLimit: cfg.UpdateKeyLimit,
+			UpdateIPLimit:  cfg.UpdateIPLimit,
+			UpdateAllow:    cfg.UpdateAllow,
+			UpdatePolicy:   grantsFor(policy, name),
+			GenericTXT:     cfg.GenericTXT,
+			Ban:            cfg.Ban,
+			UpdateAllowKey: allowFor(allowKey, name),
+			CAA:            cfg.CAA,
+			NS:             cfg.NS,
+			Hostmaster:     cfg.Hostmaster,
+			AuthorityNS:    cfg.AuthorityNS,
+			NXDomain:       cfg.NXDomain,
+			NegativeTTL:    cfg.NegativeTTL,
+			UDPSize:        cfg.UDPSize,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("--tenant %s: %w", tzone, err)
+		}
+		for _, other := range append(zones, srv) {
+			if other.Zone == zs.Zone {
+				return nil, fmt.Errorf("--tenant %s: zone already served", tzone)
+			}
+			if other.TsigName == zs.TsigName {
+				return nil, fmt.Errorf("--tenant %s: TSIG key %s already used for %s", tzone, name, other.Zone)
+			}
+		}
+		zs.KeyStats = srv.KeyStats
+		zones = append(zones, zs)
+	}
+	return zones, nil
+}
+
+// checkDryRun checks what remains to be checked of the configuration without
+// binding sockets, and prints it to w.
+func (o *serveOptions) checkDryRun(w io.Writer, srv *pajatso.Server, zones []*pajatso.Server) error {
+	var listeners []listenAddr
+	if os.Getenv("LISTEN_FDS") == "" {
+		for _, p := range o.protocols {
+			listeners = append(listeners, listenAddr{"listen", p, o.listen})
+		}
+	}
+	for _, l := range []listenAddr{
+		{"admin-listen", "tcp", o.adminListen},
+		{"api-listen", "tcp", o.apiListen},
+		{"acme-dns-listen", "tcp", o.acmeDNSListen},
+		{"webhook-listen", "tcp", o.webhookListen},
+		{"grpc-listen", "tcp", o.grpcListen},
+	} {
+		if l.addr != "" {
+			listeners = append(listeners, l)
+		}
+	}
+	for _, l := range listeners {
+		if err := l.check(); err != nil {
+			return err
+		}
+	}
+	var pairs []keyPair
+	if o.apiListen != "" && o.apiCert != "" {
+		pairs = append(pairs, keyPair{"api-tls-cert", o.apiCert, o.apiKey})
+	}
+	if o.webhookListen != "" && o.webhookCert != "" {
+		pairs = append(pairs, keyPair{"webhook-tls-cert", o.webhookCert, o.webhookKey})
+	}
+	if o.grpcListen != "" && o.grpcCert != "" {
+		pairs = append(pairs, keyPair{"grpc-tls-cert", o.grpcCert, o.grpcKey})
+	}
+	for _, k := range pairs {
+		if err := k.check(); err != nil {
+			return err
+		}
+	}
+	for _, c := range []struct {
+		ca   string
+		sans []string
+	}{{o.apiClientCA, o.apiClientSANs}, {o.webhookClientCA, nil}, {o.grpcClientCA, o.grpcSANs}} {
+		if _, err := serverTLSConfig(c.ca, c.sans); err != nil {
+			return err
+		}
+	}
+	return printConfig(w, srv, zones, listeners)
+}
+
+// dnsServers returns the DNS servers for the sockets passed by systemd, if
+// socket-activated, or for listen with the enabled protocols otherwise. The
+// TCP listeners are bound already.
+func (o *serveOptions) dnsServers(ctx context.Context, srv *pajatso.Server, zones []*pajatso.Server) ([]*dns.Server, error) {
+	var opts []pajatso.HandlerOption
+	if o.upstream != "" {
+		opts = append(opts, pajatso.WithNext(pajatso.Forward(o.upstream)))
+	}
+	if len(zones) > 0 {
+		opts = append(opts, pajatso.WithZones(zones...))
+	}
+	if files := activationFiles(); files != nil {
+		dnsServers, err := activatedServers(srv, files, opts...)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("using systemd sockets", "count", len(dnsServers))
+		return dnsServers, nil
+	}
+
+	var dnsServers []*dns.Server
+	for _, p := range slices.Compact(slices.Sorted(slices.Values(o.protocols))) {
+		n := 1
+		if p == "udp" {
+			n = o.udpWorkers
+			if err := waitUDP(ctx, o.listen, o.bindRetryWindow); err != nil {
+				return nil, err
+			}
+		}
+		for range n {
+			ds := srv.NewDNSServer(opts...)
+			ds.Addr = o.listen
+			ds.Net = p
+			// Let the kernel spread queries over the UDP sockets.
+			ds.ReusePort = n > 1
+			if p == "tcp" {
+				// Bind here to apply the connection limit.
+				ln, err := listenTCP(ctx, o.listen, o.bindRetryWindow)
+				if err != nil {
+					return nil, err
+				}
+				ds.Listener = srv.TCPListener(ln)
+			}
+			dnsServers = append(dnsServers, ds)
+		}
+	}
+	return dnsServers, nil
+}
+
+// wireEvents subscribes the webhook notifier, NATS, the propagation monitor
+// and the exec hooks to the stores of srv and the tenant zones, and sets the
+// callbacks for refused updates and accepted NOTIFY messages. It is called
+// before serving, as the handlers read the callbacks, and returns the
+// shutdown functions of the consumers.
+func (o *serveOptions) wireEvents(srv *pajatso.Server, zones []*pajatso.Server) ([]func(context.Context), error) {
+	var stoppers []func(context.Context)
+
+	// The main zone and the tenant zones, whose stores and refused updates
+	// are reported alike.
+	allZones := append([]*pajatso.Server{srv}, zones...)
+
+	// Callbacks for refused updates of each zone and accepted NOTIFY
+	// messages.
+	var refusedHooks []func(*pajatso.Server) func(context.Context, net.Addr, string)
+	var notifyHooks []func(net.Addr, string, uint32)
+
+	// Send webhook notifications, if enabled.
+	if o.notifyURL != "" {
+		nt := &notifier{
+			url:     o.notifyURL,
+			secret:  []byte(o.notifySecret),
+			retries: o.notifyRetries,
+			backoff: time.Second,
+			client:  &http.Client{Timeout: 10 * time.Second},
+		}
+		for _, zs := range allZones {
+			zs.Store.Subscribe(nt.storeEvent(zs))
+		}
+		refusedHooks = append(refusedHooks, nt.updateRefused)
+		notifyHooks = append(notifyHooks, nt.zoneNotified())
+	}
+
+	// Publish events to NATS, if enabled.
+	if o.natsURL != "" {
+		pub, err := connectNATS(o.natsURL, o.natsCreds, o.natsSubject)
+		if err != nil {
+			return nil, err
+		}
+		for _, zs := range allZones {
+			zs.Store.Subscribe(pub.storeEvent(zs))
+		}
+		refusedHooks = append(refusedHooks, pub.updateRefused)
+		notifyHooks = append(notifyHooks, pub.zoneNotified())
+		stoppers = append(stoppers, func(context.Context) { pub.close() })
+		slog.Info("nats started", "url", o.natsURL, "subject", o.natsSubject)
+	}
+
+	if len(refusedHooks) > 0 {
+		for _, zs := range allZones {
+			var fns []func(context.Context, net.Addr, string)
+			for _, bind := range refusedHooks {
+				fns = append(fns, bind(zs))
+			}
+			zs.OnUpdateRefused = func(ctx context.Context, remote net.Addr, reason string) {
+				for _, fn := range fns {
+					fn(ctx, remote, reason)
+				}
+			}
+		}
+	}
+	if len(notifyHooks) > 0 {
+		srv.OnNotify = func(remote net.Addr, zone string, serial uint32) {
+			for _, fn := range notifyHooks {
+				fn(remote, zone, serial)
+			}
+		}
+	}
+
+	// Monitor propagation of new tokens, if enabled.
+	if len(o.propagationResolvers) > 0 {
+		monitor := newPropagationMonitor(o.propagationResolvers, o.propagationInterval, o.propagationTimeout)
+		for _, zs := range allZones {
+			zs.Store.Subscribe(monitor.handle)
+		}
+	}
+
+	// Run exec hooks on store changes, if configured.
+	if o.onSet != "" || o.onDelete != "" || o.onExpire != "" {
+		commands := map[pajatso.Op]string{pajatso.OpSet: o.onSet, pajatso.OpDelete: o.onDelete, pajatso.OpExpire: o.onExpire}
+		for _, zs := range allZones {
+			zs.Store.Subscribe(newHooks(zs, commands, o.hookTimeout).storeEvent)
+		}
+	}
+	return stoppers, nil
+}
+
+// startOneShot returns a channel receiving the outcome in one-shot mode,
+// once the token has been validated, or nil otherwise. The token and the
+// hook are set before serving, so that the first query of the CA is
+// answered and counted.
+func (o *serveOptions) startOneShot(ctx context.Context, srv *pajatso.Server) chan error {
+	if !o.oneShot {
+		return nil
+	}
+	if o.oneShotToken != "" {
+		srv.Store.Set(srv.ChallengeName(), o.oneShotToken, 0)
+	}
+	served := make(chan struct{}, 1)
+	srv.OnChallengeQuery = func(net.Addr) {
+		select {
+		case served <- struct{}{}:
+		default:
+		}
+	}
+	oneShotCh := make(chan error, 1)
+	go func() { oneShotCh <- waitOneShot(ctx, served, o.oneShotTimeout, o.oneShotLinger) }()
+	return oneShotCh
+}
+
+// startListeners starts the control socket and the admin, HTTP API,
+// acme-dns, webhook and gRPC servers that are enabled, sending the errors
+// they stop with to errCh, and returns their shutdown functions. The
+// listeners are bound and their TLS key pairs loaded before it returns, so
+// that privileges are dropped only afterwards: a privileged port or a key
+// readable only by root would fail then. controlExplicit tells whether the
+// control socket was given explicitly, making failing to create it fatal.
+func (o *serveOptions) startListeners(ctx context.Context, srv *pajatso.Server, dropTo *runAs, controlExplicit bool, errCh chan<- error) ([]func(context.Context), error) {
+	var stoppers []func(context.Context)
+
+	// Start the control socket, if enabled.
+	if *o.controlSocket != "" {
+		// Keep the socket accessible to the user we run as.
+		ln, err := listenControl(*o.controlSocket, dropTo)
+		switch {
+		case err != nil && controlExplicit:
+			return stoppers, err
+		case err != nil:
+			// The default directory may not be creatable, e.g. without a
+			// writable /run; serve without the socket then.
+			slog.Warn("control socket disabled", "err", err)
+		default:
+			controlServer := &http.Server{Handler: srv.ControlHandler()}
+			go func() { errCh <- controlServer.Serve(ln) }()
+			stoppers = append(stoppers, func(ctx context.Context) { controlServer.Shutdown(ctx) })
+		}
+	}
+
+	// Start the admin server, if enabled.
+	if o.adminListen != "" {
+		adminServer := &http.Server{Handler: srv.AdminHandler()}
+		if err := serveHTTP(adminServer, o.adminListen, errCh); err != nil {
+			return stoppers, fmt.Errorf("admin listen: %w", err)
+		}
+		stoppers = append(stoppers, func(ctx context.Context) { adminServer.Shutdown(ctx) })
+		slog.Info("admin started", "listen", o.adminListen)
+	}
+
+	// Start the HTTP API server, if enabled.
+	if o.apiListen != "" {
+		apiServer := &http.Server{Handler: srv.APIHandler()}
+		if o.apiCert != "" || o.apiVault != "" {
+			var err error
+			if apiServer.TLSConfig, err = serverTLSConfig(o.apiClientCA, o.apiClientSANs); err != nil {
+				return stoppers, err
+			}
+			if err := o.loadKeyPair(ctx, apiServer.TLSConfig, o.apiVault, o.apiCert, o.apiKey); err != nil {
+				return stoppers, fmt.Errorf("loading API TLS key pair: %w", err)
+			}
+		}
+		if err := serveHTTP(apiServer, o.apiListen, errCh); err != nil {
+			return stoppers, fmt.Errorf("api listen: %w", err)
+		}
+		stoppers = append(stoppers, func(ctx context.Context) { apiServer.Shutdown(ctx) })
+		slog.Info("api started", "listen", o.apiListen, "tls", apiServer.TLSConfig != nil, "clientCerts", o.apiClientCA != "")
+	}
+
+	// Start the acme-dns compatible registration API, if enabled.
+	if o.acmeDNSListen != "" {
+		acmeDNSServer := &http.Server{Handler: srv.RegistryHandler()}
+		if err := serveHTTP(acmeDNSServer, o.acmeDNSListen, errCh); err != nil {
+			return stoppers, fmt.Errorf("acme-dns listen: %w", err)
+		}
+		stoppers = append(stoppers, func(ctx context.Context) { acmeDNSServer.Shutdown(ctx) })
+		slog.Info("acme-dns api started", "listen", o.acmeDNSListen)
+	}
+
+	// Start the cert-manager webhook solver, if enabled.
+	if o.webhookListen != "" {
+		tlsConfig, err := serverTLSConfig(o.webhookClientCA, nil)
+		if err != nil {
+			return stoppers, err
+		}
+		if err := o.loadKeyPair(ctx, tlsConfig, o.webhookVault, o.webhookCert, o.webhookKey); err != nil {
+			return stoppers, fmt.Errorf("loading webhook TLS key pair: %w", err)
+		}
+		webhookServer := &http.Server{Handler: srv.WebhookHandler(o.webhookGroup), TLSConfig: tlsConfig}
+		if err := serveHTTP(webhookServer, o.webhookListen, errCh); err != nil {
+			return stoppers, fmt.Errorf("webhook listen: %w", err)
+		}
+		stoppers = append(stoppers, func(ctx context.Context) { webhookServer.Shutdown(ctx) })
+		slog.Info("webhook started", "listen", o.webhookListen, "group", o.webhookGroup)
+	}
+
+	// Start the gRPC API server, if enabled.
+	if o.grpcListen != "" {
+		tlsConfig, err := serverTLSConfig(o.grpcClientCA, o.grpcSANs)
+		if err != nil {
+			return stoppers, err
+		}
+		if err := o.loadKeyPair(ctx, tlsConfig, o.grpcVault, o.grpcCert, o.grpcKey); err != nil {
+			return stoppers, fmt.Errorf("loading gRPC TLS key pair: %w", err)
+		}
+
+		ln, err := net.Listen("tcp", o.grpcListen)
+		if err != nil {
+			return stoppers, fmt.Errorf("gRPC listen: %w", err)
+		}
+		grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+		srv.RegisterGRPC(grpcServer)
+		go func() { errCh <- grpcServer.Serve(ln) }()
+		stoppers = append(stoppers, func(ctx context.Context) {
+			stop := context.AfterFunc(ctx, grpcServer.Stop)
+			defer stop()
+			grpcServer.GracefulStop()
+		})
+		slog.Info("grpc started", "listen", o.grpcListen)
+	}
+	return stoppers, nil
+}
+
+// loadKeyPair loads the TLS key pair of a listener into cfg: from the Vault
+// secret at vaultPath if set, following its renewals every --vault-refresh
+// until ctx is done, or from certFile and keyFile otherwise.
+func (o *serveOptions) loadKeyPair(ctx context.Context, cfg *tls.Config, vaultPath, certFile, keyFile string) error {
+	if vaultPath == "" {
+		return loadKeyPair(cfg, certFile, keyFile)
+	}
+	v, err := vaultFromEnv(o.key.vaultToken)
+	if err != nil {
+		return err
+	}
+	pair, err := loadVaultKeyPair(ctx, v, vaultPath)
+	if err != nil {
+		return err
+	}
+	cfg.GetCertificate = pair.getCertificate
+	go pair.poll(ctx, v, o.vaultPoll)
+	return nil
+}
+
+// dropWhenReady waits until all DNS servers of srv are serving, then drops
+// privileges to dropTo, if set, and tells systemd we are ready. It is
+// started once all other listeners are bound.
+func dropWhenReady(srv *pajatso.Server, dropTo *runAs, errCh chan<- error) {
+	<-srv.Ready()
+	var addrs []string
+	for _, a := range srv.Addrs() {
+		addrs = append(addrs, a.Network()+" "+a.String())
+	}
+	slog.Info("dns listening", "addrs", addrs)
+	if dropTo != nil {
+		if err := dropTo.drop(); err != nil {
+			errCh <- fmt.Errorf("dropping privileges: %w", err)
+			return
+		}
+		slog.Info("dropped privileges", "uid", dropTo.uid, "gid", dropTo.gid)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("sd_notify failed", "err", err)
+	}
+}
+
+// followSecret follows rotations of the Kubernetes, Vault or AWS secret the
+// TSIG secret of srv was read from, starting from tsigSecret.
+func (o *serveOptions) followSecret(ctx context.Context, srv *pajatso.Server, tsigSecret string, source secretSource) {
+	if source.kube != nil {
+		go source.ref.watch(ctx, source.kube, tsigSecret, func(secret string) {
+			if err := srv.SetTsigSecret(secret); err != nil {
+				slog.Error("tsig secret ref: ignoring invalid secret", "ref", source.ref, "err", err)
+				return
+			}
+			slog.Info("tsig secret rotated", "ref", source.ref)
+		})
+	}
+	if source.vault != nil {
+		go source.vref.poll(ctx, source.vault, o.vaultPoll, tsigSecret, func(secret string) {
+			if err := srv.SetTsigSecret(secret); err != nil {
+				slog.Error("vault: ignoring invalid TSIG secret", "ref", source.vref, "err", err)
+				return
+			}
+			slog.Info("tsig secret rotated", "ref", source.vref)
+		})
+	}
+	if source.aws != nil {
+		go source.aref.poll(ctx, source.aws, o.awsPoll, source.awsVersion, func(secret string) {
+			if err := srv.SetTsigSecret(secret); err != nil {
+				slog.Error("aws: ignoring invalid TSIG secret", "ref", source.aref, "err", err)
+				return
+			}
+			slog.Info("tsig secret rotated", "ref", source.aref)
+		})
+	}
+}
+
+// startACMETest returns a channel receiving the outcome in ACME test mode,
+// once a certificate has been issued through the server or issuing it
+// failed, or nil otherwise.
+func (o *serveOptions) startACMETest(ctx context.Context, srv *pajatso.Server) (chan error, error) {
+	if o.testACMEDirectory == "" {
+		return nil, nil
+	}
+	client, err := newACMETestClient(o.testACMEDirectory, o.testACMEDirectoryCA)
+	if err != nil {
+		return nil, err
+	}
+	acmeTestCh := make(chan error, 1)
+	go func() {
+		<-srv.Ready()
+		ctx, cancel := context.WithTimeout(ctx, o.testACMETimeout)
+		defer cancel()
+		acmeTestCh <- acmeTest(ctx, client, srv)
+	}()
+	return acmeTestCh, nil
+}
+
+// register adds the flags of serve to fs.
+func (o *serveOptions) register(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Check the configuration, print it and exit without serving")
+	o.zone.register(fs)
+	o.key.register(fs)
+	fs.DurationVar(&o.tsigFudge, "tsig-fudge", 5*time.Minute, "Allowed clock skew of TSIG-signed updates; updates outside it get BADTIME with the server time")
+	fs.StringVar(&o.tsigPrev, "tsig-previous-secret", "", "Previous base64 TSIG secret of the key, still accepted while clients migrate to the new one")
+	fs.DurationVar(&o.vaultPoll, "vault-refresh", 5*time.Minute, "Interval of re-reading the Vault secrets and renewing the token")
+	fs.DurationVar(&o.awsPoll, "aws-refresh", 5*time.Minute, "Interval of checking the AWS secret for new versions")
+	fs.StringArrayVar(&o.tenants, "tenant", nil, "Further zone to serve with its own TSIG key and token, as ZONE=KEYNAME:SECRET (repeatable)")
+	fs.StringVar(&o.listen, "listen", ":53", "Listen address")
+	fs.IntVar(&o.udpWorkers, "udp-workers", 1, "Number of UDP sockets bound with SO_REUSEPORT, each with its own read loop")
+	fs.IntVar(&o.tcpMaxConns, "tcp-max-conns", 256, "Maximum concurrent TCP connections, further ones are closed (0 = unlimited)")
+	fs.IntVar(&o.tcpPipelined, "tcp-max-pipelined", 64, "Maximum queries handled at once on a TCP connection, further ones are answered with SERVFAIL (0 = unlimited)")
+	fs.DurationVar(&o.tcpIdleTimeout, "tcp-idle-timeout", 8*time.Second, "Close TCP connections idle for this long between queries")
+	fs.DurationVar(&o.tcpReadTimeout, "tcp-read-timeout", 2*time.Second, "Time allowed for reading a query over TCP")
+	fs.DurationVar(&o.tcpWriteTimeout, "tcp-write-timeout", 2*time.Second, "Time allowed for writing a response over TCP")
+	fs.IntVar(&o.maxRequests, "max-requests", 1024, "Maximum DNS requests handled at once, further UDP requests are dropped and TCP requests answered with SERVFAIL (0 = unlimited)")
+	fs.DurationVar(&o.requestTimeout, "request-timeout", pajatso.DefaultRequestTimeout, "Deadline for handling a DNS request, bounding requests forwarded to --upstream")
+	fs.DurationVar(&o.bindRetryWindow, "bind-retry", 5*time.Second, "Keep retrying to bind --listen for this long while the address is in use (0 = fail right away)")
+	fs.DurationVar(&o.drainTimeout, "drain-timeout", 5*time.Second, "Time allowed on shutdown for requests in flight to finish before connections are closed")
+	fs.Uint16Var(&o.ednsUDPSize, "edns-udp-size", pajatso.DefaultUDPSize, "EDNS0 UDP payload size to advertise, larger UDP responses are truncated")
+	fs.Float64Var(&o.rrlRate, "rrl-rate", 0, "Response rate limit for UDP queries per second, client prefix and name (0 = disabled)")
+	fs.IntVar(&o.rrlSlip, "rrl-slip", 2, "Answer every Nth rate-limited query with TC instead of dropping it (0 = drop all)")
+	fs.IntVar(&o.rrlIPv4Prefix, "rrl-ipv4-prefix", 24, "IPv4 prefix length grouping clients for rate limiting")
+	fs.IntVar(&o.rrlIPv6Prefix, "rrl-ipv6-prefix", 56, "IPv6 prefix length grouping clients for rate limiting")
+	fs.Float64Var(&o.updateKeyRate, "update-key-rate", 0, "Updates allowed per second and TSIG key, further ones are refused (0 = unlimited)")
+	fs.IntVar(&o.updateKeyBurst, "update-key-burst", 10, "Updates allowed at once per TSIG key")
+	fs.Float64Var(&o.updateIPRate, "update-ip-rate", 0, "Updates allowed per second and source address, further ones are refused (0 = unlimited)")
+	fs.IntVar(&o.updateIPBurst, "update-ip-burst", 10, "Updates allowed at once per source address")
+	fs.StringSliceVar(&o.updateAllow, "update-allow", nil, "Networks (CIDR) allowed to send updates, even with a valid TSIG (all if empty)")
+	fs.StringArrayVar(&o.updateAllowKey, "update-allow-key", nil, "Networks allowed to send updates signed with a TSIG key, as KEY=CIDR[,CIDR...] (repeatable)")
+	fs.StringArrayVar(&o.updatePolicy, "update-policy", nil, "Update-policy rule for the records updates may change, e.g. 'grant acme-update. subdomain example.com. TXT' (repeatable, the challenge TXT record if none)")
+	fs.BoolVar(&o.genericTXT, "generic-txt", false, "Let updates publish TXT records at any name in the zone allowed by --update-policy, not just the challenge record (e.g. for site verification)")
+	fs.StringSliceVar(&o.acceptNotify, "accept-notify", nil, "Zones to accept NOTIFY messages for as a stealth secondary, passing them on as notify events (none if empty)")
+	fs.StringSliceVar(&o.notifyAllow, "accept-notify-allow", nil, "Networks (CIDR) allowed to send NOTIFY messages (all if empty)")
+	fs.StringSliceVar(&o.queryAllow, "query-allow", nil, "Networks (CIDR) allowed to query, others are refused (all if empty)")
+	fs.StringSliceVar(&o.queryDeny, "query-deny", nil, "Networks (CIDR) refused queries, overriding --query-allow")
+	fs.IntVar(&o.banThreshold, "ban-threshold", 0, "Refused updates within --ban-window after which all requests from a source are dropped (0 = disabled)")
+	fs.DurationVar(&o.banWindow, "ban-window", 10*time.Minute, "Window counting refused updates for --ban-threshold")
+	fs.DurationVar(&o.banDuration, "ban-duration", time.Hour, "How long requests from a banned source are dropped")
+	fs.StringSliceVar(&o.protocols, "protocols", []string{"udp", "tcp"}, "Protocols to serve DNS over on --listen (udp, tcp or both)")
+	fs.StringVar(&o.mode, "mode", "normal", "Operating mode to start in (normal, read-only or maintenance), switched at runtime with the mode subcommand")
+	fs.StringVar(&o.dumpFile, "dump-file", "", "File to write the store to as JSON on SIGUSR1, like the dump subcommand (stdout if empty)")
+	fs.StringVar(&o.healthName, "health-name", "", "Label answering TXT health checks (e.g. health for health.example.com., disabled if empty)")
+	fs.BoolVar(&o.logQueries, "log-queries", false, "Log every DNS request with its response code and duration")
+	fs.DurationVar(&o.logSlow, "log-slow-requests", 0, "Log DNS requests taking at least this long, with the time spent in the store and verifying TSIG (0 = disabled)")
+	fs.BoolVar(&o.chaos, "chaos", true, "Answer CHAOS class id.server./hostname.bind. and version.bind. queries")
+	fs.BoolVar(&o.nsid, "nsid", true, "Return the --identity as the EDNS0 NSID when requested")
+	fs.StringSliceVar(&o.ns, "ns", nil, "Name servers of the zone (e.g. ns1.example.com), enabling SOA and NS answers")
+	fs.StringSliceVar(&o.nsIPv4, "ns-ipv4", nil, "IPv4 addresses of the first --ns name server if it is inside the zone, served for it and as glue")
+	fs.StringSliceVar(&o.nsIPv6, "ns-ipv6", nil, "IPv6 addresses of the first --ns name server if it is inside the zone, served for it and as glue")
+	fs.BoolVar(&o.authorityNS, "authority-ns", false, "Add the --ns records, with glue, to the authority section of positive answers")
+	fs.BoolVar(&o.nxdomain, "nxdomain", false, "Answer names in the zone without records with NXDOMAIN instead of an empty answer (never the challenge name)")
+	fs.DurationVar(&o.negativeTTL, "negative-ttl", pajatso.DefaultNegativeTTL, "SOA minimum, how long resolvers cache negative answers")
+	fs.StringVar(&o.hostmaster, "hostmaster", "", "SOA RNAME of the zone (default hostmaster.<zone>)")
+	fs.StringVar(&o.zonefile, "zonefile", "", "RFC 1035 zone file with static records to serve, e.g. A/AAAA for the name servers (names relative to the zone)")
+	fs.StringArrayVar(&o.cnames, "cname", nil, "CNAME record to serve in the zone, as NAME=TARGET, e.g. _acme-challenge.customer.example.com=<subdomain>.example.com (repeatable)")
+	fs.StringVar(&o.upstream, "upstream", "", "Authoritative server (host:port) to forward all requests to except those for the challenge record, e.g. to run in front of an existing server")
+	fs.StringArrayVar(&o.caa, "caa", nil, "CAA record to serve at the zone apex, e.g. '0 issue \"letsencrypt.org\"' (repeatable)")
+	fs.StringVar(&o.identity, "identity", "", "Instance identity reported for id.server. and hostname.bind. and as the NSID, e.g. the host name (none if empty)")
+	fs.StringVar(&o.version, "version-string", "dns-pajatso "+pajatso.ReadBuildInfo().Version, "Version reported for version.bind.")
+	fs.DurationVar(&o.tokenTTL, "token-ttl", 0, "Lifetime of a challenge token before it expires (0 = never)")
+	fs.DurationVar(&o.grace, "delete-grace", 0, "Keep answering deleted tokens for this long, or until they expire if sooner, for CAs re-checking after cleanup (0 = disabled)")
+	fs.IntVar(&o.maxToken, "max-token-length", pajatso.DefaultMaxTokenLength, "Maximum length of a challenge token in bytes")
+	fs.BoolVar(&o.strict, "strict-tokens", false, "Only accept ACME challenge digests (43 base64url characters) as tokens")
+	fs.StringVar(&o.adminListen, "admin-listen", "", "Listen address for the admin HTTP server with health probes (disabled if empty)")
+	fs.StringVar(&o.apiListen, "api-listen", "", "Listen address for the HTTP API (disabled if empty)")
+	fs.StringVar(&o.acmeDNSListen, "acme-dns-listen", "", "Listen address for the acme-dns compatible registration API (disabled if empty)")
+	fs.StringVar(&o.acmeDNSRegistry, "acme-dns-registry", "", "JSON file persisting acme-dns registrations (in memory only if empty)")
+	fs.StringVar(&o.apiToken, "api-token", "", "Bearer token required by the HTTP API, optional with --api-client-ca")
+	fs.StringArrayVar(&o.apiKeys, "api-key", nil, "Further bearer token of the HTTP API limited to some records, as '<name> <token> present|full [<record name>...]' (repeatable)")
+	fs.StringVar(&o.apiJWTIssuer, "api-jwt-issuer", "", "OpenID Connect issuer whose JWTs the HTTP API accepts as bearer tokens (e.g. https://token.actions.githubusercontent.com)")
+	fs.StringVar(&o.apiJWTAudience, "api-jwt-audience", "", "Audience the JWTs accepted by the HTTP API must name")
+	fs.StringVar(&o.apiJWTKeys, "api-jwt-jwks-url", "", "JSON Web Key Set of the JWT issuer (discovered from its OpenID configuration if empty)")
+	fs.StringSliceVar(&o.apiJWTScopes, "api-jwt-scope", nil, "Scopes the JWTs accepted by the HTTP API must have in their scope or scp claim")
+	fs.Float64Var(&o.apiWriteKeyRate, "api-write-key-rate", 0, "Writes allowed per second and credential on the HTTP and acme-dns APIs, further ones get 429 (0 = unlimited)")
+	fs.IntVar(&o.apiWriteKeyBurst, "api-write-key-burst", 10, "Writes allowed at once per credential on the HTTP and acme-dns APIs")
+	fs.Float64Var(&o.apiWriteIPRate, "api-write-ip-rate", 0, "Writes allowed per second and source address on the HTTP and acme-dns APIs, further ones get 429 (0 = unlimited)")
+	fs.IntVar(&o.apiWriteIPBurst, "api-write-ip-burst", 10, "Writes allowed at once per source address on the HTTP and acme-dns APIs")
+	fs.DurationVar(&o.apiAuthBackoff, "api-auth-backoff", 0, "How long a source is refused after a failed authentication on the HTTP and acme-dns APIs, doubled with every further failure (0 = disabled)")
+	fs.DurationVar(&o.apiAuthMaxWait, "api-auth-max-backoff", pajatso.DefaultMaxAuthBackoff, "Longest a source is refused after failed authentications")
+	fs.StringVar(&o.apiCert, "api-tls-cert", "", "TLS certificate file for the HTTP API (plain HTTP if empty)")
+	fs.StringVar(&o.apiKey, "api-tls-key", "", "TLS private key file for the HTTP API")
+	fs.StringVar(&o.apiVault, "api-tls-vault", "", "Read the TLS certificate and private key of the HTTP API from the certificate and private_key fields of this Vault KV secret at VAULT_ADDR, following renewals, instead of --api-tls-cert and --api-tls-key")
+	fs.StringVar(&o.apiClientCA, "api-client-ca", "", "CA bundle for verifying HTTP API client certificates, which are then required")
+	fs.StringSliceVar(&o.apiClientSANs, "api-client-san", nil, "Subject alternative names (DNS names, *.domain wildcards, emails, IPs or URIs) of the HTTP API client certificates allowed, any signed by --api-client-ca if empty")
+	fs.StringVar(&o.webhookListen, "webhook-listen", "", "Listen address for the cert-manager webhook solver (disabled if empty)")
+	fs.StringVar(&o.webhookGroup, "webhook-group", "", "API group name of the cert-manager webhook solver (e.g. acme.example.com)")
+	fs.StringVar(&o.webhookCert, "webhook-tls-cert", "", "TLS certificate file for the cert-manager webhook solver")
+	fs.StringVar(&o.webhookKey, "webhook-tls-key", "", "TLS private key file for the cert-manager webhook solver")
+	fs.StringVar(&o.webhookVault, "webhook-tls-vault", "", "Read the TLS certificate and private key of the cert-manager webhook solver from a Vault KV secret, as --api-tls-vault")
+	fs.StringVar(&o.webhookClientCA, "webhook-client-ca", "", "CA bundle for verifying API server client certificates, required unless --webhook-insecure")
+	fs.BoolVar(&o.webhookInsecure, "webhook-insecure", false, "Serve the cert-manager webhook solver without --webhook-client-ca, accepting requests from anyone reaching it")
+	fs.StringVar(&o.grpcListen, "grpc-listen", "", "Listen address for the gRPC API (disabled if empty)")
+	fs.StringVar(&o.grpcCert, "grpc-tls-cert", "", "TLS certificate file for the gRPC API")
+	fs.StringVar(&o.grpcKey, "grpc-tls-key", "", "TLS private key file for the gRPC API")
+	fs.StringVar(&o.grpcVault, "grpc-tls-vault", "", "Read the TLS certificate and private key of the gRPC API from a Vault KV secret, as --api-tls-vault")
+	fs.StringVar(&o.grpcClientCA, "grpc-client-ca", "", "CA bundle for verifying gRPC client certificates")
+	fs.StringSliceVar(&o.grpcSANs, "grpc-client-san", nil, "Subject alternative names (DNS names, *.domain wildcards, emails, IPs or URIs) of the gRPC client certificates allowed, any signed by --grpc-client-ca if empty")
+
+	fs.BoolVar(&o.oneShot, "one-shot", false, "Exit once the challenge token has been queried (e.g. by a CA), for use in CI pipelines")
+	fs.StringVar(&o.oneShotToken, "one-shot-token", "", "Token to serve in one-shot mode (otherwise set by the first update)")
+	fs.DurationVar(&o.oneShotTimeout, "one-shot-timeout", 10*time.Minute, "Fail if the token has not been queried within this time in one-shot mode")
+	fs.DurationVar(&o.oneShotLinger, "one-shot-linger", 30*time.Second, "Keep serving this long after the last validation query in one-shot mode")
+	fs.StringVar(&o.testACMEDirectory, "test-acme-directory", "", "Issue a throwaway certificate for the zone from this ACME directory (e.g. a Pebble instance using this server as its DNS server), publishing the challenges with signed updates to this server, then exit with the outcome")
+	fs.StringVar(&o.testACMEDirectoryCA, "test-acme-directory-ca", "", "CA bundle for verifying the TLS certificate of --test-acme-directory (e.g. Pebble's)")
+	fs.DurationVar(&o.testACMETimeout, "test-acme-timeout", 5*time.Minute, "Fail the --test-acme-directory issuance if it hasn't completed within this time")
+	fs.StringSliceVar(&o.propagationResolvers, "propagation-resolvers", nil, "Recursive resolvers to poll for new tokens, logging when they propagated (disabled if empty)")
+	fs.DurationVar(&o.propagationInterval, "propagation-interval", 5*time.Second, "Interval between propagation polls")
+	fs.DurationVar(&o.propagationTimeout, "propagation-timeout", 5*time.Minute, "Stop polling a resolver for a token after this time")
+	fs.StringVar(&o.onSet, "on-set", "", "Command to run when a token is set, with PAJATSO_NAME and PAJATSO_VALUE in its environment")
+	fs.StringVar(&o.onDelete, "on-delete", "", "Command to run when a token is deleted, with PAJATSO_NAME and PAJATSO_VALUE in its environment")
+	fs.StringVar(&o.onExpire, "on-expire", "", "Command to run when a token expires after --token-ttl, with PAJATSO_NAME and PAJATSO_VALUE in its environment")
+	fs.DurationVar(&o.hookTimeout, "hook-timeout", 30*time.Second, "Kill hook commands running longer than this")
+	fs.StringVar(&o.notifyURL, "notify-url", "", "URL to POST JSON notifications to when a token is set, deleted or expires, an update is refused or a NOTIFY is accepted (disabled if empty)")
+	fs.StringVar(&o.notifySecret, "notify-secret", "", "Secret for the HMAC-SHA256 X-Pajatso-Signature header of notifications")
+	fs.IntVar(&o.notifyRetries, "notify-retries", 3, "Number of retries for failed notifications")
+	fs.StringVar(&o.natsURL, "nats-url", "", "NATS server URL to publish events to (disabled if empty)")
+	fs.StringVar(&o.natsCreds, "nats-creds", "", "NATS credentials file (optional)")
+	fs.StringVar(&o.natsSubject, "nats-subject", "pajatso.events", "NATS subject prefix, events are published to <prefix>.<event>")
+	fs.StringVar(&o.runUser, "user", "", "User to switch to once all listeners are bound and TLS keys loaded (e.g. nobody)")
+	fs.StringVar(&o.runGroup, "group", "", "Group to switch to once the DNS listeners are bound (defaults to the primary group of --user)")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// serverTLSConfig returns a server TLS configuration, requiring client
+// certificates signed by the CA bundle in caFile if set, and naming one of
+// sans if not empty.
+func serverTLSConfig(caFile string, sans []string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		if len(sans) > 0 {
+			return nil, errors.New("allowed client SANs require a client CA")
+		}
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(sans) > 0 {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 || !matchSAN(cs.PeerCertificates[0], sans) {
+				return errors.New("client certificate names none of the allowed SANs")
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// matchSAN reports whether cert has one of the subject alternative names
+// sans: a DNS name, where "*.example.com" matches a single label, an email
+// address, an IP address or a URI.
+func matchSAN(cert *x509.Certificate, sans []string) bool {
+	for _, san := range sans {
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, san) {
+				return true
+			}
+			if suffix, ok := strings.CutPrefix(san, "*."); ok {
+				if label, rest, _ := strings.Cut(name, "."); label != "" && label != "*" && strings.EqualFold(rest, suffix) {
+					return true
+				}
+			}
+		}
+		for _, email := range cert.EmailAddresses {
+			if email == san {
+				return true
+			}
+		}
+		for _, ip := range cert.IPAddresses {
+			if ip.String() == san {
+				return true
+			}
+		}
+		for _, uri := range cert.URIs {
+			if uri.String() == san {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadKeyPair adds the certificate and key from certFile and keyFile to cfg.
+func loadKeyPair(cfg *tls.Config, certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cfg.Certificates = append(cfg.Certificates, cert)
+	return nil
+}
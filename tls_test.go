@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert returns a certificate for the SAN dnsName signed by parent, or
+// self-signed as a CA if parent is nil.
+func testCert(t *testing.T, dnsName string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		tmpl.DNSNames = []string{dnsName}
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestServerTLSConfigSANs(t *testing.T) {
+	ca := testCert(t, "test CA", nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600)
+
+	cfg, err := serverTLSConfig(caFile, []string{"*.ci.example.com", "spiffe://example.com/acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(cert tls.Certificate) error {
+		client := ts.Client()
+		client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+		client.Transport.(*http.Transport).CloseIdleConnections()
+		resp, err := client.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(testCert(t, "runner.ci.example.com", &ca)); err != nil {
+		t.Fatalf("expected an allowed SAN to be accepted, got %v", err)
+	}
+	if err := get(testCert(t, "laptop.example.com", &ca)); err == nil {
+		t.Fatal("expected a SAN that isn't allowed to be refused")
+	}
+	if err := get(testCert(t, "runner.ci.example.com", nil)); err == nil {
+		t.Fatal("expected a certificate of another CA to be refused")
+	}
+
+	if _, err := serverTLSConfig("", []string{"a.example.com"}); err == nil {
+		t.Fatal("expected SANs without a client CA to fail")
+	}
+}
+
+func TestMatchSAN(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.com/acme")
+	cert := &x509.Certificate{DNSNames: []string{"a.b.example.com"}, URIs: []*url.URL{uri}}
+	for san, want := range map[string]bool{
+		"a.b.example.com":           true,
+		"A.B.example.com":           true,
+		"*.b.example.com":           true,
+		"*.example.com":             false,
+		"spiffe://example.com/acme": true,
+		"spiffe://example.com/x":    false,
+	} {
+		if got := matchSAN(cert, []string{san}); got != want {
+			t.Errorf("%s: expected %v, got %v", san, want, got)
+		}
+	}
+}
+
+func TestServeHTTPLoadedKeyPair(t *testing.T) {
+	ca := testCert(t, "test CA", nil)
+	cert := testCert(t, "api.example.com", &ca)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	cfg, err := serverTLSConfig("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadKeyPair(cfg, certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	// The files aren't needed once loaded, e.g. after dropping privileges.
+	os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	hs := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), TLSConfig: cfg}
+	errCh := make(chan error, 1)
+	if err := serveHTTP(hs, addr, errCh); err != nil {
+		t.Fatal(err)
+	}
+	defer hs.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "api.example.com"}}}
+	resp, err := client.Get("https://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
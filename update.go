@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"codeberg.org/miekg/dns"
+	"codeberg.org/miekg/dns/rdata"
+	"github.com/spf13/cobra"
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// newUpdate returns an RFC 2136 update for zone that sets the TXT record name
+// to value, or deletes it if value is empty.
+func newUpdate(zone, name, value string, ttl uint32) *dns.Msg {
+	m := new(dns.Msg)
+	m.ID = dns.ID()
+	m.Opcode = dns.OpcodeUpdate
+	m.Question = []dns.RR{&dns.SOA{Hdr: dns.Header{Name: zone, Class: dns.ClassINET}}}
+
+	if value == "" {
+		// Delete all RRsets at name: class ANY, type ANY.
+		m.Ns = []dns.RR{&dns.ANY{Hdr: dns.Header{Name: name, Class: dns.ClassANY}}}
+		return m
+	}
+
+	// Character strings are limited to 255 octets, split longer values.
+	var txt []string
+	for len(value) > 255 {
+		txt = append(txt, value[:255])
+		value = value[255:]
+	}
+	txt = append(txt, value)
+
+	m.Ns = []dns.RR{&dns.TXT{
+		Hdr: dns.Header{Name: name, Class: dns.ClassINET, TTL: ttl},
+		TXT: rdata.TXT{Txt: txt},
+	}}
+	return m
+}
+
+// exchangeUpdate TSIG-signs m, sends it to addr over network and verifies
+// the signature of the response.
+func exchangeUpdate(ctx context.Context, m *dns.Msg, network, addr, keyName, algorithm string, secret []byte) (*dns.Msg, error) {
+	signer := dns.HmacTSIG{Secret: secret}
+	m.Pseudo = []dns.RR{dns.NewTSIG(keyName, algorithm, 300)}
+	opt := &dns.TSIGOption{}
+	if err := dns.TSIGSign(m, signer, opt); err != nil {
+		return nil, fmt.Errorf("signing update: %w", err)
+	}
+
+	r, _, err := dns.NewClient().Exchange(ctx, m, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(r.Pseudo, func(rr dns.RR) bool { _, ok := rr.(*dns.TSIG); return ok }) {
+		return r, fmt.Errorf("response is not signed (%s)", dns.RcodeToString[r.Rcode])
+	}
+	if err := dns.TSIGVerify(r, signer, &dns.TSIGOption{RequestMAC: opt.RequestMAC}); err != nil {
+		return r, fmt.Errorf("verifying response: %w", err)
+	}
+	return r, nil
+}
+
+// updateCommand returns the update subcommand.
+func updateCommand() *cobra.Command {
+	var (
+		server   string
+		zoneOpts zoneFlags
+		keyOpts  keyFlags
+		name     string
+		set      string
+		del      bool
+		ttl      uint32
+		network  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Send a TSIG-signed RFC 2136 update setting or deleting the challenge record",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (set == "") == !del {
+				return fmt.Errorf("exactly one of --set and --delete is required")
+			}
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			tsigSecret, _, err := keyOpts.resolve(cmd.Context())
+			if err != nil {
+				return err
+			}
+			secret, err := base64.StdEncoding.DecodeString(tsigSecret)
+			if err != nil {
+				return fmt.Errorf("invalid TSIG secret: %w", err)
+			}
+
+			zone, err := asciiFQDN(zoneOpts.zone)
+			if err != nil {
+				return err
+			}
+			subdomain, err := pajatso.ToASCII(strings.TrimRight(zoneOpts.subdomain, "."))
+			if err != nil {
+				return err
+			}
+			challenge := (&pajatso.Server{Zone: zone, Subdomain: subdomain}).ChallengeName()
+			if name != "" {
+				if challenge, err = asciiFQDN(name); err != nil {
+					return err
+				}
+			}
+			m := newUpdate(zone, challenge, set, ttl)
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), 10*time.Second)
+			defer cancel()
+			r, err := exchangeUpdate(ctx, m, network, server, ensureFQDN(keyOpts.name), ensureFQDN(strings.ToLower(keyOpts.algorithm)), secret)
+			if err != nil {
+				return err
+			}
+			if r.Rcode != dns.RcodeSuccess {
+				return fmt.Errorf("update failed: %s", dns.RcodeToString[r.Rcode])
+			}
+			if del {
+				fmt.Fprintf(cmd.OutOrStdout(), "deleted %s TXT\n", challenge)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "set %s TXT %q\n", challenge, set)
+			}
+			return nil
+		},
+	}
+	cmd.SilenceUsage = true
+
+	cmd.Flags().StringVar(&server, "server", "", "Server address (host or host:port)")
+	zoneOpts.register(cmd.Flags())
+	keyOpts.register(cmd.Flags())
+	cmd.Flags().StringVar(&name, "name", "", "FQDN of the TXT record to update instead of the challenge record, for servers with --generic-txt")
+	cmd.Flags().StringVar(&set, "set", "", "Set the challenge record to this token")
+	cmd.Flags().BoolVar(&del, "delete", false, "Delete the challenge record")
+	cmd.Flags().Uint32Var(&ttl, "ttl", 60, "TTL of the added record")
+	cmd.Flags().StringVar(&network, "net", "udp", "Transport to use (udp or tcp)")
+
+	cmd.MarkFlagRequired("server")
+	cmd.MarkFlagRequired("zone")
+	cmd.MarkFlagRequired("tsig-name")
+
+	return cmd
+}
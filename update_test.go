@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"codeberg.org/miekg/dns"
+)
+
+func TestUpdateClient(t *testing.T) {
+	addr, store, cleanup := startTestServer(t)
+	defer cleanup()
+
+	secret, _ := base64.StdEncoding.DecodeString(testTsigSecret)
+	long := strings.Repeat("x", 300)
+
+	m := newUpdate(testZone, testChallenge, long, 60)
+	r, err := exchangeUpdate(context.Background(), m, "udp", addr, testTsigName, dns.HmacSHA512, secret)
+	if err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("set: expected NOERROR, got %s", dns.RcodeToString[r.Rcode])
+	}
+	if val, ok := store.Value(); !ok || val != long {
+		t.Fatalf("set: expected long token, got (%q, %v)", val, ok)
+	}
+
+	m = newUpdate(testZone, testChallenge, "", 0)
+	if _, err := exchangeUpdate(context.Background(), m, "udp", addr, testTsigName, dns.HmacSHA512, secret); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := store.Value(); ok {
+		t.Fatal("delete: expected record to be deleted")
+	}
+}
+
+func TestUpdateClientWrongSecret(t *testing.T) {
+	addr, _, cleanup := startTestServer(t)
+	defer cleanup()
+
+	m := newUpdate(testZone, testChallenge, "token", 60)
+	r, err := exchangeUpdate(context.Background(), m, "udp", addr, testTsigName, dns.HmacSHA512, []byte("wrong"))
+	if err == nil {
+		t.Fatal("expected unsigned response to be reported")
+	}
+	if r == nil || r.Rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected NOTAUTH response, got %v", r)
+	}
+}
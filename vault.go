@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// vaultRef refers to a field of a Vault KV secret holding a base64 TSIG
+// secret, e.g. secret/data/dns-pajatso#tsig for a KV v2 mount.
+type vaultRef struct {
+	Path, Field string
+}
+
+// parseVaultRef parses a path#field reference.
+func parseVaultRef(s string) (vaultRef, error) {
+	path, field, ok := strings.Cut(s, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || field == "" {
+		return vaultRef{}, fmt.Errorf("invalid Vault reference %q, want path#field", s)
+	}
+	return vaultRef{path, field}, nil
+}
+
+func (r vaultRef) String() string {
+	return r.Path + "#" + r.Field
+}
+
+// vaultClient is a minimal client for the Vault HTTP API, just enough to
+// read KV secrets and renew its token without pulling in the Vault SDK.
+type vaultClient struct {
+	addr      string // Vault URL, e.g. https://vault.example.com:8200
+	token     string // token from VAULT_TOKEN, renewed periodically
+	tokenFile string // token file, e.g. written by Vault Agent, re-read on every request
+	client    *http.Client
+}
+
+// vaultError is a non-success response of Vault.
+type vaultError struct {
+	Code   int
+	Errors []string `json:"errors"`
+}
+
+func (e *vaultError) Error() string {
+	return fmt.Sprintf("vault: %s (%d)", strings.Join(e.Errors, "; "), e.Code)
+}
+
+// vaultFromEnv returns a client for VAULT_ADDR, authenticating with the
+// token in tokenFile if set, or VAULT_TOKEN otherwise.
+func vaultFromEnv(tokenFile string) (*vaultClient, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	v := &vaultClient{addr: strings.TrimSuffix(addr, "/"), tokenFile: tokenFile, client: &http.Client{Timeout: 10 * time.Second}}
+	if tokenFile == "" {
+		if v.token = os.Getenv("VAULT_TOKEN"); v.token == "" {
+			return nil, errors.New("VAULT_TOKEN is not set and no --vault-token-file given")
+		}
+	}
+	return v, nil
+}
+
+// do performs a request for path under /v1 and decodes the response into
+// out, if not nil.
+func (v *vaultClient) do(ctx context.Context, method, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+path, nil)
+	if err != nil {
+		return err
+	}
+	token := v.token
+	if v.tokenFile != "" {
+		b, err := os.ReadFile(v.tokenFile)
+		if err != nil {
+			return fmt.Errorf("reading Vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		e := &vaultError{Code: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(e)
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// renew renews the client's token, if it came from VAULT_TOKEN. Tokens
+// from a file are renewed by whoever writes it.
+func (v *vaultClient) renew(ctx context.Context) error {
+	if v.tokenFile != "" {
+		return nil
+	}
+	return v.do(ctx, "POST", "auth/token/renew-self", nil)
+}
+
+// readKV returns the fields of the KV secret at path. Both KV v1 and v2
+// (with "data/" in the path) mounts are supported.
+func (v *vaultClient) readKV(ctx context.Context, path string) (map[string]any, error) {
+	var s struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := v.do(ctx, "GET", path, &s); err != nil {
+		return nil, fmt.Errorf("reading Vault secret %s: %w", path, err)
+	}
+	data := s.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner // KV v2
+		}
+	}
+	return data, nil
+}
+
+// read returns the TSIG secret r refers to.
+func (r vaultRef) read(ctx context.Context, v *vaultClient) (string, error) {
+	data, err := v.readKV(ctx, r.Path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[r.Field].(string)
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no string field %q", r.Path, r.Field)
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// poll renews the token and calls fn with the TSIG secret r refers to
+// whenever it changed, every interval until ctx is done. Errors are logged
+// and retried at the next interval.
+func (r vaultRef) poll(ctx context.Context, v *vaultClient, interval time.Duration, current string, fn func(string)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := v.renew(ctx); err != nil {
+			slog.Warn("vault: token renewal failed", "err", err)
+		}
+		value, err := r.read(ctx, v)
+		if err != nil {
+			slog.Warn("vault: reading TSIG secret failed, retrying", "ref", r, "err", err)
+			continue
+		}
+		if value != current {
+			current = value
+			fn(value)
+		}
+	}
+}
+
+// vaultKeyPair is a TLS key pair read from the PEM certificate and
+// private_key fields of a Vault KV secret, named like in the responses of
+// the PKI secrets engine, and served with the listener's GetCertificate.
+type vaultKeyPair struct {
+	path string
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// loadVaultKeyPair returns the key pair of the KV secret at path.
+func loadVaultKeyPair(ctx context.Context, v *vaultClient, path string) (*vaultKeyPair, error) {
+	p := &vaultKeyPair{path: strings.Trim(path, "/")}
+	if err := p.load(ctx, v); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// load reads the key pair from Vault, replacing the one served.
+func (p *vaultKeyPair) load(ctx context.Context, v *vaultClient) error {
+	data, err := v.readKV(ctx, p.path)
+	if err != nil {
+		return err
+	}
+	certPEM, _ := data["certificate"].(string)
+	keyPEM, _ := data["private_key"].(string)
+	if certPEM == "" || keyPEM == "" {
+		return fmt.Errorf("Vault secret %s needs certificate and private_key fields", p.path)
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return fmt.Errorf("Vault secret %s: %w", p.path, err)
+	}
+	p.cert.Store(&cert)
+	return nil
+}
+
+// getCertificate returns the current key pair, for tls.Config.GetCertificate.
+func (p *vaultKeyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.cert.Load(), nil
+}
+
+// poll renews the token and re-reads the key pair every interval until ctx
+// is done, so that renewed certificates are served without restarting.
+// Errors are logged and the current key pair kept until the next interval.
+func (p *vaultKeyPair) poll(ctx context.Context, v *vaultClient, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := v.renew(ctx); err != nil {
+			slog.Warn("vault: token renewal failed", "err", err)
+		}
+		if err := p.load(ctx, v); err != nil {
+			slog.Warn("vault: reading TLS key pair failed, retrying", "path", p.path, "err", err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseVaultRef(t *testing.T) {
+	ref, err := parseVaultRef("/secret/data/dns-pajatso#tsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != (vaultRef{"secret/data/dns-pajatso", "tsig"}) {
+		t.Errorf("ref = %+v", ref)
+	}
+	for _, s := range []string{"", "secret/data/dns-pajatso", "#tsig", "secret/data/dns-pajatso#"} {
+		if _, err := parseVaultRef(s); err == nil {
+			t.Errorf("parseVaultRef(%q) succeeded", s)
+		}
+	}
+}
+
+func TestVaultPoll(t *testing.T) {
+	var (
+		secret  atomic.Value
+		renewed atomic.Int32
+	)
+	secret.Store("old")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Vault-Token"); got != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/dns":
+			fmt.Fprintf(w, `{"data": {"data": {"tsig": %q}, "metadata": {"version": 1}}}`, secret.Load())
+		case "/v1/kv/dns":
+			fmt.Fprint(w, `{"data": {"tsig": "v1"}}`)
+		case "/v1/auth/token/renew-self":
+			renewed.Add(1)
+			fmt.Fprint(w, `{"auth": {"lease_duration": 3600}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": []}`)
+		}
+	}))
+	defer ts.Close()
+	v := &vaultClient{addr: ts.URL, token: "test-token", client: ts.Client()}
+
+	ref := vaultRef{"secret/data/dns", "tsig"}
+	if value, err := ref.read(context.Background(), v); err != nil || value != "old" {
+		t.Fatalf("read = %q, %v, want old", value, err)
+	}
+	if value, err := (vaultRef{"kv/dns", "tsig"}).read(context.Background(), v); err != nil || value != "v1" {
+		t.Fatalf("KV v1 read = %q, %v, want v1", value, err)
+	}
+	if _, err := (vaultRef{"secret/data/dns", "other"}).read(context.Background(), v); err == nil {
+		t.Fatal("expected an error for a missing field")
+	}
+	if err := (&vaultClient{addr: ts.URL, token: "bad", client: ts.Client()}).renew(context.Background()); err == nil {
+		t.Fatal("expected an error for a bad token")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan string, 1)
+	go ref.poll(ctx, v, 10*time.Millisecond, "old", func(s string) { got <- s })
+	secret.Store("new")
+	select {
+	case s := <-got:
+		if s != "new" {
+			t.Fatalf("got %q, want new", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the rotated secret")
+	}
+	if renewed.Load() == 0 {
+		t.Fatal("expected the token to be renewed")
+	}
+}
+
+func TestVaultKeyPair(t *testing.T) {
+	pemPair := func(cert tls.Certificate) string {
+		key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(map[string]string{
+			"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})),
+			"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})),
+		})
+		return string(b)
+	}
+	ca := testCert(t, "test CA", nil)
+	first, second := testCert(t, "api.example.com", &ca), testCert(t, "api.example.com", &ca)
+	var fields atomic.Value
+	fields.Store(pemPair(first))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/secret/data/api-tls":
+			fmt.Fprintf(w, `{"data": {"data": %s, "metadata": {"version": 1}}}`, fields.Load())
+		case "/v1/auth/token/renew-self":
+			fmt.Fprint(w, `{"auth": {"lease_duration": 3600}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": []}`)
+		}
+	}))
+	defer ts.Close()
+	v := &vaultClient{addr: ts.URL, token: "test-token", client: ts.Client()}
+
+	if _, err := loadVaultKeyPair(context.Background(), v, "secret/data/missing"); err == nil {
+		t.Fatal("expected an error for a missing secret")
+	}
+	pair, err := loadVaultKeyPair(context.Background(), v, "/secret/data/api-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serving := func() []byte {
+		cert, _ := pair.getCertificate(nil)
+		return cert.Certificate[0]
+	}
+	if !bytes.Equal(serving(), first.Certificate[0]) {
+		t.Fatal("not serving the certificate of the secret")
+	}
+
+	// A renewed certificate is served once re-read.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pair.poll(ctx, v, 10*time.Millisecond)
+	fields.Store(pemPair(second))
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(serving(), second.Certificate[0]) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the renewed certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+// writeVersion writes the build info b, as JSON if asJSON is set.
+func writeVersion(w io.Writer, b pajatso.BuildInfo, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(b)
+	}
+	fmt.Fprintf(w, "dns-pajatso %s\n", b.Version)
+	if b.Commit != "" {
+		modified := ""
+		if b.Modified {
+			modified = " (modified)"
+		}
+		fmt.Fprintf(w, "commit: %s%s\n", b.Commit, modified)
+	}
+	if b.Date != "" {
+		fmt.Fprintf(w, "date:   %s\n", b.Date)
+	}
+	if b.DNS != "" {
+		fmt.Fprintf(w, "dns:    %s\n", b.DNS)
+	}
+	_, err := fmt.Fprintf(w, "go:     %s\n", b.Go)
+	return err
+}
+
+// versionCommand returns the version subcommand.
+func versionCommand() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of the binary, its commit and the versions of Go and the DNS library it was built with",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return writeVersion(cmd.OutOrStdout(), pajatso.ReadBuildInfo(), asJSON)
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the build information as JSON")
+	return cmd
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/twelho/dns-pajatso/pkg/pajatso"
+)
+
+func TestWriteVersion(t *testing.T) {
+	b := pajatso.BuildInfo{Version: "v1.2.3", Commit: "0123abc", Date: "2026-01-02T03:04:05Z", Modified: true, Go: "go1.25.0", DNS: "v0.6.52"}
+
+	var out bytes.Buffer
+	if err := writeVersion(&out, b, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "dns-pajatso v1.2.3\ncommit: 0123abc (modified)\ndate:   2026-01-02T03:04:05Z\ndns:    v0.6.52\ngo:     go1.25.0\n"
+	if out.String() != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	out.Reset()
+	if err := writeVersion(&out, b, true); err != nil {
+		t.Fatal(err)
+	}
+	var got pajatso.BuildInfo
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil || got != b {
+		t.Fatalf("expected %+v, got %+v (%v)", b, got, err)
+	}
+}